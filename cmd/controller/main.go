@@ -32,12 +32,15 @@ func main() {
 	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "/etc/pfsense-controller/config.toml", "Configuration file path")
 	rootCmd.PersistentFlags().StringVarP(&logLevel, "log-level", "l", "info", "Log level (debug, info, warn, error)")
 
+	rootCmd.AddCommand(newPlanCommand())
+
 	if err := rootCmd.Execute(); err != nil {
 		logrus.Fatalf("Failed to execute command: %v", err)
 	}
 }
 
-func run(_ *cobra.Command, _ []string) {
+// setupLogging configures the log level and format
+func setupLogging() {
 	level, err := logrus.ParseLevel(logLevel)
 	if err != nil {
 		logrus.Fatalf("Invalid log level: %v", err)
@@ -46,6 +49,10 @@ func run(_ *cobra.Command, _ []string) {
 	logrus.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp: true,
 	})
+}
+
+func run(_ *cobra.Command, _ []string) {
+	setupLogging()
 
 	logrus.Info("Starting pfSense Container Controller")
 
@@ -0,0 +1,184 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/config"
+	"github.com/KristijanL/pfsense-container-controller/internal/controller"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var planOutput string
+
+// newPlanCommand creates the plan subcommand
+func newPlanCommand() *cobra.Command {
+	planCmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Show the pfSense changes a sync would make without applying them",
+		Run:   plan,
+	}
+
+	planCmd.Flags().StringVarP(&planOutput, "output", "o", "text", "Output format (text, json)")
+
+	return planCmd
+}
+
+func plan(_ *cobra.Command, _ []string) {
+	setupLogging()
+
+	if planOutput != "text" && planOutput != "json" {
+		logrus.Fatalf("Invalid output format: %s", planOutput)
+	}
+
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		logrus.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Never write to pfSense while planning, whatever the configuration says
+	cfg.Global.DryRun = true
+
+	op, err := controller.New(cfg)
+	if err != nil {
+		logrus.Fatalf("Failed to create controller: %v", err)
+	}
+
+	// Plans of healthy endpoints are still shown when others fail
+	plan, planErr := op.Plan(context.Background())
+
+	if planOutput == "json" {
+		err = writePlansJSON(os.Stdout, plan)
+	} else {
+		err = writePlansText(os.Stdout, plan)
+	}
+	if err != nil {
+		logrus.Fatalf("Failed to write plan: %v", err)
+	}
+
+	if planErr != nil {
+		logrus.Fatalf("Failed to plan changes: %v", planErr)
+	}
+}
+
+// writePlansJSON writes the plans as JSON
+func writePlansJSON(w io.Writer, plan *controller.Plan) error {
+	if plan == nil {
+		plan = &controller.Plan{}
+	}
+
+	// Managers without plans are written as empty lists
+	output := *plan
+	output.HAProxy = emptyIfNil(output.HAProxy)
+	output.DNS = emptyIfNil(output.DNS)
+	output.Firewall = emptyIfNil(output.Firewall)
+	output.DHCP = emptyIfNil(output.DHCP)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(output)
+}
+
+// emptyIfNil returns an empty slice instead of nil, so that it is encoded as an empty JSON list
+func emptyIfNil[T any](values []T) []T {
+	if values == nil {
+		return []T{}
+	}
+	return values
+}
+
+// plannedChange is a change of a plan as written in the text format
+type plannedChange struct {
+	changeType  string
+	description string
+}
+
+// endpointPlan is the plan of a manager on an endpoint as written in the text format
+type endpointPlan struct {
+	manager  string
+	endpoint string
+	changes  []plannedChange
+}
+
+// textPlans returns the plans of every manager in the order they are written in the text format
+func textPlans(plan *controller.Plan) []endpointPlan {
+	if plan == nil {
+		return nil
+	}
+
+	var plans []endpointPlan
+	for _, p := range plan.HAProxy {
+		text := endpointPlan{manager: "HAProxy", endpoint: p.Endpoint}
+		for i := range p.Changes {
+			text.changes = append(text.changes, plannedChange{string(p.Changes[i].Type), p.Changes[i].String()})
+		}
+		plans = append(plans, text)
+	}
+	for _, p := range plan.DNS {
+		text := endpointPlan{manager: "DNS", endpoint: p.Endpoint}
+		for i := range p.Changes {
+			text.changes = append(text.changes, plannedChange{string(p.Changes[i].Type), p.Changes[i].String()})
+		}
+		plans = append(plans, text)
+	}
+	for _, p := range plan.Firewall {
+		text := endpointPlan{manager: "Firewall", endpoint: p.Endpoint}
+		for i := range p.Changes {
+			text.changes = append(text.changes, plannedChange{string(p.Changes[i].Type), p.Changes[i].String()})
+		}
+		plans = append(plans, text)
+	}
+	for _, p := range plan.DHCP {
+		text := endpointPlan{manager: "DHCP", endpoint: p.Endpoint}
+		for i := range p.Changes {
+			text.changes = append(text.changes, plannedChange{string(p.Changes[i].Type), p.Changes[i].String()})
+		}
+		plans = append(plans, text)
+	}
+
+	return plans
+}
+
+// writePlansText writes the plans in a human-readable format
+func writePlansText(w io.Writer, plan *controller.Plan) error {
+	for _, p := range textPlans(plan) {
+		if len(p.changes) == 0 {
+			if _, err := fmt.Fprintf(w, "%s endpoint %s: no changes\n", p.manager, p.endpoint); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := fmt.Fprintf(w, "%s endpoint %s: %d changes\n", p.manager, p.endpoint, len(p.changes)); err != nil {
+			return err
+		}
+		for _, change := range p.changes {
+			if _, err := fmt.Fprintf(w, "  %s %s\n", changeSymbol(change.changeType), change.description); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// changeSymbol returns the symbol shown in front of a change
+func changeSymbol(changeType string) string {
+	switch changeType {
+	case string(pfsense.ChangeCreate):
+		return "+"
+	case string(pfsense.ChangeDelete):
+		return "-"
+	default:
+		return "~"
+	}
+}
@@ -3,8 +3,11 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -12,25 +15,48 @@ import (
 
 // Config represents the main configuration structure
 type Config struct {
-	Endpoints []EndpointConfig `toml:"endpoints"`
-	Global    GlobalConfig     `toml:"global"`
+	Runtimes  map[string]RuntimeConfig `toml:"runtimes"`
+	Endpoints []EndpointConfig         `toml:"endpoints"`
+	Global    GlobalConfig             `toml:"global"`
 }
 
 // GlobalConfig contains global controller settings
 type GlobalConfig struct {
-	LogLevel          string   `toml:"log_level"`
-	PollInterval      duration `toml:"poll_interval"`
-	RetryDelay        duration `toml:"retry_delay"`
-	RetryAttempts     int      `toml:"retry_attempts"`
-	HealthPort        int      `toml:"health_port"`
-	TraefikCompatMode bool     `toml:"traefik_compat_mode"`
+	LogLevel              string   `toml:"log_level"`
+	InstanceID            string   `toml:"instance_id"`
+	AddressMode           string   `toml:"address_mode"`
+	HostAddress           string   `toml:"host_address"`
+	SecretsDir            string   `toml:"secrets_dir"`
+	FirewallRulePosition  string   `toml:"firewall_rule_position"`
+	FirewallRuleSeparator string   `toml:"firewall_rule_separator"`
+	PreferredNetworks     []string `toml:"preferred_networks"`
+	PollInterval          duration `toml:"poll_interval"`
+	RetryDelay            duration `toml:"retry_delay"`
+	StateCacheTTL         duration `toml:"state_cache_ttl"`
+	ApplyDebounce         duration `toml:"apply_debounce"`
+	ApplyMaxDelay         duration `toml:"apply_max_delay"`
+	RetryAttempts         int      `toml:"retry_attempts"`
+	HealthPort            int      `toml:"health_port"`
+	TraefikCompatMode     bool     `toml:"traefik_compat_mode"`
+	AdoptUnowned          bool     `toml:"adopt_unowned"`
+	DryRun                bool     `toml:"dry_run"`
 }
 
+// RuntimeConfig contains settings of a container runtime
+type RuntimeConfig struct {
+	// AdvertiseAddress is the host address pfSense uses to reach ports published by this runtime
+	AdvertiseAddress string `toml:"advertise_address"`
+}
+
+// runtimeNames are the container runtimes that can be configured
+var runtimeNames = []string{"docker", "podman"}
+
 // EndpointConfig represents a pfSense endpoint configuration
 type EndpointConfig struct {
 	Name           string   `toml:"name"`
 	URL            string   `toml:"url"`
 	APIKey         string   `toml:"api_key"`
+	ProxyAddress   string   `toml:"proxy_address"`
 	RequestTimeout duration `toml:"request_timeout"`
 	InsecureTLS    bool     `toml:"insecure_tls"`
 }
@@ -50,12 +76,21 @@ func (d *duration) UnmarshalText(text []byte) error {
 func LoadConfig(configPath string) (*Config, error) {
 	config := &Config{
 		Global: GlobalConfig{
-			PollInterval:      duration{30 * time.Second},
-			RetryAttempts:     3,
-			RetryDelay:        duration{5 * time.Second},
-			LogLevel:          "info",
-			HealthPort:        8080,
-			TraefikCompatMode: false,
+			PollInterval:         duration{30 * time.Second},
+			RetryAttempts:        3,
+			RetryDelay:           duration{5 * time.Second},
+			StateCacheTTL:        duration{10 * time.Second},
+			ApplyDebounce:        duration{2 * time.Second},
+			ApplyMaxDelay:        duration{30 * time.Second},
+			LogLevel:             "info",
+			HealthPort:           8080,
+			TraefikCompatMode:    false,
+			InstanceID:           "default",
+			AddressMode:          "container",
+			SecretsDir:           "/run/secrets",
+			FirewallRulePosition: "bottom",
+			AdoptUnowned:         false,
+			DryRun:               false,
 		},
 	}
 
@@ -73,6 +108,24 @@ func LoadConfig(configPath string) (*Config, error) {
 		}
 	}
 
+	if cacheTTL := os.Getenv("PFSENSE_STATE_CACHE_TTL"); cacheTTL != "" {
+		if d, err := time.ParseDuration(cacheTTL); err == nil {
+			config.Global.StateCacheTTL.Duration = d
+		}
+	}
+
+	if applyDebounce := os.Getenv("PFSENSE_APPLY_DEBOUNCE"); applyDebounce != "" {
+		if d, err := time.ParseDuration(applyDebounce); err == nil {
+			config.Global.ApplyDebounce.Duration = d
+		}
+	}
+
+	if applyMaxDelay := os.Getenv("PFSENSE_APPLY_MAX_DELAY"); applyMaxDelay != "" {
+		if d, err := time.ParseDuration(applyMaxDelay); err == nil {
+			config.Global.ApplyMaxDelay.Duration = d
+		}
+	}
+
 	if logLevel := os.Getenv("PFSENSE_LOG_LEVEL"); logLevel != "" {
 		config.Global.LogLevel = logLevel
 	}
@@ -87,6 +140,53 @@ func LoadConfig(configPath string) (*Config, error) {
 		config.Global.TraefikCompatMode = parseBool(traefikMode, false)
 	}
 
+	if instanceID := os.Getenv("PFSENSE_INSTANCE_ID"); instanceID != "" {
+		config.Global.InstanceID = instanceID
+	}
+
+	if adoptUnowned := os.Getenv("PFSENSE_ADOPT_UNOWNED"); adoptUnowned != "" {
+		config.Global.AdoptUnowned = parseBool(adoptUnowned, false)
+	}
+
+	if dryRun := os.Getenv("PFSENSE_DRY_RUN"); dryRun != "" {
+		config.Global.DryRun = parseBool(dryRun, false)
+	}
+
+	if preferredNetworks := os.Getenv("PFSENSE_PREFERRED_NETWORKS"); preferredNetworks != "" {
+		config.Global.PreferredNetworks = parseList(preferredNetworks)
+	}
+
+	if addressMode := os.Getenv("PFSENSE_ADDRESS_MODE"); addressMode != "" {
+		config.Global.AddressMode = addressMode
+	}
+
+	if hostAddress := os.Getenv("PFSENSE_HOST_ADDRESS"); hostAddress != "" {
+		config.Global.HostAddress = hostAddress
+	}
+
+	if secretsDir := os.Getenv("PFSENSE_SECRETS_DIR"); secretsDir != "" {
+		config.Global.SecretsDir = secretsDir
+	}
+
+	if position := os.Getenv("PFSENSE_FIREWALL_RULE_POSITION"); position != "" {
+		config.Global.FirewallRulePosition = position
+	}
+
+	if separator := os.Getenv("PFSENSE_FIREWALL_RULE_SEPARATOR"); separator != "" {
+		config.Global.FirewallRuleSeparator = separator
+	}
+
+	for _, runtime := range runtimeNames {
+		if address := os.Getenv("PFSENSE_" + strings.ToUpper(runtime) + "_ADVERTISE_ADDRESS"); address != "" {
+			if config.Runtimes == nil {
+				config.Runtimes = make(map[string]RuntimeConfig)
+			}
+			runtimeConfig := config.Runtimes[runtime]
+			runtimeConfig.AdvertiseAddress = address
+			config.Runtimes[runtime] = runtimeConfig
+		}
+	}
+
 	// Load endpoints from environment if no endpoints defined in config
 	if len(config.Endpoints) == 0 {
 		if url := os.Getenv("PFSENSE_URL"); url != "" {
@@ -94,6 +194,7 @@ func LoadConfig(configPath string) (*Config, error) {
 				Name:           "default",
 				URL:            url,
 				APIKey:         os.Getenv("PFSENSE_API_KEY"),
+				ProxyAddress:   os.Getenv("PFSENSE_PROXY_ADDRESS"),
 				InsecureTLS:    parseBool(os.Getenv("PFSENSE_INSECURE_TLS"), false),
 				RequestTimeout: duration{30 * time.Second},
 			}
@@ -125,12 +226,56 @@ func (c *Config) Validate() error {
 		if endpoint.APIKey == "" {
 			return fmt.Errorf("endpoint %s: API key is required", endpoint.Name)
 		}
+		if endpoint.ProxyAddress != "" && net.ParseIP(endpoint.ProxyAddress) == nil {
+			return fmt.Errorf("endpoint %s: proxy_address must be an IP address", endpoint.Name)
+		}
 	}
 
 	if c.Global.PollInterval.Duration <= 0 {
 		return fmt.Errorf("poll_interval must be positive")
 	}
 
+	if c.Global.StateCacheTTL.Duration < 0 {
+		return fmt.Errorf("state_cache_ttl must not be negative")
+	}
+
+	if c.Global.ApplyDebounce.Duration < 0 {
+		return fmt.Errorf("apply_debounce must not be negative")
+	}
+
+	if c.Global.ApplyMaxDelay.Duration < 0 {
+		return fmt.Errorf("apply_max_delay must not be negative")
+	}
+
+	if c.Global.InstanceID == "" {
+		return fmt.Errorf("instance_id cannot be empty")
+	}
+	if strings.ContainsAny(c.Global.InstanceID, "; \n") {
+		return fmt.Errorf("instance_id must not contain spaces or semicolons")
+	}
+
+	for name := range c.Runtimes {
+		if !slices.Contains(runtimeNames, name) {
+			return fmt.Errorf("runtimes.%s: unknown container runtime", name)
+		}
+	}
+
+	switch c.Global.AddressMode {
+	case "container":
+	case "host":
+		if c.Global.HostAddress == "" && len(c.AdvertiseAddresses()) == 0 {
+			return fmt.Errorf("host_address or a runtime advertise_address is required when address_mode is host")
+		}
+	default:
+		return fmt.Errorf("address_mode must be one of: container, host")
+	}
+
+	switch c.Global.FirewallRulePosition {
+	case "top", "bottom":
+	default:
+		return fmt.Errorf("firewall_rule_position must be one of: top, bottom")
+	}
+
 	if c.Global.RetryAttempts < 0 {
 		return fmt.Errorf("retry_attempts must be non-negative")
 	}
@@ -138,6 +283,17 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// AdvertiseAddresses returns the configured advertise addresses by runtime name
+func (c *Config) AdvertiseAddresses() map[string]string {
+	addresses := make(map[string]string)
+	for name, runtime := range c.Runtimes {
+		if runtime.AdvertiseAddress != "" {
+			addresses[name] = runtime.AdvertiseAddress
+		}
+	}
+	return addresses
+}
+
 // GetEndpoint returns an endpoint by name, or nil if not found
 func (c *Config) GetEndpoint(name string) *EndpointConfig {
 	for _, endpoint := range c.Endpoints {
@@ -174,3 +330,14 @@ func parseBool(s string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+// parseList parses a comma separated list, ignoring empty entries
+func parseList(s string) []string {
+	var result []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
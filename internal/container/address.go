@@ -0,0 +1,83 @@
+package container
+
+import (
+	"cmp"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+)
+
+const (
+	// NetworkDriverMacvlan is the driver of networks giving containers their own MAC address
+	// on a host interface
+	NetworkDriverMacvlan = "macvlan"
+	// NetworkDriverIPvlan is the driver of networks giving containers their own IP address on a
+	// host interface, sharing its MAC address
+	NetworkDriverIPvlan = "ipvlan"
+)
+
+// GetContainerIP returns the primary IP address of a container. The preferred networks are
+// tried in order, then the remaining networks in name order, so that containers attached
+// to several networks always resolve to the same address.
+func GetContainerIP(container *Info, preferredNetworks ...string) string {
+	for _, network := range preferredNetworks {
+		if ip := GetNetworkIP(container, network); ip != "" {
+			return ip
+		}
+	}
+
+	for _, network := range slices.Sorted(maps.Keys(container.Networks)) {
+		if ip := container.Networks[network].IPAddress; ip != "" {
+			return ip
+		}
+	}
+	return ""
+}
+
+// GetNetworkIP returns the IP address of a container on the given network, or an empty
+// string if the container is not attached to it
+func GetNetworkIP(container *Info, network string) string {
+	return container.Networks[network].IPAddress
+}
+
+// IsDirectlyAttached reports whether a container network attaches containers directly to the
+// network of a host interface, so that the firewall sees them as hosts of their own
+func (n NetworkInfo) IsDirectlyAttached() bool {
+	return n.Driver == NetworkDriverMacvlan || n.Driver == NetworkDriverIPvlan
+}
+
+// GetPublishedPort returns the host mapping of a container port, if the port is published.
+// Ports only bound to a loopback address are ignored, as they cannot be reached from other hosts.
+func GetPublishedPort(container *Info, privatePort int, protocol string) (PortMapping, bool) {
+	for _, port := range container.Ports {
+		if port.PrivatePort != privatePort || port.PublicPort == 0 || !strings.EqualFold(port.Protocol, protocol) {
+			continue
+		}
+		if ip := net.ParseIP(port.HostIP); ip != nil && ip.IsLoopback() {
+			continue
+		}
+		return port, true
+	}
+	return PortMapping{}, false
+}
+
+// IsHostIPSpecific reports whether a port mapping is bound to a single host address
+// rather than to all addresses of the host
+func (p PortMapping) IsHostIPSpecific() bool {
+	ip := net.ParseIP(p.HostIP)
+	return ip != nil && !ip.IsUnspecified()
+}
+
+// sortPorts sorts port mappings so that lookups are deterministic
+func sortPorts(ports []PortMapping) []PortMapping {
+	slices.SortFunc(ports, func(a, b PortMapping) int {
+		return cmp.Or(
+			cmp.Compare(a.PrivatePort, b.PrivatePort),
+			cmp.Compare(a.Protocol, b.Protocol),
+			cmp.Compare(a.HostIP, b.HostIP),
+			cmp.Compare(a.PublicPort, b.PublicPort),
+		)
+	})
+	return ports
+}
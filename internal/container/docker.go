@@ -3,14 +3,17 @@ package container
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/sirupsen/logrus"
 )
@@ -22,10 +25,18 @@ const (
 	ControllerEnableLabel = "pfsense-controller.enable"
 )
 
+// dockerStreamGrace is how long a Docker events stream must stay up without failing, if no
+// event arrives earlier, before it is taken as established
+const dockerStreamGrace = 2 * time.Second
+
 // DockerClient implements the RuntimeClient interface for Docker
 type DockerClient struct {
-	client *client.Client
-	logger *logrus.Entry
+	// lastEvent is the time of the last event seen, used to resume a lost event stream
+	lastEvent time.Time
+	client    *client.Client
+	logger    *logrus.Entry
+	// streamGrace is how long an events stream must stay up before it counts as established
+	streamGrace time.Duration
 }
 
 // NewDockerClient creates a new Docker client
@@ -36,15 +47,18 @@ func NewDockerClient() (*DockerClient, error) {
 	}
 
 	return &DockerClient{
-		client: cli,
-		logger: logrus.WithField("runtime", "docker"),
+		client:      cli,
+		logger:      logrus.WithField("runtime", "docker"),
+		streamGrace: dockerStreamGrace,
 	}, nil
 }
 
 // IsAvailable checks if Docker is available
 func (d *DockerClient) IsAvailable() bool {
-	if _, err := os.Stat("/var/run/docker.sock"); os.IsNotExist(err) {
-		return false
+	if socketPath := d.SocketPath(); socketPath != "" {
+		if _, err := os.Stat(socketPath); os.IsNotExist(err) {
+			return false
+		}
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -54,12 +68,24 @@ func (d *DockerClient) IsAvailable() bool {
 	return err == nil
 }
 
+// SocketPath returns the path of the Unix socket the client connects to, honouring
+// DOCKER_HOST. It returns an empty string if the daemon is not reached through a Unix socket.
+func (d *DockerClient) SocketPath() string {
+	host := d.client.DaemonHost()
+	if !strings.HasPrefix(host, "unix://") {
+		return ""
+	}
+	return strings.TrimPrefix(host, "unix://")
+}
+
 // GetRuntimeName returns the name of the container runtime
 func (d *DockerClient) GetRuntimeName() string {
 	return "docker"
 }
 
-// ListContainers returns all containers with pfSense controller labels
+// ListContainers returns all containers with pfSense controller labels. If a container cannot
+// be converted, the other containers are returned together with an error, as the list is
+// incomplete and must not be treated as the full set of containers.
 func (d *DockerClient) ListContainers(ctx context.Context) ([]*Info, error) {
 	// Create filter to only get containers with our labels
 	filterArgs := filters.NewArgs()
@@ -73,17 +99,23 @@ func (d *DockerClient) ListContainers(ctx context.Context) ([]*Info, error) {
 		return nil, fmt.Errorf("failed to list containers: %w", err)
 	}
 
+	drivers, err := d.networkDrivers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*Info
+	var errs []error
 	for i := range containers {
-		containerInfo, err := d.convertContainer(&containers[i])
+		containerInfo, err := d.convertContainer(&containers[i], drivers)
 		if err != nil {
-			d.logger.Errorf("Failed to convert container %s: %v", containers[i].ID, err)
+			errs = append(errs, err)
 			continue
 		}
 		result = append(result, containerInfo)
 	}
 
-	return result, nil
+	return result, errors.Join(errs...)
 }
 
 // GetContainer returns detailed information about a specific container
@@ -93,38 +125,89 @@ func (d *DockerClient) GetContainer(ctx context.Context, id string) (*Info, erro
 		return nil, fmt.Errorf("failed to inspect container %s: %w", id, err)
 	}
 
-	return d.convertContainerJSON(containerJSON), nil
+	drivers, err := d.networkDrivers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.convertContainerJSON(containerJSON, drivers), nil
 }
 
-// WatchContainers watches for container events
-func (d *DockerClient) WatchContainers(ctx context.Context, eventChan chan<- Event) error {
+// networkDrivers returns the drivers of the Docker networks by network name. Containers are
+// not converted without them, as a container on a macvlan network would look like one on a
+// bridge network and lose its DHCP static mappings.
+func (d *DockerClient) networkDrivers(ctx context.Context) (map[string]string, error) {
+	networks, err := d.client.NetworkList(ctx, network.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+
+	drivers := make(map[string]string, len(networks))
+	for _, n := range networks {
+		drivers[n.Name] = n.Driver
+	}
+	return drivers, nil
+}
+
+// WatchContainers watches for container events until the stream fails or the context is canceled.
+// When called again after a failure, it resumes after the last event seen.
+func (d *DockerClient) WatchContainers(ctx context.Context, eventChan chan<- Event, connected func()) error {
 	// Create filter for container events with our labels
 	filterArgs := filters.NewArgs()
 	filterArgs.Add("type", "container")
 	filterArgs.Add("label", ControllerEnableLabel+"=true")
 
+	if d.lastEvent.IsZero() {
+		d.lastEvent = time.Now()
+	}
+
+	// Since is inclusive, so start right after the last event to not see it again
+	since := d.lastEvent.Add(time.Nanosecond)
 	eventOptions := events.ListOptions{
 		Filters: filterArgs,
+		Since:   fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond()),
 	}
 
+	// The client subscribes in the background and only reports failures, so the stream is
+	// taken as established once it delivers an event or stays up for the grace period
 	eventsChan, errChan := d.client.Events(ctx, eventOptions)
+	grace := time.NewTimer(d.streamGrace)
+	defer grace.Stop()
+	established := grace.C
 
 	for {
 		select {
+		case <-established:
+			established = nil
+			connected()
+
 		case event := <-eventsChan:
+			if established != nil {
+				established = nil
+				connected()
+			}
+			if event.TimeNano > 0 {
+				d.lastEvent = time.Unix(0, event.TimeNano)
+			}
+
 			containerEvent, err := d.handleDockerEvent(ctx, &event)
 			if err != nil {
 				d.logger.Errorf("Failed to handle Docker event: %v", err)
 				continue
 			}
 			if containerEvent != nil {
-				eventChan <- *containerEvent
+				select {
+				case eventChan <- *containerEvent:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
 			}
 
 		case err := <-errChan:
 			if err != nil {
 				return fmt.Errorf("Docker events stream error: %w", err)
 			}
+			return fmt.Errorf("Docker events stream closed")
 
 		case <-ctx.Done():
 			return ctx.Err()
@@ -133,7 +216,7 @@ func (d *DockerClient) WatchContainers(ctx context.Context, eventChan chan<- Eve
 }
 
 // convertContainer converts a Docker container to our Info struct
-func (d *DockerClient) convertContainer(c *container.Summary) (*Info, error) {
+func (d *DockerClient) convertContainer(c *container.Summary, drivers map[string]string) (*Info, error) {
 	// Validate required fields
 	if len(c.Names) == 0 {
 		return nil, fmt.Errorf("container %s has no names", c.ID)
@@ -141,21 +224,36 @@ func (d *DockerClient) convertContainer(c *container.Summary) (*Info, error) {
 
 	// Extract networks information
 	networks := make(map[string]NetworkInfo)
-	for networkName, network := range c.NetworkSettings.Networks {
+	for networkName, endpoint := range c.NetworkSettings.Networks {
 		networks[networkName] = NetworkInfo{
-			IPAddress: network.IPAddress,
-			Gateway:   network.Gateway,
+			IPAddress:  endpoint.IPAddress,
+			Gateway:    endpoint.Gateway,
+			MacAddress: endpoint.MacAddress,
+			Driver:     drivers[networkName],
 		}
 	}
 
+	// Extract published ports
+	ports := make([]PortMapping, 0, len(c.Ports))
+	for _, port := range c.Ports {
+		ports = append(ports, PortMapping{
+			HostIP:      port.IP,
+			Protocol:    port.Type,
+			PrivatePort: int(port.PrivatePort),
+			PublicPort:  int(port.PublicPort),
+		})
+	}
+
 	containerInfo := &Info{
 		ID:       c.ID,
+		Runtime:  d.GetRuntimeName(),
 		Name:     strings.TrimPrefix(c.Names[0], "/"),
 		Image:    c.Image,
 		State:    c.State,
 		Status:   c.Status,
 		Labels:   c.Labels,
 		Networks: networks,
+		Ports:    sortPorts(ports),
 		Created:  time.Unix(c.Created, 0),
 	}
 
@@ -163,14 +261,33 @@ func (d *DockerClient) convertContainer(c *container.Summary) (*Info, error) {
 }
 
 // convertContainerJSON converts a Docker container JSON to our Info struct
-func (d *DockerClient) convertContainerJSON(c container.InspectResponse) *Info {
+func (d *DockerClient) convertContainerJSON(c container.InspectResponse, drivers map[string]string) *Info {
 	// Extract networks information
 	networks := make(map[string]NetworkInfo)
+	var ports []PortMapping
 	if c.NetworkSettings != nil {
-		for networkName, network := range c.NetworkSettings.Networks {
+		for networkName, endpoint := range c.NetworkSettings.Networks {
 			networks[networkName] = NetworkInfo{
-				IPAddress: network.IPAddress,
-				Gateway:   network.Gateway,
+				IPAddress:  endpoint.IPAddress,
+				Gateway:    endpoint.Gateway,
+				MacAddress: endpoint.MacAddress,
+				Driver:     drivers[networkName],
+			}
+		}
+
+		// Extract published ports
+		for port, bindings := range c.NetworkSettings.Ports {
+			for _, binding := range bindings {
+				publicPort, err := strconv.Atoi(binding.HostPort)
+				if err != nil {
+					continue
+				}
+				ports = append(ports, PortMapping{
+					HostIP:      binding.HostIP,
+					Protocol:    port.Proto(),
+					PrivatePort: port.Int(),
+					PublicPort:  publicPort,
+				})
 			}
 		}
 	}
@@ -184,11 +301,13 @@ func (d *DockerClient) convertContainerJSON(c container.InspectResponse) *Info {
 
 	containerInfo := &Info{
 		ID:       c.ID,
+		Runtime:  d.GetRuntimeName(),
 		Name:     strings.TrimPrefix(c.Name, "/"),
 		Image:    c.Config.Image,
 		State:    c.State.Status,
 		Labels:   c.Config.Labels,
 		Networks: networks,
+		Ports:    sortPorts(ports),
 		Created:  created,
 	}
 
@@ -216,11 +335,16 @@ func (d *DockerClient) handleDockerEvent(ctx context.Context, event *events.Mess
 	// Get container information
 	containerInfo, err := d.GetContainer(ctx, event.Actor.ID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get container info for event: %w", err)
+		// Destroyed containers can no longer be inspected, fall back to the event attributes
+		if eventType != EventTypeDestroy {
+			return nil, fmt.Errorf("failed to get container info for event: %w", err)
+		}
+		containerInfo = infoFromEventAttributes(event.Actor.ID, event.Actor.Attributes)
+		containerInfo.Runtime = d.GetRuntimeName()
 	}
 
 	// Only process containers with controller labels
-	if !d.hasControllerLabels(containerInfo.Labels) {
+	if !hasControllerLabels(containerInfo.Labels) {
 		return nil, nil
 	}
 
@@ -230,24 +354,3 @@ func (d *DockerClient) handleDockerEvent(ctx context.Context, event *events.Mess
 		Timestamp: time.Unix(event.Time, 0),
 	}, nil
 }
-
-// hasControllerLabels checks if the container has any controller labels
-func (d *DockerClient) hasControllerLabels(labels map[string]string) bool {
-	if labels == nil {
-		return false
-	}
-
-	enable, exists := labels[ControllerEnableLabel]
-	return exists && enable == "true"
-}
-
-// GetContainerIP returns the primary IP address of a container
-func GetContainerIP(container *Info) string {
-	// Try to get IP from the first available network
-	for _, network := range container.Networks {
-		if network.IPAddress != "" {
-			return network.IPAddress
-		}
-	}
-	return ""
-}
@@ -0,0 +1,82 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package container
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestDocker returns a Docker client talking to a Docker API served by handler
+func newTestDocker(t *testing.T, handler http.Handler) *DockerClient {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.41"))
+	if err != nil {
+		t.Fatalf("failed to create Docker client: %v", err)
+	}
+	return &DockerClient{
+		client:      cli,
+		logger:      logrus.WithField("runtime", "docker"),
+		streamGrace: 20 * time.Millisecond,
+	}
+}
+
+func TestDockerClient_WatchContainersSubscriptionFails(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1.41/events", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, `{"message":"events unavailable"}`, http.StatusInternalServerError)
+	})
+	client := newTestDocker(t, mux)
+
+	var connected atomic.Bool
+	if err := client.WatchContainers(context.Background(), make(chan Event), func() { connected.Store(true) }); err == nil {
+		t.Error("WatchContainers() error = nil, want the failed subscription reported")
+	}
+	if connected.Load() {
+		t.Error("WatchContainers() reported a stream as established that failed to subscribe")
+	}
+}
+
+func TestDockerClient_WatchContainersConnected(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1.41/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	client := newTestDocker(t, mux)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	var connected atomic.Bool
+	go func() {
+		done <- client.WatchContainers(ctx, make(chan Event), func() {
+			connected.Store(true)
+			cancel()
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		cancel()
+		t.Fatal("WatchContainers() did not return")
+	}
+	if !connected.Load() {
+		t.Error("WatchContainers() did not report the stream as established")
+	}
+}
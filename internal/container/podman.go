@@ -0,0 +1,517 @@
+package container
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// PodmanRootfulSocket is the socket of the system-wide Podman service
+	PodmanRootfulSocket = "/run/podman/podman.sock"
+
+	// podmanAPIBase is the base path of the libpod REST API
+	podmanAPIBase = "http://podman/v4.0.0/libpod"
+)
+
+// PodmanClient implements the RuntimeClient interface for Podman using the libpod REST API
+type PodmanClient struct {
+	// lastEvent is the time of the last event seen, used to resume a lost event stream
+	lastEvent    time.Time
+	httpClient   *http.Client
+	streamClient *http.Client
+	logger       *logrus.Entry
+	socketPath   string
+}
+
+// podmanContainer is a container as returned by the libpod list endpoint
+type podmanContainer struct {
+	Labels  map[string]string `json:"Labels"`
+	ID      string            `json:"Id"`
+	Image   string            `json:"Image"`
+	State   string            `json:"State"`
+	Status  string            `json:"Status"`
+	PodName string            `json:"PodName"`
+	Names   []string          `json:"Names"`
+}
+
+// podmanInspect is a container as returned by the libpod inspect endpoint
+type podmanInspect struct {
+	Created         time.Time             `json:"Created"`
+	Config          podmanInspectConfig   `json:"Config"`
+	State           podmanInspectState    `json:"State"`
+	NetworkSettings podmanNetworkSettings `json:"NetworkSettings"`
+	ID              string                `json:"Id"`
+	Name            string                `json:"Name"`
+	ImageName       string                `json:"ImageName"`
+	Pod             string                `json:"Pod"`
+}
+
+// podmanInspectConfig is the configuration section of a libpod container inspect
+type podmanInspectConfig struct {
+	Labels map[string]string `json:"Labels"`
+}
+
+// podmanInspectState is the state section of a libpod container inspect
+type podmanInspectState struct {
+	Status string `json:"Status"`
+}
+
+// podmanNetworkSettings is the network section of a libpod container inspect
+type podmanNetworkSettings struct {
+	Networks  map[string]podmanNetwork       `json:"Networks"`
+	Ports     map[string][]podmanPortBinding `json:"Ports"`
+	IPAddress string                         `json:"IPAddress"`
+	Gateway   string                         `json:"Gateway"`
+}
+
+// podmanPortBinding is a host binding of a published Podman container port
+type podmanPortBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+// podmanNetwork is a network a Podman container is attached to
+type podmanNetwork struct {
+	IPAddress  string `json:"IPAddress"`
+	Gateway    string `json:"Gateway"`
+	MacAddress string `json:"MacAddress"`
+}
+
+// podmanNetworkSummary is a network as returned by the libpod network list endpoint
+type podmanNetworkSummary struct {
+	Name   string `json:"name"`
+	Driver string `json:"driver"`
+}
+
+// podmanPod is a pod as returned by the libpod pod inspect endpoint
+type podmanPod struct {
+	Name             string `json:"Name"`
+	InfraContainerID string `json:"InfraContainerID"`
+}
+
+// podmanEvent is an event as streamed by the libpod events endpoint
+type podmanEvent struct {
+	Actor struct {
+		Attributes map[string]string `json:"Attributes"`
+		ID         string            `json:"ID"`
+	} `json:"Actor"`
+	Type     string `json:"Type"`
+	Action   string `json:"Action"`
+	Time     int64  `json:"time"`
+	TimeNano int64  `json:"timeNano"`
+}
+
+// NewPodmanClient creates a new Podman client for the first Podman socket found.
+// CONTAINER_HOST is honoured, then the rootful socket and the rootless socket of the
+// current user are tried.
+func NewPodmanClient() (*PodmanClient, error) {
+	socketPath := findPodmanSocket()
+	if socketPath == "" {
+		return nil, fmt.Errorf("no Podman socket found")
+	}
+
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "unix", socketPath)
+	}
+
+	return &PodmanClient{
+		socketPath: socketPath,
+		httpClient: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{DialContext: dial},
+		},
+		// The event stream stays open, so it must not be subject to a request timeout
+		streamClient: &http.Client{
+			Transport: &http.Transport{DialContext: dial},
+		},
+		logger: logrus.WithField("runtime", "podman"),
+	}, nil
+}
+
+// findPodmanSocket returns the path of the first existing Podman socket
+func findPodmanSocket() string {
+	candidates := []string{}
+	if host := os.Getenv("CONTAINER_HOST"); strings.HasPrefix(host, "unix://") {
+		candidates = append(candidates, strings.TrimPrefix(host, "unix://"))
+	}
+	candidates = append(candidates, PodmanRootfulSocket)
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		candidates = append(candidates, filepath.Join(runtimeDir, "podman", "podman.sock"))
+	}
+
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// SocketPath returns the path of the Podman socket the client connects to
+func (p *PodmanClient) SocketPath() string {
+	return p.socketPath
+}
+
+// IsAvailable checks if Podman is available
+func (p *PodmanClient) IsAvailable() bool {
+	if _, err := os.Stat(p.socketPath); os.IsNotExist(err) {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return p.get(ctx, "/_ping", nil, nil) == nil
+}
+
+// GetRuntimeName returns the name of the container runtime
+func (p *PodmanClient) GetRuntimeName() string {
+	return "podman"
+}
+
+// ListContainers returns all containers with pfSense controller labels. If a container cannot
+// be inspected, the other containers are returned together with an error, as the list is
+// incomplete and must not be treated as the full set of containers.
+func (p *PodmanClient) ListContainers(ctx context.Context) ([]*Info, error) {
+	query := url.Values{}
+	query.Set("all", "true")
+	query.Set("filters", podmanFilters(map[string][]string{
+		"label": {ControllerEnableLabel + "=true"},
+	}))
+
+	var containers []podmanContainer
+	if err := p.get(ctx, "/containers/json", query, &containers); err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	drivers, err := p.networkDrivers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*Info
+	var errs []error
+	for i := range containers {
+		// The list endpoint carries no network addresses, so every container is inspected
+		containerInfo, err := p.inspectContainer(ctx, containers[i].ID, drivers)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		containerInfo.Status = containers[i].Status
+		result = append(result, containerInfo)
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// GetContainer returns detailed information about a specific container
+func (p *PodmanClient) GetContainer(ctx context.Context, id string) (*Info, error) {
+	drivers, err := p.networkDrivers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.inspectContainer(ctx, id, drivers)
+}
+
+// networkDrivers returns the drivers of the Podman networks by network name. Containers are
+// not converted without them, as a container on a macvlan network would look like one on a
+// bridge network and lose its DHCP static mappings.
+func (p *PodmanClient) networkDrivers(ctx context.Context) (map[string]string, error) {
+	var networks []podmanNetworkSummary
+	if err := p.get(ctx, "/networks/json", nil, &networks); err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+
+	drivers := make(map[string]string, len(networks))
+	for _, n := range networks {
+		drivers[n.Name] = n.Driver
+	}
+	return drivers, nil
+}
+
+// inspectContainer inspects a container, looking up the drivers of its networks in drivers
+func (p *PodmanClient) inspectContainer(ctx context.Context, id string, drivers map[string]string) (*Info, error) {
+	var inspect podmanInspect
+	if err := p.get(ctx, "/containers/"+url.PathEscape(id)+"/json", nil, &inspect); err != nil {
+		return nil, fmt.Errorf("failed to inspect container %s: %w", id, err)
+	}
+
+	containerInfo := &Info{
+		ID:       inspect.ID,
+		Runtime:  p.GetRuntimeName(),
+		Name:     strings.TrimPrefix(inspect.Name, "/"),
+		Image:    inspect.ImageName,
+		State:    inspect.State.Status,
+		Labels:   inspect.Config.Labels,
+		Networks: convertPodmanNetworks(&inspect.NetworkSettings, drivers),
+		Ports:    convertPodmanPorts(&inspect.NetworkSettings),
+		Created:  inspect.Created,
+	}
+
+	// Containers in a pod share the network namespace of the pod's infra container,
+	// which is where the addresses are reported
+	if inspect.Pod != "" {
+		if err := p.addPodInfo(ctx, containerInfo, inspect.Pod, drivers); err != nil {
+			return nil, fmt.Errorf("failed to get pod of container %s: %w", containerInfo.Name, err)
+		}
+	}
+
+	return containerInfo, nil
+}
+
+// addPodInfo adds the pod name and, if the container has none of its own, the networks and
+// published ports of the pod's infra container to the container information
+func (p *PodmanClient) addPodInfo(ctx context.Context, containerInfo *Info, podID string, drivers map[string]string) error {
+	var pod podmanPod
+	if err := p.get(ctx, "/pods/"+url.PathEscape(podID)+"/json", nil, &pod); err != nil {
+		return err
+	}
+	containerInfo.Pod = pod.Name
+
+	if len(containerInfo.Networks) > 0 || pod.InfraContainerID == "" || pod.InfraContainerID == containerInfo.ID {
+		return nil
+	}
+
+	var infra podmanInspect
+	if err := p.get(ctx, "/containers/"+url.PathEscape(pod.InfraContainerID)+"/json", nil, &infra); err != nil {
+		return fmt.Errorf("failed to inspect infra container: %w", err)
+	}
+	containerInfo.Networks = convertPodmanNetworks(&infra.NetworkSettings, drivers)
+	containerInfo.Ports = convertPodmanPorts(&infra.NetworkSettings)
+
+	return nil
+}
+
+// WatchContainers watches for container events until the stream fails or the context is canceled.
+// When called again after a failure, it resumes after the last event seen.
+func (p *PodmanClient) WatchContainers(ctx context.Context, eventChan chan<- Event, connected func()) error {
+	if p.lastEvent.IsZero() {
+		p.lastEvent = time.Now()
+	}
+
+	// Since is inclusive, so start right after the last event to not see it again
+	query := url.Values{}
+	query.Set("stream", "true")
+	query.Set("since", p.lastEvent.Add(time.Nanosecond).Format(time.RFC3339Nano))
+	query.Set("filters", podmanFilters(map[string][]string{
+		"type":  {"container"},
+		"label": {ControllerEnableLabel + "=true"},
+	}))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, podmanAPIBase+"/events?"+query.Encode(), http.NoBody)
+	if err != nil {
+		return fmt.Errorf("failed to create events request: %w", err)
+	}
+
+	resp, err := p.streamClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Podman events stream error: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			p.logger.Debugf("Failed to close events stream: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Podman events stream returned status %d", resp.StatusCode)
+	}
+	connected()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event podmanEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			p.logger.Errorf("Failed to decode Podman event: %v", err)
+			continue
+		}
+		switch {
+		case event.TimeNano > 0:
+			p.lastEvent = time.Unix(0, event.TimeNano)
+		case event.Time > 0:
+			p.lastEvent = time.Unix(event.Time, 0)
+		}
+
+		containerEvent, err := p.handlePodmanEvent(ctx, &event)
+		if err != nil {
+			p.logger.Errorf("Failed to handle Podman event: %v", err)
+			continue
+		}
+		if containerEvent != nil {
+			select {
+			case eventChan <- *containerEvent:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Podman events stream error: %w", err)
+	}
+	return fmt.Errorf("Podman events stream closed")
+}
+
+// handlePodmanEvent processes a Podman event and converts it to our Event
+func (p *PodmanClient) handlePodmanEvent(ctx context.Context, event *podmanEvent) (*Event, error) {
+	var eventType EventType
+
+	switch event.Action {
+	case "start":
+		eventType = EventTypeStart
+	case "died":
+		// Podman reports every container exit as died, stop is only sent for explicit
+		// stops and follows the died event of the same container
+		eventType = EventTypeStop
+	case "remove":
+		eventType = EventTypeDestroy
+	case "update":
+		eventType = EventTypeUpdate
+	default:
+		// Skip events we don't care about
+		return nil, nil
+	}
+
+	// Get container information
+	containerInfo, err := p.GetContainer(ctx, event.Actor.ID)
+	if err != nil {
+		// Removed containers can no longer be inspected, fall back to the event attributes
+		if eventType != EventTypeDestroy {
+			return nil, fmt.Errorf("failed to get container info for event: %w", err)
+		}
+		containerInfo = infoFromEventAttributes(event.Actor.ID, event.Actor.Attributes)
+	}
+
+	// Only process containers with controller labels
+	if !hasControllerLabels(containerInfo.Labels) {
+		return nil, nil
+	}
+
+	return &Event{
+		Type:      eventType,
+		Container: containerInfo,
+		Timestamp: time.Unix(event.Time, 0),
+	}, nil
+}
+
+// get performs a GET request against the libpod API and decodes the JSON response into out
+func (p *PodmanClient) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	endpoint := podmanAPIBase + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, http.NoBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			p.logger.Debugf("Failed to close response body: %v", closeErr)
+		}
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("Podman API request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// convertPodmanNetworks converts the network settings of a Podman container
+func convertPodmanNetworks(settings *podmanNetworkSettings, drivers map[string]string) map[string]NetworkInfo {
+	networks := make(map[string]NetworkInfo)
+	for networkName, network := range settings.Networks {
+		networks[networkName] = NetworkInfo{
+			IPAddress:  network.IPAddress,
+			Gateway:    network.Gateway,
+			MacAddress: network.MacAddress,
+			Driver:     drivers[networkName],
+		}
+	}
+
+	// Older Podman versions only report the address of the default network
+	if len(networks) == 0 && settings.IPAddress != "" {
+		networks["podman"] = NetworkInfo{
+			IPAddress: settings.IPAddress,
+			Gateway:   settings.Gateway,
+		}
+	}
+
+	return networks
+}
+
+// convertPodmanPorts converts the published ports of a Podman container
+func convertPodmanPorts(settings *podmanNetworkSettings) []PortMapping {
+	var ports []PortMapping
+	for port, bindings := range settings.Ports {
+		privatePort, protocol, _ := strings.Cut(port, "/")
+		containerPort, err := strconv.Atoi(privatePort)
+		if err != nil {
+			continue
+		}
+		if protocol == "" {
+			protocol = "tcp"
+		}
+
+		for _, binding := range bindings {
+			publicPort, err := strconv.Atoi(binding.HostPort)
+			if err != nil {
+				continue
+			}
+			ports = append(ports, PortMapping{
+				HostIP:      binding.HostIP,
+				Protocol:    protocol,
+				PrivatePort: containerPort,
+				PublicPort:  publicPort,
+			})
+		}
+	}
+	return sortPorts(ports)
+}
+
+// podmanFilters encodes filters in the JSON format expected by the libpod API
+func podmanFilters(filters map[string][]string) string {
+	encoded, err := json.Marshal(filters)
+	if err != nil {
+		return "{}"
+	}
+	return string(encoded)
+}
@@ -0,0 +1,228 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newTestPodman returns a Podman client talking to a libpod API served by handler
+func newTestPodman(t *testing.T, handler http.Handler) *PodmanClient {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "tcp", server.Listener.Addr().String())
+	}
+	return &PodmanClient{
+		httpClient:   &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{DialContext: dial}},
+		streamClient: &http.Client{Transport: &http.Transport{DialContext: dial}},
+		logger:       logrus.WithField("runtime", "podman"),
+	}
+}
+
+// writeJSON writes value as a JSON response
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// libpodMux returns a libpod API serving a container on a bridge network with a published
+// port, a container in a pod without networks of its own, and a container that cannot be
+// inspected. The network list fails if networksDown is set.
+func libpodMux(networksDown bool) *http.ServeMux {
+	labels := map[string]string{ControllerEnableLabel: "true"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v4.0.0/libpod/containers/json", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, []map[string]any{
+			{"Id": "web-id", "Names": []string{"web"}, "State": "running", "Status": "Up 5 minutes"},
+			{"Id": "app-id", "Names": []string{"app"}, "State": "running", "Status": "Up 1 minute"},
+			{"Id": "broken-id", "Names": []string{"broken"}, "State": "running"},
+		})
+	})
+	mux.HandleFunc("/v4.0.0/libpod/containers/web-id/json", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, map[string]any{
+			"Id":        "web-id",
+			"Name":      "web",
+			"ImageName": "nginx:latest",
+			"State":     map[string]any{"Status": "running"},
+			"Config":    map[string]any{"Labels": labels},
+			"NetworkSettings": map[string]any{
+				"Networks": map[string]any{
+					"podman": map[string]string{"IPAddress": "10.88.0.2", "Gateway": "10.88.0.1", "MacAddress": "02:42:0a:58:00:02"},
+				},
+				"Ports": map[string]any{
+					"80/tcp": []map[string]string{{"HostIp": "", "HostPort": "8080"}},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/v4.0.0/libpod/containers/app-id/json", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, map[string]any{
+			"Id":     "app-id",
+			"Name":   "app",
+			"Pod":    "pod-id",
+			"State":  map[string]any{"Status": "running"},
+			"Config": map[string]any{"Labels": labels},
+		})
+	})
+	mux.HandleFunc("/v4.0.0/libpod/containers/infra-id/json", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, map[string]any{
+			"Id": "infra-id",
+			"NetworkSettings": map[string]any{
+				"Networks": map[string]any{
+					"lan": map[string]string{"IPAddress": "192.168.1.60", "MacAddress": "02:42:c0:a8:01:3c"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/v4.0.0/libpod/containers/broken-id/json", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "container storage is corrupted", http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/v4.0.0/libpod/pods/pod-id/json", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, map[string]string{"Name": "app-pod", "InfraContainerID": "infra-id"})
+	})
+	mux.HandleFunc("/v4.0.0/libpod/networks/json", func(w http.ResponseWriter, _ *http.Request) {
+		if networksDown {
+			http.Error(w, "network backend unavailable", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, []map[string]string{
+			{"name": "podman", "driver": "bridge"},
+			{"name": "lan", "driver": NetworkDriverMacvlan},
+		})
+	})
+	return mux
+}
+
+func TestPodmanClient_ListContainers(t *testing.T) {
+	client := newTestPodman(t, libpodMux(false))
+
+	// The containers that could be inspected are returned together with the error
+	containers, err := client.ListContainers(context.Background())
+	if err == nil {
+		t.Error("ListContainers() error = nil, want the container that could not be inspected reported")
+	}
+	if len(containers) != 2 {
+		t.Fatalf("ListContainers() returned %d containers, want 2", len(containers))
+	}
+
+	web := containers[0]
+	if web.ID != "web-id" || web.Name != "web" || web.Image != "nginx:latest" || web.Status != "Up 5 minutes" {
+		t.Errorf("web = %+v, want details from inspect and status from the list", web)
+	}
+	wantNetwork := NetworkInfo{IPAddress: "10.88.0.2", Gateway: "10.88.0.1", MacAddress: "02:42:0a:58:00:02", Driver: "bridge"}
+	if got := web.Networks["podman"]; got != wantNetwork {
+		t.Errorf("web network = %+v, want %+v", got, wantNetwork)
+	}
+	wantPorts := []PortMapping{{Protocol: "tcp", PrivatePort: 80, PublicPort: 8080}}
+	if !slices.Equal(web.Ports, wantPorts) {
+		t.Errorf("web ports = %+v, want %+v", web.Ports, wantPorts)
+	}
+
+	// Containers in a pod take the networks of the pod's infra container
+	app := containers[1]
+	if app.Pod != "app-pod" {
+		t.Errorf("app pod = %q, want app-pod", app.Pod)
+	}
+	if got := app.Networks["lan"]; got.IPAddress != "192.168.1.60" || got.Driver != NetworkDriverMacvlan {
+		t.Errorf("app networks = %+v, want the macvlan network of the infra container", app.Networks)
+	}
+}
+
+func TestPodmanClient_ListContainersWithoutNetworkDrivers(t *testing.T) {
+	client := newTestPodman(t, libpodMux(true))
+
+	// Without the drivers a macvlan container would look bridged, so nothing is returned
+	if containers, err := client.ListContainers(context.Background()); err == nil || len(containers) != 0 {
+		t.Errorf("ListContainers() = %d containers, %v, want an error when network drivers cannot be looked up",
+			len(containers), err)
+	}
+}
+
+func TestPodmanClient_GetContainerWithoutNetworkDrivers(t *testing.T) {
+	client := newTestPodman(t, libpodMux(true))
+
+	if _, err := client.GetContainer(context.Background(), "web-id"); err == nil {
+		t.Error("GetContainer() error = nil, want an error when network drivers cannot be looked up")
+	}
+}
+
+func TestPodmanClient_WatchContainers(t *testing.T) {
+	// Events are streamed one JSON object per line
+	stream := []map[string]any{
+		{"Type": "container", "Action": "died", "time": 1700000000, "timeNano": 1700000000000000001,
+			"Actor": map[string]any{"ID": "web-id"}},
+		{"Type": "container", "Action": "stop", "time": 1700000000, "timeNano": 1700000000000000002,
+			"Actor": map[string]any{"ID": "web-id"}},
+		{"Type": "container", "Action": "remove", "time": 1700000001, "timeNano": 1700000001000000000,
+			"Actor": map[string]any{"ID": "gone-id", "Attributes": map[string]string{
+				"name":                "gone",
+				"image":               "nginx:latest",
+				ControllerEnableLabel: "true",
+			}}},
+	}
+
+	var mu sync.Mutex
+	var since []string
+	mux := libpodMux(false)
+	mux.HandleFunc("/v4.0.0/libpod/events", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		since = append(since, r.URL.Query().Get("since"))
+		mu.Unlock()
+		for _, event := range stream {
+			writeJSON(w, event)
+		}
+	})
+	mux.HandleFunc("/v4.0.0/libpod/containers/gone-id/json", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "no such container", http.StatusNotFound)
+	})
+	client := newTestPodman(t, mux)
+
+	eventChan := make(chan Event, len(stream))
+	connected := false
+	err := client.WatchContainers(context.Background(), eventChan, func() { connected = true })
+	if err == nil {
+		t.Error("WatchContainers() error = nil, want the closed stream reported")
+	}
+	if !connected {
+		t.Error("WatchContainers() did not report the stream as established")
+	}
+	close(eventChan)
+
+	var got []string
+	for event := range eventChan {
+		got = append(got, fmt.Sprintf("%s %s", event.Type, event.Container.Name))
+	}
+	want := []string{"stop web", "destroy gone"}
+	if !slices.Equal(got, want) {
+		t.Errorf("WatchContainers() sent %v, want %v", got, want)
+	}
+
+	// The stream resumes right after the last event seen
+	if err := client.WatchContainers(context.Background(), make(chan Event, len(stream)), func() {}); err == nil {
+		t.Error("WatchContainers() again error = nil, want the closed stream reported")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if wantSince := "2023-11-14T22:13:21.000000001Z"; len(since) != 2 || since[1] != wantSince {
+		t.Errorf("since = %v, want the second stream to start at %s", since, wantSince)
+	}
+}
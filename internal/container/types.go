@@ -2,6 +2,9 @@ package container
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -12,17 +15,31 @@ type Info struct {
 	Created  time.Time              `json:"created"`
 	Labels   map[string]string      `json:"labels"`
 	Networks map[string]NetworkInfo `json:"networks"`
+	Ports    []PortMapping          `json:"ports,omitempty"`
 	ID       string                 `json:"id"`
+	Runtime  string                 `json:"runtime"`
 	Name     string                 `json:"name"`
 	Image    string                 `json:"image"`
+	Pod      string                 `json:"pod,omitempty"`
 	State    string                 `json:"state"`
 	Status   string                 `json:"status"`
 }
 
 // NetworkInfo represents network information for a container
 type NetworkInfo struct {
-	IPAddress string `json:"ip_address"`
-	Gateway   string `json:"gateway"`
+	IPAddress  string `json:"ip_address"`
+	Gateway    string `json:"gateway"`
+	MacAddress string `json:"mac_address,omitempty"`
+	// Driver is the driver of the network, such as bridge or macvlan
+	Driver string `json:"driver,omitempty"`
+}
+
+// PortMapping represents a container port published on the host
+type PortMapping struct {
+	HostIP      string `json:"host_ip,omitempty"`
+	Protocol    string `json:"protocol"`
+	PrivatePort int    `json:"private_port"`
+	PublicPort  int    `json:"public_port,omitempty"`
 }
 
 // Event represents a container event
@@ -44,15 +61,34 @@ const (
 	EventTypeDestroy EventType = "destroy"
 	// EventTypeUpdate represents a container update event
 	EventTypeUpdate EventType = "update"
+	// EventTypeResync requests a full resync after events may have been missed.
+	// Resync events carry no container.
+	EventTypeResync EventType = "resync"
+)
+
+const (
+	// minWatchBackoff is the initial delay before reconnecting a lost event stream
+	minWatchBackoff = time.Second
+	// maxWatchBackoff is the maximum delay before reconnecting a lost event stream
+	maxWatchBackoff = 30 * time.Second
 )
 
+// WatcherState describes the state of the event watcher of a container runtime
+type WatcherState struct {
+	ConnectedAt time.Time `json:"connected_at"`
+	LastError   string    `json:"last_error,omitempty"`
+	Reconnects  int64     `json:"reconnects"`
+	Connected   bool      `json:"connected"`
+}
+
 // RuntimeClient represents a container runtime client
 type RuntimeClient interface {
 	// ListContainers returns all containers with pfSense controller labels
 	ListContainers(ctx context.Context) ([]*Info, error)
 
-	// WatchContainers watches for container events
-	WatchContainers(ctx context.Context, eventChan chan<- Event) error
+	// WatchContainers watches for container events. It calls connected once the event
+	// stream is established.
+	WatchContainers(ctx context.Context, eventChan chan<- Event, connected func()) error
 
 	// GetContainer returns detailed information about a specific container
 	GetContainer(ctx context.Context, id string) (*Info, error)
@@ -66,15 +102,22 @@ type RuntimeClient interface {
 
 // Manager manages multiple container runtime clients
 type Manager struct {
-	logger  *logrus.Entry
-	clients []RuntimeClient
+	logger     *logrus.Entry
+	watchers   map[string]*WatcherState
+	clients    []RuntimeClient
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	mu         sync.RWMutex
 }
 
 // NewManager creates a new container runtime manager
 func NewManager() *Manager {
 	return &Manager{
-		clients: make([]RuntimeClient, 0),
-		logger:  logrus.WithField("component", "container-manager"),
+		clients:    make([]RuntimeClient, 0),
+		watchers:   make(map[string]*WatcherState),
+		minBackoff: minWatchBackoff,
+		maxBackoff: maxWatchBackoff,
+		logger:     logrus.WithField("component", "container-manager"),
 	}
 }
 
@@ -85,34 +128,130 @@ func (m *Manager) AddClient(client RuntimeClient) {
 	}
 }
 
-// ListContainers returns containers from all available runtimes
+// ListContainers returns containers from all available runtimes.
+// If a runtime fails, the containers of the other runtimes are returned together with an
+// error, as the list is incomplete and must not be treated as the full set of containers.
 func (m *Manager) ListContainers(ctx context.Context) ([]*Info, error) {
 	var allContainers []*Info
+	var errs []error
 
 	for _, client := range m.clients {
 		containers, err := client.ListContainers(ctx)
 		if err != nil {
 			// Log error but continue with other clients
+			m.logger.Errorf("Failed to list %s containers: %v", client.GetRuntimeName(), err)
+			errs = append(errs, fmt.Errorf("%s: %w", client.GetRuntimeName(), err))
 			continue
 		}
 		allContainers = append(allContainers, containers...)
 	}
 
-	return allContainers, nil
+	return allContainers, errors.Join(errs...)
 }
 
-// WatchContainers watches for container events from all available runtimes
+// WatchContainers watches for container events from all available runtimes.
+// Lost event streams are reconnected with backoff, after which a resync event is sent
+// because events may have been missed in the meantime.
 func (m *Manager) WatchContainers(ctx context.Context, eventChan chan<- Event) error {
 	for _, client := range m.clients {
-		go func(c RuntimeClient) {
-			if err := c.WatchContainers(ctx, eventChan); err != nil {
-				m.logger.Errorf("Container runtime client error: %v", err)
-			}
-		}(client)
+		m.mu.Lock()
+		m.watchers[client.GetRuntimeName()] = &WatcherState{}
+		m.mu.Unlock()
+
+		go m.watchRuntime(ctx, client, eventChan)
 	}
 	return nil
 }
 
+// watchRuntime watches the events of a single runtime until the context is canceled
+func (m *Manager) watchRuntime(ctx context.Context, client RuntimeClient, eventChan chan<- Event) {
+	runtime := client.GetRuntimeName()
+	backoff := m.minBackoff
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			m.logger.Warnf("Reconnecting to %s events in %v", runtime, backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, m.maxBackoff)
+
+			if !client.IsAvailable() {
+				m.setWatcherDisconnected(runtime, fmt.Errorf("%s is not available", runtime))
+				continue
+			}
+		}
+
+		// The watcher only counts as connected, and a reconnect only asks for a resync, once
+		// the event stream is established
+		reconnect := attempt > 0
+		connected := func() {
+			m.setWatcherConnected(runtime, reconnect)
+			if reconnect {
+				m.logger.Infof("Reconnected to %s events, requesting resync", runtime)
+				select {
+				case eventChan <- Event{Type: EventTypeResync, Timestamp: time.Now()}:
+				case <-ctx.Done():
+				}
+			}
+		}
+
+		started := time.Now()
+		err := client.WatchContainers(ctx, eventChan, connected)
+		if ctx.Err() != nil {
+			return
+		}
+
+		m.logger.Errorf("Container runtime %s event stream failed: %v", runtime, err)
+		m.setWatcherDisconnected(runtime, err)
+
+		// Start over with a short delay if the stream was healthy for a while
+		if time.Since(started) > m.maxBackoff {
+			backoff = m.minBackoff
+		}
+	}
+}
+
+// setWatcherConnected marks the watcher of a runtime as connected
+func (m *Manager) setWatcherConnected(runtime string, reconnect bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := m.watchers[runtime]
+	state.Connected = true
+	state.ConnectedAt = time.Now()
+	state.LastError = ""
+	if reconnect {
+		state.Reconnects++
+	}
+}
+
+// setWatcherDisconnected marks the watcher of a runtime as disconnected
+func (m *Manager) setWatcherDisconnected(runtime string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := m.watchers[runtime]
+	state.Connected = false
+	if err != nil {
+		state.LastError = err.Error()
+	}
+}
+
+// WatcherStates returns the state of the event watcher of every runtime being watched
+func (m *Manager) WatcherStates() map[string]WatcherState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	states := make(map[string]WatcherState, len(m.watchers))
+	for runtime, state := range m.watchers {
+		states[runtime] = *state
+	}
+	return states
+}
+
 // GetAvailableRuntimes returns a list of available runtime names
 func (m *Manager) GetAvailableRuntimes() []string {
 	var runtimes []string
@@ -121,3 +260,32 @@ func (m *Manager) GetAvailableRuntimes() []string {
 	}
 	return runtimes
 }
+
+// infoFromEventAttributes builds container information from the actor attributes of a
+// runtime event. Docker and Podman include the container labels in the attributes, next
+// to the container name and image, which is all that is left once a container is removed.
+func infoFromEventAttributes(id string, attributes map[string]string) *Info {
+	labels := make(map[string]string, len(attributes))
+	for key, value := range attributes {
+		labels[key] = value
+	}
+
+	return &Info{
+		ID:       id,
+		Name:     attributes["name"],
+		Image:    attributes["image"],
+		State:    "removing",
+		Labels:   labels,
+		Networks: make(map[string]NetworkInfo),
+	}
+}
+
+// hasControllerLabels checks if the container has any controller labels
+func hasControllerLabels(labels map[string]string) bool {
+	if labels == nil {
+		return false
+	}
+
+	enable, exists := labels[ControllerEnableLabel]
+	return exists && enable == "true"
+}
@@ -0,0 +1,179 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package container
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWatch scripts a single call of fakeRuntime.WatchContainers
+type fakeWatch struct {
+	// ready delays establishing the stream until it is closed
+	ready  <-chan struct{}
+	err    error
+	events []Event
+	// connect establishes the stream, otherwise err is returned right away
+	connect bool
+}
+
+// fakeRuntime is a runtime whose event streams follow a script. A stream that is established
+// sends its events and fails with the scripted error, or stays open without one. Once the
+// script is used up, streams stay open until the context is canceled.
+type fakeRuntime struct {
+	watches []fakeWatch
+	starts  []time.Time
+	mu      sync.Mutex
+}
+
+func (f *fakeRuntime) ListContainers(context.Context) ([]*Info, error) { return nil, nil }
+
+func (f *fakeRuntime) GetContainer(context.Context, string) (*Info, error) { return nil, nil }
+
+func (f *fakeRuntime) GetRuntimeName() string { return "fake" }
+
+func (f *fakeRuntime) IsAvailable() bool { return true }
+
+func (f *fakeRuntime) WatchContainers(ctx context.Context, eventChan chan<- Event, connected func()) error {
+	f.mu.Lock()
+	f.starts = append(f.starts, time.Now())
+	watch := fakeWatch{connect: true}
+	if len(f.watches) > 0 {
+		watch = f.watches[0]
+		f.watches = f.watches[1:]
+	}
+	f.mu.Unlock()
+
+	if watch.ready != nil {
+		select {
+		case <-watch.ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if !watch.connect {
+		return watch.err
+	}
+	connected()
+
+	for _, event := range watch.events {
+		select {
+		case eventChan <- event:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if watch.err != nil {
+		return watch.err
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// startTimes returns the times WatchContainers was called at
+func (f *fakeRuntime) startTimes() []time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]time.Time(nil), f.starts...)
+}
+
+// newWatchManager returns a manager of the fake runtime with short reconnect delays
+func newWatchManager(runtime *fakeRuntime) *Manager {
+	m := NewManager()
+	m.minBackoff = 10 * time.Millisecond
+	m.maxBackoff = 40 * time.Millisecond
+	m.AddClient(runtime)
+	return m
+}
+
+// receive returns the next event, failing the test if none arrives in time
+func receive(t *testing.T, eventChan <-chan Event) Event {
+	t.Helper()
+
+	select {
+	case event := <-eventChan:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return Event{}
+	}
+}
+
+func TestManager_WatchReconnects(t *testing.T) {
+	lost := errors.New("stream lost")
+	runtime := &fakeRuntime{watches: []fakeWatch{
+		{connect: true, events: []Event{{Type: EventTypeStart}}, err: lost},
+		{err: errors.New("connection refused")},
+		{connect: true, err: lost},
+	}}
+	m := newWatchManager(runtime)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	eventChan := make(chan Event)
+	if err := m.WatchContainers(ctx, eventChan); err != nil {
+		t.Fatalf("WatchContainers() error = %v", err)
+	}
+
+	// Only reconnects that established the stream ask for a resync
+	for _, want := range []EventType{EventTypeStart, EventTypeResync, EventTypeResync} {
+		if event := receive(t, eventChan); event.Type != want {
+			t.Fatalf("got %s event, want %s", event.Type, want)
+		}
+	}
+
+	state := m.WatcherStates()["fake"]
+	if !state.Connected || state.Reconnects != 2 || state.LastError != "" {
+		t.Errorf("WatcherStates() = %+v, want connected after 2 reconnects", state)
+	}
+
+	// The delay between attempts doubles up to the maximum
+	starts := runtime.startTimes()
+	if len(starts) != 4 {
+		t.Fatalf("WatchContainers() called %d times, want 4", len(starts))
+	}
+	for i, want := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond} {
+		if delay := starts[i+1].Sub(starts[i]); delay < want {
+			t.Errorf("attempt %d started after %v, want at least %v", i+2, delay, want)
+		}
+	}
+}
+
+func TestManager_WatchConnectedOnceStreamEstablished(t *testing.T) {
+	ready := make(chan struct{})
+	runtime := &fakeRuntime{watches: []fakeWatch{{ready: ready, connect: true}}}
+	m := newWatchManager(runtime)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	eventChan := make(chan Event, 1)
+	if err := m.WatchContainers(ctx, eventChan); err != nil {
+		t.Fatalf("WatchContainers() error = %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if state := m.WatcherStates()["fake"]; state.Connected {
+		t.Errorf("WatcherStates() = %+v before the stream was established, want disconnected", state)
+	}
+
+	close(ready)
+	deadline := time.Now().Add(5 * time.Second)
+	for !m.WatcherStates()["fake"].Connected {
+		if time.Now().After(deadline) {
+			t.Fatal("watcher not connected after the stream was established")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The first connection needs no resync
+	select {
+	case event := <-eventChan:
+		t.Errorf("got %s event after the first connection, want none", event.Type)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
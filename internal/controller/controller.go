@@ -3,13 +3,19 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"net/http"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/KristijanL/pfsense-container-controller/internal/config"
 	"github.com/KristijanL/pfsense-container-controller/internal/container"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense/dhcp"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense/dns"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense/firewall"
 	"github.com/KristijanL/pfsense-container-controller/internal/pfsense/haproxy"
 	"github.com/sirupsen/logrus"
 )
@@ -19,6 +25,9 @@ type Controller struct {
 	config           *config.Config
 	containerManager *container.Manager
 	haproxyManager   *haproxy.Manager
+	dnsManager       *dns.Manager
+	firewallManager  *firewall.Manager
+	dhcpManager      *dhcp.Manager
 	logger           *logrus.Entry
 	healthServer     *http.Server
 	lastSyncTime     time.Time
@@ -33,22 +42,55 @@ func New(cfg *config.Config) (*Controller, error) {
 	containerManager := container.NewManager()
 
 	// Add Docker client if available
+	dockerSocket := ""
 	if dockerClient, err := container.NewDockerClient(); err == nil {
 		containerManager.AddClient(dockerClient)
+		dockerSocket = dockerClient.SocketPath()
 	} else {
 		logrus.Warnf("Docker client not available: %v", err)
 	}
 
+	// Add Podman client if a Podman socket is present. Podman's Docker-compatible socket may
+	// already be in use by the Docker client, in which case its containers are listed once.
+	if podmanClient, err := container.NewPodmanClient(); err == nil {
+		if podmanClient.SocketPath() != dockerSocket {
+			containerManager.AddClient(podmanClient)
+		}
+	} else {
+		logrus.Debugf("Podman client not available: %v", err)
+	}
+
 	// Create HAProxy manager
 	haproxyManager, err := haproxy.NewManager(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create HAProxy manager: %w", err)
 	}
 
+	// Create DNS manager
+	dnsManager, err := dns.NewManager(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DNS manager: %w", err)
+	}
+
+	// Create firewall manager
+	firewallManager, err := firewall.NewManager(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firewall manager: %w", err)
+	}
+
+	// Create DHCP manager
+	dhcpManager, err := dhcp.NewManager(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DHCP manager: %w", err)
+	}
+
 	controller := &Controller{
 		config:           cfg,
 		containerManager: containerManager,
 		haproxyManager:   haproxyManager,
+		dnsManager:       dnsManager,
+		firewallManager:  firewallManager,
+		dhcpManager:      dhcpManager,
 		logger:           logrus.WithField("component", "controller"),
 	}
 
@@ -61,6 +103,9 @@ func New(cfg *config.Config) (*Controller, error) {
 // Run starts the controller main loop
 func (c *Controller) Run(ctx context.Context) error {
 	c.logger.Info("Starting pfSense Container Controller")
+	if c.config.Global.DryRun {
+		c.logger.Warn("Dry run mode enabled, no changes will be made to pfSense")
+	}
 
 	// Log available runtimes
 	runtimes := c.containerManager.GetAvailableRuntimes()
@@ -78,7 +123,7 @@ func (c *Controller) Run(ctx context.Context) error {
 	}()
 
 	// Perform initial health check
-	c.performHealthCheck()
+	c.performHealthCheck(ctx)
 
 	// Start container event watcher
 	eventChan := make(chan container.Event, 100)
@@ -92,6 +137,15 @@ func (c *Controller) Run(ctx context.Context) error {
 	ticker := time.NewTicker(c.config.Global.PollInterval.Duration)
 	defer ticker.Stop()
 
+	// HAProxy changes of container events are applied once no event came in for the debounce
+	// window, so that a burst of events reloads HAProxy once. A steady stream of events would
+	// keep pushing the apply back, so it runs at the latest once the maximum delay since the
+	// first unapplied event passed. Full syncs apply their own changes.
+	applyTimer := time.NewTimer(0)
+	applyTimer.Stop()
+	defer applyTimer.Stop()
+	var applyDeadline time.Time
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -106,6 +160,14 @@ func (c *Controller) Run(ctx context.Context) error {
 
 		case event := <-eventChan:
 			c.handleContainerEvent(ctx, event)
+			if applyDeadline.IsZero() {
+				applyDeadline = time.Now().Add(c.config.Global.ApplyMaxDelay.Duration)
+			}
+			applyTimer.Reset(min(c.config.Global.ApplyDebounce.Duration, time.Until(applyDeadline)))
+
+		case <-applyTimer.C:
+			applyDeadline = time.Time{}
+			c.applyChanges(ctx)
 		}
 	}
 }
@@ -131,30 +193,95 @@ func (c *Controller) performSync(ctx context.Context) error {
 
 	c.logger.Infof("Found %d containers to sync", len(containers))
 
-	// Sync each container
-	for _, containerInfo := range containers {
-		if err := c.syncContainer(containerInfo); err != nil {
-			c.logger.Errorf("Failed to sync container %s: %v", containerInfo.Name, err)
-			c.incrementErrorCount()
-		}
+	// Reconcile the pfSense configuration with the desired state of all containers
+	var errs []error
+	if err := c.haproxyManager.Reconcile(ctx, containers); err != nil {
+		errs = append(errs, fmt.Errorf("failed to reconcile HAProxy configuration: %w", err))
+	}
+	if err := c.dnsManager.Reconcile(ctx, containers); err != nil {
+		errs = append(errs, fmt.Errorf("failed to reconcile DNS host overrides: %w", err))
+	}
+	if err := c.firewallManager.Reconcile(ctx, containers); err != nil {
+		errs = append(errs, fmt.Errorf("failed to reconcile firewall configuration: %w", err))
+	}
+	if err := c.dhcpManager.Reconcile(ctx, containers); err != nil {
+		errs = append(errs, fmt.Errorf("failed to reconcile DHCP static mappings: %w", err))
 	}
 
-	return nil
+	return errors.Join(errs...)
+}
+
+// Plan holds the changes a sync would make on every pfSense endpoint, by the manager making them
+type Plan struct {
+	HAProxy  []*haproxy.Plan  `json:"haproxy"`
+	DNS      []*dns.Plan      `json:"dns"`
+	Firewall []*firewall.Plan `json:"firewall"`
+	DHCP     []*dhcp.Plan     `json:"dhcp"`
+}
+
+// Plan lists all containers and returns the changes a sync would make on every pfSense
+// endpoint, without writing anything to pfSense. The plans of the managers and endpoints
+// that could be planned are returned together with any errors.
+func (c *Controller) Plan(ctx context.Context) (*Plan, error) {
+	if len(c.containerManager.GetAvailableRuntimes()) == 0 {
+		return nil, fmt.Errorf("no container runtimes available")
+	}
+
+	containers, err := c.containerManager.ListContainers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	plan := &Plan{}
+	var errs []error
+	if plan.HAProxy, err = c.haproxyManager.Plan(ctx, containers); err != nil {
+		errs = append(errs, fmt.Errorf("failed to plan HAProxy configuration: %w", err))
+	}
+	if plan.DNS, err = c.dnsManager.Plan(ctx, containers); err != nil {
+		errs = append(errs, fmt.Errorf("failed to plan DNS host overrides: %w", err))
+	}
+	if plan.Firewall, err = c.firewallManager.Plan(ctx, containers); err != nil {
+		errs = append(errs, fmt.Errorf("failed to plan firewall configuration: %w", err))
+	}
+	if plan.DHCP, err = c.dhcpManager.Plan(ctx, containers); err != nil {
+		errs = append(errs, fmt.Errorf("failed to plan DHCP static mappings: %w", err))
+	}
+
+	return plan, errors.Join(errs...)
 }
 
 // handleContainerEvent handles individual container events
-func (c *Controller) handleContainerEvent(_ context.Context, event container.Event) {
+func (c *Controller) handleContainerEvent(ctx context.Context, event container.Event) {
+	// Events may have been missed while an event stream was down, so sync everything
+	if event.Type == container.EventTypeResync {
+		c.logger.Info("Container event stream resumed, performing full resync")
+		if err := c.performSync(ctx); err != nil {
+			c.logger.Errorf("Resync failed: %v", err)
+			c.incrementErrorCount()
+		}
+		return
+	}
+
 	c.logger.Infof("Handling container event: %s for container %s", event.Type, event.Container.Name)
 
+	// In dry run mode, show what a full sync would change instead
+	if c.config.Global.DryRun {
+		if err := c.performSync(ctx); err != nil {
+			c.logger.Errorf("Dry run sync failed: %v", err)
+			c.incrementErrorCount()
+		}
+		return
+	}
+
 	switch event.Type {
 	case container.EventTypeStart, container.EventTypeUpdate:
-		if err := c.syncContainer(event.Container); err != nil {
+		if err := c.syncContainer(ctx, event.Container); err != nil {
 			c.logger.Errorf("Failed to sync container %s on %s event: %v", event.Container.Name, event.Type, err)
 			c.incrementErrorCount()
 		}
 
 	case container.EventTypeStop, container.EventTypeDestroy:
-		if err := c.haproxyManager.RemoveContainer(event.Container); err != nil {
+		if err := c.removeContainer(ctx, event.Container); err != nil {
 			c.logger.Errorf("Failed to remove container %s on %s event: %v", event.Container.Name, event.Type, err)
 			c.incrementErrorCount()
 		}
@@ -165,21 +292,44 @@ func (c *Controller) handleContainerEvent(_ context.Context, event container.Eve
 }
 
 // syncContainer synchronizes a single container
-func (c *Controller) syncContainer(containerInfo *container.Info) error {
+func (c *Controller) syncContainer(ctx context.Context, containerInfo *container.Info) error {
 	// Only sync running containers
 	if containerInfo.State != "running" {
 		c.logger.Debugf("Skipping non-running container %s (state: %s)", containerInfo.Name, containerInfo.State)
 		return nil
 	}
 
-	return c.haproxyManager.SyncContainer(containerInfo)
+	return errors.Join(
+		c.haproxyManager.SyncContainer(ctx, containerInfo),
+		c.dnsManager.SyncContainer(ctx, containerInfo),
+		c.firewallManager.SyncContainer(ctx, containerInfo),
+		c.dhcpManager.SyncContainer(ctx, containerInfo),
+	)
+}
+
+// removeContainer removes the pfSense configuration of a stopped or destroyed container
+func (c *Controller) removeContainer(ctx context.Context, containerInfo *container.Info) error {
+	return errors.Join(
+		c.haproxyManager.RemoveContainer(ctx, containerInfo),
+		c.dnsManager.RemoveContainer(ctx, containerInfo),
+		c.firewallManager.RemoveContainer(ctx, containerInfo),
+		c.dhcpManager.RemoveContainer(ctx, containerInfo),
+	)
+}
+
+// applyChanges applies the HAProxy changes staged by container events
+func (c *Controller) applyChanges(ctx context.Context) {
+	if err := c.haproxyManager.ApplyChanges(ctx); err != nil {
+		c.logger.Errorf("Failed to apply HAProxy changes: %v", err)
+		c.incrementErrorCount()
+	}
 }
 
 // performHealthCheck checks the health of all pfSense endpoints
-func (c *Controller) performHealthCheck() {
+func (c *Controller) performHealthCheck(ctx context.Context) {
 	c.logger.Debug("Performing health check")
 
-	results := c.haproxyManager.HealthCheck()
+	results := c.haproxyManager.HealthCheck(ctx)
 	healthy := 0
 	total := len(results)
 
@@ -217,8 +367,8 @@ func (c *Controller) setupHealthServer() {
 }
 
 // healthHandler handles health check requests
-func (c *Controller) healthHandler(w http.ResponseWriter, _ *http.Request) {
-	results := c.haproxyManager.HealthCheck()
+func (c *Controller) healthHandler(w http.ResponseWriter, r *http.Request) {
+	results := c.haproxyManager.HealthCheck(r.Context())
 
 	healthy := true
 	for _, err := range results {
@@ -245,6 +395,20 @@ func (c *Controller) healthHandler(w http.ResponseWriter, _ *http.Request) {
 func (c *Controller) readyHandler(w http.ResponseWriter, _ *http.Request) {
 	runtimes := c.containerManager.GetAvailableRuntimes()
 
+	// Events are missed while a watcher is disconnected
+	watchers := c.containerManager.WatcherStates()
+	for _, runtime := range slices.Sorted(maps.Keys(watchers)) {
+		state := watchers[runtime]
+		if state.Connected {
+			continue
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if _, err := fmt.Fprintf(w, "Not Ready - %s event watcher disconnected: %s\n", runtime, state.LastError); err != nil {
+			c.logger.Errorf("Failed to write ready response: %v", err)
+		}
+		return
+	}
+
 	if len(runtimes) > 0 {
 		w.WriteHeader(http.StatusOK)
 		if _, err := fmt.Fprintf(w, "Ready\n"); err != nil {
@@ -268,14 +432,14 @@ func (c *Controller) writeMetric(w http.ResponseWriter, format string, args ...i
 }
 
 // metricsHandler provides basic metrics
-func (c *Controller) metricsHandler(w http.ResponseWriter, _ *http.Request) {
+func (c *Controller) metricsHandler(w http.ResponseWriter, r *http.Request) {
 	c.mu.RLock()
 	syncCount := c.syncCount
 	errorCount := c.errorCount
 	lastSync := c.lastSyncTime
 	c.mu.RUnlock()
 
-	stats, err := c.haproxyManager.GetStats()
+	stats, err := c.haproxyManager.GetStats(r.Context())
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to get stats: %v", err), http.StatusInternalServerError)
 		return
@@ -318,6 +482,38 @@ func (c *Controller) metricsHandler(w http.ResponseWriter, _ *http.Request) {
 		}
 	}
 
+	// Write event watcher metrics
+	watchers := c.containerManager.WatcherStates()
+	if len(watchers) > 0 {
+		if !c.writeMetric(w, "# HELP pfsense_controller_watcher_connected Whether the container event watcher is connected\n") {
+			return
+		}
+		if !c.writeMetric(w, "# TYPE pfsense_controller_watcher_connected gauge\n") {
+			return
+		}
+		for _, runtime := range slices.Sorted(maps.Keys(watchers)) {
+			connected := 0
+			if watchers[runtime].Connected {
+				connected = 1
+			}
+			if !c.writeMetric(w, "pfsense_controller_watcher_connected{runtime=\"%s\"} %d\n", runtime, connected) {
+				return
+			}
+		}
+
+		if !c.writeMetric(w, "# HELP pfsense_controller_watcher_reconnects_total Total number of container event stream reconnects\n") {
+			return
+		}
+		if !c.writeMetric(w, "# TYPE pfsense_controller_watcher_reconnects_total counter\n") {
+			return
+		}
+		for _, runtime := range slices.Sorted(maps.Keys(watchers)) {
+			if !c.writeMetric(w, "pfsense_controller_watcher_reconnects_total{runtime=\"%s\"} %d\n", runtime, watchers[runtime].Reconnects) {
+				return
+			}
+		}
+	}
+
 	// Write HAProxy stats
 	for endpoint, endpointStats := range stats {
 		if statsMap, ok := endpointStats.(map[string]interface{}); ok {
@@ -344,6 +540,35 @@ func (c *Controller) metricsHandler(w http.ResponseWriter, _ *http.Request) {
 					return
 				}
 			}
+
+			if pending, ok := statsMap["pending_changes"].(int); ok {
+				if !c.writeMetric(w, "# HELP pfsense_haproxy_pending_changes Number of HAProxy changes waiting to be applied\n") {
+					return
+				}
+				if !c.writeMetric(w, "# TYPE pfsense_haproxy_pending_changes gauge\n") {
+					return
+				}
+				if !c.writeMetric(w, "pfsense_haproxy_pending_changes{endpoint=\"%s\"} %d\n", endpoint, pending) {
+					return
+				}
+			}
+
+			applies, ok := statsMap["applies"].(int)
+			applied, appliedOK := statsMap["applied_changes"].(int)
+			if ok && appliedOK {
+				if !c.writeMetric(w, "# HELP pfsense_haproxy_apply_changes Number of changes carried by each HAProxy apply\n") {
+					return
+				}
+				if !c.writeMetric(w, "# TYPE pfsense_haproxy_apply_changes summary\n") {
+					return
+				}
+				if !c.writeMetric(w, "pfsense_haproxy_apply_changes_sum{endpoint=\"%s\"} %d\n", endpoint, applied) {
+					return
+				}
+				if !c.writeMetric(w, "pfsense_haproxy_apply_changes_count{endpoint=\"%s\"} %d\n", endpoint, applies) {
+					return
+				}
+			}
 		}
 	}
 }
@@ -357,10 +582,12 @@ func (c *Controller) incrementErrorCount() {
 
 // shutdown gracefully shuts down the controller
 func (c *Controller) shutdown() error {
-	c.logger.Info("Shutting down health server")
-
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// Changes left staged would otherwise wait on pfSense for the next change to be applied
+	c.applyChanges(ctx)
+
+	c.logger.Info("Shutting down health server")
 	return c.healthServer.Shutdown(ctx)
 }
@@ -0,0 +1,181 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/config"
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense/dhcp"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense/dns"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense/firewall"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense/haproxy"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense/pfsensetest"
+	"github.com/sirupsen/logrus"
+)
+
+// startPodman serves a libpod API with a single macvlan container on a Unix socket and points
+// CONTAINER_HOST at it. The network list fails while networksDown is set.
+func startPodman(t *testing.T, networksDown *atomic.Bool) {
+	t.Helper()
+
+	writeJSON := func(w http.ResponseWriter, value any) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(value)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v4.0.0/libpod/_ping", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("OK"))
+	})
+	mux.HandleFunc("/v4.0.0/libpod/containers/json", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, []map[string]any{{"Id": "nas-id", "Names": []string{"nas"}, "State": "running"}})
+	})
+	mux.HandleFunc("/v4.0.0/libpod/containers/nas-id/json", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, map[string]any{
+			"Id":   "nas-id",
+			"Name": "nas",
+			"State": map[string]any{
+				"Status": "running",
+			},
+			"Config": map[string]any{"Labels": map[string]string{
+				container.ControllerEnableLabel:    "true",
+				"pfsense-controller.dhcp.hostname": "nas",
+			}},
+			"NetworkSettings": map[string]any{"Networks": map[string]any{
+				"lan": map[string]string{"IPAddress": "192.168.1.50", "MacAddress": "02:42:c0:a8:01:32"},
+			}},
+		})
+	})
+	mux.HandleFunc("/v4.0.0/libpod/networks/json", func(w http.ResponseWriter, _ *http.Request) {
+		if networksDown.Load() {
+			http.Error(w, "network backend unavailable", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, []map[string]string{{"name": "lan", "driver": container.NetworkDriverMacvlan}})
+	})
+
+	socket := filepath.Join(t.TempDir(), "podman.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen on %s: %v", socket, err)
+	}
+	server := httptest.NewUnstartedServer(mux)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	t.Setenv("CONTAINER_HOST", "unix://"+socket)
+}
+
+// newTestController returns a controller watching the libpod API started by startPodman and
+// managing a fake pfSense
+func newTestController(t *testing.T, server *pfsensetest.Server) *Controller {
+	t.Helper()
+
+	cfg := &config.Config{
+		Endpoints: []config.EndpointConfig{server.Endpoint("default")},
+		Global: config.GlobalConfig{
+			InstanceID:    "test",
+			AddressMode:   "container",
+			RetryAttempts: 1,
+		},
+	}
+	cfg.Global.RetryDelay.Duration = time.Millisecond
+
+	podmanClient, err := container.NewPodmanClient()
+	if err != nil {
+		t.Fatalf("NewPodmanClient() error = %v", err)
+	}
+	containerManager := container.NewManager()
+	containerManager.AddClient(podmanClient)
+
+	haproxyManager, _ := haproxy.NewManager(cfg)
+	dnsManager, _ := dns.NewManager(cfg)
+	firewallManager, _ := firewall.NewManager(cfg)
+	dhcpManager, _ := dhcp.NewManager(cfg)
+
+	return &Controller{
+		config:           cfg,
+		containerManager: containerManager,
+		haproxyManager:   haproxyManager,
+		dnsManager:       dnsManager,
+		firewallManager:  firewallManager,
+		dhcpManager:      dhcpManager,
+		logger:           logrus.WithField("component", "test"),
+	}
+}
+
+func TestController_SyncKeepsMappingsWithoutNetworkDrivers(t *testing.T) {
+	var networksDown atomic.Bool
+	startPodman(t, &networksDown)
+
+	server := pfsensetest.NewServer()
+	defer server.Close()
+	server.SetInterfaces([]pfsense.Interface{{ID: "lan", IPAddress: "192.168.1.1", Subnet: "24"}})
+
+	c := newTestController(t, server)
+	ctx := context.Background()
+
+	if err := c.performSync(ctx); err != nil {
+		t.Fatalf("performSync() error = %v", err)
+	}
+	if mappings := server.DHCPStaticMappings(); len(mappings) != 1 || mappings[0].Hostname != "nas" {
+		t.Fatalf("DHCP static mappings = %+v, want one for nas", mappings)
+	}
+
+	// Without the network drivers the macvlan container cannot be told from a bridged one, so
+	// the sync must fail rather than prune its mapping
+	networksDown.Store(true)
+	writes := len(server.Writes())
+	if err := c.performSync(ctx); err == nil {
+		t.Error("performSync() succeeded although the networks could not be listed")
+	}
+	if mappings := server.DHCPStaticMappings(); len(mappings) != 1 {
+		t.Errorf("DHCP static mappings = %+v after a failed network lookup, want the mapping kept", mappings)
+	}
+	if got := server.Writes()[writes:]; len(got) != 0 {
+		t.Errorf("sync with a failed network lookup wrote %+v, want nothing", got)
+	}
+}
+
+func TestController_Plan(t *testing.T) {
+	var networksDown atomic.Bool
+	startPodman(t, &networksDown)
+
+	server := pfsensetest.NewServer()
+	defer server.Close()
+	server.SetInterfaces([]pfsense.Interface{{ID: "lan", IPAddress: "192.168.1.1", Subnet: "24"}})
+
+	c := newTestController(t, server)
+	plan, err := c.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	// Every manager plans every endpoint, the container only needs a DHCP static mapping
+	if len(plan.HAProxy) != 1 || len(plan.DNS) != 1 || len(plan.Firewall) != 1 || len(plan.DHCP) != 1 {
+		t.Fatalf("Plan() = %+v, want a plan of every manager for the endpoint", plan)
+	}
+	if changes := plan.DHCP[0].Changes; len(changes) != 1 || changes[0].Type != pfsense.ChangeCreate || changes[0].Mapping.Hostname != "nas" {
+		t.Errorf("DHCP changes = %+v, want the static mapping of nas created", changes)
+	}
+	if len(plan.HAProxy[0].Changes)+len(plan.DNS[0].Changes)+len(plan.Firewall[0].Changes) != 0 {
+		t.Errorf("Plan() = %+v, want only DHCP changes", plan)
+	}
+	if writes := server.Writes(); len(writes) != 0 {
+		t.Errorf("Plan() wrote %+v, want nothing", writes)
+	}
+}
@@ -2,7 +2,11 @@
 package labels
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -17,6 +21,10 @@ const (
 	// ControllerEndpointLabel defines the label to specify which pfSense endpoint to use
 	ControllerEndpointLabel = "pfsense-controller.endpoint"
 
+	// ControllerAdoptLabel defines the label that allows taking over existing pfSense objects
+	// that were not created by this controller
+	ControllerAdoptLabel = "pfsense-controller.adopt"
+
 	// ControllerBackendNameLabel defines the label for HAProxy backend name
 	ControllerBackendNameLabel = "pfsense-controller.backend.name"
 	// ControllerBackendPortLabel defines the label for HAProxy backend port
@@ -29,6 +37,10 @@ const (
 	ControllerBackendCheckTypeLabel = "pfsense-controller.backend.check_type"
 	// ControllerBackendServerNameLabel defines the label for HAProxy backend server name
 	ControllerBackendServerNameLabel = "pfsense-controller.backend.server_name"
+	// ControllerBackendNetworkLabel defines the label for the network whose address is used for the backend server
+	ControllerBackendNetworkLabel = "pfsense-controller.backend.network"
+	// ControllerBackendAddressModeLabel defines the label for the address mode of the backend server
+	ControllerBackendAddressModeLabel = "pfsense-controller.backend.address_mode"
 
 	// ControllerFrontendNameLabel defines the label for HAProxy frontend name
 	ControllerFrontendNameLabel = "pfsense-controller.frontend.name"
@@ -36,9 +48,41 @@ const (
 	ControllerFrontendRuleLabel = "pfsense-controller.frontend.rule"
 	// ControllerFrontendACLNameLabel defines the label for HAProxy frontend ACL name
 	ControllerFrontendACLNameLabel = "pfsense-controller.frontend.acl_name"
+	// ControllerFrontendTLSLabel defines the label enabling SSL offloading on the HAProxy frontend
+	ControllerFrontendTLSLabel = "pfsense-controller.frontend.tls"
+	// ControllerFrontendTLSCertificateLabel defines the label for the refid or description of the
+	// pfSense certificate offered by the HAProxy frontend
+	ControllerFrontendTLSCertificateLabel = "pfsense-controller.frontend.tls.certificate"
+	// ControllerFrontendTLSCertFileLabel defines the label for the path of a PEM certificate chain
+	// imported into the pfSense certificate manager, relative paths are resolved in the secrets directory
+	ControllerFrontendTLSCertFileLabel = "pfsense-controller.frontend.tls.cert_file"
+	// ControllerFrontendTLSKeyFileLabel defines the label for the path of the PEM private key of the
+	// certificate in ControllerFrontendTLSCertFileLabel
+	ControllerFrontendTLSKeyFileLabel = "pfsense-controller.frontend.tls.key_file"
+	// ControllerTLSACMELabel defines the label for the pfSense ACME account key that issues a
+	// certificate for the hosts of the frontend rule
+	ControllerTLSACMELabel = "pfsense-controller.tls.acme"
+	// ControllerTLSACMEMethodLabel defines the label for the ACME domain validation method
+	ControllerTLSACMEMethodLabel = "pfsense-controller.tls.acme.method"
+
+	// ControllerRoutersPrefix is the prefix of named router labels,
+	// such as pfsense-controller.routers.<name>.rule
+	ControllerRoutersPrefix = "pfsense-controller.routers."
+	// ControllerServicesPrefix is the prefix of named service labels,
+	// such as pfsense-controller.services.<name>.port
+	ControllerServicesPrefix = "pfsense-controller.services."
+
+	// DefaultACMEMethod is the ACME domain validation method used by default
+	DefaultACMEMethod = "standalone"
+	// AutoFrontendPrefix is the name prefix of frontends generated by the controller
+	AutoFrontendPrefix = "auto-frontend-"
+	// AutoACLPrefix is the name prefix of ACLs generated by the controller
+	AutoACLPrefix = "auto-acl-"
 
 	// TraefikEnableLabel defines the Traefik enable label for compatibility mode
 	TraefikEnableLabel = "traefik.enable"
+	// TraefikDockerNetworkLabel defines the Traefik label for the network used to reach the container
+	TraefikDockerNetworkLabel = "traefik.docker.network"
 
 	// TrueValue represents the string "true" for label comparisons
 	TrueValue = "true"
@@ -56,24 +100,89 @@ const (
 	PathBeg = "path_beg"
 	// PathValue represents the string "path" for rule parsing
 	PathValue = "path"
+	// HostRegex represents the string "host_regex" for rule parsing
+	HostRegex = "host_regex"
+	// PathRegex represents the string "path_regex" for rule parsing
+	PathRegex = "path_regex"
+	// SourceIP represents the string "source_ip" for rule parsing
+	SourceIP = "source_ip"
+	// CustomACL represents the string "custom" for rule parsing, used for matchers
+	// without a dedicated pfSense ACL type
+	CustomACL = "custom"
+	// AddressModeContainer targets the container IP address and port
+	AddressModeContainer = "container"
+	// AddressModeHost targets the host address and the published port
+	AddressModeHost = "host"
+
+	// ControllerDNSEnableLabel defines the label to enable DNS host overrides for a container
+	ControllerDNSEnableLabel = "pfsense-controller.dns.enable"
+	// ControllerDNSHostLabel defines the label for the comma separated host names of the DNS host overrides
+	ControllerDNSHostLabel = "pfsense-controller.dns.host"
+	// ControllerDNSDomainLabel defines the label for the domain of the DNS host overrides
+	ControllerDNSDomainLabel = "pfsense-controller.dns.domain"
+	// ControllerDNSIPLabel defines the label for the IP address the DNS host overrides resolve to
+	ControllerDNSIPLabel = "pfsense-controller.dns.ip"
+
+	// ControllerDHCPHostnameLabel defines the label for the hostname of the DHCP static mappings
+	// of a container attached to macvlan or ipvlan networks
+	ControllerDHCPHostnameLabel = "pfsense-controller.dhcp.hostname"
+	// ControllerDHCPInterfaceLabel defines the label for the interface of the DHCP static mappings,
+	// by default the interface whose network contains the container address
+	ControllerDHCPInterfaceLabel = "pfsense-controller.dhcp.interface"
+
+	// ControllerFirewallAliasLabel defines the label for the comma separated firewall host aliases
+	// the container's address is added to
+	ControllerFirewallAliasLabel = "pfsense-controller.firewall.alias"
 
-	// TODO: Add DNS labels when implementing DNS parser
-	// ControllerDNSEnableLabel = "pfsense-controller.dns.enable"
-	// ControllerDNSHostLabel   = "pfsense-controller.dns.host"
-	// ControllerDNSDomainLabel = "pfsense-controller.dns.domain"
+	// ControllerNATPrefix is the prefix of NAT port forward labels,
+	// such as pfsense-controller.nat.<name>.external_port
+	ControllerNATPrefix = "pfsense-controller.nat."
 
-	// TODO: Add firewall labels when implementing firewall parser
-	// ControllerFirewallEnableLabel = "pfsense-controller.firewall.enable"
-	// ControllerFirewallRuleLabel   = "pfsense-controller.firewall.rule"
+	// DefaultNATInterface is the interface NAT port forwards listen on by default
+	DefaultNATInterface = "wan"
+	// DefaultNATProtocol is the protocol of NAT port forwards by default
+	DefaultNATProtocol = "tcp"
+
+	// ControllerFirewallRulesPrefix is the prefix of firewall rule labels,
+	// such as pfsense-controller.firewall.rules.<name>=pass tcp from LAN_NET to self port 5432
+	ControllerFirewallRulesPrefix = "pfsense-controller.firewall.rules."
+
+	// DefaultFirewallRuleInterface is the interface firewall rules apply to when neither the
+	// rule nor its source names one
+	DefaultFirewallRuleInterface = "lan"
 )
 
+// Options configures how container labels are parsed
+type Options struct {
+	// PreferredNetworks are tried in order when a container is attached to several networks
+	PreferredNetworks []string
+	// AddressMode is either AddressModeContainer or AddressModeHost
+	AddressMode string
+	// AdvertiseAddresses are the host addresses used in AddressModeHost, by runtime name
+	AdvertiseAddresses map[string]string
+	// HostAddress is the address of the container host used in AddressModeHost for runtimes
+	// without an advertise address
+	HostAddress string
+	// TraefikCompatMode enables parsing of Traefik labels
+	TraefikCompatMode bool
+}
+
 // ContainerConfig represents the parsed configuration from container labels
 type ContainerConfig struct {
+	Routes       []RouteConfig
+	EndpointName string
+	ParseMode    string
+	LabelHash    string
+	Enabled      bool
+	Adopt        bool
+}
+
+// RouteConfig represents a router of a container and the service it routes to
+type RouteConfig struct {
+	// Name is the router name, empty for the route defined by the backend and frontend labels
+	Name           string
 	BackendConfig  BackendConfig
 	FrontendConfig FrontendConfig
-	EndpointName   string
-	ParseMode      string
-	Enabled        bool
 }
 
 // BackendConfig represents HAProxy backend configuration
@@ -94,19 +203,107 @@ type FrontendConfig struct {
 	Name    string
 	Rule    string
 	ACLName string
+	// TLSCertificate is the refid or description of the pfSense certificate offered by the
+	// frontend when TLS is enabled
+	TLSCertificate string
+	// TLSCertFile and TLSKeyFile are the certificate and key imported into pfSense and offered
+	// by the frontend instead of TLSCertificate
+	TLSCertFile string
+	TLSKeyFile  string
+	// ACMEAccount is the ACME account key issuing the certificate named TLSCertificate for
+	// ACMEHosts, the hosts of the rule, validated with ACMEMethod
+	ACMEAccount string
+	ACMEMethod  string
+	ACMEHosts   []string
+	TLS         bool
+}
+
+// DNSConfig represents the DNS host overrides of a container
+type DNSConfig struct {
+	Records      []DNSRecord
+	EndpointName string
+	LabelHash    string
+	Enabled      bool
+	Adopt        bool
+}
+
+// DNSRecord represents a single DNS host override
+type DNSRecord struct {
+	Host   string
+	Domain string
+	// IP is the address the host resolves to. It is empty for records derived from the
+	// frontend rule without a DNS IP label, which resolve to the HAProxy address of the endpoint.
+	IP string
+}
+
+// FQDN returns the fully qualified name of the record
+func (r *DNSRecord) FQDN() string {
+	return r.Host + "." + r.Domain
+}
+
+// DHCPConfig represents the DHCP static mappings of a container
+type DHCPConfig struct {
+	Hostname string
+	// Interface is the interface of the static mappings, empty to derive it from their address
+	Interface    string
+	Mappings     []DHCPMapping
+	EndpointName string
+	LabelHash    string
+	Adopt        bool
+}
+
+// DHCPMapping represents the address of a container on a macvlan or ipvlan network
+type DHCPMapping struct {
+	Network string
+	MAC     string
+	IP      string
+}
+
+// FirewallConfig represents the firewall configuration of a container
+type FirewallConfig struct {
+	// Aliases are the names of the host aliases containing the container's address
+	Aliases []string
+	// PortForwards are the NAT port forwards to the container
+	PortForwards []PortForwardConfig
+	// Rules are the firewall filter rules of the container
+	Rules []FirewallRule
+	// Address is the address the firewall sees the container's traffic from
+	Address      string
+	EndpointName string
+	LabelHash    string
+	Adopt        bool
 }
 
-// TODO: Add DNS configuration when implementing DNS parser
-// type DNSConfig struct {
-// 	Host   string
-// 	Domain string
-// 	IP     string
-// }
+// PortForwardConfig represents a NAT port forward to a container
+type PortForwardConfig struct {
+	Name      string
+	Interface string
+	// Protocol is tcp, udp or tcp/udp
+	Protocol string
+	// ExternalPort is the port or port range forwarded, such as 27015 or 27015-27020
+	ExternalPort string
+	// Target is the address traffic is forwarded to
+	Target string
+	// InternalPort is the port traffic is forwarded to. With a port range it is the first port.
+	InternalPort string
+	// Source restricts the forward to an address, network or alias, empty for any source
+	Source string
+}
 
-// TODO: Add firewall configuration when implementing firewall parser
-// type FirewallConfig struct {
-// 	Rules []FirewallRule
-// }
+// FirewallRule represents a firewall filter rule of a container. Addresses and ports are in
+// the form pfSense expects them, such as lan, lan:ip, an alias or a negated !10.0.0.0/8.
+type FirewallRule struct {
+	Name string
+	// Action is pass, block or reject
+	Action    string
+	Interface string
+	// Protocol is tcp, udp, tcp/udp, icmp or any
+	Protocol        string
+	Source          string
+	SourcePort      string
+	Destination     string
+	DestinationPort string
+}
 
 // getStringLabel gets a string value from labels with optional default
 func getStringLabel(labels map[string]string, key, defaultValue string) string {
@@ -116,6 +313,56 @@ func getStringLabel(labels map[string]string, key, defaultValue string) string {
 	return defaultValue
 }
 
+// labelGroupNames returns the sorted names of the label groups with the given prefix,
+// such as "web" and "api" for pfsense-controller.routers.web.rule and pfsense-controller.routers.api.rule
+func labelGroupNames(labels map[string]string, prefix string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for key := range labels {
+		rest, found := strings.CutPrefix(key, prefix)
+		if !found {
+			continue
+		}
+		name, _, found := strings.Cut(rest, ".")
+		if !found || name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// scopeLabels copies the labels of a label group into the given labels, renaming
+// them according to keys, which maps label suffixes to the labels they stand for
+func scopeLabels(scoped, labels map[string]string, prefix string, keys map[string]string) {
+	for suffix, key := range keys {
+		if value, exists := labels[prefix+suffix]; exists {
+			scoped[key] = value
+		}
+	}
+}
+
+// hashLabels returns a short stable hash of the controller and Traefik labels,
+// used to detect label changes of the container owning a pfSense object
+func hashLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		if strings.HasPrefix(key, ControllerPrefix+".") || strings.HasPrefix(key, TraefikPrefix+".") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s=%s\n", key, labels[key])
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:12]
+}
+
 // sanitizeName sanitizes a name for use in pfSense configurations
 func sanitizeName(name string) string {
 	// Replace invalid characters with hyphens
@@ -134,9 +381,9 @@ func sanitizeName(name string) string {
 
 // generateNameFromRule generates a name from a frontend rule
 func generateNameFromRule(rule string) string {
-	// Extract meaningful part from the rule
-	if expression, value, err := parseRule(rule); err == nil {
-		switch expression {
+	// Extract meaningful part from the first matcher of the rule
+	if clauses, err := parseRuleClauses(rule); err == nil {
+		switch expression, value := matcherACL(clauses[0][0].matcher); expression {
 		case HostMatches:
 			return sanitizeName(value)
 		case PathBeg, PathValue:
@@ -0,0 +1,80 @@
+package labels
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+)
+
+// hostnamePattern matches the hostnames pfSense accepts for DHCP static mappings
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+// DHCPParser handles parsing of DHCP static mapping labels
+type DHCPParser struct{}
+
+// NewDHCPParser creates a new DHCP label parser
+func NewDHCPParser() *DHCPParser {
+	return &DHCPParser{}
+}
+
+// ParseContainer parses the DHCP labels of a container into a DHCPConfig
+func (p *DHCPParser) ParseContainer(containerInfo *container.Info) (*DHCPConfig, error) {
+	return p.parseContainer(containerInfo, true)
+}
+
+// ParseContainerForRemoval parses the DHCP labels of a container that is being removed.
+// Unlike ParseContainer it does not require the container to have a macvlan or ipvlan address.
+func (p *DHCPParser) ParseContainerForRemoval(containerInfo *container.Info) (*DHCPConfig, error) {
+	return p.parseContainer(containerInfo, false)
+}
+
+// parseContainer parses the DHCP labels of a container. Every macvlan or ipvlan network the
+// container has a MAC and IP address on gets a static mapping.
+func (p *DHCPParser) parseContainer(containerInfo *container.Info, requireAddress bool) (*DHCPConfig, error) {
+	labels := containerInfo.Labels
+	if labels == nil {
+		return nil, fmt.Errorf("container has no labels")
+	}
+
+	hostname := getStringLabel(labels, ControllerDHCPHostnameLabel, "")
+	if hostname == "" {
+		return nil, fmt.Errorf("no DHCP hostname found")
+	}
+	if !hostnamePattern.MatchString(hostname) {
+		return nil, fmt.Errorf("invalid DHCP hostname '%s', must be up to 63 letters, digits or dashes", hostname)
+	}
+
+	config := &DHCPConfig{
+		Hostname:     hostname,
+		Interface:    strings.ToLower(getStringLabel(labels, ControllerDHCPInterfaceLabel, "")),
+		EndpointName: getStringLabel(labels, ControllerEndpointLabel, "default"),
+		LabelHash:    hashLabels(labels),
+		Adopt:        getStringLabel(labels, ControllerAdoptLabel, "") == TrueValue,
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(containerInfo.Networks)) {
+		network := containerInfo.Networks[name]
+		if !network.IsDirectlyAttached() || network.IPAddress == "" {
+			continue
+		}
+
+		mac, err := net.ParseMAC(network.MacAddress)
+		if err != nil {
+			// ipvlan containers share the MAC address of the host interface, which runtimes
+			// may not report
+			continue
+		}
+		config.Mappings = append(config.Mappings, DHCPMapping{Network: name, MAC: mac.String(), IP: network.IPAddress})
+	}
+
+	if len(config.Mappings) == 0 && requireAddress {
+		return nil, fmt.Errorf("container has no MAC and IP address on a macvlan or ipvlan network")
+	}
+
+	return config, nil
+}
@@ -0,0 +1,77 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package labels
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+)
+
+func TestDHCPParser_ParseContainer(t *testing.T) {
+	parser := NewDHCPParser()
+	networks := map[string]container.NetworkInfo{
+		"bridge": {IPAddress: "172.17.0.2", MacAddress: "02:42:ac:11:00:02", Driver: "bridge"},
+		"lan":    {IPAddress: "192.168.1.50", MacAddress: "02:42:C0:A8:01:32", Driver: container.NetworkDriverMacvlan},
+		"iot":    {IPAddress: "192.168.20.50", Driver: container.NetworkDriverIPvlan},
+	}
+
+	tests := []struct {
+		labels       map[string]string
+		networks     map[string]container.NetworkInfo
+		name         string
+		wantMappings []DHCPMapping
+		wantErr      bool
+	}{
+		{
+			name:     "macvlan network",
+			labels:   map[string]string{ControllerDHCPHostnameLabel: "nas"},
+			networks: networks,
+			wantMappings: []DHCPMapping{
+				{Network: "lan", MAC: "02:42:c0:a8:01:32", IP: "192.168.1.50"},
+			},
+		},
+		{
+			name:     "no hostname",
+			labels:   map[string]string{ControllerEnableLabel: "true"},
+			networks: networks,
+			wantErr:  true,
+		},
+		{
+			name:     "invalid hostname",
+			labels:   map[string]string{ControllerDHCPHostnameLabel: "nas.example.com"},
+			networks: networks,
+			wantErr:  true,
+		},
+		{
+			name:     "bridge network only",
+			labels:   map[string]string{ControllerDHCPHostnameLabel: "nas"},
+			networks: map[string]container.NetworkInfo{"bridge": networks["bridge"]},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parser.ParseContainer(&container.Info{Labels: tt.labels, Networks: tt.networks})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseContainer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !slices.Equal(config.Mappings, tt.wantMappings) {
+				t.Errorf("ParseContainer() mappings = %+v, want %+v", config.Mappings, tt.wantMappings)
+			}
+		})
+	}
+
+	// Removed containers no longer report their networks
+	info := &container.Info{Labels: map[string]string{ControllerDHCPHostnameLabel: "nas"}}
+	if _, err := parser.ParseContainerForRemoval(info); err != nil {
+		t.Errorf("ParseContainerForRemoval() error = %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package labels
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+)
+
+// DNSParser handles parsing of DNS host override labels
+type DNSParser struct {
+	options Options
+}
+
+// NewDNSParser creates a new DNS label parser
+func NewDNSParser(options Options) *DNSParser {
+	return &DNSParser{options: options}
+}
+
+// ParseContainer parses the DNS labels of a container into a DNSConfig
+func (p *DNSParser) ParseContainer(containerInfo *container.Info) (*DNSConfig, error) {
+	return p.parseContainer(containerInfo, true)
+}
+
+// ParseContainerForRemoval parses the DNS labels of a container that is being removed.
+// Unlike ParseContainer it does not require the container to have an IP address.
+func (p *DNSParser) ParseContainerForRemoval(containerInfo *container.Info) (*DNSConfig, error) {
+	return p.parseContainer(containerInfo, false)
+}
+
+// parseContainer parses the DNS labels of a container. Host names are taken from the DNS
+// host label, or derived from the Host matchers of the container's frontend rules.
+func (p *DNSParser) parseContainer(containerInfo *container.Info, requireAddress bool) (*DNSConfig, error) {
+	labels := containerInfo.Labels
+	if labels == nil {
+		return nil, fmt.Errorf("container has no labels")
+	}
+
+	if getStringLabel(labels, ControllerDNSEnableLabel, "") != TrueValue {
+		return nil, fmt.Errorf("DNS not enabled for container")
+	}
+
+	config := &DNSConfig{
+		Enabled:      true,
+		EndpointName: getStringLabel(labels, ControllerEndpointLabel, "default"),
+		LabelHash:    hashLabels(labels),
+		Adopt:        getStringLabel(labels, ControllerAdoptLabel, "") == TrueValue,
+	}
+
+	ip := getStringLabel(labels, ControllerDNSIPLabel, "")
+	if ip != "" && net.ParseIP(ip) == nil {
+		return nil, fmt.Errorf("invalid value '%s' for %s, must be an IP address", ip, ControllerDNSIPLabel)
+	}
+
+	if hosts := getStringLabel(labels, ControllerDNSHostLabel, ""); hosts != "" {
+		domain := getStringLabel(labels, ControllerDNSDomainLabel, "")
+		if domain == "" {
+			return nil, fmt.Errorf("%s is required with %s", ControllerDNSDomainLabel, ControllerDNSHostLabel)
+		}
+
+		// Explicit host names resolve to the container unless an IP is given. A network given by
+		// label must be used, falling back to another network would publish the wrong address.
+		if ip == "" {
+			network := getStringLabel(labels, ControllerBackendNetworkLabel, "")
+			if network != "" {
+				ip = container.GetNetworkIP(containerInfo, network)
+				if ip == "" && requireAddress {
+					return nil, fmt.Errorf("container has no IP address on network %s", network)
+				}
+			} else {
+				ip = container.GetContainerIP(containerInfo, p.options.PreferredNetworks...)
+				if ip == "" && requireAddress {
+					return nil, fmt.Errorf("container has no IP address for %s", ControllerDNSHostLabel)
+				}
+			}
+		}
+
+		for _, host := range parseLabelList(hosts) {
+			config.Records = append(config.Records, DNSRecord{Host: host, Domain: domain, IP: ip})
+		}
+		return config, nil
+	}
+
+	records, err := p.recordsFromRules(labels, ip)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no DNS host names found, set %s or use Host matchers in the frontend rule", ControllerDNSHostLabel)
+	}
+	config.Records = records
+
+	return config, nil
+}
+
+// recordsFromRules derives DNS records from the Host matchers of the frontend rule and the
+// rules of named routers. The first label of a host name is the host, the rest the domain.
+func (p *DNSParser) recordsFromRules(labels map[string]string, ip string) ([]DNSRecord, error) {
+	rules := []string{getStringLabel(labels, ControllerFrontendRuleLabel, "")}
+	for _, name := range labelGroupNames(labels, ControllerRoutersPrefix) {
+		rules = append(rules, getStringLabel(labels, ControllerRoutersPrefix+name+".rule", ""))
+	}
+
+	var records []DNSRecord
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		if rule == "" {
+			continue
+		}
+
+		hosts, err := ruleHosts(rule)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range hosts {
+			host, domain, found := strings.Cut(name, ".")
+			if !found || host == "" || domain == "" {
+				return nil, fmt.Errorf("host %s has no domain", name)
+			}
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			records = append(records, DNSRecord{Host: host, Domain: domain, IP: ip})
+		}
+	}
+
+	return records, nil
+}
+
+// ConvertToDNSHostOverrides converts a DNSConfig to Unbound host overrides. Records without
+// an IP resolve to the given proxy address.
+func (p *DNSParser) ConvertToDNSHostOverrides(config *DNSConfig, proxyAddress string) ([]pfsense.DNSHostOverride, error) {
+	overrides := make([]pfsense.DNSHostOverride, 0, len(config.Records))
+	for _, record := range config.Records {
+		ip := record.IP
+		if ip == "" {
+			ip = proxyAddress
+		}
+		if ip == "" {
+			return nil, fmt.Errorf("host %s resolves to the HAProxy address, but the endpoint has no proxy_address", record.FQDN())
+		}
+
+		overrides = append(overrides, pfsense.DNSHostOverride{
+			Host:   record.Host,
+			Domain: record.Domain,
+			IP:     []string{ip},
+		})
+	}
+	return overrides, nil
+}
+
+// parseLabelList splits a comma separated label value, dropping empty entries
+func parseLabelList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,143 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package labels
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+)
+
+func TestDNSParser_ParseContainer(t *testing.T) {
+	parser := NewDNSParser(Options{})
+	networks := map[string]container.NetworkInfo{
+		"bridge": {IPAddress: "172.17.0.2"},
+		"lan":    {IPAddress: "192.168.1.50"},
+	}
+
+	tests := []struct {
+		labels      map[string]string
+		name        string
+		wantRecords []string
+		wantIP      string
+		wantErr     bool
+	}{
+		{
+			name:    "not enabled",
+			labels:  map[string]string{ControllerDNSHostLabel: "app"},
+			wantErr: true,
+		},
+		{
+			name: "explicit hosts resolve to the container",
+			labels: map[string]string{
+				ControllerDNSEnableLabel: "true",
+				ControllerDNSHostLabel:   "app, www",
+				ControllerDNSDomainLabel: "home.arpa",
+			},
+			wantRecords: []string{"app.home.arpa", "www.home.arpa"},
+			wantIP:      "172.17.0.2",
+		},
+		{
+			name: "explicit hosts with IP",
+			labels: map[string]string{
+				ControllerDNSEnableLabel: "true",
+				ControllerDNSHostLabel:   "app",
+				ControllerDNSDomainLabel: "home.arpa",
+				ControllerDNSIPLabel:     "10.0.0.5",
+			},
+			wantRecords: []string{"app.home.arpa"},
+			wantIP:      "10.0.0.5",
+		},
+		{
+			name: "explicit hosts on a network",
+			labels: map[string]string{
+				ControllerDNSEnableLabel:      "true",
+				ControllerDNSHostLabel:        "app",
+				ControllerDNSDomainLabel:      "home.arpa",
+				ControllerBackendNetworkLabel: "lan",
+			},
+			wantRecords: []string{"app.home.arpa"},
+			wantIP:      "192.168.1.50",
+		},
+		{
+			name: "explicit hosts on a missing network",
+			labels: map[string]string{
+				ControllerDNSEnableLabel:      "true",
+				ControllerDNSHostLabel:        "app",
+				ControllerDNSDomainLabel:      "home.arpa",
+				ControllerBackendNetworkLabel: "lna",
+			},
+			wantErr: true,
+		},
+		{
+			name: "explicit hosts without domain",
+			labels: map[string]string{
+				ControllerDNSEnableLabel: "true",
+				ControllerDNSHostLabel:   "app",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid IP",
+			labels: map[string]string{
+				ControllerDNSEnableLabel: "true",
+				ControllerDNSHostLabel:   "app",
+				ControllerDNSDomainLabel: "home.arpa",
+				ControllerDNSIPLabel:     "not-an-ip",
+			},
+			wantErr: true,
+		},
+		{
+			name: "hosts from frontend and router rules resolve to the proxy",
+			labels: map[string]string{
+				ControllerDNSEnableLabel:               "true",
+				ControllerFrontendRuleLabel:            "Host(`App.example.com`) || Host(`www.example.com`)",
+				ControllerRoutersPrefix + "api.rule":   "Host(`api.example.com`) && PathPrefix(`/v1`)",
+				ControllerRoutersPrefix + "admin.rule": "Host(`app.example.com`)",
+			},
+			wantRecords: []string{"app.example.com", "www.example.com", "api.example.com"},
+		},
+		{
+			name: "rule host without domain",
+			labels: map[string]string{
+				ControllerDNSEnableLabel:    "true",
+				ControllerFrontendRuleLabel: "Host(`localhost`)",
+			},
+			wantErr: true,
+		},
+		{
+			name: "no hosts",
+			labels: map[string]string{
+				ControllerDNSEnableLabel:    "true",
+				ControllerFrontendRuleLabel: "PathPrefix(`/app`)",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parser.ParseContainer(&container.Info{Labels: tt.labels, Networks: networks})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseContainer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var names []string
+			for _, record := range config.Records {
+				names = append(names, record.FQDN())
+				if record.IP != tt.wantIP {
+					t.Errorf("record %s IP = %q, want %q", record.FQDN(), record.IP, tt.wantIP)
+				}
+			}
+			if len(tt.wantRecords) > 0 && !slices.Equal(names, tt.wantRecords) {
+				t.Errorf("ParseContainer() records = %v, want %v", names, tt.wantRecords)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package labels
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+)
+
+// aliasNamePattern matches the names pfSense accepts for firewall aliases
+var aliasNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,31}$`)
+
+// FirewallParser handles parsing of firewall labels
+type FirewallParser struct {
+	options Options
+}
+
+// NewFirewallParser creates a new firewall label parser
+func NewFirewallParser(options Options) *FirewallParser {
+	if options.AddressMode == "" {
+		options.AddressMode = AddressModeContainer
+	}
+	return &FirewallParser{options: options}
+}
+
+// ParseContainer parses the firewall labels of a container into a FirewallConfig
+func (p *FirewallParser) ParseContainer(containerInfo *container.Info) (*FirewallConfig, error) {
+	return p.parseContainer(containerInfo, true)
+}
+
+// ParseContainerForRemoval parses the firewall labels of a container that is being removed.
+// Unlike ParseContainer it does not require the container to have an IP address.
+func (p *FirewallParser) ParseContainerForRemoval(containerInfo *container.Info) (*FirewallConfig, error) {
+	return p.parseContainer(containerInfo, false)
+}
+
+// parseContainer parses the firewall labels of a container
+func (p *FirewallParser) parseContainer(containerInfo *container.Info, requireAddress bool) (*FirewallConfig, error) {
+	labels := containerInfo.Labels
+	if labels == nil {
+		return nil, fmt.Errorf("container has no labels")
+	}
+
+	aliases := parseLabelList(getStringLabel(labels, ControllerFirewallAliasLabel, ""))
+	forwards := labelGroupNames(labels, ControllerNATPrefix)
+	if len(aliases) == 0 && len(forwards) == 0 && !hasLabelPrefix(labels, ControllerFirewallRulesPrefix) {
+		return nil, fmt.Errorf("no firewall labels found")
+	}
+	for _, alias := range aliases {
+		if !aliasNamePattern.MatchString(alias) {
+			return nil, fmt.Errorf("invalid firewall alias name '%s', must be up to 31 letters, digits or underscores", alias)
+		}
+	}
+
+	config := &FirewallConfig{
+		Aliases:      aliases,
+		EndpointName: getStringLabel(labels, ControllerEndpointLabel, "default"),
+		LabelHash:    hashLabels(labels),
+		Adopt:        getStringLabel(labels, ControllerAdoptLabel, "") == TrueValue,
+	}
+
+	address, err := p.resolveAddress(containerInfo, labels)
+	if err != nil {
+		return nil, err
+	}
+	if address == "" && requireAddress {
+		return nil, fmt.Errorf("could not determine container address for firewall configuration")
+	}
+	config.Address = address
+
+	for _, name := range forwards {
+		forward, err := p.parsePortForward(containerInfo, labels, name, address, requireAddress)
+		if err != nil {
+			return nil, fmt.Errorf("NAT port forward %s: %w", name, err)
+		}
+		config.PortForwards = append(config.PortForwards, forward)
+	}
+
+	if config.Rules, err = parseFirewallRules(labels, address); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// hasLabelPrefix reports whether any label starts with the given prefix
+func hasLabelPrefix(labels map[string]string, prefix string) bool {
+	for key := range labels {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePortForward parses the labels of a NAT port forward. In host address mode traffic is
+// forwarded to the host port the internal port is published on.
+func (p *FirewallParser) parsePortForward(
+	containerInfo *container.Info,
+	labels map[string]string,
+	name string,
+	address string,
+	requireAddress bool,
+) (PortForwardConfig, error) {
+	prefix := ControllerNATPrefix + name + "."
+	forward := PortForwardConfig{
+		Name:         name,
+		Interface:    getStringLabel(labels, prefix+"interface", DefaultNATInterface),
+		Protocol:     strings.ToLower(getStringLabel(labels, prefix+"protocol", DefaultNATProtocol)),
+		ExternalPort: getStringLabel(labels, prefix+"external_port", ""),
+		Source:       getStringLabel(labels, prefix+"source", ""),
+		Target:       address,
+	}
+
+	switch forward.Protocol {
+	case "tcp", "udp", "tcp/udp":
+	default:
+		return forward, fmt.Errorf("invalid protocol '%s', must be one of: tcp, udp, tcp/udp", forward.Protocol)
+	}
+
+	if forward.ExternalPort == "" {
+		return forward, fmt.Errorf("external port is required (%sexternal_port)", prefix)
+	}
+	first, last, err := parsePortRange(forward.ExternalPort)
+	if err != nil {
+		return forward, fmt.Errorf("invalid external port: %w", err)
+	}
+
+	forward.InternalPort = getStringLabel(labels, prefix+"internal_port", strconv.Itoa(first))
+	internal, err := parsePort(forward.InternalPort)
+	if err != nil {
+		return forward, fmt.Errorf("invalid internal port: %w", err)
+	}
+	if internal+last-first > 65535 {
+		return forward, fmt.Errorf("internal port range %d-%d exceeds 65535", internal, internal+last-first)
+	}
+
+	if getStringLabel(labels, ControllerBackendAddressModeLabel, p.options.AddressMode) != AddressModeHost {
+		return forward, nil
+	}
+
+	if last != first {
+		return forward, fmt.Errorf("port ranges are not supported in host address mode")
+	}
+
+	// Both protocols of a tcp/udp forward are expected on the same published port
+	protocol, _, _ := strings.Cut(forward.Protocol, "/")
+	port, found := container.GetPublishedPort(containerInfo, internal, protocol)
+	if !found {
+		if requireAddress {
+			return forward, fmt.Errorf("container port %d/%s is not published on the host", internal, protocol)
+		}
+		return forward, nil
+	}
+
+	forward.InternalPort = strconv.Itoa(port.PublicPort)
+	if port.IsHostIPSpecific() {
+		forward.Target = port.HostIP
+	}
+
+	return forward, nil
+}
+
+// parsePortRange parses a port, or a range of ports such as 27015-27020
+func parsePortRange(value string) (first, last int, err error) {
+	start, end, isRange := strings.Cut(value, "-")
+	if first, err = parsePort(start); err != nil {
+		return 0, 0, err
+	}
+	if !isRange {
+		return first, first, nil
+	}
+
+	if last, err = parsePort(end); err != nil {
+		return 0, 0, err
+	}
+	if last < first {
+		return 0, 0, fmt.Errorf("port range %s ends before it starts", value)
+	}
+	return first, last, nil
+}
+
+// parsePort parses a port number
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("'%s' is not a port between 1 and 65535", value)
+	}
+	return port, nil
+}
+
+// resolveAddress returns the address the firewall sees the container at. In container mode this
+// is the container IP on the backend network or the first preferred network, in host mode the
+// address of the container host.
+func (p *FirewallParser) resolveAddress(containerInfo *container.Info, labels map[string]string) (string, error) {
+	addressMode := getStringLabel(labels, ControllerBackendAddressModeLabel, p.options.AddressMode)
+
+	switch addressMode {
+	case AddressModeHost:
+		if address := p.options.AdvertiseAddresses[containerInfo.Runtime]; address != "" {
+			return address, nil
+		}
+		return p.options.HostAddress, nil
+	case AddressModeContainer:
+	default:
+		return "", fmt.Errorf("invalid address mode '%s', must be one of: container, host", addressMode)
+	}
+
+	if network := getStringLabel(labels, ControllerBackendNetworkLabel, ""); network != "" {
+		return container.GetNetworkIP(containerInfo, network), nil
+	}
+	return container.GetContainerIP(containerInfo, p.options.PreferredNetworks...), nil
+}
@@ -0,0 +1,293 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package labels
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+)
+
+func TestFirewallParser_ParseContainer(t *testing.T) {
+	parser := NewFirewallParser(Options{
+		PreferredNetworks: []string{"frontend"},
+		HostAddress:       "192.168.1.10",
+	})
+	networks := map[string]container.NetworkInfo{
+		"backend":  {IPAddress: "172.18.0.2"},
+		"frontend": {IPAddress: "172.19.0.2"},
+	}
+
+	tests := []struct {
+		labels      map[string]string
+		name        string
+		wantAliases []string
+		wantAddress string
+		wantErr     bool
+	}{
+		{
+			name:    "no alias",
+			labels:  map[string]string{ControllerEnableLabel: "true"},
+			wantErr: true,
+		},
+		{
+			name:        "preferred network",
+			labels:      map[string]string{ControllerFirewallAliasLabel: "app_servers, web_servers"},
+			wantAliases: []string{"app_servers", "web_servers"},
+			wantAddress: "172.19.0.2",
+		},
+		{
+			name: "backend network",
+			labels: map[string]string{
+				ControllerFirewallAliasLabel:  "app_servers",
+				ControllerBackendNetworkLabel: "backend",
+			},
+			wantAliases: []string{"app_servers"},
+			wantAddress: "172.18.0.2",
+		},
+		{
+			name: "host address mode",
+			labels: map[string]string{
+				ControllerFirewallAliasLabel:      "app_servers",
+				ControllerBackendAddressModeLabel: AddressModeHost,
+			},
+			wantAliases: []string{"app_servers"},
+			wantAddress: "192.168.1.10",
+		},
+		{
+			name:    "invalid alias name",
+			labels:  map[string]string{ControllerFirewallAliasLabel: "app-servers"},
+			wantErr: true,
+		},
+		{
+			name: "no address on network",
+			labels: map[string]string{
+				ControllerFirewallAliasLabel:  "app_servers",
+				ControllerBackendNetworkLabel: "missing",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parser.ParseContainer(&container.Info{Labels: tt.labels, Networks: networks})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseContainer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !slices.Equal(config.Aliases, tt.wantAliases) {
+				t.Errorf("ParseContainer() aliases = %v, want %v", config.Aliases, tt.wantAliases)
+			}
+			if config.Address != tt.wantAddress {
+				t.Errorf("ParseContainer() address = %s, want %s", config.Address, tt.wantAddress)
+			}
+		})
+	}
+}
+
+func TestFirewallParser_ParsePortForwards(t *testing.T) {
+	parser := NewFirewallParser(Options{HostAddress: "192.168.1.10"})
+	info := &container.Info{
+		Networks: map[string]container.NetworkInfo{"bridge": {IPAddress: "172.17.0.2"}},
+		Ports: []container.PortMapping{
+			{Protocol: "udp", PrivatePort: 27015, PublicPort: 28015},
+			{Protocol: "tcp", PrivatePort: 8080, PublicPort: 18080, HostIP: "192.168.1.20"},
+		},
+	}
+
+	tests := []struct {
+		labels  map[string]string
+		name    string
+		want    PortForwardConfig
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			labels: map[string]string{
+				ControllerNATPrefix + "game.external_port": "27015",
+			},
+			want: PortForwardConfig{Name: "game", Interface: "wan", Protocol: "tcp", ExternalPort: "27015",
+				InternalPort: "27015", Target: "172.17.0.2"},
+		},
+		{
+			name: "port range with source",
+			labels: map[string]string{
+				ControllerNATPrefix + "game.interface":     "opt1",
+				ControllerNATPrefix + "game.protocol":      "TCP/UDP",
+				ControllerNATPrefix + "game.external_port": "27015-27020",
+				ControllerNATPrefix + "game.internal_port": "7015",
+				ControllerNATPrefix + "game.source":        "203.0.113.0/24",
+			},
+			want: PortForwardConfig{Name: "game", Interface: "opt1", Protocol: "tcp/udp", ExternalPort: "27015-27020",
+				InternalPort: "7015", Target: "172.17.0.2", Source: "203.0.113.0/24"},
+		},
+		{
+			name: "host address mode",
+			labels: map[string]string{
+				ControllerNATPrefix + "game.protocol":      "udp",
+				ControllerNATPrefix + "game.external_port": "27015",
+				ControllerBackendAddressModeLabel:          AddressModeHost,
+			},
+			want: PortForwardConfig{Name: "game", Interface: "wan", Protocol: "udp", ExternalPort: "27015",
+				InternalPort: "28015", Target: "192.168.1.10"},
+		},
+		{
+			name: "host address mode with host IP",
+			labels: map[string]string{
+				ControllerNATPrefix + "web.external_port": "80",
+				ControllerNATPrefix + "web.internal_port": "8080",
+				ControllerBackendAddressModeLabel:         AddressModeHost,
+			},
+			want: PortForwardConfig{Name: "web", Interface: "wan", Protocol: "tcp", ExternalPort: "80",
+				InternalPort: "18080", Target: "192.168.1.20"},
+		},
+		{
+			name: "port not published",
+			labels: map[string]string{
+				ControllerNATPrefix + "game.external_port": "27015",
+				ControllerBackendAddressModeLabel:          AddressModeHost,
+			},
+			wantErr: true,
+		},
+		{
+			name:    "missing external port",
+			labels:  map[string]string{ControllerNATPrefix + "game.protocol": "udp"},
+			wantErr: true,
+		},
+		{
+			name: "invalid protocol",
+			labels: map[string]string{
+				ControllerNATPrefix + "game.protocol":      "icmp",
+				ControllerNATPrefix + "game.external_port": "27015",
+			},
+			wantErr: true,
+		},
+		{
+			name:    "inverted port range",
+			labels:  map[string]string{ControllerNATPrefix + "game.external_port": "27020-27015"},
+			wantErr: true,
+		},
+		{
+			name: "internal port range past 65535",
+			labels: map[string]string{
+				ControllerNATPrefix + "game.external_port": "1000-1010",
+				ControllerNATPrefix + "game.internal_port": "65530",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info.Labels = tt.labels
+			config, err := parser.ParseContainer(info)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseContainer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(config.PortForwards) != 1 || config.PortForwards[0] != tt.want {
+				t.Errorf("ParseContainer() port forwards = %+v, want %+v", config.PortForwards, tt.want)
+			}
+		})
+	}
+}
+
+func TestFirewallParser_ParseRules(t *testing.T) {
+	parser := NewFirewallParser(Options{})
+	info := &container.Info{
+		Networks: map[string]container.NetworkInfo{"bridge": {IPAddress: "172.17.0.2"}},
+	}
+
+	tests := []struct {
+		name    string
+		rule    string
+		want    FirewallRule
+		wantErr bool
+	}{
+		{
+			name: "interface macro source",
+			rule: "pass tcp from LAN_NET to self port 5432",
+			want: FirewallRule{Name: "db", Action: "pass", Interface: "lan", Protocol: "tcp",
+				Source: "lan", Destination: "172.17.0.2", DestinationPort: "5432"},
+		},
+		{
+			name: "defaults",
+			rule: "block",
+			want: FirewallRule{Name: "db", Action: "block", Interface: "lan", Protocol: "any",
+				Source: "any", Destination: "172.17.0.2"},
+		},
+		{
+			name: "explicit interface with negated source and port range",
+			rule: "Reject on OPT1 udp from !10.0.0.0/8 port 1000:2000 to OPT1_ADDRESS port 53",
+			want: FirewallRule{Name: "db", Action: "reject", Interface: "opt1", Protocol: "udp",
+				Source: "!10.0.0.0/8", SourcePort: "1000:2000", Destination: "opt1:ip", DestinationPort: "53"},
+		},
+		{
+			name: "alias source and port alias",
+			rule: "pass tcp/udp from admin_hosts to self port db_ports",
+			want: FirewallRule{Name: "db", Action: "pass", Interface: "lan", Protocol: "tcp/udp",
+				Source: "admin_hosts", Destination: "172.17.0.2", DestinationPort: "db_ports"},
+		},
+		{
+			name:    "invalid action",
+			rule:    "allow tcp from any to self",
+			wantErr: true,
+		},
+		{
+			name:    "port without protocol",
+			rule:    "pass from any to self port 5432",
+			wantErr: true,
+		},
+		{
+			name:    "interface after protocol",
+			rule:    "pass tcp on lan from any to self",
+			wantErr: true,
+		},
+		{
+			name:    "invalid address",
+			rule:    "pass tcp from 10.0.0.300 to self",
+			wantErr: true,
+		},
+		{
+			name:    "missing address",
+			rule:    "pass tcp from",
+			wantErr: true,
+		},
+		{
+			name:    "negated any",
+			rule:    "block from !any",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info.Labels = map[string]string{ControllerFirewallRulesPrefix + "db": tt.rule}
+			config, err := parser.ParseContainer(info)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseContainer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(config.Rules) != 1 || config.Rules[0] != tt.want {
+				t.Errorf("ParseContainer() rules = %+v, want %+v", config.Rules, tt.want)
+			}
+		})
+	}
+
+	t.Run("invalid rule name", func(t *testing.T) {
+		info.Labels = map[string]string{ControllerFirewallRulesPrefix + "db.main": "pass"}
+		if _, err := parser.ParseContainer(info); err == nil {
+			t.Error("ParseContainer() expected error for rule name with a dot")
+		}
+	})
+}
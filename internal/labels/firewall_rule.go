@@ -0,0 +1,250 @@
+package labels
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+)
+
+var (
+	// ruleNamePattern matches the names of firewall rule labels
+	ruleNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	// interfaceMacroPattern matches the interface network and address macros, such as LAN_NET
+	// or OPT1_ADDRESS
+	interfaceMacroPattern = regexp.MustCompile(`^(WAN|LAN|OPT[0-9]+)_(NET|ADDRESS)$`)
+)
+
+// parseFirewallRules parses the firewall rule labels of a container. The address "self" in a
+// rule stands for the container's address.
+func parseFirewallRules(labels map[string]string, address string) ([]FirewallRule, error) {
+	var names []string
+	for key := range labels {
+		if name, found := strings.CutPrefix(key, ControllerFirewallRulesPrefix); found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	rules := make([]FirewallRule, 0, len(names))
+	for _, name := range names {
+		if !ruleNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid firewall rule name '%s', must be letters, digits, dashes or underscores", name)
+		}
+
+		rule, err := parseFirewallRule(name, labels[ControllerFirewallRulesPrefix+name], address)
+		if err != nil {
+			return nil, fmt.Errorf("firewall rule %s: %w", name, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parseFirewallRule parses a firewall rule of the form
+//
+//	<pass|block|reject> [on <interface>] [<protocol>] [from <address> [port <port>]] [to <address> [port <port>]]
+//
+// Rules match any protocol from any source to the container unless stated otherwise. Without
+// an interface the rule applies to the interface of an interface macro source, such as LAN_NET,
+// or the LAN.
+func parseFirewallRule(name, value, address string) (FirewallRule, error) {
+	rule := FirewallRule{
+		Name:        name,
+		Protocol:    "any",
+		Source:      "any",
+		Destination: address,
+	}
+
+	tokens := strings.Fields(value)
+	if len(tokens) == 0 {
+		return rule, fmt.Errorf("rule is empty")
+	}
+
+	rule.Action = strings.ToLower(tokens[0])
+	switch rule.Action {
+	case "pass", "block", "reject":
+	default:
+		return rule, fmt.Errorf("invalid action '%s', must be one of: pass, block, reject", tokens[0])
+	}
+	tokens = tokens[1:]
+
+	// next returns the value following a keyword
+	next := func(keyword string) (string, error) {
+		if len(tokens) < 2 {
+			return "", fmt.Errorf("'%s' must be followed by a value", keyword)
+		}
+		value := tokens[1]
+		tokens = tokens[2:]
+		return value, nil
+	}
+
+	var err error
+	var sourceInterface string
+	seen := make(map[string]bool)
+	for len(tokens) > 0 {
+		keyword := strings.ToLower(tokens[0])
+		if seen[keyword] {
+			return rule, fmt.Errorf("'%s' is given more than once", keyword)
+		}
+		seen[keyword] = true
+
+		switch keyword {
+		case "on":
+			if len(seen) > 1 {
+				return rule, fmt.Errorf("'on' must come right after the action")
+			}
+			if rule.Interface, err = next(keyword); err != nil {
+				return rule, err
+			}
+			rule.Interface = strings.ToLower(rule.Interface)
+		case "tcp", "udp", "tcp/udp", "icmp", "any":
+			if seen["protocol"] {
+				return rule, fmt.Errorf("protocol is given more than once")
+			}
+			if seen["from"] || seen["to"] {
+				return rule, fmt.Errorf("protocol '%s' must come before 'from' and 'to'", keyword)
+			}
+			rule.Protocol = keyword
+			seen["protocol"] = true
+			tokens = tokens[1:]
+		case "from":
+			if seen["to"] {
+				return rule, fmt.Errorf("'from' must come before 'to'")
+			}
+			value, err := next(keyword)
+			if err != nil {
+				return rule, err
+			}
+			if rule.Source, sourceInterface, err = resolveRuleAddress(value, address); err != nil {
+				return rule, err
+			}
+			if rule.SourcePort, err = parseRulePort(&tokens); err != nil {
+				return rule, err
+			}
+		case "to":
+			value, err := next(keyword)
+			if err != nil {
+				return rule, err
+			}
+			if rule.Destination, _, err = resolveRuleAddress(value, address); err != nil {
+				return rule, err
+			}
+			if rule.DestinationPort, err = parseRulePort(&tokens); err != nil {
+				return rule, err
+			}
+		default:
+			return rule, fmt.Errorf("unexpected '%s'", tokens[0])
+		}
+	}
+
+	if rule.SourcePort != "" || rule.DestinationPort != "" {
+		switch rule.Protocol {
+		case "tcp", "udp", "tcp/udp":
+		default:
+			return rule, fmt.Errorf("ports require protocol tcp, udp or tcp/udp")
+		}
+	}
+
+	if rule.Interface == "" {
+		rule.Interface = sourceInterface
+	}
+	if rule.Interface == "" {
+		rule.Interface = DefaultFirewallRuleInterface
+	}
+
+	return rule, nil
+}
+
+// parseRulePort parses the optional port following an address of a rule. Port ranges such as
+// 8000-8080 are converted to the 8000:8080 form pfSense expects.
+func parseRulePort(tokens *[]string) (string, error) {
+	if len(*tokens) == 0 || strings.ToLower((*tokens)[0]) != "port" {
+		return "", nil
+	}
+	if len(*tokens) < 2 {
+		return "", fmt.Errorf("'port' must be followed by a value")
+	}
+	value := (*tokens)[1]
+	*tokens = (*tokens)[2:]
+
+	// Port aliases are passed on as they are
+	if aliasNamePattern.MatchString(value) && unicode.IsLetter(rune(value[0])) {
+		return value, nil
+	}
+
+	first, last, err := parsePortRange(strings.Replace(value, ":", "-", 1))
+	if err != nil {
+		return "", err
+	}
+	if first == last {
+		return fmt.Sprint(first), nil
+	}
+	return fmt.Sprintf("%d:%d", first, last), nil
+}
+
+// resolveRuleAddress converts an address of a rule to the form pfSense expects. It also returns
+// the interface of interface macros, which rules from them apply to by default.
+func resolveRuleAddress(value, address string) (resolved, iface string, err error) {
+	negated := strings.HasPrefix(value, "!")
+	value = strings.TrimPrefix(value, "!")
+
+	switch {
+	case strings.EqualFold(value, "any"):
+		if negated {
+			return "", "", fmt.Errorf("'any' cannot be negated")
+		}
+		return "any", "", nil
+	case strings.EqualFold(value, "self"):
+		resolved = address
+	case value == "(self)":
+		// The firewall itself
+		resolved = value
+	case interfaceMacroPattern.MatchString(value):
+		match := interfaceMacroPattern.FindStringSubmatch(value)
+		iface = strings.ToLower(match[1])
+		resolved = iface
+		if match[2] == "ADDRESS" {
+			resolved = iface + ":ip"
+		}
+	case net.ParseIP(value) != nil, aliasNamePattern.MatchString(value):
+		resolved = value
+	default:
+		if _, _, err := net.ParseCIDR(value); err != nil {
+			return "", "", fmt.Errorf("invalid address '%s', must be any, self, an interface macro, alias, IP or CIDR", value)
+		}
+		resolved = value
+	}
+
+	if negated {
+		resolved = "!" + resolved
+	}
+	return resolved, iface, nil
+}
+
+// ConvertToFirewallRules converts the firewall rules of a FirewallConfig to pfSense filter rules
+func (p *FirewallParser) ConvertToFirewallRules(config *FirewallConfig) []pfsense.FirewallRule {
+	rules := make([]pfsense.FirewallRule, 0, len(config.Rules))
+	for _, rule := range config.Rules {
+		converted := pfsense.FirewallRule{
+			Type:            rule.Action,
+			Interface:       []string{rule.Interface},
+			IPProtocol:      "inet",
+			Source:          rule.Source,
+			SourcePort:      rule.SourcePort,
+			Destination:     rule.Destination,
+			DestinationPort: rule.DestinationPort,
+			Description:     rule.Name,
+		}
+		if rule.Protocol != "any" {
+			protocol := rule.Protocol
+			converted.Protocol = &protocol
+		}
+		rules = append(rules, converted)
+	}
+	return rules
+}
@@ -3,7 +3,7 @@ package labels
 import (
 	"encoding/base64"
 	"fmt"
-	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -13,33 +13,55 @@ import (
 
 // HAProxyParser handles parsing of HAProxy-specific container labels
 type HAProxyParser struct {
-	traefikCompatMode bool
+	options Options
 }
 
 // NewHAProxyParser creates a new HAProxy label parser
 func NewHAProxyParser(traefikCompatMode bool) *HAProxyParser {
+	return NewHAProxyParserWithOptions(Options{TraefikCompatMode: traefikCompatMode})
+}
+
+// NewHAProxyParserWithOptions creates a new HAProxy label parser with the given options
+func NewHAProxyParserWithOptions(options Options) *HAProxyParser {
+	if options.AddressMode == "" {
+		options.AddressMode = AddressModeContainer
+	}
 	return &HAProxyParser{
-		traefikCompatMode: traefikCompatMode,
+		options: options,
 	}
 }
 
 // ParseContainer parses container labels into HAProxy configuration
 func (p *HAProxyParser) ParseContainer(containerInfo *container.Info) (*ContainerConfig, error) {
+	return p.parseContainer(containerInfo, true)
+}
+
+// ParseContainerForRemoval parses container labels into HAProxy configuration
+// without requiring a container IP address. Stopped and destroyed containers no
+// longer have an address, but their labels still identify the pfSense objects to remove.
+func (p *HAProxyParser) ParseContainerForRemoval(containerInfo *container.Info) (*ContainerConfig, error) {
+	return p.parseContainer(containerInfo, false)
+}
+
+// parseContainer parses container labels, optionally requiring a container IP address
+func (p *HAProxyParser) parseContainer(containerInfo *container.Info, requireAddress bool) (*ContainerConfig, error) {
 	labels := containerInfo.Labels
 	if labels == nil {
 		return nil, fmt.Errorf("container has no labels")
 	}
 
 	// Try controller mode first (always check, regardless of compat mode)
-	if config, err := p.parseControllerLabels(containerInfo, labels); err == nil {
+	if config, err := p.parseControllerLabels(containerInfo, labels, requireAddress); err == nil {
 		config.ParseMode = "controller"
+		p.parseOwnership(config, labels)
 		return config, nil
 	}
 
 	// If Traefik compat mode is enabled, try parsing Traefik labels for backend
-	if p.traefikCompatMode {
-		if config, err := p.parseTraefikLabels(containerInfo, labels); err == nil {
+	if p.options.TraefikCompatMode {
+		if config, err := p.parseTraefikLabels(containerInfo, labels, requireAddress); err == nil {
 			config.ParseMode = TraefikMode
+			p.parseOwnership(config, labels)
 			return config, nil
 		}
 	}
@@ -47,19 +69,24 @@ func (p *HAProxyParser) ParseContainer(containerInfo *container.Info) (*Containe
 	return nil, fmt.Errorf("no valid HAProxy labels found for container")
 }
 
+// parseOwnership parses the labels related to ownership of pfSense objects
+func (p *HAProxyParser) parseOwnership(config *ContainerConfig, labels map[string]string) {
+	config.Adopt = getStringLabel(labels, ControllerAdoptLabel, "") == TrueValue
+	config.LabelHash = hashLabels(labels)
+}
+
 // parseControllerLabels parses pfSense controller specific HAProxy labels
-func (p *HAProxyParser) parseControllerLabels(containerInfo *container.Info, labels map[string]string) (*ContainerConfig, error) {
+func (p *HAProxyParser) parseControllerLabels(
+	containerInfo *container.Info,
+	labels map[string]string,
+	requireAddress bool,
+) (*ContainerConfig, error) {
 	// Check if controller is enabled
 	enabled, exists := labels[ControllerEnableLabel]
 	if !exists || enabled != TrueValue {
 		return nil, fmt.Errorf("controller not enabled for container")
 	}
 
-	// Validate that both backend and frontend labels are present
-	if err := p.validateRequiredLabels(labels); err != nil {
-		return nil, fmt.Errorf("missing required labels: %w", err)
-	}
-
 	config := &ContainerConfig{
 		Enabled: true,
 	}
@@ -67,30 +94,159 @@ func (p *HAProxyParser) parseControllerLabels(containerInfo *container.Info, lab
 	// Parse endpoint name (optional, defaults to "default")
 	config.EndpointName = getStringLabel(labels, ControllerEndpointLabel, "default")
 
+	// The backend and frontend labels define the default route
+	if hasDefaultRoute(labels) {
+		// Validate that both backend and frontend labels are present
+		if err := p.validateRequiredLabels(labels); err != nil {
+			return nil, fmt.Errorf("missing required labels: %w", err)
+		}
+
+		route, err := p.parseControllerRoute(containerInfo, labels, requireAddress)
+		if err != nil {
+			return nil, err
+		}
+		config.Routes = append(config.Routes, *route)
+	}
+
+	// Named routers define additional routes
+	routes, err := p.parseRouters(containerInfo, labels, requireAddress)
+	if err != nil {
+		return nil, err
+	}
+	config.Routes = append(config.Routes, routes...)
+
+	if len(config.Routes) == 0 {
+		return nil, fmt.Errorf("missing required labels: %w", p.validateRequiredLabels(labels))
+	}
+
+	return config, nil
+}
+
+// hasDefaultRoute reports whether the container uses the backend and frontend labels
+func hasDefaultRoute(labels map[string]string) bool {
+	_, hasPort := labels[ControllerBackendPortLabel]
+	_, hasRule := labels[ControllerFrontendRuleLabel]
+	return hasPort || hasRule
+}
+
+// parseControllerRoute parses a route from the backend and frontend labels
+func (p *HAProxyParser) parseControllerRoute(
+	containerInfo *container.Info,
+	labels map[string]string,
+	requireAddress bool,
+) (*RouteConfig, error) {
+	route := &RouteConfig{}
+
 	// Parse backend configuration
-	backendConfig, err := p.parseControllerBackendConfig(containerInfo, labels)
+	backendConfig, err := p.parseControllerBackendConfig(containerInfo, labels, requireAddress)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse backend config: %w", err)
 	}
-	config.BackendConfig = *backendConfig
+	route.BackendConfig = *backendConfig
 
 	// Parse frontend configuration
 	frontendConfig, err := p.parseControllerFrontendConfig(labels)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse frontend config: %w", err)
 	}
-	config.FrontendConfig = *frontendConfig
+	route.FrontendConfig = *frontendConfig
 
 	// Validate configuration
-	if err := p.validateConfig(config); err != nil {
+	if err := p.validateRoute(route, requireAddress); err != nil {
 		return nil, fmt.Errorf("configuration validation failed: %w", err)
 	}
 
-	return config, nil
+	return route, nil
+}
+
+// routerLabelKeys maps the labels of a named router to the frontend labels they stand for
+var routerLabelKeys = map[string]string{
+	"rule":            ControllerFrontendRuleLabel,
+	"frontend":        ControllerFrontendNameLabel,
+	"acl_name":        ControllerFrontendACLNameLabel,
+	"tls":             ControllerFrontendTLSLabel,
+	"tls.certificate": ControllerFrontendTLSCertificateLabel,
+	"tls.cert_file":   ControllerFrontendTLSCertFileLabel,
+	"tls.key_file":    ControllerFrontendTLSKeyFileLabel,
+	"tls.acme":        ControllerTLSACMELabel,
+	"tls.acme.method": ControllerTLSACMEMethodLabel,
+}
+
+// serviceLabelKeys maps the labels of a named service to the backend labels they stand for
+var serviceLabelKeys = map[string]string{
+	"name":                ControllerBackendNameLabel,
+	"port":                ControllerBackendPortLabel,
+	"server_name":         ControllerBackendServerNameLabel,
+	"check_type":          ControllerBackendCheckTypeLabel,
+	"health_check_path":   ControllerBackendHealthCheckLabel,
+	"health_check_method": ControllerBackendHealthMethodLabel,
+	"network":             ControllerBackendNetworkLabel,
+	"address_mode":        ControllerBackendAddressModeLabel,
+}
+
+// parseRouters parses the named routers of a container, such as
+// pfsense-controller.routers.<name>.rule, together with the named services they route to,
+// such as pfsense-controller.services.<name>.port
+func (p *HAProxyParser) parseRouters(
+	containerInfo *container.Info,
+	labels map[string]string,
+	requireAddress bool,
+) ([]RouteConfig, error) {
+	services := labelGroupNames(labels, ControllerServicesPrefix)
+
+	var routes []RouteConfig
+	for _, name := range labelGroupNames(labels, ControllerRoutersPrefix) {
+		// Routers route to the service of the same name, or to the only service, by default
+		service := getStringLabel(labels, ControllerRoutersPrefix+name+".service", "")
+		if service == "" {
+			switch {
+			case slices.Contains(services, name):
+				service = name
+			case len(services) == 1:
+				service = services[0]
+			default:
+				return nil, fmt.Errorf("router %s: service is required (%s%s.service)", name, ControllerRoutersPrefix, name)
+			}
+		}
+		if !slices.Contains(services, service) {
+			return nil, fmt.Errorf("router %s: service %s not found", name, service)
+		}
+
+		// Services share the network settings of the container
+		scoped := map[string]string{
+			ControllerBackendNameLabel: sanitizeName(containerInfo.Name+"-"+service) + "-backend",
+		}
+		scopeLabels(scoped, labels, "", map[string]string{
+			ControllerBackendNetworkLabel:     ControllerBackendNetworkLabel,
+			ControllerBackendAddressModeLabel: ControllerBackendAddressModeLabel,
+		})
+		scopeLabels(scoped, labels, ControllerServicesPrefix+service+".", serviceLabelKeys)
+		scopeLabels(scoped, labels, ControllerRoutersPrefix+name+".", routerLabelKeys)
+
+		if scoped[ControllerBackendPortLabel] == "" {
+			return nil, fmt.Errorf("router %s: service port is required (%s%s.port)", name, ControllerServicesPrefix, service)
+		}
+		if scoped[ControllerFrontendRuleLabel] == "" {
+			return nil, fmt.Errorf("router %s: rule is required (%s%s.rule)", name, ControllerRoutersPrefix, name)
+		}
+
+		route, err := p.parseControllerRoute(containerInfo, scoped, requireAddress)
+		if err != nil {
+			return nil, fmt.Errorf("router %s: %w", name, err)
+		}
+		route.Name = name
+		routes = append(routes, *route)
+	}
+
+	return routes, nil
 }
 
 // parseTraefikLabels parses Traefik labels and converts them to HAProxy format
-func (p *HAProxyParser) parseTraefikLabels(containerInfo *container.Info, labels map[string]string) (*ContainerConfig, error) {
+func (p *HAProxyParser) parseTraefikLabels(
+	containerInfo *container.Info,
+	labels map[string]string,
+	requireAddress bool,
+) (*ContainerConfig, error) {
 	// Check if Traefik is enabled
 	enabled, exists := labels[TraefikEnableLabel]
 	if !exists || enabled != TrueValue {
@@ -109,24 +265,27 @@ func (p *HAProxyParser) parseTraefikLabels(containerInfo *container.Info, labels
 	// Default endpoint
 	config.EndpointName = "default"
 
+	route := RouteConfig{}
+
 	// Parse backend configuration from Traefik labels
-	backendConfig, err := p.parseTraefikBackendConfig(containerInfo, labels)
+	backendConfig, err := p.parseTraefikBackendConfig(containerInfo, labels, requireAddress)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse traefik backend config: %w", err)
 	}
-	config.BackendConfig = *backendConfig
+	route.BackendConfig = *backendConfig
 
 	// Parse frontend configuration using controller labels (same as controller mode)
 	frontendConfig, err := p.parseControllerFrontendConfig(labels)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse frontend config: %w", err)
 	}
-	config.FrontendConfig = *frontendConfig
+	route.FrontendConfig = *frontendConfig
 
 	// Validate configuration
-	if err := p.validateConfig(config); err != nil {
+	if err := p.validateRoute(&route, requireAddress); err != nil {
 		return nil, fmt.Errorf("configuration validation failed: %w", err)
 	}
+	config.Routes = []RouteConfig{route}
 
 	return config, nil
 }
@@ -135,6 +294,7 @@ func (p *HAProxyParser) parseTraefikLabels(containerInfo *container.Info, labels
 func (p *HAProxyParser) parseControllerBackendConfig(
 	containerInfo *container.Info,
 	labels map[string]string,
+	requireAddress bool,
 ) (*BackendConfig, error) {
 	config := &BackendConfig{}
 
@@ -158,10 +318,10 @@ func (p *HAProxyParser) parseControllerBackendConfig(
 		return nil, fmt.Errorf("invalid backend port: %s", config.Port)
 	}
 
-	// Get container IP address
-	config.Address = container.GetContainerIP(containerInfo)
-	if config.Address == "" {
-		return nil, fmt.Errorf("could not determine container IP address")
+	// Resolve the address pfSense connects to
+	network := getStringLabel(labels, ControllerBackendNetworkLabel, "")
+	if err := p.resolveAddress(containerInfo, labels, config, network, requireAddress); err != nil {
+		return nil, err
 	}
 
 	// Parse check type (optional, defaults to "basic")
@@ -178,6 +338,70 @@ func (p *HAProxyParser) parseControllerBackendConfig(
 	return config, nil
 }
 
+// resolveAddress sets the backend server address. In container mode this is the container IP
+// on the given network, or on the first preferred network the container is attached to. In host
+// mode it is the host address and the port the container port is published on.
+func (p *HAProxyParser) resolveAddress(
+	containerInfo *container.Info,
+	labels map[string]string,
+	config *BackendConfig,
+	network string,
+	requireAddress bool,
+) error {
+	addressMode := getStringLabel(labels, ControllerBackendAddressModeLabel, p.options.AddressMode)
+
+	switch addressMode {
+	case AddressModeHost:
+		return p.resolveHostAddress(containerInfo, config, requireAddress)
+	case AddressModeContainer:
+	default:
+		return fmt.Errorf("invalid address mode '%s', must be one of: container, host", addressMode)
+	}
+
+	if network != "" {
+		config.Address = container.GetNetworkIP(containerInfo, network)
+		if config.Address == "" && requireAddress {
+			return fmt.Errorf("container has no IP address on network %s", network)
+		}
+		return nil
+	}
+
+	config.Address = container.GetContainerIP(containerInfo, p.options.PreferredNetworks...)
+	if config.Address == "" && requireAddress {
+		return fmt.Errorf("could not determine container IP address")
+	}
+	return nil
+}
+
+// resolveHostAddress sets the backend server to the host port the container port is published on.
+// Ports bound to a single host address use that address, other ports use the advertise address of
+// the container's runtime, falling back to the global host address.
+func (p *HAProxyParser) resolveHostAddress(containerInfo *container.Info, config *BackendConfig, requireAddress bool) error {
+	config.Address = p.options.HostAddress
+	if address := p.options.AdvertiseAddresses[containerInfo.Runtime]; address != "" {
+		config.Address = address
+	}
+
+	containerPort, _ := strconv.Atoi(config.Port)
+	port, found := container.GetPublishedPort(containerInfo, containerPort, "tcp")
+	if !found {
+		if requireAddress {
+			return fmt.Errorf("container port %s is not published on the host", config.Port)
+		}
+		return nil
+	}
+
+	config.Port = strconv.Itoa(port.PublicPort)
+	if port.IsHostIPSpecific() {
+		config.Address = port.HostIP
+	}
+
+	if config.Address == "" && requireAddress {
+		return fmt.Errorf("no advertise address configured for runtime %s", containerInfo.Runtime)
+	}
+	return nil
+}
+
 // parseControllerFrontendConfig parses frontend-related labels for controller mode
 func (p *HAProxyParser) parseControllerFrontendConfig(labels map[string]string) (*FrontendConfig, error) {
 	config := &FrontendConfig{}
@@ -196,10 +420,55 @@ func (p *HAProxyParser) parseControllerFrontendConfig(labels map[string]string)
 
 	// Generate default names if not provided
 	if config.Name == "" {
-		config.Name = "auto-frontend-" + generateNameFromRule(config.Rule)
+		config.Name = AutoFrontendPrefix + generateNameFromRule(config.Rule)
 	}
 	if config.ACLName == "" {
-		config.ACLName = "auto-acl-" + generateNameFromRule(config.Rule)
+		config.ACLName = AutoACLPrefix + generateNameFromRule(config.Rule)
+	}
+
+	// Parse TLS settings, a certificate enables TLS unless it is disabled explicitly
+	config.TLSCertificate = getStringLabel(labels, ControllerFrontendTLSCertificateLabel, "")
+	config.TLSCertFile = getStringLabel(labels, ControllerFrontendTLSCertFileLabel, "")
+	config.TLSKeyFile = getStringLabel(labels, ControllerFrontendTLSKeyFileLabel, "")
+	config.ACMEAccount = getStringLabel(labels, ControllerTLSACMELabel, "")
+	hasCertificate := config.TLSCertificate != "" || config.TLSCertFile != "" || config.ACMEAccount != ""
+
+	switch tls := getStringLabel(labels, ControllerFrontendTLSLabel, ""); tls {
+	case TrueValue:
+		config.TLS = true
+	case "":
+		config.TLS = hasCertificate
+	case "false":
+	default:
+		return nil, fmt.Errorf("invalid value '%s' for %s, must be true or false", tls, ControllerFrontendTLSLabel)
+	}
+
+	switch {
+	case config.TLSCertificate != "" && config.TLSCertFile != "":
+		return nil, fmt.Errorf("%s and %s cannot be used together", ControllerFrontendTLSCertificateLabel, ControllerFrontendTLSCertFileLabel)
+	case (config.TLSCertFile == "") != (config.TLSKeyFile == ""):
+		return nil, fmt.Errorf("%s and %s must be used together", ControllerFrontendTLSCertFileLabel, ControllerFrontendTLSKeyFileLabel)
+	case config.ACMEAccount != "" && (config.TLSCertificate != "" || config.TLSCertFile != ""):
+		return nil, fmt.Errorf("%s cannot be used together with %s or %s",
+			ControllerTLSACMELabel, ControllerFrontendTLSCertificateLabel, ControllerFrontendTLSCertFileLabel)
+	case config.TLS && !hasCertificate:
+		return nil, fmt.Errorf("%s, %s or %s is required when TLS is enabled",
+			ControllerFrontendTLSCertificateLabel, ControllerFrontendTLSCertFileLabel, ControllerTLSACMELabel)
+	}
+
+	// ACME certificates cover the hosts of the rule, the issued certificate is named after
+	// the frontend and account
+	if config.ACMEAccount != "" && config.TLS {
+		hosts, err := ruleHosts(config.Rule)
+		if err != nil {
+			return nil, err
+		}
+		if len(hosts) == 0 {
+			return nil, fmt.Errorf("%s requires a Host matcher in the frontend rule", ControllerTLSACMELabel)
+		}
+		config.ACMEHosts = hosts
+		config.ACMEMethod = getStringLabel(labels, ControllerTLSACMEMethodLabel, DefaultACMEMethod)
+		config.TLSCertificate = sanitizeName(config.Name + "-" + config.ACMEAccount)
 	}
 
 	return config, nil
@@ -209,6 +478,7 @@ func (p *HAProxyParser) parseControllerFrontendConfig(labels map[string]string)
 func (p *HAProxyParser) parseTraefikBackendConfig(
 	containerInfo *container.Info,
 	labels map[string]string,
+	requireAddress bool,
 ) (*BackendConfig, error) {
 	config := &BackendConfig{}
 
@@ -245,10 +515,10 @@ func (p *HAProxyParser) parseTraefikBackendConfig(
 		return nil, fmt.Errorf("invalid traefik service port: %s", config.Port)
 	}
 
-	// Get container IP address
-	config.Address = container.GetContainerIP(containerInfo)
-	if config.Address == "" {
-		return nil, fmt.Errorf("could not determine container IP address")
+	// Resolve the address pfSense connects to, the controller network label takes precedence
+	network := getStringLabel(labels, ControllerBackendNetworkLabel, getStringLabel(labels, TraefikDockerNetworkLabel, ""))
+	if err := p.resolveAddress(containerInfo, labels, config, network, requireAddress); err != nil {
+		return nil, err
 	}
 
 	// Store service name for frontend parsing
@@ -345,20 +615,23 @@ func (p *HAProxyParser) configureHealthCheck(config *BackendConfig, labels map[s
 	return nil
 }
 
-// validateConfig validates the parsed HAProxy configuration
-func (p *HAProxyParser) validateConfig(config *ContainerConfig) error {
+// validateRoute validates the parsed HAProxy configuration of a route
+func (p *HAProxyParser) validateRoute(config *RouteConfig, requireAddress bool) error {
 	if config.BackendConfig.Name == "" {
 		return fmt.Errorf("backend name cannot be empty")
 	}
 	if config.BackendConfig.Port == "" {
 		return fmt.Errorf("backend port cannot be empty")
 	}
-	if config.BackendConfig.Address == "" {
+	if config.BackendConfig.Address == "" && requireAddress {
 		return fmt.Errorf("backend address cannot be empty")
 	}
 	if config.FrontendConfig.Rule == "" {
 		return fmt.Errorf("frontend rule cannot be empty")
 	}
+	if _, err := parseRuleClauses(config.FrontendConfig.Rule); err != nil {
+		return err
+	}
 
 	// Validate health check configuration
 	if config.BackendConfig.CheckType == HTTPValue {
@@ -370,8 +643,8 @@ func (p *HAProxyParser) validateConfig(config *ContainerConfig) error {
 	return nil
 }
 
-// ConvertToHAProxyBackend converts ContainerConfig to HAProxy backend
-func (p *HAProxyParser) ConvertToHAProxyBackend(config *ContainerConfig) *pfsense.HAProxyBackend {
+// ConvertToHAProxyBackend converts a route to HAProxy backend
+func (p *HAProxyParser) ConvertToHAProxyBackend(config *RouteConfig) *pfsense.HAProxyBackend {
 	// Create advanced backend configuration (base64 encoded)
 	advancedBackendB64 := base64.StdEncoding.EncodeToString([]byte(config.BackendConfig.BackendPassThru))
 
@@ -407,53 +680,23 @@ func (p *HAProxyParser) ConvertToHAProxyBackend(config *ContainerConfig) *pfsens
 	return backend
 }
 
-// ConvertToHAProxyFrontend converts ContainerConfig to HAProxy frontend
-func (p *HAProxyParser) ConvertToHAProxyFrontend(config *ContainerConfig) (*pfsense.HAProxyFrontend, error) {
-	// Parse the rule to extract ACL expression and value
-	expression, value, err := parseRule(config.FrontendConfig.Rule)
+// ConvertToHAProxyFrontend converts a route to HAProxy frontend
+func (p *HAProxyParser) ConvertToHAProxyFrontend(config *RouteConfig) (*pfsense.HAProxyFrontend, error) {
+	// Compile the rule into ACLs and the condition combining them
+	rule, err := compileRule(config.FrontendConfig.Rule, config.FrontendConfig.ACLName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse frontend rule: %w", err)
 	}
 
 	return &pfsense.HAProxyFrontend{
-		Name: config.FrontendConfig.Name,
-		HAACLs: []pfsense.HAProxyACL{
-			{
-				Name:       config.FrontendConfig.ACLName,
-				Expression: expression,
-				Value:      value,
-			},
-		},
+		Name:   config.FrontendConfig.Name,
+		HAACLs: rule.ACLs,
 		ActionItems: []pfsense.HAProxyAction{
 			{
 				Action:  "use_backend",
-				ACL:     config.FrontendConfig.ACLName,
+				ACL:     rule.Condition,
 				Backend: config.BackendConfig.Name,
 			},
 		},
 	}, nil
 }
-
-// parseRule parses a frontend rule into ACL expression and value
-// Supports rules like: Host(`example.com`) or PathPrefix(`/api`)
-func parseRule(rule string) (expression, value string, err error) {
-	// Host rule pattern
-	hostPattern := regexp.MustCompile(`Host\(\s*` + "`" + `([^` + "`" + `]+)` + "`" + `\s*\)`)
-	if matches := hostPattern.FindStringSubmatch(rule); len(matches) > 1 {
-		return "host_matches", matches[1], nil
-	}
-
-	// PathPrefix rule pattern
-	pathPattern := regexp.MustCompile(`PathPrefix\(\s*` + "`" + `([^` + "`" + `]+)` + "`" + `\s*\)`)
-	if matches := pathPattern.FindStringSubmatch(rule); len(matches) > 1 {
-		return "path_beg", matches[1], nil
-	}
-
-	// Path rule pattern
-	pathExactPattern := regexp.MustCompile(`Path\(\s*` + "`" + `([^` + "`" + `]+)` + "`" + `\s*\)`)
-	if matches := pathExactPattern.FindStringSubmatch(rule); len(matches) > 1 {
-		return "path", matches[1], nil
-	}
-
-	return "", "", fmt.Errorf("unsupported rule format: %s", rule)
-}
@@ -10,58 +10,95 @@ import (
 // Parser handles parsing container labels into pfSense configurations
 // It orchestrates different parsers for different pfSense modules
 type Parser struct {
-	haproxyParser *HAProxyParser
-	// TODO: Add other parsers when implementing additional functionality
-	// dnsParser      *DNSParser
-	// firewallParser *FirewallParser
+	haproxyParser  *HAProxyParser
+	dnsParser      *DNSParser
+	firewallParser *FirewallParser
+	dhcpParser     *DHCPParser
 }
 
 // NewParser creates a new label parser
 func NewParser(traefikCompatMode bool) *Parser {
+	return NewParserWithOptions(Options{TraefikCompatMode: traefikCompatMode})
+}
+
+// NewParserWithOptions creates a new label parser with the given options
+func NewParserWithOptions(options Options) *Parser {
 	return &Parser{
-		haproxyParser: NewHAProxyParser(traefikCompatMode),
-		// TODO: Initialize other parsers
-		// dnsParser:      NewDNSParser(),
-		// firewallParser: NewFirewallParser(),
+		haproxyParser:  NewHAProxyParserWithOptions(options),
+		dnsParser:      NewDNSParser(options),
+		firewallParser: NewFirewallParser(options),
+		dhcpParser:     NewDHCPParser(),
 	}
 }
 
 // ParseContainer parses container labels into a ContainerConfig
-// Currently only supports HAProxy parsing, but can be extended for other pfSense modules
+// Currently only supports HAProxy parsing, other pfSense modules have their own parse methods
 func (p *Parser) ParseContainer(containerInfo *container.Info) (*ContainerConfig, error) {
-	// Try HAProxy parsing first
+	// Only HAProxy labels make up a ContainerConfig
 	if config, err := p.haproxyParser.ParseContainer(containerInfo); err == nil {
 		return config, nil
 	}
 
-	// TODO: Try other parsers when implemented
-	// if config, err := p.dnsParser.ParseContainer(containerInfo); err == nil {
-	// 	return config, nil
-	// }
+	return nil, fmt.Errorf("no valid pfSense labels found for container")
+}
 
-	// TODO: Add firewall parser support
-	// if config, err := p.firewallParser.ParseContainer(containerInfo); err == nil {
-	//     return config, nil
-	// }
+// ParseContainerForRemoval parses container labels of a container that is being removed.
+// Unlike ParseContainer it does not require the container to have an IP address.
+func (p *Parser) ParseContainerForRemoval(containerInfo *container.Info) (*ContainerConfig, error) {
+	if config, err := p.haproxyParser.ParseContainerForRemoval(containerInfo); err == nil {
+		return config, nil
+	}
 
 	return nil, fmt.Errorf("no valid pfSense labels found for container")
 }
 
-// ConvertToHAProxyBackend converts ContainerConfig to HAProxy backend
-func (p *Parser) ConvertToHAProxyBackend(config *ContainerConfig) *pfsense.HAProxyBackend {
+// ConvertToHAProxyBackend converts a route of a ContainerConfig to HAProxy backend
+func (p *Parser) ConvertToHAProxyBackend(config *RouteConfig) *pfsense.HAProxyBackend {
 	return p.haproxyParser.ConvertToHAProxyBackend(config)
 }
 
-// ConvertToHAProxyFrontend converts ContainerConfig to HAProxy frontend
-func (p *Parser) ConvertToHAProxyFrontend(config *ContainerConfig) (*pfsense.HAProxyFrontend, error) {
+// ConvertToHAProxyFrontend converts a route of a ContainerConfig to HAProxy frontend
+func (p *Parser) ConvertToHAProxyFrontend(config *RouteConfig) (*pfsense.HAProxyFrontend, error) {
 	return p.haproxyParser.ConvertToHAProxyFrontend(config)
 }
 
-// TODO: Add methods for other pfSense modules when implemented
-// func (p *Parser) ConvertToDNSRecord(config *ContainerConfig) (*pfsense.DNSRecord, error) {
-// 	return p.dnsParser.ConvertToDNSRecord(config)
-// }
+// ParseContainerDNS parses the DNS labels of a container into a DNSConfig
+func (p *Parser) ParseContainerDNS(containerInfo *container.Info) (*DNSConfig, error) {
+	return p.dnsParser.ParseContainer(containerInfo)
+}
+
+// ParseContainerDNSForRemoval parses the DNS labels of a container that is being removed
+func (p *Parser) ParseContainerDNSForRemoval(containerInfo *container.Info) (*DNSConfig, error) {
+	return p.dnsParser.ParseContainerForRemoval(containerInfo)
+}
+
+// ConvertToDNSHostOverrides converts a DNSConfig to Unbound host overrides, resolving records
+// without an IP to the proxy address
+func (p *Parser) ConvertToDNSHostOverrides(config *DNSConfig, proxyAddress string) ([]pfsense.DNSHostOverride, error) {
+	return p.dnsParser.ConvertToDNSHostOverrides(config, proxyAddress)
+}
+
+// ParseContainerFirewall parses the firewall labels of a container into a FirewallConfig
+func (p *Parser) ParseContainerFirewall(containerInfo *container.Info) (*FirewallConfig, error) {
+	return p.firewallParser.ParseContainer(containerInfo)
+}
+
+// ParseContainerFirewallForRemoval parses the firewall labels of a container that is being removed
+func (p *Parser) ParseContainerFirewallForRemoval(containerInfo *container.Info) (*FirewallConfig, error) {
+	return p.firewallParser.ParseContainerForRemoval(containerInfo)
+}
 
-// func (p *Parser) ConvertToFirewallRule(config *ContainerConfig) (*pfsense.FirewallRule, error) {
-// 	return p.firewallParser.ConvertToFirewallRule(config)
-// }
+// ParseContainerDHCP parses the DHCP labels of a container into a DHCPConfig
+func (p *Parser) ParseContainerDHCP(containerInfo *container.Info) (*DHCPConfig, error) {
+	return p.dhcpParser.ParseContainer(containerInfo)
+}
+
+// ParseContainerDHCPForRemoval parses the DHCP labels of a container that is being removed
+func (p *Parser) ParseContainerDHCPForRemoval(containerInfo *container.Info) (*DHCPConfig, error) {
+	return p.dhcpParser.ParseContainerForRemoval(containerInfo)
+}
+
+// ConvertToFirewallRules converts the firewall rules of a FirewallConfig to pfSense filter rules
+func (p *Parser) ConvertToFirewallRules(config *FirewallConfig) []pfsense.FirewallRule {
+	return p.firewallParser.ConvertToFirewallRules(config)
+}
@@ -133,6 +133,420 @@ func TestParser_ParseContainer_TraefikMode(t *testing.T) {
 	}
 }
 
+func TestParser_ParseContainer_Address(t *testing.T) {
+	labels := map[string]string{
+		"pfsense-controller.enable":        "true",
+		"pfsense-controller.backend.port":  "8080",
+		"pfsense-controller.frontend.rule": "Host(`test.example.com`)",
+	}
+	networks := map[string]container.NetworkInfo{
+		"frontend": {IPAddress: "10.0.1.2"},
+		"database": {IPAddress: "10.0.2.2"},
+		"bridge":   {IPAddress: "172.17.0.2"},
+	}
+	ports := []container.PortMapping{
+		{HostIP: "0.0.0.0", Protocol: "tcp", PrivatePort: 8080, PublicPort: 18080},
+		{HostIP: "127.0.0.1", Protocol: "tcp", PrivatePort: 8081, PublicPort: 18081},
+		{HostIP: "127.0.0.1", Protocol: "tcp", PrivatePort: 8082, PublicPort: 18082},
+		{HostIP: "192.168.1.20", Protocol: "tcp", PrivatePort: 8082, PublicPort: 28082},
+	}
+
+	tests := []struct {
+		extraLabels map[string]string
+		name        string
+		wantAddress string
+		wantPort    string
+		options     Options
+		wantErr     bool
+	}{
+		{
+			name:        "deterministic fallback by network name",
+			wantAddress: "172.17.0.2",
+			wantPort:    "8080",
+		},
+		{
+			name:        "preferred network",
+			options:     Options{PreferredNetworks: []string{"missing", "frontend"}},
+			wantAddress: "10.0.1.2",
+			wantPort:    "8080",
+		},
+		{
+			name:        "network label overrides preferred networks",
+			extraLabels: map[string]string{"pfsense-controller.backend.network": "database"},
+			options:     Options{PreferredNetworks: []string{"frontend"}},
+			wantAddress: "10.0.2.2",
+			wantPort:    "8080",
+		},
+		{
+			name:        "network label for unattached network",
+			extraLabels: map[string]string{"pfsense-controller.backend.network": "missing"},
+			wantErr:     true,
+		},
+		{
+			name:        "host address and published port",
+			options:     Options{AddressMode: AddressModeHost, HostAddress: "192.168.1.10"},
+			wantAddress: "192.168.1.10",
+			wantPort:    "18080",
+		},
+		{
+			name:        "runtime advertise address",
+			options:     Options{AddressMode: AddressModeHost, HostAddress: "192.168.1.10", AdvertiseAddresses: map[string]string{"docker": "192.168.1.11"}},
+			wantAddress: "192.168.1.11",
+			wantPort:    "18080",
+		},
+		{
+			name:        "address mode label",
+			extraLabels: map[string]string{"pfsense-controller.backend.address_mode": "host"},
+			options:     Options{AdvertiseAddresses: map[string]string{"docker": "192.168.1.11"}},
+			wantAddress: "192.168.1.11",
+			wantPort:    "18080",
+		},
+		{
+			name:        "host IP binding",
+			extraLabels: map[string]string{"pfsense-controller.backend.port": "8082"},
+			options:     Options{AddressMode: AddressModeHost, HostAddress: "192.168.1.10"},
+			wantAddress: "192.168.1.20",
+			wantPort:    "28082",
+		},
+		{
+			name:        "loopback binding only",
+			extraLabels: map[string]string{"pfsense-controller.backend.port": "8081"},
+			options:     Options{AddressMode: AddressModeHost, HostAddress: "192.168.1.10"},
+			wantErr:     true,
+		},
+		{
+			name:    "host mode without address",
+			options: Options{AddressMode: AddressModeHost},
+			wantErr: true,
+		},
+		{
+			name:        "unpublished port in host mode",
+			extraLabels: map[string]string{"pfsense-controller.backend.port": "9090"},
+			options:     Options{AddressMode: AddressModeHost, HostAddress: "192.168.1.10"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			containerLabels := make(map[string]string)
+			for key, value := range labels {
+				containerLabels[key] = value
+			}
+			for key, value := range tt.extraLabels {
+				containerLabels[key] = value
+			}
+
+			containerInfo := &container.Info{
+				ID:       "test-container",
+				Runtime:  "docker",
+				Name:     "test-service",
+				State:    "running",
+				Labels:   containerLabels,
+				Networks: networks,
+				Ports:    ports,
+			}
+
+			config, err := NewParserWithOptions(tt.options).ParseContainer(containerInfo)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseContainer() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseContainer() error = %v", err)
+			}
+
+			backend := config.Routes[0].BackendConfig
+			if backend.Address != tt.wantAddress {
+				t.Errorf("ParseContainer() address = %v, want %v", backend.Address, tt.wantAddress)
+			}
+			if backend.Port != tt.wantPort {
+				t.Errorf("ParseContainer() port = %v, want %v", backend.Port, tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestParser_ParseContainer_Routers(t *testing.T) {
+	parser := NewParser(false)
+
+	tests := []struct {
+		labels       map[string]string
+		name         string
+		wantBackends []string // backend name and port of every route
+		wantRules    []string
+		wantErr      bool
+	}{
+		{
+			name: "router per service",
+			labels: map[string]string{
+				"pfsense-controller.services.api.port":        "9000",
+				"pfsense-controller.services.console.port":    "9001",
+				"pfsense-controller.services.console.name":    "minio-console",
+				"pfsense-controller.routers.api.rule":         "Host(`s3.example.com`)",
+				"pfsense-controller.routers.ui.rule":          "Host(`minio.example.com`)",
+				"pfsense-controller.routers.ui.service":       "console",
+				"pfsense-controller.routers.ui.acl_name":      "minio-ui",
+				"pfsense-controller.services.console.network": "frontend",
+			},
+			wantBackends: []string{"minio-api-backend:9000", "minio-console:9001"},
+			wantRules:    []string{"Host(`s3.example.com`)", "Host(`minio.example.com`)"},
+		},
+		{
+			name: "default route and single service",
+			labels: map[string]string{
+				"pfsense-controller.backend.port":      "3000",
+				"pfsense-controller.frontend.rule":     "Host(`git.example.com`)",
+				"pfsense-controller.services.ssh.port": "22",
+				"pfsense-controller.routers.ssh.rule":  "ClientIP(`10.0.0.0/8`)",
+			},
+			wantBackends: []string{"minio-backend:3000", "minio-ssh-backend:22"},
+			wantRules:    []string{"Host(`git.example.com`)", "ClientIP(`10.0.0.0/8`)"},
+		},
+		{
+			name: "ambiguous service",
+			labels: map[string]string{
+				"pfsense-controller.services.a.port":  "9000",
+				"pfsense-controller.services.b.port":  "9001",
+				"pfsense-controller.routers.web.rule": "Host(`example.com`)",
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown service",
+			labels: map[string]string{
+				"pfsense-controller.services.a.port":     "9000",
+				"pfsense-controller.routers.web.rule":    "Host(`example.com`)",
+				"pfsense-controller.routers.web.service": "b",
+			},
+			wantErr: true,
+		},
+		{
+			name: "router without rule",
+			labels: map[string]string{
+				"pfsense-controller.services.web.port":    "9000",
+				"pfsense-controller.routers.web.acl_name": "web",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			containerLabels := map[string]string{"pfsense-controller.enable": "true"}
+			for key, value := range tt.labels {
+				containerLabels[key] = value
+			}
+
+			config, err := parser.ParseContainer(&container.Info{
+				ID:     "test-container",
+				Name:   "minio",
+				State:  "running",
+				Labels: containerLabels,
+				Networks: map[string]container.NetworkInfo{
+					"backend":  {IPAddress: "10.0.2.2"},
+					"frontend": {IPAddress: "10.0.1.2"},
+				},
+			})
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseContainer() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseContainer() error = %v", err)
+			}
+
+			if len(config.Routes) != len(tt.wantBackends) {
+				t.Fatalf("ParseContainer() got %d routes, want %d", len(config.Routes), len(tt.wantBackends))
+			}
+			for i, route := range config.Routes {
+				if got := route.BackendConfig.Name + ":" + route.BackendConfig.Port; got != tt.wantBackends[i] {
+					t.Errorf("ParseContainer() route %d backend = %v, want %v", i, got, tt.wantBackends[i])
+				}
+				if route.FrontendConfig.Rule != tt.wantRules[i] {
+					t.Errorf("ParseContainer() route %d rule = %v, want %v", i, route.FrontendConfig.Rule, tt.wantRules[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParser_ParseContainer_TLS(t *testing.T) {
+	parser := NewParser(false)
+
+	tests := []struct {
+		labels          map[string]string
+		name            string
+		wantCertificate string
+		wantTLS         bool
+		wantErr         bool
+	}{
+		{
+			name:   "no TLS",
+			labels: map[string]string{},
+		},
+		{
+			name: "TLS with certificate",
+			labels: map[string]string{
+				"pfsense-controller.frontend.tls":             "true",
+				"pfsense-controller.frontend.tls.certificate": "wildcard.example.com",
+			},
+			wantTLS:         true,
+			wantCertificate: "wildcard.example.com",
+		},
+		{
+			name: "certificate enables TLS",
+			labels: map[string]string{
+				"pfsense-controller.frontend.tls.certificate": "5f3c2a1b9d4e7",
+			},
+			wantTLS:         true,
+			wantCertificate: "5f3c2a1b9d4e7",
+		},
+		{
+			name: "TLS disabled explicitly",
+			labels: map[string]string{
+				"pfsense-controller.frontend.tls":             "false",
+				"pfsense-controller.frontend.tls.certificate": "wildcard.example.com",
+			},
+			wantCertificate: "wildcard.example.com",
+		},
+		{
+			name: "TLS without certificate",
+			labels: map[string]string{
+				"pfsense-controller.frontend.tls": "true",
+			},
+			wantErr: true,
+		},
+		{
+			name: "certificate files",
+			labels: map[string]string{
+				"pfsense-controller.frontend.tls.cert_file": "app.crt",
+				"pfsense-controller.frontend.tls.key_file":  "app.key",
+			},
+			wantTLS: true,
+		},
+		{
+			name: "certificate file without key",
+			labels: map[string]string{
+				"pfsense-controller.frontend.tls.cert_file": "app.crt",
+			},
+			wantErr: true,
+		},
+		{
+			name: "certificate file and certificate manager certificate",
+			labels: map[string]string{
+				"pfsense-controller.frontend.tls.certificate": "wildcard.example.com",
+				"pfsense-controller.frontend.tls.cert_file":   "app.crt",
+				"pfsense-controller.frontend.tls.key_file":    "app.key",
+			},
+			wantErr: true,
+		},
+		{
+			name: "ACME certificate",
+			labels: map[string]string{
+				"pfsense-controller.frontend.name": "https",
+				"pfsense-controller.tls.acme":      "letsencrypt",
+			},
+			wantTLS:         true,
+			wantCertificate: "https-letsencrypt",
+		},
+		{
+			name: "ACME certificate and certificate manager certificate",
+			labels: map[string]string{
+				"pfsense-controller.tls.acme":                 "letsencrypt",
+				"pfsense-controller.frontend.tls.certificate": "wildcard.example.com",
+			},
+			wantErr: true,
+		},
+		{
+			name: "ACME certificate without host",
+			labels: map[string]string{
+				"pfsense-controller.frontend.rule": "PathPrefix(`/api`)",
+				"pfsense-controller.tls.acme":      "letsencrypt",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid TLS value",
+			labels: map[string]string{
+				"pfsense-controller.frontend.tls":             "yes",
+				"pfsense-controller.frontend.tls.certificate": "wildcard.example.com",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			containerLabels := map[string]string{
+				"pfsense-controller.enable":        "true",
+				"pfsense-controller.backend.port":  "8080",
+				"pfsense-controller.frontend.rule": "Host(`app.example.com`)",
+			}
+			for key, value := range tt.labels {
+				containerLabels[key] = value
+			}
+
+			config, err := parser.ParseContainer(&container.Info{
+				ID:       "test-container",
+				Name:     "app",
+				State:    "running",
+				Labels:   containerLabels,
+				Networks: map[string]container.NetworkInfo{"bridge": {IPAddress: "172.17.0.2"}},
+			})
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseContainer() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseContainer() error = %v", err)
+			}
+
+			frontend := config.Routes[0].FrontendConfig
+			if frontend.TLS != tt.wantTLS {
+				t.Errorf("ParseContainer() TLS = %v, want %v", frontend.TLS, tt.wantTLS)
+			}
+			if frontend.TLSCertificate != tt.wantCertificate {
+				t.Errorf("ParseContainer() TLSCertificate = %v, want %v", frontend.TLSCertificate, tt.wantCertificate)
+			}
+		})
+	}
+
+	t.Run("router TLS", func(t *testing.T) {
+		config, err := parser.ParseContainer(&container.Info{
+			ID:    "test-container",
+			Name:  "app",
+			State: "running",
+			Labels: map[string]string{
+				"pfsense-controller.enable":                       "true",
+				"pfsense-controller.services.web.port":            "8080",
+				"pfsense-controller.routers.web.rule":             "Host(`app.example.com`)",
+				"pfsense-controller.routers.web.tls.certificate":  "app.example.com",
+				"pfsense-controller.routers.http.rule":            "Host(`app.example.com`)",
+				"pfsense-controller.routers.http.frontend":        "http",
+				"pfsense-controller.routers.http.tls":             "false",
+				"pfsense-controller.routers.http.tls.certificate": "app.example.com",
+			},
+			Networks: map[string]container.NetworkInfo{"bridge": {IPAddress: "172.17.0.2"}},
+		})
+		if err != nil {
+			t.Fatalf("ParseContainer() error = %v", err)
+		}
+
+		for _, route := range config.Routes {
+			if want := route.Name == "web"; route.FrontendConfig.TLS != want {
+				t.Errorf("ParseContainer() router %s TLS = %v, want %v", route.Name, route.FrontendConfig.TLS, want)
+			}
+		}
+	})
+}
+
 func Test_sanitizeName(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -0,0 +1,553 @@
+package labels
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+)
+
+// maxRuleClauses limits the number of alternatives a rule may expand to
+const maxRuleClauses = 32
+
+// hostPlaceholder finds the start of a Traefik v2 HostRegexp placeholder, {name} or {name:regexp}.
+// Quantifiers of v3 regular expressions, such as {2,3}, do not start with a letter.
+var hostPlaceholder = regexp.MustCompile(`\{[A-Za-z_][A-Za-z0-9_]*[:}]`)
+
+// ruleTokenKind is the kind of a token of a frontend rule
+type ruleTokenKind int
+
+const (
+	tokenEOF ruleTokenKind = iota
+	tokenIdent
+	tokenString
+	tokenLParen
+	tokenRParen
+	tokenComma
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+// ruleToken is a token of a frontend rule
+type ruleToken struct {
+	value string
+	kind  ruleTokenKind
+	pos   int
+}
+
+// ruleMatcher is a matcher function of a rule, such as Host(`example.com`)
+type ruleMatcher struct {
+	name string
+	args []string
+}
+
+// ruleNode is a node of the syntax tree of a rule. Leaf nodes hold a matcher, other nodes
+// combine their children with the "&&", "||" or "!" operator.
+type ruleNode struct {
+	matcher *ruleMatcher
+	left    *ruleNode
+	right   *ruleNode
+	op      string
+}
+
+// ruleLiteral is a possibly negated matcher within a clause of a rule in disjunctive normal form
+type ruleLiteral struct {
+	matcher *ruleMatcher
+	negated bool
+}
+
+// compiledRule is a frontend rule compiled into pfSense ACLs and the condition of the
+// action using them
+type compiledRule struct {
+	ACLs      []pfsense.HAProxyACL
+	Condition string
+}
+
+// tokenizeRule splits a rule into tokens
+func tokenizeRule(rule string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	runes := []rune(rule)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, ruleToken{kind: tokenLParen, value: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, ruleToken{kind: tokenRParen, value: ")", pos: i})
+			i++
+		case r == ',':
+			tokens = append(tokens, ruleToken{kind: tokenComma, value: ",", pos: i})
+			i++
+		case r == '!':
+			tokens = append(tokens, ruleToken{kind: tokenNot, value: "!", pos: i})
+			i++
+		case r == '&' || r == '|':
+			if i+1 >= len(runes) || runes[i+1] != r {
+				return nil, fmt.Errorf("unexpected character %q at position %d", r, i)
+			}
+			kind := tokenAnd
+			if r == '|' {
+				kind = tokenOr
+			}
+			tokens = append(tokens, ruleToken{kind: kind, value: string([]rune{r, r}), pos: i})
+			i += 2
+		case r == '`' || r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, ruleToken{kind: tokenString, value: string(runes[i+1 : end]), pos: i})
+			i = end + 1
+		case unicode.IsLetter(r):
+			end := i
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end])) {
+				end++
+			}
+			tokens = append(tokens, ruleToken{kind: tokenIdent, value: string(runes[i:end]), pos: i})
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", r, i)
+		}
+	}
+
+	return append(tokens, ruleToken{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+// ruleParser is a recursive descent parser for the Traefik rule syntax:
+//
+//	or      = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | "(" or ")" | matcher
+//	matcher = ident "(" string { "," string } ")"
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+}
+
+// parseRuleExpression parses a rule into its syntax tree
+func parseRuleExpression(rule string) (*ruleNode, error) {
+	tokens, err := tokenizeRule(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ruleParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token := p.peek(); token.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", token.value, token.pos)
+	}
+
+	return node, nil
+}
+
+func (p *ruleParser) peek() ruleToken {
+	return p.tokens[p.pos]
+}
+
+func (p *ruleParser) next() ruleToken {
+	token := p.tokens[p.pos]
+	if token.kind != tokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *ruleParser) expect(kind ruleTokenKind, what string) (ruleToken, error) {
+	token := p.next()
+	if token.kind != kind {
+		if token.kind == tokenEOF {
+			return token, fmt.Errorf("expected %s at end of rule", what)
+		}
+		return token, fmt.Errorf("expected %s at position %d, got %q", what, token.pos, token.value)
+	}
+	return token, nil
+}
+
+func (p *ruleParser) parseOr() (*ruleNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &ruleNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseAnd() (*ruleNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &ruleNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseUnary() (*ruleNode, error) {
+	switch p.peek().kind {
+	case tokenNot:
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &ruleNode{op: "!", left: operand}, nil
+
+	case tokenLParen:
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return node, nil
+
+	default:
+		return p.parseMatcher()
+	}
+}
+
+func (p *ruleParser) parseMatcher() (*ruleNode, error) {
+	name, err := p.expect(tokenIdent, "matcher")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenLParen, "'('"); err != nil {
+		return nil, err
+	}
+
+	var args []string
+	for {
+		arg, err := p.expect(tokenString, "quoted argument")
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg.value)
+
+		if p.peek().kind != tokenComma {
+			break
+		}
+		p.next()
+	}
+
+	if _, err := p.expect(tokenRParen, "')'"); err != nil {
+		return nil, err
+	}
+
+	return expandMatcher(name.value, args)
+}
+
+// expandMatcher validates the arguments of a matcher. Traefik v2 matchers taking a list of
+// values, such as Host(`a`, `b`) or Query(`a=1`, `b=2`), are expanded into alternatives of
+// single value matchers.
+func expandMatcher(name string, args []string) (*ruleNode, error) {
+	switch name {
+	case "HostRegexp":
+		for i, arg := range args {
+			pattern, err := convertHostRegexp(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			args[i] = pattern
+		}
+		return anyMatcher(name, args, func(arg string) []string { return []string{arg} }), nil
+
+	case "Host", "Path", "PathPrefix", "PathRegexp", "Method", "ClientIP":
+		return anyMatcher(name, args, func(arg string) []string { return []string{arg} }), nil
+
+	case "Header":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s expects a name and a value", name)
+		}
+	case "Query":
+		if !slices.ContainsFunc(args, func(arg string) bool { return !strings.Contains(arg, "=") }) {
+			return anyMatcher(name, args, func(arg string) []string {
+				key, value, _ := strings.Cut(arg, "=")
+				return []string{key, value}
+			}), nil
+		}
+		if len(args) > 2 {
+			return nil, fmt.Errorf("%s expects a name and an optional value", name)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported matcher %s", name)
+	}
+
+	return &ruleNode{matcher: &ruleMatcher{name: name, args: args}}, nil
+}
+
+// anyMatcher returns the alternatives of a matcher for every argument, converted into the
+// arguments of the single value matcher by split
+func anyMatcher(name string, args []string, split func(string) []string) *ruleNode {
+	var node *ruleNode
+	for _, arg := range args {
+		leaf := &ruleNode{matcher: &ruleMatcher{name: name, args: split(arg)}}
+		if node == nil {
+			node = leaf
+		} else {
+			node = &ruleNode{op: "||", left: node, right: leaf}
+		}
+	}
+	return node
+}
+
+// convertHostRegexp converts a Traefik v2 HostRegexp pattern with {name} and {name:regexp}
+// placeholders, such as {subdomain:[a-z]+}.example.com, into an anchored regular expression
+// matching the whole host. Traefik v3 patterns are regular expressions already and are
+// returned unchanged.
+func convertHostRegexp(pattern string) (string, error) {
+	if !hostPlaceholder.MatchString(pattern) {
+		return pattern, nil
+	}
+
+	var converted strings.Builder
+	converted.WriteString("^")
+	for rest := pattern; rest != ""; {
+		loc := hostPlaceholder.FindStringIndex(rest)
+		if loc == nil {
+			converted.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		converted.WriteString(regexp.QuoteMeta(rest[:loc[0]]))
+
+		// The regexp of a placeholder may contain braces of its own, such as [a-z]{3}
+		end, depth := -1, 0
+		for i := loc[0]; i < len(rest) && end < 0; i++ {
+			switch rest[i] {
+			case '{':
+				depth++
+			case '}':
+				if depth--; depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end < 0 {
+			return "", fmt.Errorf("unterminated placeholder in %s", pattern)
+		}
+
+		_, placeholder, _ := strings.Cut(rest[loc[0]+1:end], ":")
+		if placeholder == "" {
+			placeholder = "[^.]+"
+		}
+		converted.WriteString("(?:" + placeholder + ")")
+		rest = rest[end+1:]
+	}
+	converted.WriteString("$")
+
+	return converted.String(), nil
+}
+
+// toDNF converts a syntax tree into disjunctive normal form: a list of alternative clauses,
+// each matching when all of its literals match
+func toDNF(node *ruleNode, negated bool) ([][]ruleLiteral, error) {
+	if node.matcher != nil {
+		return [][]ruleLiteral{{{matcher: node.matcher, negated: negated}}}, nil
+	}
+
+	if node.op == "!" {
+		return toDNF(node.left, !negated)
+	}
+
+	left, err := toDNF(node.left, negated)
+	if err != nil {
+		return nil, err
+	}
+	right, err := toDNF(node.right, negated)
+	if err != nil {
+		return nil, err
+	}
+
+	// De Morgan: a negated conjunction is a disjunction of negations and vice versa
+	if (node.op == "||") != negated {
+		if len(left)+len(right) > maxRuleClauses {
+			return nil, fmt.Errorf("rule expands to more than %d alternatives", maxRuleClauses)
+		}
+		return append(left, right...), nil
+	}
+
+	if len(left)*len(right) > maxRuleClauses {
+		return nil, fmt.Errorf("rule expands to more than %d alternatives", maxRuleClauses)
+	}
+	clauses := make([][]ruleLiteral, 0, len(left)*len(right))
+	for _, l := range left {
+		for _, r := range right {
+			clause := make([]ruleLiteral, 0, len(l)+len(r))
+			clause = append(clause, l...)
+			clause = append(clause, r...)
+			clauses = append(clauses, clause)
+		}
+	}
+	return clauses, nil
+}
+
+// matcherACL converts a matcher into the expression and value of a pfSense ACL
+func matcherACL(matcher *ruleMatcher) (expression, value string) {
+	switch matcher.name {
+	case "Host":
+		return HostMatches, matcher.args[0]
+	case "HostRegexp":
+		return HostRegex, matcher.args[0]
+	case "Path":
+		return PathValue, matcher.args[0]
+	case "PathPrefix":
+		return PathBeg, matcher.args[0]
+	case "PathRegexp":
+		return PathRegex, matcher.args[0]
+	case "ClientIP":
+		return SourceIP, matcher.args[0]
+	case "Method":
+		return CustomACL, "method " + strings.ToUpper(matcher.args[0])
+	case "Header":
+		return CustomACL, fmt.Sprintf("req.hdr(%s) -m str %s", matcher.args[0], quoteACLValue(matcher.args[1]))
+	default: // Query
+		if len(matcher.args) == 1 {
+			return CustomACL, fmt.Sprintf("urlp(%s) -m found", matcher.args[0])
+		}
+		return CustomACL, fmt.Sprintf("urlp(%s) -m str %s", matcher.args[0], quoteACLValue(matcher.args[1]))
+	}
+}
+
+// quoteACLValue quotes the value of a custom ACL if HAProxy would otherwise split it into
+// several values at spaces or interpret its quotes, backslashes or comment signs. Single
+// quotes are used unless the value contains one, as HAProxy expands environment variables
+// within double quotes.
+func quoteACLValue(value string) string {
+	if value != "" && !strings.ContainsFunc(value, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(`'"\#$`, r)
+	}) {
+		return value
+	}
+	if !strings.Contains(value, "'") {
+		return "'" + value + "'"
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`).Replace(value) + `"`
+}
+
+// parseRuleClauses parses a rule into disjunctive normal form
+func parseRuleClauses(rule string) ([][]ruleLiteral, error) {
+	node, err := parseRuleExpression(rule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule %s: %w", rule, err)
+	}
+
+	clauses, err := toDNF(node, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule %s: %w", rule, err)
+	}
+	return clauses, nil
+}
+
+// compileRule compiles a frontend rule into one pfSense ACL per distinct matcher and the
+// condition of the action routing to the backend. Alternatives are joined with "||" and
+// negated matchers are prefixed with "!", following the HAProxy condition syntax.
+//
+// A rule with a single matcher uses aclName for its ACL. Otherwise every ACL is named after
+// aclName and a hash of its matcher, so that rules sharing a frontend never produce ACLs
+// with the same name but a different meaning.
+func compileRule(rule, aclName string) (*compiledRule, error) {
+	clauses, err := parseRuleClauses(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	type aclKey struct{ expression, value string }
+	var keys []aclKey
+	seen := make(map[aclKey]bool)
+	for _, clause := range clauses {
+		for _, literal := range clause {
+			expression, value := matcherACL(literal.matcher)
+			key := aclKey{expression, value}
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	compiled := &compiledRule{}
+	names := make(map[aclKey]string, len(keys))
+	for _, key := range keys {
+		name := aclName
+		if len(keys) > 1 {
+			hash := sha256.Sum256([]byte(key.expression + " " + key.value))
+			name = aclName + "-" + hex.EncodeToString(hash[:])[:6]
+		}
+		names[key] = name
+		compiled.ACLs = append(compiled.ACLs, pfsense.HAProxyACL{
+			Name:       name,
+			Expression: key.expression,
+			Value:      key.value,
+		})
+	}
+
+	alternatives := make([]string, 0, len(clauses))
+	for _, clause := range clauses {
+		terms := make([]string, 0, len(clause))
+		for _, literal := range clause {
+			expression, value := matcherACL(literal.matcher)
+			term := names[aclKey{expression, value}]
+			if literal.negated {
+				term = "!" + term
+			}
+			terms = append(terms, term)
+		}
+		alternatives = append(alternatives, strings.Join(terms, " "))
+	}
+	compiled.Condition = strings.Join(alternatives, " || ")
+
+	return compiled, nil
+}
+
+// ruleHosts returns the host names a rule matches with Host matchers, in order of appearance.
+// Negated matchers are skipped, as they never select a host to serve.
+func ruleHosts(rule string) ([]string, error) {
+	clauses, err := parseRuleClauses(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	for _, clause := range clauses {
+		for _, literal := range clause {
+			if literal.negated || literal.matcher.name != "Host" {
+				continue
+			}
+			host := strings.ToLower(literal.matcher.args[0])
+			if !slices.Contains(hosts, host) {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts, nil
+}
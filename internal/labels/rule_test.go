@@ -0,0 +1,231 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package labels
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func Test_compileRule(t *testing.T) {
+	tests := []struct {
+		name          string
+		rule          string
+		wantCondition string   // ACLs referenced as $<index>
+		wantACLs      []string // "expression value" of every ACL in order
+		wantErr       bool
+	}{
+		{
+			name:          "single host keeps the ACL name",
+			rule:          "Host(`example.com`)",
+			wantCondition: "$0",
+			wantACLs:      []string{"host_matches example.com"},
+		},
+		{
+			name:          "host and path prefix",
+			rule:          "Host(`example.com`) && PathPrefix(`/api`)",
+			wantCondition: "$0 $1",
+			wantACLs:      []string{"host_matches example.com", "path_beg /api"},
+		},
+		{
+			name:          "alternatives share ACLs",
+			rule:          "(Host(`a.com`) || Host(`b.com`)) && Path(`/x`)",
+			wantCondition: "$0 $1 || $2 $1",
+			wantACLs:      []string{"host_matches a.com", "path /x", "host_matches b.com"},
+		},
+		{
+			name:          "negation",
+			rule:          "Host(`example.com`) && !PathPrefix(`/admin`)",
+			wantCondition: "$0 !$1",
+			wantACLs:      []string{"host_matches example.com", "path_beg /admin"},
+		},
+		{
+			name:          "negated conjunction",
+			rule:          "!(Method(`post`) && ClientIP(`10.0.0.0/8`))",
+			wantCondition: "!$0 || !$1",
+			wantACLs:      []string{"custom method POST", "source_ip 10.0.0.0/8"},
+		},
+		{
+			name:          "v2 host list",
+			rule:          "Host(`a.com`, `b.com`)",
+			wantCondition: "$0 || $1",
+			wantACLs:      []string{"host_matches a.com", "host_matches b.com"},
+		},
+		{
+			name:          "header query and regexps",
+			rule:          "Header(`X-Env`, `prod`) && Query(`debug`) && HostRegexp(`^.+\\.example\\.com$`) && PathRegexp(\"^/v[0-9]+\")",
+			wantCondition: "$0 $1 $2 $3",
+			wantACLs: []string{
+				"custom req.hdr(X-Env) -m str prod",
+				"custom urlp(debug) -m found",
+				"host_regex ^.+\\.example\\.com$",
+				"path_regex ^/v[0-9]+",
+			},
+		},
+		{
+			name:          "v2 query pair",
+			rule:          "Query(`debug=1`)",
+			wantCondition: "$0",
+			wantACLs:      []string{"custom urlp(debug) -m str 1"},
+		},
+		{
+			name:          "v2 query pair list",
+			rule:          "Query(`env=prod`, `env=staging`)",
+			wantCondition: "$0 || $1",
+			wantACLs:      []string{"custom urlp(env) -m str prod", "custom urlp(env) -m str staging"},
+		},
+		{
+			name:          "values with spaces and quotes are quoted",
+			rule:          "Header(`User-Agent`, `Mozilla 5.0`) || Header(`X-Note`, `it's $HOME`) || Query(`q`, ``)",
+			wantCondition: "$0 || $1 || $2",
+			wantACLs: []string{
+				"custom req.hdr(User-Agent) -m str 'Mozilla 5.0'",
+				"custom req.hdr(X-Note) -m str \"it's \\$HOME\"",
+				"custom urlp(q) -m str ''",
+			},
+		},
+		{
+			name:          "v2 host regexp placeholders",
+			rule:          "HostRegexp(`{subdomain:[a-z]{2,3}}.example.com`, `{name}.example.org`)",
+			wantCondition: "$0 || $1",
+			wantACLs: []string{
+				"host_regex ^(?:[a-z]{2,3})\\.example\\.com$",
+				"host_regex ^(?:[^.]+)\\.example\\.org$",
+			},
+		},
+		{
+			name:          "v3 host regexp quantifiers are kept",
+			rule:          "HostRegexp(`^[a-z]{2}\\.example\\.com$`)",
+			wantCondition: "$0",
+			wantACLs:      []string{"host_regex ^[a-z]{2}\\.example\\.com$"},
+		},
+		{
+			name:    "unterminated host regexp placeholder",
+			rule:    "HostRegexp(`{subdomain:[a-z]+.example.com`)",
+			wantErr: true,
+		},
+		{
+			name:    "too many alternatives",
+			rule:    strings.TrimSuffix(strings.Repeat("Host(`example.com`) || ", maxRuleClauses+1), " || "),
+			wantErr: true,
+		},
+		{
+			name:    "too many v2 list values",
+			rule:    "Host(`example.com`" + strings.Repeat(", `example.com`", maxRuleClauses) + ")",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported matcher",
+			rule:    "HostSNI(`example.com`)",
+			wantErr: true,
+		},
+		{
+			name:    "unbalanced parentheses",
+			rule:    "(Host(`example.com`)",
+			wantErr: true,
+		},
+		{
+			name:    "dangling operator",
+			rule:    "Host(`example.com`) &&",
+			wantErr: true,
+		},
+		{
+			name:    "single ampersand",
+			rule:    "Host(`example.com`) & Path(`/`)",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated string",
+			rule:    "Host(`example.com)",
+			wantErr: true,
+		},
+		{
+			name:    "header without value",
+			rule:    "Header(`X-Env`)",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, err := compileRule(tt.rule, "acl")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("compileRule() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("compileRule() error = %v", err)
+			}
+
+			if len(compiled.ACLs) == 1 && compiled.ACLs[0].Name != "acl" {
+				t.Errorf("compileRule() ACL name = %q, want %q", compiled.ACLs[0].Name, "acl")
+			}
+
+			condition := compiled.Condition
+			for i := len(compiled.ACLs) - 1; i >= 0; i-- {
+				condition = strings.ReplaceAll(condition, compiled.ACLs[i].Name, fmt.Sprintf("$%d", i))
+			}
+			if condition != tt.wantCondition {
+				t.Errorf("compileRule() condition = %q, want %q", compiled.Condition, tt.wantCondition)
+			}
+
+			if len(compiled.ACLs) != len(tt.wantACLs) {
+				t.Fatalf("compileRule() got %d ACLs, want %d", len(compiled.ACLs), len(tt.wantACLs))
+			}
+			for i, acl := range compiled.ACLs {
+				if got := acl.Expression + " " + acl.Value; got != tt.wantACLs[i] {
+					t.Errorf("compileRule() ACL %d = %q, want %q", i, got, tt.wantACLs[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_generateNameFromRule(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{rule: "Host(`app.example.com`)", want: "app-example-com"},
+		{rule: "Host(`app.example.com`) && PathPrefix(`/api`)", want: "app-example-com"},
+		{rule: "PathPrefix(`/api/v1`)", want: "api-v1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			if got := generateNameFromRule(tt.rule); got != tt.want {
+				t.Errorf("generateNameFromRule() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ruleHosts(t *testing.T) {
+	tests := []struct {
+		rule string
+		want []string
+	}{
+		{rule: "Host(`app.example.com`)", want: []string{"app.example.com"}},
+		{rule: "Host(`a.example.com`, `B.example.com`) && PathPrefix(`/api`)", want: []string{"a.example.com", "b.example.com"}},
+		{rule: "(Host(`a.example.com`) || Host(`b.example.com`)) && !Host(`c.example.com`)", want: []string{"a.example.com", "b.example.com"}},
+		{rule: "PathPrefix(`/api`)", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			got, err := ruleHosts(tt.rule)
+			if err != nil {
+				t.Fatalf("ruleHosts() error = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ruleHosts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package pfsense
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// ACMECertificate represents a certificate of the pfSense ACME package. Once issued, the
+// certificate is stored in the certificate manager with the ACME certificate's name as
+// description.
+type ACMECertificate struct {
+	Name        string       `json:"name"`
+	Description string       `json:"descr"`
+	Status      string       `json:"status"`
+	Account     string       `json:"acmeaccount"`
+	KeyLength   string       `json:"keylength"`
+	Domains     []ACMEDomain `json:"a_domainlist"`
+	RenewAfter  int          `json:"renewafter,omitempty"`
+	ID          int          `json:"id,omitempty"`
+}
+
+// ACMEDomain represents a domain of an ACME certificate and how it is validated
+type ACMEDomain struct {
+	Status string `json:"status"`
+	Name   string `json:"name"`
+	Method string `json:"method"`
+}
+
+// DomainNames returns the names of the domains of the certificate
+func (c *ACMECertificate) DomainNames() []string {
+	names := make([]string, 0, len(c.Domains))
+	for _, domain := range c.Domains {
+		names = append(names, domain.Name)
+	}
+	return names
+}
+
+// HasDomain reports whether the certificate covers the domain
+func (c *ACMECertificate) HasDomain(name string) bool {
+	return slices.Contains(c.DomainNames(), name)
+}
+
+// Owner returns the ownership marker stored in the ACME certificate's description
+func (c *ACMECertificate) Owner() *Owner {
+	return ParseOwner(c.Description)
+}
+
+// SetOwner stores an ownership marker in the ACME certificate's description
+func (c *ACMECertificate) SetOwner(owner Owner) {
+	c.Description = owner.String()
+}
+
+// GetACMECertificates retrieves all certificates of the ACME package
+func (c *Client) GetACMECertificates(ctx context.Context) ([]ACMECertificate, error) {
+	resp, err := c.makeRequest(ctx, "GET", "/services/acme/certificates?limit=0&offset=0", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates []ACMECertificate
+	if len(resp.Data) > 0 {
+		if err := json.Unmarshal(resp.Data, &certificates); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal ACME certificates: %w", err)
+		}
+	}
+
+	return certificates, nil
+}
+
+// CreateACMECertificate creates a new ACME certificate
+func (c *Client) CreateACMECertificate(ctx context.Context, certificate *ACMECertificate) error {
+	_, err := c.makeRequest(ctx, "POST", "/services/acme/certificate", certificate)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Created ACME certificate: %s", certificate.Name)
+	return nil
+}
+
+// UpdateACMECertificate updates an existing ACME certificate
+func (c *Client) UpdateACMECertificate(ctx context.Context, certificate *ACMECertificate) error {
+	_, err := c.makeRequest(ctx, "PATCH", "/services/acme/certificate", withID(certificate, certificate.ID))
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Updated ACME certificate: %s", certificate.Name)
+	return nil
+}
+
+// DeleteACMECertificate deletes an existing ACME certificate
+func (c *Client) DeleteACMECertificate(ctx context.Context, certificateID int) error {
+	_, err := c.makeRequest(ctx, "DELETE", fmt.Sprintf("/services/acme/certificate?id=%d", certificateID), nil)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Deleted ACME certificate ID %d", certificateID)
+	return nil
+}
+
+// IssueACMECertificate starts issuing an ACME certificate. Issuance runs in the background
+// on pfSense, the certificate appears in the certificate manager once it succeeds.
+func (c *Client) IssueACMECertificate(ctx context.Context, name string) error {
+	return c.acmeCertificateAction(ctx, "issue", name)
+}
+
+// RenewACMECertificate starts renewing an ACME certificate, which also picks up changed domains
+func (c *Client) RenewACMECertificate(ctx context.Context, name string) error {
+	return c.acmeCertificateAction(ctx, "renew", name)
+}
+
+// acmeCertificateAction starts issuing or renewing an ACME certificate
+func (c *Client) acmeCertificateAction(ctx context.Context, action, name string) error {
+	_, err := c.makeRequest(ctx, "POST", "/services/acme/certificate/"+action, map[string]interface{}{
+		"certificate": name,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Started to %s ACME certificate: %s", action, name)
+	return nil
+}
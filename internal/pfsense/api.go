@@ -0,0 +1,75 @@
+package pfsense
+
+import "context"
+
+// API is the pfSense REST API as used by the managers. Client implements it against a real
+// pfSense, tests may implement it otherwise or point a Client at a pfsensetest.Server.
+type API interface {
+	// HAProxy
+	GetHAProxyBackends(ctx context.Context) ([]HAProxyBackend, error)
+	CreateHAProxyBackend(ctx context.Context, backend *HAProxyBackend) error
+	UpdateHAProxyBackend(ctx context.Context, backend *HAProxyBackend) error
+	DeleteHAProxyBackend(ctx context.Context, backendID int) error
+	FindBackendByName(ctx context.Context, name string) (*HAProxyBackend, error)
+	GetHAProxyFrontends(ctx context.Context) ([]HAProxyFrontend, error)
+	CreateHAProxyFrontend(ctx context.Context, frontend *HAProxyFrontend) error
+	DeleteHAProxyFrontend(ctx context.Context, frontendID int) error
+	FindFrontendByName(ctx context.Context, name string) (*HAProxyFrontend, error)
+	AddACLToFrontend(ctx context.Context, frontendID int, acl HAProxyACL) error
+	UpdateFrontendACL(ctx context.Context, frontendID int, acl HAProxyACL) error
+	DeleteACLFromFrontend(ctx context.Context, frontendID, aclID int) error
+	AddActionToFrontend(ctx context.Context, frontendID int, action HAProxyAction) error
+	UpdateFrontendAction(ctx context.Context, frontendID int, action HAProxyAction) error
+	DeleteActionFromFrontend(ctx context.Context, frontendID, actionID int) error
+	UpdateFrontendAddress(ctx context.Context, frontendID int, address HAProxyFrontendAddress) error
+	SetFrontendSSLOffloadCertificate(ctx context.Context, frontendID int, refID string) error
+	SetFrontendDescription(ctx context.Context, frontendID int, description string) error
+	AddCertificateToFrontend(ctx context.Context, frontendID int, refID string) error
+	DeleteCertificateFromFrontend(ctx context.Context, frontendID, certificateID int) error
+	ApplyHAProxyChanges(ctx context.Context) error
+
+	// Certificate manager
+	GetCertificates(ctx context.Context) ([]Certificate, error)
+	FindCertificate(ctx context.Context, ref string) (*Certificate, error)
+	ImportCertificate(ctx context.Context, descr string, bundle *CertificateBundle) (string, error)
+	DeleteCertificate(ctx context.Context, certificateID int) error
+
+	// ACME
+	GetACMECertificates(ctx context.Context) ([]ACMECertificate, error)
+	CreateACMECertificate(ctx context.Context, certificate *ACMECertificate) error
+	UpdateACMECertificate(ctx context.Context, certificate *ACMECertificate) error
+	DeleteACMECertificate(ctx context.Context, certificateID int) error
+	IssueACMECertificate(ctx context.Context, name string) error
+	RenewACMECertificate(ctx context.Context, name string) error
+
+	// DNS resolver
+	GetDNSHostOverrides(ctx context.Context) ([]DNSHostOverride, error)
+	CreateDNSHostOverride(ctx context.Context, override *DNSHostOverride) error
+	UpdateDNSHostOverride(ctx context.Context, override *DNSHostOverride) error
+	DeleteDNSHostOverride(ctx context.Context, overrideID int) error
+	ApplyDNSResolverChanges(ctx context.Context) error
+
+	// Firewall
+	GetFirewallAliases(ctx context.Context) ([]FirewallAlias, error)
+	CreateFirewallAlias(ctx context.Context, alias *FirewallAlias) error
+	UpdateFirewallAlias(ctx context.Context, alias *FirewallAlias) error
+	GetNATPortForwards(ctx context.Context) ([]NATPortForward, error)
+	CreateNATPortForward(ctx context.Context, forward *NATPortForward) error
+	UpdateNATPortForward(ctx context.Context, forward *NATPortForward) error
+	DeleteNATPortForward(ctx context.Context, forwardID int) error
+	GetFirewallRules(ctx context.Context) ([]FirewallRule, error)
+	CreateFirewallRule(ctx context.Context, rule *FirewallRule) error
+	UpdateFirewallRule(ctx context.Context, rule *FirewallRule) error
+	DeleteFirewallRule(ctx context.Context, ruleID int) error
+	ApplyFirewallChanges(ctx context.Context) error
+
+	// DHCP server
+	GetInterfaces(ctx context.Context) ([]Interface, error)
+	GetDHCPStaticMappings(ctx context.Context) ([]DHCPStaticMapping, error)
+	CreateDHCPStaticMapping(ctx context.Context, mapping *DHCPStaticMapping) error
+	UpdateDHCPStaticMapping(ctx context.Context, mapping *DHCPStaticMapping) error
+	DeleteDHCPStaticMapping(ctx context.Context, parentID string, mappingID int) error
+	ApplyDHCPServerChanges(ctx context.Context) error
+}
+
+var _ API = (*Client)(nil)
@@ -0,0 +1,75 @@
+package pfsense
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CertificateBundle is a certificate and private key provided by a container, to be imported
+// into the pfSense certificate manager
+type CertificateBundle struct {
+	// Name is the common name of the certificate, or the certificate file name without one
+	Name string
+	// Fingerprint is the SHA-256 fingerprint of the leaf certificate
+	Fingerprint string
+	// Certificate is the PEM encoded certificate chain
+	Certificate string
+	// Key is the PEM encoded private key
+	Key string
+}
+
+// LoadCertificateBundle reads a PEM encoded certificate chain and private key from files
+// and checks that they belong together
+func LoadCertificateBundle(certFile, keyFile string) (*CertificateBundle, error) {
+	certPEM, err := os.ReadFile(certFile) // #nosec G304 - Path comes from the container's labels by design
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+
+	keyPEM, err := os.ReadFile(keyFile) // #nosec G304 - Path comes from the container's labels by design
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate or private key: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+
+	name := leaf.Subject.CommonName
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(certFile), filepath.Ext(certFile))
+	}
+
+	fingerprint := sha256.Sum256(leaf.Raw)
+
+	return &CertificateBundle{
+		Name:        name,
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+		Certificate: string(certPEM),
+		Key:         string(keyPEM),
+	}, nil
+}
+
+// Description returns the description of the imported certificate in the certificate manager,
+// carrying an ownership marker with the instance ID and the certificate fingerprint
+func (b *CertificateBundle) Description(instanceID string) string {
+	return fmt.Sprintf("%s %sinstance=%s;fingerprint=%s", b.Name, ownerMarkerPrefix, instanceID, b.Fingerprint)
+}
+
+// Owner returns the ownership marker stored in the certificate's description. Certificates
+// imported by the controller carry the fingerprint of the imported certificate.
+func (c *Certificate) Owner() *Owner {
+	return ParseOwner(c.Description)
+}
@@ -0,0 +1,93 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package pfsense
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate and its key into dir
+func writeCertificate(t *testing.T, dir, commonName string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestLoadCertificateBundle(t *testing.T) {
+	certFile, keyFile := writeCertificate(t, t.TempDir(), "app.example.com")
+
+	bundle, err := LoadCertificateBundle(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadCertificateBundle() error = %v", err)
+	}
+	if bundle.Name != "app.example.com" {
+		t.Errorf("LoadCertificateBundle() name = %v, want app.example.com", bundle.Name)
+	}
+	if len(bundle.Fingerprint) != 64 {
+		t.Errorf("LoadCertificateBundle() fingerprint = %v, want SHA-256 hex", bundle.Fingerprint)
+	}
+
+	cert := &Certificate{Description: bundle.Description("host-a")}
+	owner := cert.Owner()
+	if !owner.IsOwnedBy("host-a") || owner.Fingerprint != bundle.Fingerprint {
+		t.Errorf("Owner() = %+v, want host-a with fingerprint %s", owner, bundle.Fingerprint)
+	}
+
+	// A rotated certificate has another fingerprint
+	rotatedCert, rotatedKey := writeCertificate(t, t.TempDir(), "app.example.com")
+	rotated, err := LoadCertificateBundle(rotatedCert, rotatedKey)
+	if err != nil {
+		t.Fatalf("LoadCertificateBundle() error = %v", err)
+	}
+	if rotated.Fingerprint == bundle.Fingerprint {
+		t.Errorf("LoadCertificateBundle() rotated fingerprint equals original")
+	}
+
+	// Keys of another certificate are rejected
+	if _, err := LoadCertificateBundle(certFile, rotatedKey); err == nil {
+		t.Errorf("LoadCertificateBundle() expected error for mismatched key")
+	}
+	if _, err := LoadCertificateBundle(filepath.Join(t.TempDir(), "missing.crt"), keyFile); err == nil {
+		t.Errorf("LoadCertificateBundle() expected error for missing file")
+	}
+}
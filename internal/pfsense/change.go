@@ -0,0 +1,46 @@
+package pfsense
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// ChangeType describes the operation a change performs on a pfSense object
+type ChangeType string
+
+const (
+	// ChangeCreate creates a new object
+	ChangeCreate ChangeType = "create"
+	// ChangeUpdate updates an existing object
+	ChangeUpdate ChangeType = "update"
+	// ChangeDelete deletes an existing object
+	ChangeDelete ChangeType = "delete"
+)
+
+// Plan is the ordered list of changes that converges an endpoint to the desired state. Each
+// manager plans its own change type C.
+type Plan[C any] struct {
+	Endpoint string `json:"endpoint"`
+	Changes  []C    `json:"changes"`
+}
+
+// Execute performs the changes of the plan in order through perform, retrying each failed
+// change and stopping at the first that keeps failing. Changes are logged and reported through
+// their String method.
+func (p *Plan[C]) Execute(ctx context.Context, global *config.GlobalConfig, logger *logrus.Entry, perform func(*C) error) error {
+	for i := range p.Changes {
+		change := &p.Changes[i]
+		logger.Infof("Endpoint %s: %s", p.Endpoint, change)
+
+		if err := RetryOperation(ctx, global, logger, func() error {
+			return perform(change)
+		}); err != nil {
+			return fmt.Errorf("failed to %s: %w", change, err)
+		}
+	}
+
+	return nil
+}
@@ -3,11 +3,13 @@ package pfsense
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/KristijanL/pfsense-container-controller/internal/config"
@@ -18,6 +20,7 @@ import (
 type Client struct {
 	httpClient *http.Client
 	logger     *logrus.Entry
+	name       string
 	baseURL    string
 	apiKey     string
 }
@@ -39,6 +42,7 @@ func NewClient(endpoint *config.EndpointConfig) *Client {
 	}
 
 	return &Client{
+		name:       endpoint.Name,
 		baseURL:    endpoint.URL,
 		apiKey:     endpoint.APIKey,
 		httpClient: httpClient,
@@ -62,14 +66,56 @@ type HAProxyBackendServer struct {
 	Name    string `json:"name"`
 	Address string `json:"address"`
 	Port    string `json:"port"`
+	ID      int    `json:"id,omitempty"`
 }
 
 // HAProxyFrontend represents a HAProxy frontend configuration
 type HAProxyFrontend struct {
-	Name        string          `json:"name"`
-	HAACLs      []HAProxyACL    `json:"ha_acls"`
-	ActionItems []HAProxyAction `json:"a_actionitems"`
-	ID          int             `json:"id,omitempty"`
+	Name           string                       `json:"name"`
+	Description    string                       `json:"descr,omitempty"`
+	SSLOffloadCert string                       `json:"ssloffloadcert,omitempty"`
+	Addresses      []HAProxyFrontendAddress     `json:"a_extaddr,omitempty"`
+	Certificates   []HAProxyFrontendCertificate `json:"ha_certificates,omitempty"`
+	HAACLs         []HAProxyACL                 `json:"ha_acls"`
+	ActionItems    []HAProxyAction              `json:"a_actionitems"`
+	ID             int                          `json:"id,omitempty"`
+}
+
+// HAProxyFrontendAddress represents an address a HAProxy frontend listens on
+type HAProxyFrontendAddress struct {
+	Address string `json:"extaddr"`
+	Port    string `json:"extaddr_port"`
+	SSL     bool   `json:"extaddr_ssl"`
+	ID      int    `json:"id,omitempty"`
+}
+
+// HAProxyFrontendCertificate represents an additional certificate of a HAProxy frontend,
+// offered through SNI next to the default SSL offloading certificate
+type HAProxyFrontendCertificate struct {
+	Certificate string `json:"ssl_certificate"`
+	ID          int    `json:"id,omitempty"`
+}
+
+// HasCertificate reports whether the frontend offers the certificate with the given refid,
+// either as its default SSL offloading certificate or as an additional certificate
+func (f *HAProxyFrontend) HasCertificate(refID string) bool {
+	if f.SSLOffloadCert == refID {
+		return true
+	}
+	for _, cert := range f.Certificates {
+		if cert.Certificate == refID {
+			return true
+		}
+	}
+	return false
+}
+
+// Certificate represents a certificate of the pfSense certificate manager
+type Certificate struct {
+	RefID       string `json:"refid"`
+	Description string `json:"descr"`
+	Type        string `json:"type,omitempty"`
+	ID          int    `json:"id,omitempty"`
 }
 
 // HAProxyACL represents a HAProxy Access Control List
@@ -88,16 +134,29 @@ type HAProxyAction struct {
 	ID      int    `json:"id,omitempty"`
 }
 
+// ACLNames returns the names of the ACLs used in the action's condition, which may combine
+// several ACLs with "||" and negate them with "!"
+func (a *HAProxyAction) ACLNames() []string {
+	var names []string
+	for _, term := range strings.Fields(a.ACL) {
+		if name := strings.TrimLeft(term, "!"); name != "" && name != "||" && name != "or" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 // APIResponse represents a generic API response
 type APIResponse struct {
-	Status  string          `json:"status"`
-	Message string          `json:"message"`
-	Data    json.RawMessage `json:"data"`
-	Code    int             `json:"code"`
+	Status     string          `json:"status"`
+	ResponseID string          `json:"response_id"`
+	Message    string          `json:"message"`
+	Data       json.RawMessage `json:"data"`
+	Code       int             `json:"code"`
 }
 
-// makeRequest performs an HTTP request to the pfSense API
-func (c *Client) makeRequest(method, endpoint string, body interface{}) (*APIResponse, error) {
+// makeRequest performs an HTTP request to the pfSense API, aborting it when ctx is canceled
+func (c *Client) makeRequest(ctx context.Context, method, endpoint string, body interface{}) (*APIResponse, error) {
 	url := fmt.Sprintf("%s%s", c.baseURL, endpoint)
 
 	var reqBody io.Reader
@@ -109,7 +168,7 @@ func (c *Client) makeRequest(method, endpoint string, body interface{}) (*APIRes
 		reqBody = bytes.NewBuffer(jsonData)
 	}
 
-	req, err := http.NewRequest(method, url, reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -139,7 +198,7 @@ func (c *Client) makeRequest(method, endpoint string, body interface{}) (*APIRes
 
 	if resp.StatusCode >= 400 {
 		c.logger.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
+		return nil, c.apiError(method, endpoint, resp.StatusCode, respBody)
 	}
 
 	var apiResp APIResponse
@@ -152,12 +211,54 @@ func (c *Client) makeRequest(method, endpoint string, body interface{}) (*APIRes
 		}
 	}
 
+	// Failures reported in the response body only are errors all the same
+	if apiResp.Code >= 400 {
+		c.logger.Errorf("API request failed with code %d: %s", apiResp.Code, apiResp.Message)
+		return nil, c.apiError(method, endpoint, apiResp.Code, respBody)
+	}
+
 	return &apiResp, nil
 }
 
+// apiError builds the error of a failed request from pfSense's error response, or from the
+// raw body if it is not one
+func (c *Client) apiError(method, endpoint string, status int, body []byte) *APIError {
+	path, _, _ := strings.Cut(endpoint, "?")
+	apiErr := &APIError{
+		Endpoint:   c.name,
+		Method:     method,
+		Path:       path,
+		StatusCode: status,
+		Message:    strings.TrimSpace(string(body)),
+	}
+
+	var apiResp APIResponse
+	if err := json.Unmarshal(body, &apiResp); err == nil && (apiResp.ResponseID != "" || apiResp.Message != "") {
+		apiErr.ResponseID = apiResp.ResponseID
+		apiErr.Message = apiResp.Message
+	}
+	return apiErr
+}
+
+// withID returns the fields of an object along with its ID. Objects omit an ID of 0, but that
+// is the ID of the first object of a kind and updates must always name their object. Objects
+// that cannot be marshaled are returned as they are, for makeRequest to report.
+func withID(object interface{}, id int) interface{} {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return object
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return object
+	}
+	fields["id"] = id
+	return fields
+}
+
 // GetHAProxyBackends retrieves all HAProxy backends
-func (c *Client) GetHAProxyBackends() ([]HAProxyBackend, error) {
-	resp, err := c.makeRequest("GET", "/services/haproxy/backends?limit=0&offset=0", nil)
+func (c *Client) GetHAProxyBackends(ctx context.Context) ([]HAProxyBackend, error) {
+	resp, err := c.makeRequest(ctx, "GET", "/services/haproxy/backends?limit=0&offset=0", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -173,38 +274,30 @@ func (c *Client) GetHAProxyBackends() ([]HAProxyBackend, error) {
 }
 
 // CreateHAProxyBackend creates a new HAProxy backend
-func (c *Client) CreateHAProxyBackend(backend *HAProxyBackend) error {
-	resp, err := c.makeRequest("POST", "/services/haproxy/backend", backend)
+func (c *Client) CreateHAProxyBackend(ctx context.Context, backend *HAProxyBackend) error {
+	_, err := c.makeRequest(ctx, "POST", "/services/haproxy/backend", backend)
 	if err != nil {
 		return err
 	}
 
-	if resp.Code >= 400 {
-		return fmt.Errorf("failed to create backend: %s", resp.Message)
-	}
-
 	c.logger.Infof("Created HAProxy backend: %s", backend.Name)
 	return nil
 }
 
 // UpdateHAProxyBackend updates an existing HAProxy backend
-func (c *Client) UpdateHAProxyBackend(backend *HAProxyBackend) error {
-	resp, err := c.makeRequest("PATCH", "/services/haproxy/backend", backend)
+func (c *Client) UpdateHAProxyBackend(ctx context.Context, backend *HAProxyBackend) error {
+	_, err := c.makeRequest(ctx, "PATCH", "/services/haproxy/backend", withID(backend, backend.ID))
 	if err != nil {
 		return err
 	}
 
-	if resp.Code >= 400 {
-		return fmt.Errorf("failed to update backend: %s", resp.Message)
-	}
-
 	c.logger.Infof("Updated HAProxy backend: %s", backend.Name)
 	return nil
 }
 
 // GetHAProxyFrontends retrieves all HAProxy frontends
-func (c *Client) GetHAProxyFrontends() ([]HAProxyFrontend, error) {
-	resp, err := c.makeRequest("GET", "/services/haproxy/frontends?limit=0&offset=0", nil)
+func (c *Client) GetHAProxyFrontends(ctx context.Context) ([]HAProxyFrontend, error) {
+	resp, err := c.makeRequest(ctx, "GET", "/services/haproxy/frontends?limit=0&offset=0", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -220,38 +313,30 @@ func (c *Client) GetHAProxyFrontends() ([]HAProxyFrontend, error) {
 }
 
 // CreateHAProxyFrontend creates a new HAProxy frontend
-func (c *Client) CreateHAProxyFrontend(frontend *HAProxyFrontend) error {
-	resp, err := c.makeRequest("POST", "/services/haproxy/frontend", frontend)
+func (c *Client) CreateHAProxyFrontend(ctx context.Context, frontend *HAProxyFrontend) error {
+	_, err := c.makeRequest(ctx, "POST", "/services/haproxy/frontend", frontend)
 	if err != nil {
 		return err
 	}
 
-	if resp.Code >= 400 {
-		return fmt.Errorf("failed to create frontend: %s", resp.Message)
-	}
-
 	c.logger.Infof("Created HAProxy frontend: %s", frontend.Name)
 	return nil
 }
 
 // ApplyHAProxyChanges applies HAProxy configuration changes
-func (c *Client) ApplyHAProxyChanges() error {
-	resp, err := c.makeRequest("POST", "/services/haproxy/apply", map[string]interface{}{})
+func (c *Client) ApplyHAProxyChanges(ctx context.Context) error {
+	_, err := c.makeRequest(ctx, "POST", "/services/haproxy/apply", map[string]interface{}{})
 	if err != nil {
 		return err
 	}
 
-	if resp.Code >= 400 {
-		return fmt.Errorf("failed to apply HAProxy changes: %s", resp.Message)
-	}
-
 	c.logger.Info("Applied HAProxy configuration changes")
 	return nil
 }
 
 // FindBackendByName finds a backend by name
-func (c *Client) FindBackendByName(name string) (*HAProxyBackend, error) {
-	backends, err := c.GetHAProxyBackends()
+func (c *Client) FindBackendByName(ctx context.Context, name string) (*HAProxyBackend, error) {
+	backends, err := c.GetHAProxyBackends(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -266,8 +351,8 @@ func (c *Client) FindBackendByName(name string) (*HAProxyBackend, error) {
 }
 
 // FindFrontendByName finds a frontend by name
-func (c *Client) FindFrontendByName(name string) (*HAProxyFrontend, error) {
-	frontends, err := c.GetHAProxyFrontends()
+func (c *Client) FindFrontendByName(ctx context.Context, name string) (*HAProxyFrontend, error) {
+	frontends, err := c.GetHAProxyFrontends(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -282,8 +367,8 @@ func (c *Client) FindFrontendByName(name string) (*HAProxyFrontend, error) {
 }
 
 // AddACLToFrontend adds an ACL to an existing frontend
-func (c *Client) AddACLToFrontend(frontendID int, acl HAProxyACL) error {
-	resp, err := c.makeRequest("POST", "/services/haproxy/frontend/acl", map[string]interface{}{
+func (c *Client) AddACLToFrontend(ctx context.Context, frontendID int, acl HAProxyACL) error {
+	_, err := c.makeRequest(ctx, "POST", "/services/haproxy/frontend/acl", map[string]interface{}{
 		"parent_id":  frontendID,
 		"name":       acl.Name,
 		"expression": acl.Expression,
@@ -293,17 +378,13 @@ func (c *Client) AddACLToFrontend(frontendID int, acl HAProxyACL) error {
 		return err
 	}
 
-	if resp.Code >= 400 {
-		return fmt.Errorf("failed to add ACL to frontend: %s", resp.Message)
-	}
-
 	c.logger.Infof("Added ACL '%s' to frontend ID %d", acl.Name, frontendID)
 	return nil
 }
 
 // AddActionToFrontend adds an action to an existing frontend
-func (c *Client) AddActionToFrontend(frontendID int, action HAProxyAction) error {
-	resp, err := c.makeRequest("POST", "/services/haproxy/frontend/action", map[string]interface{}{
+func (c *Client) AddActionToFrontend(ctx context.Context, frontendID int, action HAProxyAction) error {
+	_, err := c.makeRequest(ctx, "POST", "/services/haproxy/frontend/action", map[string]interface{}{
 		"parent_id": frontendID,
 		"action":    action.Action,
 		"acl":       action.ACL,
@@ -313,26 +394,235 @@ func (c *Client) AddActionToFrontend(frontendID int, action HAProxyAction) error
 		return err
 	}
 
-	if resp.Code >= 400 {
-		return fmt.Errorf("failed to add action to frontend: %s", resp.Message)
+	c.logger.Infof("Added action '%s' to frontend ID %d", action.Action, frontendID)
+	return nil
+}
+
+// SetFrontendSSLOffloadCertificate sets the default SSL offloading certificate of a frontend
+func (c *Client) SetFrontendSSLOffloadCertificate(ctx context.Context, frontendID int, refID string) error {
+	_, err := c.makeRequest(ctx, "PATCH", "/services/haproxy/frontend", map[string]interface{}{
+		"id":             frontendID,
+		"ssloffloadcert": refID,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Set SSL offloading certificate '%s' of frontend ID %d", refID, frontendID)
+	return nil
+}
+
+// SetFrontendDescription sets the description of a frontend
+func (c *Client) SetFrontendDescription(ctx context.Context, frontendID int, description string) error {
+	_, err := c.makeRequest(ctx, "PATCH", "/services/haproxy/frontend", map[string]interface{}{
+		"id":    frontendID,
+		"descr": description,
+	})
+	if err != nil {
+		return err
 	}
 
-	c.logger.Infof("Added action '%s' to frontend ID %d", action.Action, frontendID)
+	c.logger.Infof("Set description of frontend ID %d", frontendID)
+	return nil
+}
+
+// AddCertificateToFrontend adds a certificate to the additional certificates of a frontend
+func (c *Client) AddCertificateToFrontend(ctx context.Context, frontendID int, refID string) error {
+	_, err := c.makeRequest(ctx, "POST", "/services/haproxy/frontend/certificate", map[string]interface{}{
+		"parent_id":       frontendID,
+		"ssl_certificate": refID,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Added certificate '%s' to frontend ID %d", refID, frontendID)
+	return nil
+}
+
+// DeleteCertificateFromFrontend deletes a certificate from the additional certificates of a frontend
+func (c *Client) DeleteCertificateFromFrontend(ctx context.Context, frontendID, certificateID int) error {
+	endpoint := fmt.Sprintf("/services/haproxy/frontend/certificate?parent_id=%d&id=%d", frontendID, certificateID)
+	_, err := c.makeRequest(ctx, "DELETE", endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Deleted certificate ID %d from frontend ID %d", certificateID, frontendID)
+	return nil
+}
+
+// UpdateFrontendAddress updates an existing listen address of a frontend
+func (c *Client) UpdateFrontendAddress(ctx context.Context, frontendID int, address HAProxyFrontendAddress) error {
+	_, err := c.makeRequest(ctx, "PATCH", "/services/haproxy/frontend/address", map[string]interface{}{
+		"parent_id":    frontendID,
+		"id":           address.ID,
+		"extaddr":      address.Address,
+		"extaddr_port": address.Port,
+		"extaddr_ssl":  address.SSL,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Updated address '%s:%s' of frontend ID %d", address.Address, address.Port, frontendID)
+	return nil
+}
+
+// GetCertificates retrieves all certificates of the certificate manager
+func (c *Client) GetCertificates(ctx context.Context) ([]Certificate, error) {
+	resp, err := c.makeRequest(ctx, "GET", "/system/certificates?limit=0&offset=0", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates []Certificate
+	if len(resp.Data) > 0 {
+		if err := json.Unmarshal(resp.Data, &certificates); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal certificates: %w", err)
+		}
+	}
+
+	return certificates, nil
+}
+
+// ImportCertificate imports a certificate and its private key into the certificate manager
+// and returns the refid pfSense assigned to it
+func (c *Client) ImportCertificate(ctx context.Context, descr string, bundle *CertificateBundle) (string, error) {
+	resp, err := c.makeRequest(ctx, "POST", "/system/certificate", map[string]interface{}{
+		"method": "import",
+		"descr":  descr,
+		"crt":    bundle.Certificate,
+		"prv":    bundle.Key,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var certificate Certificate
+	if len(resp.Data) > 0 {
+		if err := json.Unmarshal(resp.Data, &certificate); err != nil {
+			return "", fmt.Errorf("failed to unmarshal certificate: %w", err)
+		}
+	}
+
+	c.logger.Infof("Imported certificate '%s' with refid %s", bundle.Name, certificate.RefID)
+	return certificate.RefID, nil
+}
+
+// DeleteCertificate deletes a certificate from the certificate manager
+func (c *Client) DeleteCertificate(ctx context.Context, certificateID int) error {
+	_, err := c.makeRequest(ctx, "DELETE", fmt.Sprintf("/system/certificate?id=%d", certificateID), nil)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Deleted certificate ID %d", certificateID)
+	return nil
+}
+
+// FindCertificate finds a certificate by refid or description
+func (c *Client) FindCertificate(ctx context.Context, ref string) (*Certificate, error) {
+	certificates, err := c.GetCertificates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return MatchCertificate(certificates, ref), nil
+}
+
+// MatchCertificate returns the certificate with the given refid or, failing that, the
+// given description
+func MatchCertificate(certificates []Certificate, ref string) *Certificate {
+	for i := range certificates {
+		if certificates[i].RefID == ref {
+			return &certificates[i]
+		}
+	}
+	for i := range certificates {
+		if certificates[i].Description == ref {
+			return &certificates[i]
+		}
+	}
+	return nil
+}
+
+// UpdateFrontendACL updates an existing ACL of a frontend
+func (c *Client) UpdateFrontendACL(ctx context.Context, frontendID int, acl HAProxyACL) error {
+	_, err := c.makeRequest(ctx, "PATCH", "/services/haproxy/frontend/acl", map[string]interface{}{
+		"parent_id":  frontendID,
+		"id":         acl.ID,
+		"name":       acl.Name,
+		"expression": acl.Expression,
+		"value":      acl.Value,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Updated ACL '%s' of frontend ID %d", acl.Name, frontendID)
 	return nil
 }
 
-// UpdateFrontendWithACLAndAction updates an existing frontend by adding ACL and action
-func (c *Client) UpdateFrontendWithACLAndAction(frontendID int, acl HAProxyACL, action HAProxyAction) error {
-	// Add ACL first
-	if err := c.AddACLToFrontend(frontendID, acl); err != nil {
-		return fmt.Errorf("failed to add ACL: %w", err)
+// UpdateFrontendAction updates an existing action of a frontend
+func (c *Client) UpdateFrontendAction(ctx context.Context, frontendID int, action HAProxyAction) error {
+	_, err := c.makeRequest(ctx, "PATCH", "/services/haproxy/frontend/action", map[string]interface{}{
+		"parent_id": frontendID,
+		"id":        action.ID,
+		"action":    action.Action,
+		"acl":       action.ACL,
+		"backend":   action.Backend,
+	})
+	if err != nil {
+		return err
 	}
 
-	// Add action
-	if err := c.AddActionToFrontend(frontendID, action); err != nil {
-		return fmt.Errorf("failed to add action: %w", err)
+	c.logger.Infof("Updated action '%s' of frontend ID %d", action.Action, frontendID)
+	return nil
+}
+
+// DeleteHAProxyBackend deletes an existing HAProxy backend
+func (c *Client) DeleteHAProxyBackend(ctx context.Context, backendID int) error {
+	_, err := c.makeRequest(ctx, "DELETE", fmt.Sprintf("/services/haproxy/backend?id=%d", backendID), nil)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Deleted HAProxy backend ID %d", backendID)
+	return nil
+}
+
+// DeleteHAProxyFrontend deletes an existing HAProxy frontend
+func (c *Client) DeleteHAProxyFrontend(ctx context.Context, frontendID int) error {
+	_, err := c.makeRequest(ctx, "DELETE", fmt.Sprintf("/services/haproxy/frontend?id=%d", frontendID), nil)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Deleted HAProxy frontend ID %d", frontendID)
+	return nil
+}
+
+// DeleteACLFromFrontend deletes an ACL from an existing frontend
+func (c *Client) DeleteACLFromFrontend(ctx context.Context, frontendID, aclID int) error {
+	endpoint := fmt.Sprintf("/services/haproxy/frontend/acl?parent_id=%d&id=%d", frontendID, aclID)
+	_, err := c.makeRequest(ctx, "DELETE", endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Deleted ACL ID %d from frontend ID %d", aclID, frontendID)
+	return nil
+}
+
+// DeleteActionFromFrontend deletes an action from an existing frontend
+func (c *Client) DeleteActionFromFrontend(ctx context.Context, frontendID, actionID int) error {
+	endpoint := fmt.Sprintf("/services/haproxy/frontend/action?parent_id=%d&id=%d", frontendID, actionID)
+	_, err := c.makeRequest(ctx, "DELETE", endpoint, nil)
+	if err != nil {
+		return err
 	}
 
-	c.logger.Infof("Successfully updated frontend ID %d with new ACL and action", frontendID)
+	c.logger.Infof("Deleted action ID %d from frontend ID %d", actionID, frontendID)
 	return nil
 }
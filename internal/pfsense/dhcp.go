@@ -0,0 +1,135 @@
+package pfsense
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// DHCPStaticMapping represents a static mapping of the DHCP server of an interface
+type DHCPStaticMapping struct {
+	// ParentID is the interface whose DHCP server the mapping belongs to, such as lan
+	ParentID    string `json:"parent_id"`
+	MAC         string `json:"mac"`
+	IPAddress   string `json:"ipaddr"`
+	Hostname    string `json:"hostname"`
+	Description string `json:"descr"`
+	ID          int    `json:"id,omitempty"`
+}
+
+// Key identifies the static mapping by its interface and MAC address
+func (m *DHCPStaticMapping) Key() string {
+	return m.ParentID + "/" + strings.ToLower(m.MAC)
+}
+
+// Owner returns the ownership marker stored in the static mapping's description
+func (m *DHCPStaticMapping) Owner() *Owner {
+	return ParseOwner(m.Description)
+}
+
+// SetOwner stores an ownership marker in the static mapping's description
+func (m *DHCPStaticMapping) SetOwner(owner Owner) {
+	m.Description = owner.String()
+}
+
+// Interface represents a pfSense network interface
+type Interface struct {
+	// ID is the internal name of the interface, such as lan or opt1
+	ID          string `json:"id"`
+	Description string `json:"descr"`
+	// IPAddress is the IPv4 address of the interface, or how it is obtained, such as dhcp
+	IPAddress string `json:"ipaddr"`
+	// Subnet is the prefix length of the interface's IPv4 network
+	Subnet json.Number `json:"subnet"`
+}
+
+// Contains reports whether an IP address is in the network of the interface
+func (i *Interface) Contains(ip string) bool {
+	_, network, err := net.ParseCIDR(i.IPAddress + "/" + i.Subnet.String())
+	if err != nil {
+		return false
+	}
+	return network.Contains(net.ParseIP(ip))
+}
+
+// GetInterfaces retrieves all network interfaces
+func (c *Client) GetInterfaces(ctx context.Context) ([]Interface, error) {
+	resp, err := c.makeRequest(ctx, "GET", "/interfaces?limit=0&offset=0", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var interfaces []Interface
+	if len(resp.Data) > 0 {
+		if err := json.Unmarshal(resp.Data, &interfaces); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal interfaces: %w", err)
+		}
+	}
+
+	return interfaces, nil
+}
+
+// GetDHCPStaticMappings retrieves the static mappings of the DHCP servers of all interfaces
+func (c *Client) GetDHCPStaticMappings(ctx context.Context) ([]DHCPStaticMapping, error) {
+	resp, err := c.makeRequest(ctx, "GET", "/services/dhcp_server/static_mappings?limit=0&offset=0", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var mappings []DHCPStaticMapping
+	if len(resp.Data) > 0 {
+		if err := json.Unmarshal(resp.Data, &mappings); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal DHCP static mappings: %w", err)
+		}
+	}
+
+	return mappings, nil
+}
+
+// CreateDHCPStaticMapping creates a new static mapping of the DHCP server of an interface
+func (c *Client) CreateDHCPStaticMapping(ctx context.Context, mapping *DHCPStaticMapping) error {
+	_, err := c.makeRequest(ctx, "POST", "/services/dhcp_server/static_mapping", mapping)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Created DHCP static mapping: %s", mapping.Key())
+	return nil
+}
+
+// UpdateDHCPStaticMapping updates an existing static mapping of the DHCP server of an interface
+func (c *Client) UpdateDHCPStaticMapping(ctx context.Context, mapping *DHCPStaticMapping) error {
+	_, err := c.makeRequest(ctx, "PATCH", "/services/dhcp_server/static_mapping", withID(mapping, mapping.ID))
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Updated DHCP static mapping: %s", mapping.Key())
+	return nil
+}
+
+// DeleteDHCPStaticMapping deletes an existing static mapping of the DHCP server of an interface
+func (c *Client) DeleteDHCPStaticMapping(ctx context.Context, parentID string, mappingID int) error {
+	endpoint := fmt.Sprintf("/services/dhcp_server/static_mapping?parent_id=%s&id=%d", url.QueryEscape(parentID), mappingID)
+	_, err := c.makeRequest(ctx, "DELETE", endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Deleted DHCP static mapping ID %d of interface %s", mappingID, parentID)
+	return nil
+}
+
+// ApplyDHCPServerChanges applies pending DHCP server configuration changes
+func (c *Client) ApplyDHCPServerChanges(ctx context.Context) error {
+	_, err := c.makeRequest(ctx, "POST", "/services/dhcp_server/apply", map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+
+	c.logger.Info("Applied DHCP server configuration changes")
+	return nil
+}
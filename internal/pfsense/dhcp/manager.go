@@ -0,0 +1,442 @@
+// Package dhcp provides pfSense DHCP server static mapping management
+package dhcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/config"
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+	"github.com/KristijanL/pfsense-container-controller/internal/labels"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+	"github.com/sirupsen/logrus"
+)
+
+// Manager manages DHCP static mappings for containers on macvlan and ipvlan networks
+type Manager struct {
+	clients map[string]pfsense.API
+	parser  *labels.Parser
+	logger  *logrus.Entry
+	config  *config.Config
+}
+
+// NewManager creates a new DHCP manager
+func NewManager(cfg *config.Config) (*Manager, error) {
+	clients := make(map[string]pfsense.API)
+
+	// Create clients for all configured endpoints
+	for _, endpoint := range cfg.Endpoints {
+		client := pfsense.NewClient(&endpoint)
+		clients[endpoint.Name] = client
+	}
+
+	return &Manager{
+		clients: clients,
+		parser: labels.NewParserWithOptions(labels.Options{
+			TraefikCompatMode:  cfg.Global.TraefikCompatMode,
+			PreferredNetworks:  cfg.Global.PreferredNetworks,
+			AddressMode:        cfg.Global.AddressMode,
+			HostAddress:        cfg.Global.HostAddress,
+			AdvertiseAddresses: cfg.AdvertiseAddresses(),
+		}),
+		logger: logrus.WithField("component", "dhcp-manager"),
+		config: cfg,
+	}, nil
+}
+
+// Change is a single create, update or delete of a static mapping
+type Change struct {
+	Mapping *pfsense.DHCPStaticMapping `json:"static_mapping"`
+	Type    pfsense.ChangeType         `json:"type"`
+}
+
+// String names the static mapping by its key, address and hostname
+func (c *Change) String() string {
+	return fmt.Sprintf("%s DHCP static mapping %s (%s %s)", c.Type, c.Mapping.Key(), c.Mapping.IPAddress, c.Mapping.Hostname)
+}
+
+// Plan holds the static mapping changes of an endpoint
+type Plan = pfsense.Plan[Change]
+
+// changeID returns the pfSense ID of the static mapping a change applies to
+func changeID(c *Change) int {
+	return c.Mapping.ID
+}
+
+// desiredMapping is a static mapping wanted on an endpoint
+type desiredMapping struct {
+	mapping *pfsense.DHCPStaticMapping
+	adopt   bool
+}
+
+// eligibleContainer is a container with DHCP labels, waiting for the interfaces of its
+// endpoint to be known
+type eligibleContainer struct {
+	info       *container.Info
+	dhcpConfig *labels.DHCPConfig
+}
+
+// SyncContainer creates or updates the static mappings of a container, replacing owned mappings
+// of the same address or hostname left behind by an earlier container
+func (m *Manager) SyncContainer(ctx context.Context, containerInfo *container.Info) error {
+	dhcpConfig, err := m.parser.ParseContainerDHCP(containerInfo)
+	if err != nil {
+		m.logger.Debugf("Container %s not eligible for DHCP sync: %v", containerInfo.Name, err)
+		return nil
+	}
+
+	endpoint := pfsense.ResolveEndpoint(m.config, m.clients, dhcpConfig.EndpointName, m.logger)
+	if endpoint == "" {
+		return fmt.Errorf("pfSense endpoint '%s' not found", dhcpConfig.EndpointName)
+	}
+
+	if m.config.Global.DryRun {
+		m.logger.Infof("Dry run: not syncing DHCP static mappings of container %s", containerInfo.Name)
+		return nil
+	}
+
+	client := m.clients[endpoint]
+	interfaces, err := m.getInterfaces(ctx, client, []eligibleContainer{{info: containerInfo, dhcpConfig: dhcpConfig}})
+	if err != nil {
+		return err
+	}
+
+	desired := make(map[string]*desiredMapping)
+	if err := m.addDesiredContainer(desired, interfaces, containerInfo, dhcpConfig); err != nil {
+		return err
+	}
+
+	actual, err := client.GetDHCPStaticMappings(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get DHCP static mappings: %w", err)
+	}
+
+	return m.executeChanges(ctx, endpoint, client, m.planMappings(desired, actual, false))
+}
+
+// RemoveContainer deletes the static mappings owned by a removed container
+func (m *Manager) RemoveContainer(ctx context.Context, containerInfo *container.Info) error {
+	dhcpConfig, err := m.parser.ParseContainerDHCPForRemoval(containerInfo)
+	if err != nil {
+		m.logger.Debugf("Container %s had no DHCP static mappings", containerInfo.Name)
+		return nil
+	}
+
+	endpoint := pfsense.ResolveEndpoint(m.config, m.clients, dhcpConfig.EndpointName, m.logger)
+	if endpoint == "" {
+		return fmt.Errorf("pfSense endpoint '%s' not found", dhcpConfig.EndpointName)
+	}
+
+	if m.config.Global.DryRun {
+		m.logger.Infof("Dry run: not removing DHCP static mappings of container %s", containerInfo.Name)
+		return nil
+	}
+
+	client := m.clients[endpoint]
+	actual, err := client.GetDHCPStaticMappings(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get DHCP static mappings: %w", err)
+	}
+
+	// Removed containers no longer report their networks, so mappings are found by owner
+	containerID := pfsense.ShortContainerID(containerInfo.ID)
+	var deletes []Change
+	for i := range actual {
+		owner := actual[i].Owner()
+		if owner.IsOwnedBy(m.config.Global.InstanceID) && owner.ContainerID == containerID {
+			deletes = append(deletes, Change{Type: pfsense.ChangeDelete, Mapping: &actual[i]})
+		}
+	}
+	pfsense.SortByIDDescending(deletes, changeID)
+
+	return m.executeChanges(ctx, endpoint, client, deletes)
+}
+
+// Reconcile converges the static mappings of every endpoint to the desired state derived from
+// the given containers. Static mappings owned by this controller that no container wants
+// anymore are deleted.
+func (m *Manager) Reconcile(ctx context.Context, containers []*container.Info) error {
+	eligible := m.eligibleContainers(containers)
+
+	var errs []error
+	for _, endpoint := range pfsense.SortedKeys(m.clients) {
+		if err := m.reconcileEndpoint(ctx, endpoint, eligible[endpoint]); err != nil {
+			errs = append(errs, fmt.Errorf("endpoint %s: %w", endpoint, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Plan computes the static mapping changes Reconcile would make on every endpoint without
+// executing them. Only the actual state is read from pfSense, nothing is written or applied.
+func (m *Manager) Plan(ctx context.Context, containers []*container.Info) ([]*Plan, error) {
+	eligible := m.eligibleContainers(containers)
+
+	var plans []*Plan
+	var errs []error
+	for _, endpoint := range pfsense.SortedKeys(m.clients) {
+		changes, err := m.planEndpoint(ctx, endpoint, eligible[endpoint])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("endpoint %s: failed to plan changes: %w", endpoint, err))
+			continue
+		}
+		plans = append(plans, &Plan{Endpoint: endpoint, Changes: changes})
+	}
+
+	return plans, errors.Join(errs...)
+}
+
+// reconcileEndpoint plans and executes the static mapping changes of a single endpoint
+func (m *Manager) reconcileEndpoint(ctx context.Context, endpoint string, eligible []eligibleContainer) error {
+	changes, err := m.planEndpoint(ctx, endpoint, eligible)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		m.logger.Debugf("DHCP static mappings of endpoint %s are up to date", endpoint)
+		return nil
+	}
+
+	if m.config.Global.DryRun {
+		for i := range changes {
+			m.logger.Infof("Dry run: endpoint %s: would %s", endpoint, &changes[i])
+		}
+		return nil
+	}
+
+	return m.executeChanges(ctx, endpoint, m.clients[endpoint], changes)
+}
+
+// planEndpoint fetches the interfaces and static mappings of an endpoint and computes the
+// changes that converge the static mappings to the desired state
+func (m *Manager) planEndpoint(ctx context.Context, endpoint string, eligible []eligibleContainer) ([]Change, error) {
+	client := m.clients[endpoint]
+
+	desired := make(map[string]*desiredMapping)
+	if len(eligible) > 0 {
+		interfaces, err := m.getInterfaces(ctx, client, eligible)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range eligible {
+			if err := m.addDesiredContainer(desired, interfaces, c.info, c.dhcpConfig); err != nil {
+				m.logger.Errorf("Skipping DHCP static mappings of container %s: %v", c.info.Name, err)
+			}
+		}
+	}
+
+	actual, err := client.GetDHCPStaticMappings(ctx)
+	if err != nil {
+		// Without DHCP labels the static mappings are only needed for cleaning up, which an
+		// endpoint without the DHCP server API leaves nothing to do for. Other failures are
+		// reported, as they would keep stale mappings around unnoticed.
+		if len(desired) == 0 && pfsense.IsNotFound(err) {
+			m.logger.Debugf("Not cleaning up DHCP static mappings of endpoint %s: %v", endpoint, err)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get DHCP static mappings: %w", err)
+	}
+
+	return m.planMappings(desired, actual, true), nil
+}
+
+// eligibleContainers returns the running containers with DHCP labels of every endpoint, in
+// name order so conflicts resolve the same way every cycle
+func (m *Manager) eligibleContainers(containers []*container.Info) map[string][]eligibleContainer {
+	sorted := make([]*container.Info, len(containers))
+	copy(sorted, containers)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	eligible := make(map[string][]eligibleContainer)
+	for _, containerInfo := range sorted {
+		if containerInfo.State != "running" {
+			continue
+		}
+
+		dhcpConfig, err := m.parser.ParseContainerDHCP(containerInfo)
+		if err != nil {
+			m.logger.Debugf("Container %s not eligible for DHCP sync: %v", containerInfo.Name, err)
+			continue
+		}
+
+		endpoint := pfsense.ResolveEndpoint(m.config, m.clients, dhcpConfig.EndpointName, m.logger)
+		if endpoint == "" {
+			m.logger.Errorf("pfSense endpoint '%s' not found for container %s", dhcpConfig.EndpointName, containerInfo.Name)
+			continue
+		}
+
+		eligible[endpoint] = append(eligible[endpoint], eligibleContainer{info: containerInfo, dhcpConfig: dhcpConfig})
+	}
+
+	return eligible
+}
+
+// getInterfaces retrieves the interfaces of an endpoint if any container leaves its interface
+// to be derived from its address
+func (m *Manager) getInterfaces(ctx context.Context, client pfsense.API, eligible []eligibleContainer) ([]pfsense.Interface, error) {
+	for _, c := range eligible {
+		if c.dhcpConfig.Interface != "" {
+			continue
+		}
+
+		interfaces, err := client.GetInterfaces(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get interfaces: %w", err)
+		}
+		return interfaces, nil
+	}
+	return nil, nil
+}
+
+// addDesiredContainer adds the static mappings of a container to the desired state, on the
+// labeled interface or the interface whose network contains the container address. A MAC
+// address claimed by several containers is mapped for the first one.
+func (m *Manager) addDesiredContainer(
+	desired map[string]*desiredMapping,
+	interfaces []pfsense.Interface,
+	containerInfo *container.Info,
+	dhcpConfig *labels.DHCPConfig,
+) error {
+	owner := pfsense.Owner{
+		InstanceID:  m.config.Global.InstanceID,
+		ContainerID: pfsense.ShortContainerID(containerInfo.ID),
+		LabelHash:   dhcpConfig.LabelHash,
+	}
+
+	for _, entry := range dhcpConfig.Mappings {
+		iface := dhcpConfig.Interface
+		if iface == "" {
+			iface = findInterface(interfaces, entry.IP)
+		}
+		if iface == "" {
+			return fmt.Errorf("no interface network contains address %s of network %s, set %s",
+				entry.IP, entry.Network, labels.ControllerDHCPInterfaceLabel)
+		}
+
+		mapping := &pfsense.DHCPStaticMapping{
+			ParentID:  iface,
+			MAC:       entry.MAC,
+			IPAddress: entry.IP,
+			Hostname:  dhcpConfig.Hostname,
+		}
+		mapping.SetOwner(owner)
+
+		if existing, exists := desired[mapping.Key()]; exists {
+			m.logger.Warnf("DHCP static mapping %s of container %s conflicts with %s, skipping",
+				mapping.Key(), containerInfo.Name, existing.mapping.Hostname)
+			continue
+		}
+		desired[mapping.Key()] = &desiredMapping{mapping: mapping, adopt: dhcpConfig.Adopt}
+	}
+
+	return nil
+}
+
+// planMappings computes the static mapping creates, updates and deletes that converge the
+// actual mappings to the desired ones: updates first, then deletes from the highest ID down,
+// then creates. Mappings this controller does not own are left alone unless adopted. Without
+// prune only owned mappings with the address or hostname of a desired mapping are deleted,
+// since the DHCP server rejects duplicates and containers get a new MAC address when recreated.
+func (m *Manager) planMappings(desired map[string]*desiredMapping, actual []pfsense.DHCPStaticMapping, prune bool) []Change {
+	actualByKey := make(map[string]*pfsense.DHCPStaticMapping, len(actual))
+	for i := range actual {
+		if _, exists := actualByKey[actual[i].Key()]; !exists {
+			actualByKey[actual[i].Key()] = &actual[i]
+		}
+	}
+
+	// claimed holds the interface addresses and hostnames of the desired mappings
+	claimed := make(map[string]bool)
+	var updates, creates []Change
+	for _, key := range pfsense.SortedKeys(desired) {
+		wanted := desired[key]
+		claimed[wanted.mapping.ParentID+"/"+wanted.mapping.IPAddress] = true
+		claimed[wanted.mapping.ParentID+"/"+wanted.mapping.Hostname] = true
+
+		existing := actualByKey[key]
+		if existing == nil {
+			creates = append(creates, Change{Type: pfsense.ChangeCreate, Mapping: wanted.mapping})
+			continue
+		}
+
+		if !pfsense.CanModify(&m.config.Global, existing.Owner(), wanted.adopt) {
+			m.logger.Warnf("Not updating DHCP static mapping %s, it is not owned by this controller", key)
+			continue
+		}
+
+		updated := *wanted.mapping
+		updated.MAC = existing.MAC
+		updated.ID = existing.ID
+		if updated != *existing {
+			updates = append(updates, Change{Type: pfsense.ChangeUpdate, Mapping: &updated})
+		}
+	}
+
+	var deletes []Change
+	for i := range actual {
+		existing := &actual[i]
+		if !existing.Owner().IsOwnedBy(m.config.Global.InstanceID) ||
+			(desired[existing.Key()] != nil && actualByKey[existing.Key()] == existing) {
+			continue
+		}
+
+		conflicting := claimed[existing.ParentID+"/"+existing.IPAddress] || claimed[existing.ParentID+"/"+existing.Hostname]
+		if prune || conflicting {
+			deletes = append(deletes, Change{Type: pfsense.ChangeDelete, Mapping: existing})
+		}
+	}
+	pfsense.SortByIDDescending(deletes, changeID)
+
+	changes := append(updates, deletes...)
+	return append(changes, creates...)
+}
+
+// executeChanges executes static mapping changes in order and applies them, stopping at the
+// first failure
+func (m *Manager) executeChanges(ctx context.Context, endpoint string, client pfsense.API, changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	plan := &Plan{Endpoint: endpoint, Changes: changes}
+	if err := plan.Execute(ctx, &m.config.Global, m.logger, func(c *Change) error {
+		return executeChange(ctx, client, c)
+	}); err != nil {
+		return err
+	}
+
+	if err := pfsense.RetryOperation(ctx, &m.config.Global, m.logger, func() error {
+		return client.ApplyDHCPServerChanges(ctx)
+	}); err != nil {
+		return fmt.Errorf("failed to apply DHCP server changes: %w", err)
+	}
+
+	return nil
+}
+
+// executeChange creates, updates or deletes the static mapping of a change
+func executeChange(ctx context.Context, client pfsense.API, c *Change) error {
+	switch c.Type {
+	case pfsense.ChangeCreate:
+		return client.CreateDHCPStaticMapping(ctx, c.Mapping)
+	case pfsense.ChangeUpdate:
+		return client.UpdateDHCPStaticMapping(ctx, c.Mapping)
+	case pfsense.ChangeDelete:
+		return client.DeleteDHCPStaticMapping(ctx, c.Mapping.ParentID, c.Mapping.ID)
+	}
+	return fmt.Errorf("unsupported change %s", c)
+}
+
+// findInterface returns the interface whose network contains the given address, or an empty
+// string if there is none
+func findInterface(interfaces []pfsense.Interface, ip string) string {
+	for i := range interfaces {
+		if interfaces[i].Contains(ip) {
+			return interfaces[i].ID
+		}
+	}
+	return ""
+}
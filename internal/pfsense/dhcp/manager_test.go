@@ -0,0 +1,111 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package dhcp
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/config"
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+	"github.com/KristijanL/pfsense-container-controller/internal/labels"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestManager() *Manager {
+	return &Manager{
+		parser: labels.NewParserWithOptions(labels.Options{}),
+		config: &config.Config{Global: config.GlobalConfig{InstanceID: "host-a"}},
+		logger: logrus.WithField("component", "test"),
+	}
+}
+
+func TestAddDesiredContainer(t *testing.T) {
+	m := newTestManager()
+	interfaces := []pfsense.Interface{
+		{ID: "wan", IPAddress: "dhcp"},
+		{ID: "lan", IPAddress: "192.168.1.1", Subnet: "24"},
+		{ID: "opt1", IPAddress: "192.168.20.1", Subnet: "24"},
+	}
+
+	desired := make(map[string]*desiredMapping)
+	info := &container.Info{ID: "aaa", Name: "nas"}
+	dhcpConfig := &labels.DHCPConfig{Hostname: "nas", Mappings: []labels.DHCPMapping{
+		{Network: "lan", MAC: "02:42:c0:a8:01:32", IP: "192.168.1.50"},
+		{Network: "iot", MAC: "02:42:c0:a8:14:32", IP: "192.168.20.50"},
+	}}
+	if err := m.addDesiredContainer(desired, interfaces, info, dhcpConfig); err != nil {
+		t.Fatalf("addDesiredContainer() error = %v", err)
+	}
+	if got := pfsense.SortedKeys(desired); !slices.Equal(got, []string{"lan/02:42:c0:a8:01:32", "opt1/02:42:c0:a8:14:32"}) {
+		t.Errorf("desired mappings = %v, want one on lan and one on opt1", got)
+	}
+
+	// Addresses outside every interface network need the interface label
+	dhcpConfig.Mappings = []labels.DHCPMapping{{Network: "lan", MAC: "02:42:0a:00:00:05", IP: "10.0.0.5"}}
+	if err := m.addDesiredContainer(desired, interfaces, info, dhcpConfig); err == nil {
+		t.Error("addDesiredContainer() expected error for an address outside every interface network")
+	}
+	dhcpConfig.Interface = "opt2"
+	if err := m.addDesiredContainer(desired, interfaces, info, dhcpConfig); err != nil {
+		t.Errorf("addDesiredContainer() with interface label error = %v", err)
+	}
+}
+
+func TestPlanMappings(t *testing.T) {
+	m := newTestManager()
+	owner := pfsense.Owner{InstanceID: "host-a", ContainerID: "aaa"}
+	oldOwner := pfsense.Owner{InstanceID: "host-a", ContainerID: "zzz"}
+
+	newMapping := func(mac, ip, hostname string, owner *pfsense.Owner, id int) pfsense.DHCPStaticMapping {
+		mapping := pfsense.DHCPStaticMapping{ParentID: "lan", MAC: mac, IPAddress: ip, Hostname: hostname, ID: id}
+		if owner != nil {
+			mapping.SetOwner(*owner)
+		}
+		return mapping
+	}
+
+	desired := make(map[string]*desiredMapping)
+	for _, mapping := range []pfsense.DHCPStaticMapping{
+		newMapping("02:00:00:00:00:01", "192.168.1.51", "nas", &owner, 0),
+		newMapping("02:00:00:00:00:0a", "192.168.1.52", "media", &owner, 0),
+		newMapping("02:00:00:00:00:03", "192.168.1.53", "printer", &owner, 0),
+	} {
+		desired[mapping.Key()] = &desiredMapping{mapping: &mapping}
+	}
+
+	actual := []pfsense.DHCPStaticMapping{
+		// Moved to another address
+		newMapping("02:00:00:00:00:01", "192.168.1.61", "nas", &owner, 0),
+		// Up to date, with the MAC address in upper case
+		newMapping("02:00:00:00:00:0A", "192.168.1.52", "media", &owner, 1),
+		// Left behind by a recreated container with a new MAC address
+		newMapping("02:00:00:00:00:09", "192.168.1.53", "printer", &oldOwner, 2),
+		// Created by hand
+		newMapping("02:00:00:00:00:04", "192.168.1.54", "desktop", nil, 3),
+		// No longer wanted
+		newMapping("02:00:00:00:00:05", "192.168.1.55", "old", &oldOwner, 4),
+	}
+
+	changeNames := func(changes []Change) []string {
+		var names []string
+		for i := range changes {
+			names = append(names, string(changes[i].Type)+" "+changes[i].Mapping.Hostname)
+		}
+		return names
+	}
+
+	want := []string{"update nas", "delete old", "delete printer", "create printer"}
+	if got := changeNames(m.planMappings(desired, actual, true)); !slices.Equal(got, want) {
+		t.Errorf("planMappings() = %v, want %v", got, want)
+	}
+
+	// Without prune only the mapping holding a desired address is deleted
+	want = []string{"update nas", "delete printer", "create printer"}
+	if got := changeNames(m.planMappings(desired, actual, false)); !slices.Equal(got, want) {
+		t.Errorf("planMappings() without prune = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,98 @@
+package pfsense
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// DNSHostOverride represents a host override of the Unbound DNS resolver
+type DNSHostOverride struct {
+	Host        string   `json:"host"`
+	Domain      string   `json:"domain"`
+	Description string   `json:"descr"`
+	IP          []string `json:"ip"`
+	ID          int      `json:"id,omitempty"`
+}
+
+// FQDN returns the fully qualified name of the host override
+func (o *DNSHostOverride) FQDN() string {
+	return o.Host + "." + o.Domain
+}
+
+// Equal reports whether the host overrides resolve the same name to the same addresses
+func (o *DNSHostOverride) Equal(other *DNSHostOverride) bool {
+	return o.Host == other.Host && o.Domain == other.Domain && slices.Equal(o.IP, other.IP)
+}
+
+// Owner returns the ownership marker stored in the host override's description
+func (o *DNSHostOverride) Owner() *Owner {
+	return ParseOwner(o.Description)
+}
+
+// SetOwner stores an ownership marker in the host override's description
+func (o *DNSHostOverride) SetOwner(owner Owner) {
+	o.Description = owner.String()
+}
+
+// GetDNSHostOverrides retrieves all host overrides of the DNS resolver
+func (c *Client) GetDNSHostOverrides(ctx context.Context) ([]DNSHostOverride, error) {
+	resp, err := c.makeRequest(ctx, "GET", "/services/dns_resolver/host_overrides?limit=0&offset=0", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var overrides []DNSHostOverride
+	if len(resp.Data) > 0 {
+		if err := json.Unmarshal(resp.Data, &overrides); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal host overrides: %w", err)
+		}
+	}
+
+	return overrides, nil
+}
+
+// CreateDNSHostOverride creates a new host override of the DNS resolver
+func (c *Client) CreateDNSHostOverride(ctx context.Context, override *DNSHostOverride) error {
+	_, err := c.makeRequest(ctx, "POST", "/services/dns_resolver/host_override", override)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Created DNS host override: %s", override.FQDN())
+	return nil
+}
+
+// UpdateDNSHostOverride updates an existing host override of the DNS resolver
+func (c *Client) UpdateDNSHostOverride(ctx context.Context, override *DNSHostOverride) error {
+	_, err := c.makeRequest(ctx, "PATCH", "/services/dns_resolver/host_override", withID(override, override.ID))
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Updated DNS host override: %s", override.FQDN())
+	return nil
+}
+
+// DeleteDNSHostOverride deletes an existing host override of the DNS resolver
+func (c *Client) DeleteDNSHostOverride(ctx context.Context, overrideID int) error {
+	_, err := c.makeRequest(ctx, "DELETE", fmt.Sprintf("/services/dns_resolver/host_override?id=%d", overrideID), nil)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Deleted DNS host override ID %d", overrideID)
+	return nil
+}
+
+// ApplyDNSResolverChanges applies DNS resolver configuration changes
+func (c *Client) ApplyDNSResolverChanges(ctx context.Context) error {
+	_, err := c.makeRequest(ctx, "POST", "/services/dns_resolver/apply", map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+
+	c.logger.Info("Applied DNS resolver configuration changes")
+	return nil
+}
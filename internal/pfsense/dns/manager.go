@@ -0,0 +1,418 @@
+// Package dns provides pfSense DNS resolver host override management
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/config"
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+	"github.com/KristijanL/pfsense-container-controller/internal/labels"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+	"github.com/sirupsen/logrus"
+)
+
+// Manager manages DNS resolver host overrides for containers
+type Manager struct {
+	clients map[string]pfsense.API
+	parser  *labels.Parser
+	logger  *logrus.Entry
+	config  *config.Config
+}
+
+// NewManager creates a new DNS manager
+func NewManager(cfg *config.Config) (*Manager, error) {
+	clients := make(map[string]pfsense.API)
+
+	// Create clients for all configured endpoints
+	for _, endpoint := range cfg.Endpoints {
+		client := pfsense.NewClient(&endpoint)
+		clients[endpoint.Name] = client
+	}
+
+	return &Manager{
+		clients: clients,
+		parser: labels.NewParserWithOptions(labels.Options{
+			TraefikCompatMode:  cfg.Global.TraefikCompatMode,
+			PreferredNetworks:  cfg.Global.PreferredNetworks,
+			AddressMode:        cfg.Global.AddressMode,
+			HostAddress:        cfg.Global.HostAddress,
+			AdvertiseAddresses: cfg.AdvertiseAddresses(),
+		}),
+		logger: logrus.WithField("component", "dns-manager"),
+		config: cfg,
+	}, nil
+}
+
+// Change is a single create, update or delete of a host override
+type Change struct {
+	Override *pfsense.DNSHostOverride `json:"host_override"`
+	Type     pfsense.ChangeType       `json:"type"`
+}
+
+// String names the host override by its FQDN
+func (c *Change) String() string {
+	return fmt.Sprintf("%s DNS host override %s", c.Type, c.Override.FQDN())
+}
+
+// Plan holds the host override changes of an endpoint
+type Plan = pfsense.Plan[Change]
+
+// changeID returns the pfSense ID of the host override a change applies to
+func changeID(c *Change) int {
+	return c.Override.ID
+}
+
+// desiredOverride is a host override wanted on an endpoint, merged from all containers using it
+type desiredOverride struct {
+	override *pfsense.DNSHostOverride
+	owner    pfsense.Owner
+	adopt    bool
+}
+
+// SyncContainer creates or updates the host overrides of a container
+func (m *Manager) SyncContainer(ctx context.Context, containerInfo *container.Info) error {
+	dnsConfig, err := m.parser.ParseContainerDNS(containerInfo)
+	if err != nil {
+		m.logger.Debugf("Container %s not eligible for DNS sync: %v", containerInfo.Name, err)
+		return nil
+	}
+
+	endpoint := pfsense.ResolveEndpoint(m.config, m.clients, dnsConfig.EndpointName, m.logger)
+	if endpoint == "" {
+		return fmt.Errorf("pfSense endpoint '%s' not found", dnsConfig.EndpointName)
+	}
+
+	if m.config.Global.DryRun {
+		m.logger.Infof("Dry run: not syncing DNS host overrides of container %s", containerInfo.Name)
+		return nil
+	}
+
+	desired := make(map[string]*desiredOverride)
+	if err := m.addDesiredContainer(desired, endpoint, containerInfo, dnsConfig); err != nil {
+		return err
+	}
+
+	client := m.clients[endpoint]
+	actual, err := client.GetDNSHostOverrides(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get DNS host overrides: %w", err)
+	}
+
+	return m.executeChanges(ctx, endpoint, client, m.planOverrides(desired, actual, false))
+}
+
+// RemoveContainer removes the container from the owners of its host overrides, and deletes
+// the overrides no other container uses
+func (m *Manager) RemoveContainer(ctx context.Context, containerInfo *container.Info) error {
+	dnsConfig, err := m.parser.ParseContainerDNSForRemoval(containerInfo)
+	if err != nil {
+		m.logger.Debugf("Container %s had no DNS host overrides", containerInfo.Name)
+		return nil
+	}
+
+	endpoint := pfsense.ResolveEndpoint(m.config, m.clients, dnsConfig.EndpointName, m.logger)
+	if endpoint == "" {
+		return fmt.Errorf("pfSense endpoint '%s' not found", dnsConfig.EndpointName)
+	}
+
+	if m.config.Global.DryRun {
+		m.logger.Infof("Dry run: not removing DNS host overrides of container %s", containerInfo.Name)
+		return nil
+	}
+
+	client := m.clients[endpoint]
+	actual, err := client.GetDNSHostOverrides(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get DNS host overrides: %w", err)
+	}
+
+	names := make(map[string]bool, len(dnsConfig.Records))
+	for _, record := range dnsConfig.Records {
+		names[strings.ToLower(record.FQDN())] = true
+	}
+
+	containerID := pfsense.ShortContainerID(containerInfo.ID)
+	var changes, deletes []Change
+	for i := range actual {
+		existing := &actual[i]
+		if !names[strings.ToLower(existing.FQDN())] {
+			continue
+		}
+
+		owner := existing.Owner()
+		if !owner.IsOwnedBy(m.config.Global.InstanceID) {
+			m.logger.Debugf("Leaving DNS host override %s alone, it is not owned by this controller", existing.FQDN())
+			continue
+		}
+
+		remaining := owner.Remove(containerID)
+		switch {
+		case remaining.ContainerID == "":
+			deletes = append(deletes, Change{Type: pfsense.ChangeDelete, Override: existing})
+		case remaining.ContainerID != owner.ContainerID:
+			updated := *existing
+			updated.SetOwner(remaining)
+			changes = append(changes, Change{Type: pfsense.ChangeUpdate, Override: &updated})
+		}
+	}
+	pfsense.SortByIDDescending(deletes, changeID)
+
+	return m.executeChanges(ctx, endpoint, client, append(changes, deletes...))
+}
+
+// Reconcile converges the host overrides of every endpoint to the desired state derived from
+// the given containers. Host overrides owned by this controller that no container wants
+// anymore are deleted.
+func (m *Manager) Reconcile(ctx context.Context, containers []*container.Info) error {
+	states := m.buildDesiredStates(containers)
+
+	var errs []error
+	for _, endpoint := range pfsense.SortedKeys(m.clients) {
+		if err := m.reconcileEndpoint(ctx, endpoint, states[endpoint]); err != nil {
+			errs = append(errs, fmt.Errorf("endpoint %s: %w", endpoint, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Plan computes the host override changes Reconcile would make on every endpoint without
+// executing them. Only the actual state is read from pfSense, nothing is written or applied.
+func (m *Manager) Plan(ctx context.Context, containers []*container.Info) ([]*Plan, error) {
+	states := m.buildDesiredStates(containers)
+
+	var plans []*Plan
+	var errs []error
+	for _, endpoint := range pfsense.SortedKeys(m.clients) {
+		changes, err := m.planEndpoint(ctx, endpoint, states[endpoint])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("endpoint %s: failed to plan changes: %w", endpoint, err))
+			continue
+		}
+		plans = append(plans, &Plan{Endpoint: endpoint, Changes: changes})
+	}
+
+	return plans, errors.Join(errs...)
+}
+
+// reconcileEndpoint plans and executes the host override changes of a single endpoint
+func (m *Manager) reconcileEndpoint(ctx context.Context, endpoint string, desired map[string]*desiredOverride) error {
+	changes, err := m.planEndpoint(ctx, endpoint, desired)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		m.logger.Debugf("DNS host overrides of endpoint %s are up to date", endpoint)
+		return nil
+	}
+
+	if m.config.Global.DryRun {
+		for i := range changes {
+			m.logger.Infof("Dry run: endpoint %s: would %s", endpoint, &changes[i])
+		}
+		return nil
+	}
+
+	return m.executeChanges(ctx, endpoint, m.clients[endpoint], changes)
+}
+
+// planEndpoint fetches the host overrides of an endpoint and computes the changes that
+// converge them to the desired state
+func (m *Manager) planEndpoint(ctx context.Context, endpoint string, desired map[string]*desiredOverride) ([]Change, error) {
+	actual, err := m.clients[endpoint].GetDNSHostOverrides(ctx)
+	if err != nil {
+		// Without DNS labels the overrides are only needed for cleaning up, which an endpoint
+		// without the DNS resolver API leaves nothing to do for. Other failures are reported,
+		// as they would keep stale overrides around unnoticed.
+		if len(desired) == 0 && pfsense.IsNotFound(err) {
+			m.logger.Debugf("Not cleaning up DNS host overrides of endpoint %s: %v", endpoint, err)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get DNS host overrides: %w", err)
+	}
+
+	return m.planOverrides(desired, actual, true), nil
+}
+
+// buildDesiredStates builds the desired host overrides of every endpoint, keyed by host name
+func (m *Manager) buildDesiredStates(containers []*container.Info) map[string]map[string]*desiredOverride {
+	states := make(map[string]map[string]*desiredOverride)
+	for endpoint := range m.clients {
+		states[endpoint] = make(map[string]*desiredOverride)
+	}
+
+	// Process containers in a stable order so conflicts resolve the same way every cycle
+	sorted := make([]*container.Info, len(containers))
+	copy(sorted, containers)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	for _, containerInfo := range sorted {
+		if containerInfo.State != "running" {
+			continue
+		}
+
+		dnsConfig, err := m.parser.ParseContainerDNS(containerInfo)
+		if err != nil {
+			m.logger.Debugf("Container %s not eligible for DNS sync: %v", containerInfo.Name, err)
+			continue
+		}
+
+		endpoint := pfsense.ResolveEndpoint(m.config, m.clients, dnsConfig.EndpointName, m.logger)
+		if endpoint == "" {
+			m.logger.Errorf("pfSense endpoint '%s' not found for container %s", dnsConfig.EndpointName, containerInfo.Name)
+			continue
+		}
+
+		if err := m.addDesiredContainer(states[endpoint], endpoint, containerInfo, dnsConfig); err != nil {
+			m.logger.Errorf("Skipping DNS host overrides of container %s: %v", containerInfo.Name, err)
+		}
+	}
+
+	return states
+}
+
+// addDesiredContainer adds the host overrides of a container to the desired state. A host name
+// claimed by several containers resolves to the address of the first one.
+func (m *Manager) addDesiredContainer(
+	desired map[string]*desiredOverride,
+	endpoint string,
+	containerInfo *container.Info,
+	dnsConfig *labels.DNSConfig,
+) error {
+	proxyAddress := ""
+	if endpointConfig := m.config.GetEndpoint(endpoint); endpointConfig != nil {
+		proxyAddress = endpointConfig.ProxyAddress
+	}
+
+	overrides, err := m.parser.ConvertToDNSHostOverrides(dnsConfig, proxyAddress)
+	if err != nil {
+		return err
+	}
+
+	owner := pfsense.Owner{
+		InstanceID:  m.config.Global.InstanceID,
+		ContainerID: pfsense.ShortContainerID(containerInfo.ID),
+		LabelHash:   dnsConfig.LabelHash,
+	}
+
+	for i := range overrides {
+		name := strings.ToLower(overrides[i].FQDN())
+		if existing, exists := desired[name]; exists {
+			if existing.override.IP[0] != overrides[i].IP[0] {
+				m.logger.Warnf("DNS host %s of container %s conflicts with another container, keeping %s",
+					name, containerInfo.Name, existing.override.IP[0])
+			}
+			existing.owner = existing.owner.Merge(owner)
+			existing.override.SetOwner(existing.owner)
+			existing.adopt = existing.adopt || dnsConfig.Adopt
+			continue
+		}
+
+		override := overrides[i]
+		override.SetOwner(owner)
+		desired[name] = &desiredOverride{override: &override, owner: owner, adopt: dnsConfig.Adopt}
+	}
+
+	return nil
+}
+
+// planOverrides computes the host override creates, updates and deletes that converge the
+// actual overrides to the desired ones. Overrides this controller does not own are left alone
+// unless adopted. Without prune, the owners of existing overrides are extended and nothing is
+// deleted.
+func (m *Manager) planOverrides(desired map[string]*desiredOverride, actual []pfsense.DNSHostOverride, prune bool) []Change {
+	actualByName := make(map[string]*pfsense.DNSHostOverride, len(actual))
+	for i := range actual {
+		name := strings.ToLower(actual[i].FQDN())
+		if _, exists := actualByName[name]; !exists {
+			actualByName[name] = &actual[i]
+		}
+	}
+
+	var changes []Change
+	for _, name := range pfsense.SortedKeys(desired) {
+		wanted := desired[name]
+		existing := actualByName[name]
+
+		if existing == nil {
+			changes = append(changes, Change{Type: pfsense.ChangeCreate, Override: wanted.override})
+			continue
+		}
+
+		owner := existing.Owner()
+		if !pfsense.CanModify(&m.config.Global, owner, wanted.adopt) {
+			m.logger.Warnf("Not updating DNS host override %s, it is not owned by this controller", name)
+			continue
+		}
+
+		updated := *wanted.override
+		updated.ID = existing.ID
+		if !prune && owner.IsOwnedBy(m.config.Global.InstanceID) {
+			updated.SetOwner(owner.Merge(wanted.owner))
+		}
+
+		if !existing.Equal(&updated) || existing.Description != updated.Description {
+			changes = append(changes, Change{Type: pfsense.ChangeUpdate, Override: &updated})
+		}
+	}
+
+	if !prune {
+		return changes
+	}
+
+	var deletes []Change
+	for i := range actual {
+		existing := &actual[i]
+		if !existing.Owner().IsOwnedBy(m.config.Global.InstanceID) {
+			continue
+		}
+		// Duplicates of a desired host override go as well, Unbound only uses one of them
+		name := strings.ToLower(existing.FQDN())
+		if desired[name] == nil || actualByName[name] != existing {
+			deletes = append(deletes, Change{Type: pfsense.ChangeDelete, Override: existing})
+		}
+	}
+	pfsense.SortByIDDescending(deletes, changeID)
+
+	return append(changes, deletes...)
+}
+
+// executeChanges executes host override changes in order and applies them, stopping at the
+// first failure
+func (m *Manager) executeChanges(ctx context.Context, endpoint string, client pfsense.API, changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	plan := &Plan{Endpoint: endpoint, Changes: changes}
+	if err := plan.Execute(ctx, &m.config.Global, m.logger, func(c *Change) error {
+		return executeChange(ctx, client, c)
+	}); err != nil {
+		return err
+	}
+
+	if err := pfsense.RetryOperation(ctx, &m.config.Global, m.logger, func() error {
+		return client.ApplyDNSResolverChanges(ctx)
+	}); err != nil {
+		return fmt.Errorf("failed to apply DNS resolver changes: %w", err)
+	}
+
+	return nil
+}
+
+// executeChange creates, updates or deletes the host override of a change
+func executeChange(ctx context.Context, client pfsense.API, c *Change) error {
+	switch c.Type {
+	case pfsense.ChangeCreate:
+		return client.CreateDNSHostOverride(ctx, c.Override)
+	case pfsense.ChangeUpdate:
+		return client.UpdateDNSHostOverride(ctx, c.Override)
+	case pfsense.ChangeDelete:
+		return client.DeleteDNSHostOverride(ctx, c.Override.ID)
+	}
+	return fmt.Errorf("unsupported change %s", c)
+}
@@ -0,0 +1,148 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package dns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/config"
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+	"github.com/KristijanL/pfsense-container-controller/internal/labels"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense/pfsensetest"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestManager(adoptUnowned bool) *Manager {
+	return &Manager{
+		parser: labels.NewParserWithOptions(labels.Options{}),
+		config: &config.Config{
+			Global:    config.GlobalConfig{InstanceID: "host-a", AdoptUnowned: adoptUnowned},
+			Endpoints: []config.EndpointConfig{{Name: "default", ProxyAddress: "10.0.0.1"}},
+		},
+		logger: logrus.WithField("component", "test"),
+	}
+}
+
+func TestAddDesiredContainer(t *testing.T) {
+	m := newTestManager(false)
+	desired := make(map[string]*desiredOverride)
+
+	for _, id := range []string{"aaa", "bbb"} {
+		info := &container.Info{ID: id, Name: id}
+		dnsConfig := &labels.DNSConfig{
+			Records:   []labels.DNSRecord{{Host: "app", Domain: "example.com"}},
+			LabelHash: "hash-" + id,
+		}
+		if err := m.addDesiredContainer(desired, "default", info, dnsConfig); err != nil {
+			t.Fatalf("addDesiredContainer() error = %v", err)
+		}
+	}
+
+	override := desired["app.example.com"].override
+	if len(override.IP) != 1 || override.IP[0] != "10.0.0.1" {
+		t.Errorf("override IP = %v, want the proxy address", override.IP)
+	}
+	if owner := override.Owner(); owner.ContainerID != "aaa,bbb" {
+		t.Errorf("override owner = %+v, want both containers", owner)
+	}
+}
+
+func TestPlanOverrides(t *testing.T) {
+	owned := pfsense.Owner{InstanceID: "host-a", ContainerID: "aaa", LabelHash: "111"}
+	other := pfsense.Owner{InstanceID: "host-a", ContainerID: "bbb", LabelHash: "222"}
+
+	newOverride := func(host, ip string, owner *pfsense.Owner, id int) pfsense.DNSHostOverride {
+		override := pfsense.DNSHostOverride{Host: host, Domain: "example.com", IP: []string{ip}, ID: id}
+		if owner != nil {
+			override.SetOwner(*owner)
+		}
+		return override
+	}
+	desiredOf := func(host, ip string) *desiredOverride {
+		override := newOverride(host, ip, &owned, 0)
+		return &desiredOverride{override: &override, owner: owned}
+	}
+
+	desired := map[string]*desiredOverride{
+		"app.example.com":  desiredOf("app", "10.0.0.1"),
+		"api.example.com":  desiredOf("api", "10.0.0.1"),
+		"new.example.com":  desiredOf("new", "10.0.0.1"),
+		"hand.example.com": desiredOf("hand", "10.0.0.1"),
+	}
+	actual := []pfsense.DNSHostOverride{
+		newOverride("app", "10.0.0.1", &owned, 0),
+		newOverride("api", "10.0.0.9", &owned, 1),
+		newOverride("hand", "192.168.1.5", nil, 2),
+		newOverride("old", "10.0.0.1", &owned, 3),
+		newOverride("gone", "10.0.0.1", &other, 4),
+		newOverride("manual", "192.168.1.6", nil, 5),
+	}
+
+	changes := newTestManager(false).planOverrides(desired, actual, true)
+
+	want := []string{
+		"update DNS host override api.example.com",
+		"create DNS host override new.example.com",
+		"delete DNS host override gone.example.com",
+		"delete DNS host override old.example.com",
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("planOverrides() = %v, want %v", changes, want)
+	}
+	for i := range want {
+		if got := changes[i].String(); got != want[i] {
+			t.Errorf("change %d = %s, want %s", i, got, want[i])
+		}
+	}
+	if changes[0].Override.ID != 1 || changes[0].Override.IP[0] != "10.0.0.1" {
+		t.Errorf("update = %+v, want override 1 resolving to 10.0.0.1", changes[0].Override)
+	}
+
+	// Without prune, owners of existing overrides are extended and nothing is deleted
+	changes = newTestManager(false).planOverrides(map[string]*desiredOverride{
+		"app.example.com": {override: desiredOf("app", "10.0.0.1").override, owner: other},
+	}, actual, false)
+	if len(changes) != 1 || changes[0].Type != pfsense.ChangeUpdate {
+		t.Fatalf("planOverrides() without prune = %v, want one update", changes)
+	}
+	if owner := changes[0].Override.Owner(); owner.ContainerID != "aaa,bbb" {
+		t.Errorf("update owner = %+v, want both containers", owner)
+	}
+
+	// Adopting takes over overrides created by hand
+	changes = newTestManager(true).planOverrides(map[string]*desiredOverride{
+		"hand.example.com": desiredOf("hand", "10.0.0.1"),
+	}, actual, false)
+	if len(changes) != 1 || changes[0].Override.ID != 2 {
+		t.Errorf("planOverrides() with adopt_unowned = %v, want update of override 2", changes)
+	}
+}
+
+func TestManager_PlanWithoutLabels(t *testing.T) {
+	server := pfsensetest.NewServer()
+	defer server.Close()
+
+	cfg := &config.Config{
+		Endpoints: []config.EndpointConfig{server.Endpoint("default")},
+		Global:    config.GlobalConfig{InstanceID: "host-a", RetryAttempts: 1},
+	}
+	m, err := NewManager(cfg)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	// The fake serves no DNS resolver API, so there is nothing to clean up
+	if _, err := m.Plan(context.Background(), nil); err != nil {
+		t.Errorf("Plan() without the DNS resolver API error = %v, want nil", err)
+	}
+
+	// Other failures would leave stale overrides behind and are reported
+	server.AddFault(pfsensetest.Fault{Method: "GET", Path: "/services/dns_resolver/host_overrides", Status: 500})
+	if _, err := m.Plan(context.Background(), nil); err == nil {
+		t.Error("Plan() error = nil, want the failure to read the host overrides reported")
+	}
+}
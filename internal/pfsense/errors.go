@@ -0,0 +1,71 @@
+package pfsense
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// APIError is an error response of the pfSense REST API
+type APIError struct {
+	// Endpoint is the name of the configured pfSense endpoint
+	Endpoint string
+	Method   string
+	// Path is the API path requested, such as /services/haproxy/backend
+	Path string
+	// ResponseID is pfSense's identifier of the error, such as MODEL_OBJECT_NOT_FOUND
+	ResponseID string
+	Message    string
+	StatusCode int
+}
+
+func (e *APIError) Error() string {
+	message := e.Message
+	if e.ResponseID != "" {
+		message = e.ResponseID + ": " + message
+	}
+	return fmt.Sprintf("endpoint %s: %s %s failed with status %d: %s", e.Endpoint, e.Method, e.Path, e.StatusCode, message)
+}
+
+// statusOf returns the HTTP status of an API error, or 0 if err is not one
+func statusOf(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// IsNotFound reports whether err is an API error for an object or path that does not exist
+func IsNotFound(err error) bool {
+	return statusOf(err) == http.StatusNotFound
+}
+
+// IsAuth reports whether err is an API error for a missing, invalid or insufficiently
+// privileged API key
+func IsAuth(err error) bool {
+	status := statusOf(err)
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+// IsConflict reports whether err is an API error for a change conflicting with the current
+// configuration, such as deleting an object that is still in use
+func IsConflict(err error) bool {
+	return statusOf(err) == http.StatusConflict
+}
+
+// IsRetryable reports whether an operation failing with err may succeed when retried: server
+// errors, rate limiting, timeouts and connection failures. Other API errors, such as invalid
+// requests or API keys, fail the same way again.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if status := statusOf(err); status != 0 {
+		return status >= http.StatusInternalServerError || status == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
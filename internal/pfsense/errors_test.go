@@ -0,0 +1,199 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package pfsense_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense/pfsensetest"
+	"github.com/sirupsen/logrus"
+)
+
+func TestAPIError(t *testing.T) {
+	server := pfsensetest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	endpoint := server.Endpoint("primary")
+	endpoint.RequestTimeout.Duration = 20 * time.Millisecond
+	client := pfsense.NewClient(&endpoint)
+
+	server.SetBackends([]pfsense.HAProxyBackend{{Name: "web"}})
+	server.SetFrontends([]pfsense.HAProxyFrontend{{
+		Name:        "shared",
+		ActionItems: []pfsense.HAProxyAction{{Action: "use_backend", Backend: "web"}},
+	}})
+
+	wrongKey := server.Endpoint("primary")
+	wrongKey.APIKey = "wrong"
+
+	// pfSense may also report a failure in the body of a successful response
+	bodyFailure := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"code":409,"status":"conflict","response_id":"HAPROXY_FRONTEND_IN_USE","message":"in use"}`))
+	}))
+	defer bodyFailure.Close()
+	bodyFailureEndpoint := server.Endpoint("primary")
+	bodyFailureEndpoint.URL = bodyFailure.URL
+
+	tests := []struct {
+		request        func() error
+		name           string
+		wantResponseID string
+		wantStatus     int
+		wantNotFound   bool
+		wantAuth       bool
+		wantConflict   bool
+		wantRetryable  bool
+	}{
+		{
+			name:           "missing object",
+			request:        func() error { return client.DeleteHAProxyBackend(ctx, 5) },
+			wantStatus:     http.StatusNotFound,
+			wantResponseID: "MODEL_OBJECT_NOT_FOUND",
+			wantNotFound:   true,
+		},
+		{
+			name: "bad API key",
+			request: func() error {
+				_, err := pfsense.NewClient(&wrongKey).GetHAProxyBackends(ctx)
+				return err
+			},
+			wantStatus:     http.StatusUnauthorized,
+			wantResponseID: "AUTH_AUTHENTICATION_FAILED",
+			wantAuth:       true,
+		},
+		{
+			name:           "backend in use",
+			request:        func() error { return client.DeleteHAProxyBackend(ctx, 0) },
+			wantStatus:     http.StatusConflict,
+			wantResponseID: "HAPROXY_BACKEND_IN_USE",
+			wantConflict:   true,
+		},
+		{
+			name:           "validation error",
+			request:        func() error { return client.CreateHAProxyBackend(ctx, &pfsense.HAProxyBackend{Name: "web"}) },
+			wantStatus:     http.StatusBadRequest,
+			wantResponseID: "FIELD_MUST_BE_UNIQUE",
+		},
+		{
+			name: "failure in the response body",
+			request: func() error {
+				return pfsense.NewClient(&bodyFailureEndpoint).SetFrontendDescription(ctx, 0, "shared")
+			},
+			wantStatus:     http.StatusConflict,
+			wantResponseID: "HAPROXY_FRONTEND_IN_USE",
+			wantConflict:   true,
+		},
+		{
+			name: "server error",
+			request: func() error {
+				server.AddFault(pfsensetest.Fault{Status: http.StatusBadGateway, Times: 1})
+				return client.ApplyHAProxyChanges(ctx)
+			},
+			wantStatus:     http.StatusBadGateway,
+			wantResponseID: "PFSENSETEST_FAULT",
+			wantRetryable:  true,
+		},
+		{
+			name: "timeout",
+			request: func() error {
+				server.AddFault(pfsensetest.Fault{Latency: time.Second, Times: 1})
+				return client.ApplyHAProxyChanges(ctx)
+			},
+			wantRetryable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.request()
+			if err == nil {
+				t.Fatal("request succeeded, want an error")
+			}
+
+			var apiErr *pfsense.APIError
+			if errors.As(err, &apiErr) != (tt.wantStatus != 0) {
+				t.Fatalf("error %v: is APIError = %v, want %v", err, !(tt.wantStatus != 0), tt.wantStatus != 0)
+			}
+			if apiErr != nil {
+				if apiErr.StatusCode != tt.wantStatus || apiErr.ResponseID != tt.wantResponseID || apiErr.Endpoint != "primary" {
+					t.Errorf("APIError = %+v, want status %d, response ID %s and endpoint primary",
+						apiErr, tt.wantStatus, tt.wantResponseID)
+				}
+			}
+
+			if got := pfsense.IsNotFound(err); got != tt.wantNotFound {
+				t.Errorf("IsNotFound() = %v, want %v", got, tt.wantNotFound)
+			}
+			if got := pfsense.IsAuth(err); got != tt.wantAuth {
+				t.Errorf("IsAuth() = %v, want %v", got, tt.wantAuth)
+			}
+			if got := pfsense.IsConflict(err); got != tt.wantConflict {
+				t.Errorf("IsConflict() = %v, want %v", got, tt.wantConflict)
+			}
+			if got := pfsense.IsRetryable(err); got != tt.wantRetryable {
+				t.Errorf("IsRetryable() = %v, want %v", got, tt.wantRetryable)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	ctx := context.Background()
+	serverErr := &pfsense.APIError{StatusCode: http.StatusServiceUnavailable}
+	clientErr := &pfsense.APIError{StatusCode: http.StatusBadRequest}
+
+	tests := []struct {
+		errs         []error
+		name         string
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "success", wantAttempts: 1},
+		{name: "transient server error", errs: []error{serverErr}, wantAttempts: 2},
+		{name: "lasting server error", errs: []error{serverErr, serverErr, serverErr}, wantAttempts: 3, wantErr: true},
+		{name: "client error fails right away", errs: []error{clientErr}, wantAttempts: 1, wantErr: true},
+		{name: "local error fails right away", errs: []error{errors.New("invalid")}, wantAttempts: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := pfsense.Retry(ctx, 3, time.Millisecond, logger, func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Retry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("Retry() made %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+
+	t.Run("canceled context stops retrying", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		attempts := 0
+		err := pfsense.Retry(ctx, 5, time.Hour, logger, func() error {
+			attempts++
+			cancel()
+			return serverErr
+		})
+		if !errors.Is(err, context.Canceled) || attempts != 1 {
+			t.Errorf("Retry() = %v after %d attempts, want the cancellation after 1", err, attempts)
+		}
+	})
+}
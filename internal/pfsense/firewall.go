@@ -0,0 +1,265 @@
+package pfsense
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// FirewallAliasTypeHost is the type of aliases listing host addresses
+const FirewallAliasTypeHost = "host"
+
+// FirewallAlias represents a pfSense firewall alias. Every address has a detail entry
+// describing it at the same index.
+type FirewallAlias struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Description string   `json:"descr"`
+	Address     []string `json:"address"`
+	Detail      []string `json:"detail"`
+	ID          int      `json:"id,omitempty"`
+}
+
+// Equal reports whether the aliases list the same addresses with the same details
+func (a *FirewallAlias) Equal(other *FirewallAlias) bool {
+	return a.Name == other.Name && a.Type == other.Type && a.Description == other.Description &&
+		slices.Equal(a.Address, other.Address) && slices.Equal(a.Detail, other.Detail)
+}
+
+// Owner returns the ownership marker stored in the alias's description
+func (a *FirewallAlias) Owner() *Owner {
+	return ParseOwner(a.Description)
+}
+
+// SetOwner stores an ownership marker in the alias's description
+func (a *FirewallAlias) SetOwner(owner Owner) {
+	a.Description = owner.String()
+}
+
+// GetFirewallAliases retrieves all firewall aliases
+func (c *Client) GetFirewallAliases(ctx context.Context) ([]FirewallAlias, error) {
+	resp, err := c.makeRequest(ctx, "GET", "/firewall/aliases?limit=0&offset=0", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var aliases []FirewallAlias
+	if len(resp.Data) > 0 {
+		if err := json.Unmarshal(resp.Data, &aliases); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal firewall aliases: %w", err)
+		}
+	}
+
+	return aliases, nil
+}
+
+// CreateFirewallAlias creates a new firewall alias
+func (c *Client) CreateFirewallAlias(ctx context.Context, alias *FirewallAlias) error {
+	_, err := c.makeRequest(ctx, "POST", "/firewall/alias", alias)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Created firewall alias: %s", alias.Name)
+	return nil
+}
+
+// UpdateFirewallAlias updates an existing firewall alias
+func (c *Client) UpdateFirewallAlias(ctx context.Context, alias *FirewallAlias) error {
+	_, err := c.makeRequest(ctx, "PATCH", "/firewall/alias", withID(alias, alias.ID))
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Updated firewall alias: %s", alias.Name)
+	return nil
+}
+
+// ApplyFirewallChanges applies pending firewall configuration changes
+func (c *Client) ApplyFirewallChanges(ctx context.Context) error {
+	_, err := c.makeRequest(ctx, "POST", "/firewall/apply", map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+
+	c.logger.Info("Applied firewall configuration changes")
+	return nil
+}
+
+// NATPortForward represents a pfSense NAT port forward rule
+type NATPortForward struct {
+	Interface       string `json:"interface"`
+	IPProtocol      string `json:"ipprotocol"`
+	Protocol        string `json:"protocol"`
+	Source          string `json:"source"`
+	Destination     string `json:"destination"`
+	DestinationPort string `json:"destination_port"`
+	Target          string `json:"target"`
+	LocalPort       string `json:"local_port"`
+	Description     string `json:"descr"`
+	// AssociatedRuleID links the filter rule passing the forwarded traffic. Creating a port
+	// forward with "new" creates the filter rule along with it.
+	AssociatedRuleID string `json:"associated_rule_id,omitempty"`
+	ID               int    `json:"id,omitempty"`
+}
+
+// Owner returns the ownership marker stored in the port forward's description
+func (f *NATPortForward) Owner() *Owner {
+	return ParseOwner(f.Description)
+}
+
+// Key identifies the port forward by the interface, port and protocol it listens on
+func (f *NATPortForward) Key() string {
+	return f.Interface + ":" + f.DestinationPort + "/" + f.Protocol
+}
+
+// SetOwner stores an ownership marker in the port forward's description, after its name
+func (f *NATPortForward) SetOwner(name string, owner Owner) {
+	f.Description = name + " " + owner.String()
+}
+
+// GetNATPortForwards retrieves all NAT port forward rules
+func (c *Client) GetNATPortForwards(ctx context.Context) ([]NATPortForward, error) {
+	resp, err := c.makeRequest(ctx, "GET", "/firewall/nat/port_forwards?limit=0&offset=0", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var forwards []NATPortForward
+	if len(resp.Data) > 0 {
+		if err := json.Unmarshal(resp.Data, &forwards); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal NAT port forwards: %w", err)
+		}
+	}
+
+	return forwards, nil
+}
+
+// CreateNATPortForward creates a new NAT port forward rule
+func (c *Client) CreateNATPortForward(ctx context.Context, forward *NATPortForward) error {
+	_, err := c.makeRequest(ctx, "POST", "/firewall/nat/port_forward", forward)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Created NAT port forward: %s", forward.Key())
+	return nil
+}
+
+// UpdateNATPortForward updates an existing NAT port forward rule
+func (c *Client) UpdateNATPortForward(ctx context.Context, forward *NATPortForward) error {
+	_, err := c.makeRequest(ctx, "PATCH", "/firewall/nat/port_forward", withID(forward, forward.ID))
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Updated NAT port forward: %s", forward.Key())
+	return nil
+}
+
+// DeleteNATPortForward deletes an existing NAT port forward rule along with its associated
+// filter rule
+func (c *Client) DeleteNATPortForward(ctx context.Context, forwardID int) error {
+	_, err := c.makeRequest(ctx, "DELETE", fmt.Sprintf("/firewall/nat/port_forward?id=%d", forwardID), nil)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Deleted NAT port forward ID %d", forwardID)
+	return nil
+}
+
+// FirewallRule represents a pfSense firewall filter rule
+type FirewallRule struct {
+	// Type is pass, block or reject
+	Type       string   `json:"type"`
+	Interface  []string `json:"interface"`
+	IPProtocol string   `json:"ipprotocol"`
+	// Protocol is nil for rules matching any protocol
+	Protocol        *string `json:"protocol"`
+	Source          string  `json:"source"`
+	SourcePort      string  `json:"source_port,omitempty"`
+	Destination     string  `json:"destination"`
+	DestinationPort string  `json:"destination_port,omitempty"`
+	Description     string  `json:"descr"`
+	Disabled        bool    `json:"disabled,omitempty"`
+	// Placement is the position a new rule is inserted at, new rules are appended without it
+	Placement *int `json:"placement,omitempty"`
+	ID        int  `json:"id,omitempty"`
+}
+
+// Name returns the description of the rule without its ownership marker
+func (r *FirewallRule) Name() string {
+	name, _, _ := strings.Cut(r.Description, ownerMarkerPrefix)
+	return strings.TrimSpace(name)
+}
+
+// ProtocolName returns the protocol of the rule, "any" for rules matching any protocol
+func (r *FirewallRule) ProtocolName() string {
+	if r.Protocol == nil {
+		return "any"
+	}
+	return *r.Protocol
+}
+
+// Owner returns the ownership marker stored in the rule's description
+func (r *FirewallRule) Owner() *Owner {
+	return ParseOwner(r.Description)
+}
+
+// SetOwner stores an ownership marker in the rule's description, after its name
+func (r *FirewallRule) SetOwner(name string, owner Owner) {
+	r.Description = name + " " + owner.String()
+}
+
+// GetFirewallRules retrieves all firewall filter rules, in rule order
+func (c *Client) GetFirewallRules(ctx context.Context) ([]FirewallRule, error) {
+	resp, err := c.makeRequest(ctx, "GET", "/firewall/rules?limit=0&offset=0", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []FirewallRule
+	if len(resp.Data) > 0 {
+		if err := json.Unmarshal(resp.Data, &rules); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal firewall rules: %w", err)
+		}
+	}
+
+	return rules, nil
+}
+
+// CreateFirewallRule creates a new firewall filter rule
+func (c *Client) CreateFirewallRule(ctx context.Context, rule *FirewallRule) error {
+	_, err := c.makeRequest(ctx, "POST", "/firewall/rule", rule)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Created firewall rule: %s", rule.Name())
+	return nil
+}
+
+// UpdateFirewallRule updates an existing firewall filter rule
+func (c *Client) UpdateFirewallRule(ctx context.Context, rule *FirewallRule) error {
+	_, err := c.makeRequest(ctx, "PATCH", "/firewall/rule", withID(rule, rule.ID))
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Updated firewall rule: %s", rule.Name())
+	return nil
+}
+
+// DeleteFirewallRule deletes an existing firewall filter rule
+func (c *Client) DeleteFirewallRule(ctx context.Context, ruleID int) error {
+	_, err := c.makeRequest(ctx, "DELETE", fmt.Sprintf("/firewall/rule?id=%d", ruleID), nil)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("Deleted firewall rule ID %d", ruleID)
+	return nil
+}
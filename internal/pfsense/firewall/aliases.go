@@ -0,0 +1,265 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+	"github.com/KristijanL/pfsense-container-controller/internal/labels"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+)
+
+// aliasDetailSeparator separates the names of the containers sharing an alias address, such
+// as containers published on the same host in host address mode
+const aliasDetailSeparator = ", "
+
+// desiredAlias is a host alias wanted on an endpoint, listing the addresses of all containers
+// carrying its name
+type desiredAlias struct {
+	// entries maps addresses to the names of the containers at that address
+	entries map[string][]string
+	owner   pfsense.Owner
+	adopt   bool
+}
+
+// alias returns the pfSense alias with the desired addresses, in sorted order
+func (d *desiredAlias) alias(name string) *pfsense.FirewallAlias {
+	alias := &pfsense.FirewallAlias{
+		Name:    name,
+		Type:    pfsense.FirewallAliasTypeHost,
+		Address: []string{},
+		Detail:  []string{},
+	}
+	for _, address := range pfsense.SortedKeys(d.entries) {
+		alias.Address = append(alias.Address, address)
+		alias.Detail = append(alias.Detail, strings.Join(d.entries[address], aliasDetailSeparator))
+	}
+	alias.SetOwner(d.owner)
+	return alias
+}
+
+// addDesiredAliases adds the container's address to the desired aliases it names
+func addDesiredAliases(state *desiredState, containerInfo *container.Info, firewallConfig *labels.FirewallConfig, owner pfsense.Owner) {
+	for _, name := range firewallConfig.Aliases {
+		desired, exists := state.aliases[name]
+		if !exists {
+			desired = &desiredAlias{entries: make(map[string][]string), owner: owner}
+			state.aliases[name] = desired
+		}
+
+		desired.owner = desired.owner.Merge(owner)
+		desired.adopt = desired.adopt || firewallConfig.Adopt
+		if !slices.Contains(desired.entries[firewallConfig.Address], containerInfo.Name) {
+			desired.entries[firewallConfig.Address] = append(desired.entries[firewallConfig.Address], containerInfo.Name)
+		}
+	}
+}
+
+// planAliases computes the alias creates and updates that converge the actual aliases to the
+// desired ones. Aliases this controller does not own are left alone unless adopted. Owned
+// aliases no container carries anymore are emptied rather than deleted, since firewall rules
+// may still reference them.
+func (m *Manager) planAliases(state *desiredState, actual []pfsense.FirewallAlias) []Change {
+	actualByName := make(map[string]*pfsense.FirewallAlias, len(actual))
+	for i := range actual {
+		actualByName[actual[i].Name] = &actual[i]
+	}
+
+	var changes []Change
+	for _, name := range pfsense.SortedKeys(state.aliases) {
+		desired := state.aliases[name]
+		wanted := desired.alias(name)
+
+		existing := actualByName[name]
+		if existing == nil {
+			changes = append(changes, Change{Type: pfsense.ChangeCreate, Kind: KindAlias, Name: name, Alias: wanted})
+			continue
+		}
+
+		if !pfsense.CanModify(&m.config.Global, existing.Owner(), desired.adopt) {
+			m.logger.Warnf("Not updating firewall alias %s, it is not owned by this controller", name)
+			continue
+		}
+
+		wanted.ID = existing.ID
+		if !existing.Equal(wanted) {
+			changes = append(changes, Change{Type: pfsense.ChangeUpdate, Kind: KindAlias, Name: name, Alias: wanted})
+		}
+	}
+
+	for i := range actual {
+		existing := &actual[i]
+		if state.aliases[existing.Name] != nil || len(existing.Address) == 0 ||
+			!existing.Owner().IsOwnedBy(m.config.Global.InstanceID) {
+			continue
+		}
+
+		emptied := *existing
+		emptied.Address = []string{}
+		emptied.Detail = []string{}
+		emptied.SetOwner(pfsense.Owner{InstanceID: m.config.Global.InstanceID})
+		changes = append(changes, Change{Type: pfsense.ChangeUpdate, Kind: KindAlias, Name: existing.Name, Alias: &emptied})
+	}
+
+	return changes
+}
+
+// syncAliases computes the changes that add a container's address to its aliases, creating
+// missing aliases and dropping addresses the container had on other networks
+func (m *Manager) syncAliases(
+	ctx context.Context,
+	client pfsense.API,
+	containerInfo *container.Info,
+	firewallConfig *labels.FirewallConfig,
+) ([]Change, error) {
+	actual, err := client.GetFirewallAliases(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get firewall aliases: %w", err)
+	}
+
+	owner := m.ownerFor(containerInfo, firewallConfig)
+
+	var changes []Change
+	for _, name := range firewallConfig.Aliases {
+		existing := findAlias(actual, name)
+		if existing == nil {
+			alias := &pfsense.FirewallAlias{
+				Name:    name,
+				Type:    pfsense.FirewallAliasTypeHost,
+				Address: []string{firewallConfig.Address},
+				Detail:  []string{containerInfo.Name},
+			}
+			alias.SetOwner(owner)
+			changes = append(changes, Change{Type: pfsense.ChangeCreate, Kind: KindAlias, Name: name, Alias: alias})
+			continue
+		}
+
+		current := existing.Owner()
+		if !pfsense.CanModify(&m.config.Global, current, firewallConfig.Adopt) {
+			return nil, fmt.Errorf("firewall alias %s: %w", name, ErrNotOwned)
+		}
+
+		updated := cloneAlias(existing)
+		addAliasEntry(updated, firewallConfig.Address, containerInfo.Name)
+		removeAliasEntries(updated, containerInfo.Name, firewallConfig.Address)
+		if current.IsOwnedBy(m.config.Global.InstanceID) {
+			updated.SetOwner(current.Merge(owner))
+		} else {
+			updated.SetOwner(owner)
+		}
+
+		if !existing.Equal(updated) {
+			changes = append(changes, Change{Type: pfsense.ChangeUpdate, Kind: KindAlias, Name: name, Alias: updated})
+		}
+	}
+
+	return changes, nil
+}
+
+// removeFromAliases computes the changes that remove a container's addresses from its aliases.
+// Aliases are kept, even without addresses, since firewall rules may reference them.
+func (m *Manager) removeFromAliases(
+	ctx context.Context,
+	client pfsense.API,
+	containerInfo *container.Info,
+	firewallConfig *labels.FirewallConfig,
+) ([]Change, error) {
+	actual, err := client.GetFirewallAliases(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get firewall aliases: %w", err)
+	}
+
+	containerID := m.ownerFor(containerInfo, firewallConfig).ContainerID
+
+	var changes []Change
+	for _, name := range firewallConfig.Aliases {
+		existing := findAlias(actual, name)
+		if existing == nil {
+			continue
+		}
+
+		owner := existing.Owner()
+		if !owner.IsOwnedBy(m.config.Global.InstanceID) {
+			m.logger.Debugf("Leaving firewall alias %s alone, it is not owned by this controller", name)
+			continue
+		}
+
+		updated := cloneAlias(existing)
+		removeAliasEntries(updated, containerInfo.Name, "")
+		updated.SetOwner(owner.Remove(containerID))
+
+		if !existing.Equal(updated) {
+			changes = append(changes, Change{Type: pfsense.ChangeUpdate, Kind: KindAlias, Name: name, Alias: updated})
+		}
+	}
+
+	return changes, nil
+}
+
+// addAliasEntry adds a container at an address to an alias
+func addAliasEntry(alias *pfsense.FirewallAlias, address, containerName string) {
+	index := slices.Index(alias.Address, address)
+	if index < 0 {
+		alias.Address = append(alias.Address, address)
+		alias.Detail = append(alias.Detail, containerName)
+		return
+	}
+
+	names := splitDetail(alias.Detail[index])
+	if !slices.Contains(names, containerName) {
+		alias.Detail[index] = strings.Join(append(names, containerName), aliasDetailSeparator)
+	}
+}
+
+// removeAliasEntries removes a container from every address of an alias except the given one.
+// Addresses without containers left are removed.
+func removeAliasEntries(alias *pfsense.FirewallAlias, containerName, keepAddress string) {
+	addresses := []string{}
+	details := []string{}
+	for i, address := range alias.Address {
+		detail := alias.Detail[i]
+		if address != keepAddress {
+			names := splitDetail(detail)
+			if index := slices.Index(names, containerName); index >= 0 {
+				names = slices.Delete(names, index, index+1)
+				if len(names) == 0 {
+					continue
+				}
+				detail = strings.Join(names, aliasDetailSeparator)
+			}
+		}
+		addresses = append(addresses, address)
+		details = append(details, detail)
+	}
+	alias.Address = addresses
+	alias.Detail = details
+}
+
+// cloneAlias copies an alias, padding its details to one per address
+func cloneAlias(alias *pfsense.FirewallAlias) *pfsense.FirewallAlias {
+	clone := *alias
+	clone.Address = slices.Clone(alias.Address)
+	clone.Detail = make([]string, len(alias.Address))
+	copy(clone.Detail, alias.Detail)
+	return &clone
+}
+
+// splitDetail splits the container names of an alias address detail
+func splitDetail(detail string) []string {
+	if detail == "" {
+		return nil
+	}
+	return strings.Split(detail, aliasDetailSeparator)
+}
+
+// findAlias returns the alias with the given name, or nil if there is none
+func findAlias(aliases []pfsense.FirewallAlias, name string) *pfsense.FirewallAlias {
+	for i := range aliases {
+		if aliases[i].Name == name {
+			return &aliases[i]
+		}
+	}
+	return nil
+}
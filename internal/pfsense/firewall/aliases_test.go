@@ -0,0 +1,100 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package firewall
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/config"
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+	"github.com/KristijanL/pfsense-container-controller/internal/labels"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestManager() *Manager {
+	return &Manager{
+		config: &config.Config{Global: config.GlobalConfig{InstanceID: "host-a"}},
+		parser: labels.NewParser(false),
+		logger: logrus.WithField("component", "test"),
+	}
+}
+
+func TestPlanAliases(t *testing.T) {
+	m := newTestManager()
+
+	state := &desiredState{aliases: make(map[string]*desiredAlias)}
+	for _, c := range []struct{ id, name, address, alias string }{
+		{"aaa", "web-1", "172.17.0.3", "app_servers"},
+		{"bbb", "web-2", "172.17.0.2", "app_servers"},
+		{"ccc", "db", "172.17.0.4", "db_servers"},
+		{"ddd", "cache", "172.17.0.5", "hand_made"},
+	} {
+		firewallConfig := &labels.FirewallConfig{Aliases: []string{c.alias}, Address: c.address}
+		info := &container.Info{ID: c.id, Name: c.name}
+		addDesiredAliases(state, info, firewallConfig, m.ownerFor(info, firewallConfig))
+	}
+
+	owned := pfsense.FirewallAlias{Name: "app_servers", Type: "host", Address: []string{"172.17.0.9"}, Detail: []string{"old"}, ID: 0}
+	owned.SetOwner(pfsense.Owner{InstanceID: "host-a", ContainerID: "zzz"})
+	stale := pfsense.FirewallAlias{Name: "gone", Type: "host", Address: []string{"172.17.0.8"}, Detail: []string{"gone"}, ID: 2}
+	stale.SetOwner(pfsense.Owner{InstanceID: "host-a", ContainerID: "yyy"})
+	actual := []pfsense.FirewallAlias{
+		owned,
+		{Name: "hand_made", Type: "host", Address: []string{"10.0.0.1"}, Description: "Managed by hand", ID: 1},
+		stale,
+	}
+
+	changes := m.planAliases(state, actual)
+
+	var names []string
+	for i := range changes {
+		names = append(names, changes[i].String())
+	}
+	want := []string{
+		"update firewall alias app_servers",
+		"create firewall alias db_servers",
+		"update firewall alias gone",
+	}
+	if !slices.Equal(names, want) {
+		t.Fatalf("planAliases() = %v, want %v", names, want)
+	}
+
+	app := changes[0].Alias
+	if !slices.Equal(app.Address, []string{"172.17.0.2", "172.17.0.3"}) || !slices.Equal(app.Detail, []string{"web-2", "web-1"}) {
+		t.Errorf("app_servers = %v %v, want both web containers", app.Address, app.Detail)
+	}
+	if owner := app.Owner(); owner.ContainerID != "aaa,bbb" {
+		t.Errorf("app_servers owner = %+v, want both web containers", owner)
+	}
+	if gone := changes[2].Alias; len(gone.Address) != 0 || gone.ID != 2 {
+		t.Errorf("gone = %+v, want alias 2 emptied", gone)
+	}
+}
+
+func TestAliasEntries(t *testing.T) {
+	alias := &pfsense.FirewallAlias{
+		Address: []string{"192.168.1.10", "172.17.0.2"},
+		Detail:  []string{"web-1", "web-2"},
+	}
+
+	// A container moving networks replaces its old address, a shared address lists both containers
+	addAliasEntry(alias, "192.168.1.10", "web-2")
+	removeAliasEntries(alias, "web-2", "192.168.1.10")
+	if !slices.Equal(alias.Address, []string{"192.168.1.10"}) || !slices.Equal(alias.Detail, []string{"web-1, web-2"}) {
+		t.Fatalf("alias = %v %v, want shared address", alias.Address, alias.Detail)
+	}
+
+	removeAliasEntries(alias, "web-1", "")
+	if !slices.Equal(alias.Address, []string{"192.168.1.10"}) || !slices.Equal(alias.Detail, []string{"web-2"}) {
+		t.Fatalf("alias = %v %v, want address kept for web-2", alias.Address, alias.Detail)
+	}
+
+	removeAliasEntries(alias, "web-2", "")
+	if len(alias.Address) != 0 || len(alias.Detail) != 0 {
+		t.Errorf("alias = %v %v, want no addresses left", alias.Address, alias.Detail)
+	}
+}
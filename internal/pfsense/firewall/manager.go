@@ -0,0 +1,419 @@
+// Package firewall provides pfSense firewall configuration management
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/config"
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+	"github.com/KristijanL/pfsense-container-controller/internal/labels"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotOwned is returned when the controller refuses to modify a pfSense object it did not create
+var ErrNotOwned = errors.New("object is not owned by this controller, set the adopt label or adopt_unowned to take it over")
+
+// Manager manages firewall configurations for containers
+type Manager struct {
+	clients map[string]pfsense.API
+	parser  *labels.Parser
+	logger  *logrus.Entry
+	config  *config.Config
+}
+
+// NewManager creates a new firewall manager
+func NewManager(cfg *config.Config) (*Manager, error) {
+	clients := make(map[string]pfsense.API)
+
+	// Create clients for all configured endpoints
+	for _, endpoint := range cfg.Endpoints {
+		client := pfsense.NewClient(&endpoint)
+		clients[endpoint.Name] = client
+	}
+
+	return &Manager{
+		clients: clients,
+		parser: labels.NewParserWithOptions(labels.Options{
+			TraefikCompatMode:  cfg.Global.TraefikCompatMode,
+			PreferredNetworks:  cfg.Global.PreferredNetworks,
+			AddressMode:        cfg.Global.AddressMode,
+			HostAddress:        cfg.Global.HostAddress,
+			AdvertiseAddresses: cfg.AdvertiseAddresses(),
+		}),
+		logger: logrus.WithField("component", "firewall-manager"),
+		config: cfg,
+	}, nil
+}
+
+// ObjectKind describes the kind of firewall object a change applies to
+type ObjectKind string
+
+const (
+	// KindAlias is a firewall alias
+	KindAlias ObjectKind = "firewall alias"
+	// KindPortForward is a NAT port forward
+	KindPortForward ObjectKind = "NAT port forward"
+	// KindRule is a firewall filter rule
+	KindRule ObjectKind = "firewall rule"
+)
+
+// Change is a single create, update or delete of a firewall object
+type Change struct {
+	Alias       *pfsense.FirewallAlias  `json:"alias,omitempty"`
+	PortForward *pfsense.NATPortForward `json:"port_forward,omitempty"`
+	Rule        *pfsense.FirewallRule   `json:"rule,omitempty"`
+	Type        pfsense.ChangeType      `json:"type"`
+	Kind        ObjectKind              `json:"kind"`
+	Name        string                  `json:"name"`
+	ID          int                     `json:"-"`
+}
+
+// String names the object by its kind and name
+func (c *Change) String() string {
+	return fmt.Sprintf("%s %s %s", c.Type, c.Kind, c.Name)
+}
+
+// Plan holds the firewall changes of an endpoint
+type Plan = pfsense.Plan[Change]
+
+// changeID returns the pfSense ID of the object a change applies to
+func changeID(c *Change) int {
+	return c.ID
+}
+
+// desiredState is the firewall configuration wanted on a single endpoint
+type desiredState struct {
+	aliases map[string]*desiredAlias
+	// forwards are the NAT port forwards, by the interface, port and protocol they listen on
+	forwards map[string]*desiredPortForward
+	// rules are the filter rules, by the name of the container and the rule
+	rules map[string]*desiredRule
+}
+
+// SyncContainer adds a container to the firewall configuration
+func (m *Manager) SyncContainer(ctx context.Context, containerInfo *container.Info) error {
+	firewallConfig, err := m.parser.ParseContainerFirewall(containerInfo)
+	if err != nil {
+		m.logger.Debugf("Container %s not eligible for firewall sync: %v", containerInfo.Name, err)
+		return nil
+	}
+
+	endpoint := pfsense.ResolveEndpoint(m.config, m.clients, firewallConfig.EndpointName, m.logger)
+	if endpoint == "" {
+		return fmt.Errorf("pfSense endpoint '%s' not found", firewallConfig.EndpointName)
+	}
+
+	if m.config.Global.DryRun {
+		m.logger.Infof("Dry run: not syncing firewall configuration of container %s", containerInfo.Name)
+		return nil
+	}
+
+	client := m.clients[endpoint]
+	changes, err := m.syncAliases(ctx, client, containerInfo, firewallConfig)
+	if err != nil {
+		return err
+	}
+
+	forwards, err := m.syncPortForwards(ctx, client, containerInfo, firewallConfig)
+	if err != nil {
+		return err
+	}
+
+	changes = append(changes, forwards...)
+	if err := m.executeChanges(ctx, endpoint, client, changes); err != nil {
+		return err
+	}
+
+	// Port forwards create their associated rules, so rules are planned once they exist
+	rules, err := m.syncRules(ctx, client, containerInfo, firewallConfig)
+	if err != nil {
+		return m.applyChanges(ctx, client, len(changes), err)
+	}
+
+	err = m.executeChanges(ctx, endpoint, client, rules)
+	return m.applyChanges(ctx, client, len(changes)+len(rules), err)
+}
+
+// RemoveContainer removes a stopped or destroyed container from the firewall configuration
+func (m *Manager) RemoveContainer(ctx context.Context, containerInfo *container.Info) error {
+	firewallConfig, err := m.parser.ParseContainerFirewallForRemoval(containerInfo)
+	if err != nil {
+		m.logger.Debugf("Container %s had no firewall configuration", containerInfo.Name)
+		return nil
+	}
+
+	endpoint := pfsense.ResolveEndpoint(m.config, m.clients, firewallConfig.EndpointName, m.logger)
+	if endpoint == "" {
+		return fmt.Errorf("pfSense endpoint '%s' not found", firewallConfig.EndpointName)
+	}
+
+	if m.config.Global.DryRun {
+		m.logger.Infof("Dry run: not removing firewall configuration of container %s", containerInfo.Name)
+		return nil
+	}
+
+	client := m.clients[endpoint]
+
+	// Rules go first, since deleting port forwards renumbers the rules after their associated rules
+	rules, err := m.removeRules(ctx, client, containerInfo, firewallConfig)
+	if err != nil {
+		return err
+	}
+	if err := m.executeChanges(ctx, endpoint, client, rules); err != nil {
+		return m.applyChanges(ctx, client, len(rules), err)
+	}
+
+	changes, err := m.removeFromAliases(ctx, client, containerInfo, firewallConfig)
+	if err != nil {
+		return m.applyChanges(ctx, client, len(rules), err)
+	}
+
+	forwards, err := m.removePortForwards(ctx, client, containerInfo, firewallConfig)
+	if err != nil {
+		return m.applyChanges(ctx, client, len(rules), err)
+	}
+
+	changes = append(changes, forwards...)
+	err = m.executeChanges(ctx, endpoint, client, changes)
+	return m.applyChanges(ctx, client, len(rules)+len(changes), err)
+}
+
+// Reconcile converges the firewall configuration of every endpoint to the desired state
+// derived from the given containers
+func (m *Manager) Reconcile(ctx context.Context, containers []*container.Info) error {
+	states := m.buildDesiredStates(containers)
+
+	var errs []error
+	for _, endpoint := range pfsense.SortedKeys(m.clients) {
+		if err := m.reconcileEndpoint(ctx, endpoint, states[endpoint]); err != nil {
+			errs = append(errs, fmt.Errorf("endpoint %s: %w", endpoint, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Plan computes the firewall changes Reconcile would make on every endpoint without executing
+// them. Only the actual state is read from pfSense, nothing is written or applied. Filter
+// rules are planned against the current rules, before the port forward changes would create
+// or delete their associated rules.
+func (m *Manager) Plan(ctx context.Context, containers []*container.Info) ([]*Plan, error) {
+	states := m.buildDesiredStates(containers)
+
+	var plans []*Plan
+	var errs []error
+	for _, endpoint := range pfsense.SortedKeys(m.clients) {
+		client := m.clients[endpoint]
+		changes, err := m.planEndpoint(ctx, endpoint, client, states[endpoint])
+		if err == nil {
+			var rules []Change
+			rules, err = m.planEndpointRules(ctx, endpoint, client, states[endpoint])
+			changes = append(changes, rules...)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("endpoint %s: failed to plan changes: %w", endpoint, err))
+			continue
+		}
+		plans = append(plans, &Plan{Endpoint: endpoint, Changes: changes})
+	}
+
+	return plans, errors.Join(errs...)
+}
+
+// reconcileEndpoint plans and executes the firewall changes of a single endpoint. Filter rules
+// are planned after the alias and port forward changes are executed, since port forwards
+// create and delete their associated rules, which renumbers the rules after them.
+func (m *Manager) reconcileEndpoint(ctx context.Context, endpoint string, state *desiredState) error {
+	client := m.clients[endpoint]
+
+	changes, err := m.planEndpoint(ctx, endpoint, client, state)
+	if err != nil {
+		return err
+	}
+
+	if m.config.Global.DryRun {
+		rules, err := m.planEndpointRules(ctx, endpoint, client, state)
+		if err != nil {
+			return err
+		}
+		for _, c := range append(changes, rules...) {
+			m.logger.Infof("Dry run: endpoint %s: would %s", endpoint, &c)
+		}
+		return nil
+	}
+
+	if err := m.executeChanges(ctx, endpoint, client, changes); err != nil {
+		return m.applyChanges(ctx, client, len(changes), err)
+	}
+
+	rules, err := m.planEndpointRules(ctx, endpoint, client, state)
+	if err == nil {
+		err = m.executeChanges(ctx, endpoint, client, rules)
+	}
+
+	if len(changes)+len(rules) == 0 && err == nil {
+		m.logger.Debugf("Firewall configuration of endpoint %s is up to date", endpoint)
+		return nil
+	}
+
+	return m.applyChanges(ctx, client, len(changes)+len(rules), err)
+}
+
+// planEndpoint computes the firewall changes of a single endpoint: alias and port forward
+// writes first, then port forward deletes from the highest ID down
+func (m *Manager) planEndpoint(ctx context.Context, endpoint string, client pfsense.API, state *desiredState) ([]Change, error) {
+	var changes []Change
+
+	// Without firewall labels the actual objects are only needed for cleaning up, which an
+	// endpoint without the API for them leaves nothing to do for. Other failures are reported,
+	// as they would keep stale objects around unnoticed.
+	aliases, err := client.GetFirewallAliases(ctx)
+	switch {
+	case err == nil:
+		changes = append(changes, m.planAliases(state, aliases)...)
+	case len(state.aliases) > 0 || !pfsense.IsNotFound(err):
+		return nil, fmt.Errorf("failed to get firewall aliases: %w", err)
+	default:
+		m.logger.Debugf("Not cleaning up firewall aliases of endpoint %s: %v", endpoint, err)
+	}
+
+	var deletes []Change
+	forwards, err := client.GetNATPortForwards(ctx)
+	switch {
+	case err == nil:
+		var writes []Change
+		writes, deletes = m.planPortForwards(state, forwards, true)
+		changes = append(changes, writes...)
+	case len(state.forwards) > 0 || !pfsense.IsNotFound(err):
+		return nil, fmt.Errorf("failed to get NAT port forwards: %w", err)
+	default:
+		m.logger.Debugf("Not cleaning up NAT port forwards of endpoint %s: %v", endpoint, err)
+	}
+
+	return append(changes, deletes...), nil
+}
+
+// planEndpointRules computes the filter rule changes of a single endpoint
+func (m *Manager) planEndpointRules(ctx context.Context, endpoint string, client pfsense.API, state *desiredState) ([]Change, error) {
+	rules, err := client.GetFirewallRules(ctx)
+	switch {
+	case err == nil:
+		return m.planRules(state, rules, true), nil
+	case len(state.rules) > 0 || !pfsense.IsNotFound(err):
+		return nil, fmt.Errorf("failed to get firewall rules: %w", err)
+	default:
+		m.logger.Debugf("Not cleaning up firewall rules of endpoint %s: %v", endpoint, err)
+		return nil, nil
+	}
+}
+
+// buildDesiredStates builds the desired firewall state of every endpoint from all running containers
+func (m *Manager) buildDesiredStates(containers []*container.Info) map[string]*desiredState {
+	states := make(map[string]*desiredState)
+	for endpoint := range m.clients {
+		states[endpoint] = &desiredState{
+			aliases:  make(map[string]*desiredAlias),
+			forwards: make(map[string]*desiredPortForward),
+			rules:    make(map[string]*desiredRule),
+		}
+	}
+
+	// Process containers in a stable order so conflicts resolve the same way every cycle
+	sorted := make([]*container.Info, len(containers))
+	copy(sorted, containers)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	for _, containerInfo := range sorted {
+		if containerInfo.State != "running" {
+			continue
+		}
+
+		firewallConfig, err := m.parser.ParseContainerFirewall(containerInfo)
+		if err != nil {
+			m.logger.Debugf("Container %s not eligible for firewall sync: %v", containerInfo.Name, err)
+			continue
+		}
+
+		endpoint := pfsense.ResolveEndpoint(m.config, m.clients, firewallConfig.EndpointName, m.logger)
+		if endpoint == "" {
+			m.logger.Errorf("pfSense endpoint '%s' not found for container %s", firewallConfig.EndpointName, containerInfo.Name)
+			continue
+		}
+
+		owner := m.ownerFor(containerInfo, firewallConfig)
+		addDesiredAliases(states[endpoint], containerInfo, firewallConfig, owner)
+		m.addDesiredPortForwards(states[endpoint], containerInfo, firewallConfig, owner)
+		m.addDesiredRules(states[endpoint], containerInfo, firewallConfig, owner)
+	}
+
+	return states
+}
+
+// executeChanges executes firewall changes in order, stopping at the first failure
+func (m *Manager) executeChanges(ctx context.Context, endpoint string, client pfsense.API, changes []Change) error {
+	plan := &Plan{Endpoint: endpoint, Changes: changes}
+	return plan.Execute(ctx, &m.config.Global, m.logger, func(c *Change) error {
+		return executeChange(ctx, client, c)
+	})
+}
+
+// applyChanges applies the pending firewall changes if there are any, including those made
+// before an operation failed with err, and returns err joined with any apply failure
+func (m *Manager) applyChanges(ctx context.Context, client pfsense.API, pending int, err error) error {
+	if pending == 0 {
+		return err
+	}
+
+	if applyErr := pfsense.RetryOperation(ctx, &m.config.Global, m.logger, func() error {
+		return client.ApplyFirewallChanges(ctx)
+	}); applyErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to apply firewall changes: %w", applyErr))
+	}
+
+	return err
+}
+
+// executeChange calls the client operation matching the kind and type of a change
+func executeChange(ctx context.Context, client pfsense.API, c *Change) error {
+	switch c.Kind {
+	case KindAlias:
+		switch c.Type {
+		case pfsense.ChangeCreate:
+			return client.CreateFirewallAlias(ctx, c.Alias)
+		case pfsense.ChangeUpdate:
+			return client.UpdateFirewallAlias(ctx, c.Alias)
+		}
+	case KindPortForward:
+		switch c.Type {
+		case pfsense.ChangeCreate:
+			return client.CreateNATPortForward(ctx, c.PortForward)
+		case pfsense.ChangeUpdate:
+			return client.UpdateNATPortForward(ctx, c.PortForward)
+		case pfsense.ChangeDelete:
+			return client.DeleteNATPortForward(ctx, c.ID)
+		}
+	case KindRule:
+		switch c.Type {
+		case pfsense.ChangeCreate:
+			return client.CreateFirewallRule(ctx, c.Rule)
+		case pfsense.ChangeUpdate:
+			return client.UpdateFirewallRule(ctx, c.Rule)
+		case pfsense.ChangeDelete:
+			return client.DeleteFirewallRule(ctx, c.ID)
+		}
+	}
+	return fmt.Errorf("unsupported change %s", c)
+}
+
+// ownerFor returns the ownership marker for pfSense objects created for a container
+func (m *Manager) ownerFor(containerInfo *container.Info, firewallConfig *labels.FirewallConfig) pfsense.Owner {
+	return pfsense.Owner{
+		InstanceID:  m.config.Global.InstanceID,
+		ContainerID: pfsense.ShortContainerID(containerInfo.ID),
+		LabelHash:   firewallConfig.LabelHash,
+	}
+}
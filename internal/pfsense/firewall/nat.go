@@ -0,0 +1,185 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+	"github.com/KristijanL/pfsense-container-controller/internal/labels"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+)
+
+// associatedRuleNew makes pfSense create the filter rule passing a new port forward's traffic
+const associatedRuleNew = "new"
+
+// desiredPortForward is a NAT port forward wanted on an endpoint
+type desiredPortForward struct {
+	forward *pfsense.NATPortForward
+	adopt   bool
+}
+
+// newPortForward converts a port forward of a container to a pfSense NAT port forward
+func newPortForward(containerInfo *container.Info, config *labels.PortForwardConfig, owner pfsense.Owner) *pfsense.NATPortForward {
+	source := config.Source
+	if source == "" {
+		source = "any"
+	}
+
+	forward := &pfsense.NATPortForward{
+		Interface:       config.Interface,
+		IPProtocol:      "inet",
+		Protocol:        config.Protocol,
+		Source:          source,
+		Destination:     config.Interface + ":ip",
+		DestinationPort: config.ExternalPort,
+		Target:          config.Target,
+		LocalPort:       config.InternalPort,
+	}
+	forward.SetOwner(containerInfo.Name+"-"+config.Name, owner)
+	return forward
+}
+
+// addDesiredPortForwards adds the port forwards of a container to the desired state. A port
+// claimed by several containers is forwarded to the first one.
+func (m *Manager) addDesiredPortForwards(
+	state *desiredState,
+	containerInfo *container.Info,
+	firewallConfig *labels.FirewallConfig,
+	owner pfsense.Owner,
+) {
+	for i := range firewallConfig.PortForwards {
+		forward := newPortForward(containerInfo, &firewallConfig.PortForwards[i], owner)
+		if existing, exists := state.forwards[forward.Key()]; exists {
+			m.logger.Warnf("NAT port forward %s of container %s conflicts with %s, skipping",
+				forward.Key(), containerInfo.Name, existing.forward.Description)
+			continue
+		}
+		state.forwards[forward.Key()] = &desiredPortForward{forward: forward, adopt: firewallConfig.Adopt}
+	}
+}
+
+// planPortForwards computes the port forward creates, updates and deletes that converge the
+// actual port forwards to the desired ones. New port forwards get an associated filter rule,
+// which pfSense keeps in sync with the port forward and deletes along with it. Port forwards
+// this controller does not own are left alone unless adopted. Without prune nothing is deleted.
+func (m *Manager) planPortForwards(
+	state *desiredState,
+	actual []pfsense.NATPortForward,
+	prune bool,
+) (writes, deletes []Change) {
+	actualByKey := make(map[string]*pfsense.NATPortForward, len(actual))
+	for i := range actual {
+		if _, exists := actualByKey[actual[i].Key()]; !exists {
+			actualByKey[actual[i].Key()] = &actual[i]
+		}
+	}
+
+	for _, key := range pfsense.SortedKeys(state.forwards) {
+		desired := state.forwards[key]
+		existing := actualByKey[key]
+
+		if existing == nil {
+			forward := *desired.forward
+			forward.AssociatedRuleID = associatedRuleNew
+			writes = append(writes, Change{Type: pfsense.ChangeCreate, Kind: KindPortForward, Name: key, PortForward: &forward})
+			continue
+		}
+
+		if !pfsense.CanModify(&m.config.Global, existing.Owner(), desired.adopt) {
+			m.logger.Warnf("Not updating NAT port forward %s, it is not owned by this controller", key)
+			continue
+		}
+
+		updated := *desired.forward
+		updated.ID = existing.ID
+		if portForwardChanged(existing, &updated) {
+			writes = append(writes, Change{Type: pfsense.ChangeUpdate, Kind: KindPortForward, Name: key, PortForward: &updated})
+		}
+	}
+
+	for i := range actual {
+		existing := &actual[i]
+		if !prune || !existing.Owner().IsOwnedBy(m.config.Global.InstanceID) {
+			continue
+		}
+		// Duplicates of a desired port forward go as well, only the first one forwards traffic
+		if state.forwards[existing.Key()] == nil || actualByKey[existing.Key()] != existing {
+			deletes = append(deletes, Change{Type: pfsense.ChangeDelete, Kind: KindPortForward, Name: existing.Key(), ID: existing.ID})
+		}
+	}
+	pfsense.SortByIDDescending(deletes, changeID)
+
+	return writes, deletes
+}
+
+// syncPortForwards computes the changes that create or update the port forwards of a container
+func (m *Manager) syncPortForwards(
+	ctx context.Context,
+	client pfsense.API,
+	containerInfo *container.Info,
+	firewallConfig *labels.FirewallConfig,
+) ([]Change, error) {
+	if len(firewallConfig.PortForwards) == 0 {
+		return nil, nil
+	}
+
+	actual, err := client.GetNATPortForwards(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get NAT port forwards: %w", err)
+	}
+
+	state := &desiredState{forwards: make(map[string]*desiredPortForward)}
+	m.addDesiredPortForwards(state, containerInfo, firewallConfig, m.ownerFor(containerInfo, firewallConfig))
+
+	writes, _ := m.planPortForwards(state, actual, false)
+	return writes, nil
+}
+
+// removePortForwards computes the deletion of the port forwards owned by a removed container
+func (m *Manager) removePortForwards(
+	ctx context.Context,
+	client pfsense.API,
+	containerInfo *container.Info,
+	firewallConfig *labels.FirewallConfig,
+) ([]Change, error) {
+	if len(firewallConfig.PortForwards) == 0 {
+		return nil, nil
+	}
+
+	actual, err := client.GetNATPortForwards(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get NAT port forwards: %w", err)
+	}
+
+	keys := make(map[string]bool)
+	for i := range firewallConfig.PortForwards {
+		keys[newPortForward(containerInfo, &firewallConfig.PortForwards[i], pfsense.Owner{}).Key()] = true
+	}
+
+	containerID := m.ownerFor(containerInfo, firewallConfig).ContainerID
+
+	var deletes []Change
+	for i := range actual {
+		existing := &actual[i]
+		owner := existing.Owner()
+		if !keys[existing.Key()] || !owner.IsOwnedBy(m.config.Global.InstanceID) || owner.ContainerID != containerID {
+			continue
+		}
+		deletes = append(deletes, Change{Type: pfsense.ChangeDelete, Kind: KindPortForward, Name: existing.Key(), ID: existing.ID})
+	}
+	pfsense.SortByIDDescending(deletes, changeID)
+
+	return deletes, nil
+}
+
+// portForwardChanged reports whether an existing port forward differs from the desired one
+func portForwardChanged(existing, desired *pfsense.NATPortForward) bool {
+	return existing.Interface != desired.Interface ||
+		existing.Protocol != desired.Protocol ||
+		existing.Source != desired.Source ||
+		existing.Destination != desired.Destination ||
+		existing.DestinationPort != desired.DestinationPort ||
+		existing.Target != desired.Target ||
+		existing.LocalPort != desired.LocalPort ||
+		existing.Description != desired.Description
+}
@@ -0,0 +1,72 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package firewall
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+	"github.com/KristijanL/pfsense-container-controller/internal/labels"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+)
+
+func TestPlanPortForwards(t *testing.T) {
+	m := newTestManager()
+
+	game := &container.Info{ID: "aaa", Name: "game"}
+	gameConfig := &labels.FirewallConfig{PortForwards: []labels.PortForwardConfig{
+		{Name: "game", Interface: "wan", Protocol: "udp", ExternalPort: "27015", InternalPort: "27015", Target: "172.17.0.2"},
+		{Name: "rcon", Interface: "wan", Protocol: "tcp", ExternalPort: "27020", InternalPort: "27020", Target: "172.17.0.2", Source: "ADMINS"},
+	}}
+	vpn := &container.Info{ID: "bbb", Name: "vpn"}
+	vpnConfig := &labels.FirewallConfig{PortForwards: []labels.PortForwardConfig{
+		{Name: "wg", Interface: "wan", Protocol: "udp", ExternalPort: "51820", InternalPort: "51820", Target: "172.17.0.3"},
+		{Name: "game", Interface: "wan", Protocol: "udp", ExternalPort: "27015", InternalPort: "27015", Target: "172.17.0.3"},
+	}}
+
+	state := &desiredState{forwards: make(map[string]*desiredPortForward)}
+	m.addDesiredPortForwards(state, game, gameConfig, m.ownerFor(game, gameConfig))
+	m.addDesiredPortForwards(state, vpn, vpnConfig, m.ownerFor(vpn, vpnConfig))
+
+	owner := pfsense.Owner{InstanceID: "host-a", ContainerID: "aaa"}
+	current := *state.forwards["wan:27015/udp"].forward
+	current.ID = 0
+	moved := *state.forwards["wan:27020/tcp"].forward
+	moved.Target = "172.17.0.9"
+	moved.ID = 1
+	stale := pfsense.NATPortForward{Interface: "wan", Protocol: "tcp", DestinationPort: "2222", ID: 2}
+	stale.SetOwner("game-ssh", owner)
+	manual := pfsense.NATPortForward{Interface: "wan", Protocol: "tcp", DestinationPort: "443", Description: "Web", ID: 3}
+
+	writes, deletes := m.planPortForwards(state, []pfsense.NATPortForward{current, moved, stale, manual}, true)
+
+	var got []string
+	for _, changes := range [][]Change{writes, deletes} {
+		for i := range changes {
+			got = append(got, changes[i].String())
+		}
+	}
+	want := []string{
+		"update NAT port forward wan:27020/tcp",
+		"create NAT port forward wan:51820/udp",
+		"delete NAT port forward wan:2222/tcp",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("planPortForwards() = %v, want %v", got, want)
+	}
+
+	if update := writes[0].PortForward; update.ID != 1 || update.Target != "172.17.0.2" || update.AssociatedRuleID != "" {
+		t.Errorf("update = %+v, want forward 1 to 172.17.0.2 keeping its filter rule", update)
+	}
+	if create := writes[1].PortForward; create.AssociatedRuleID != associatedRuleNew || create.Destination != "wan:ip" || create.Source != "any" {
+		t.Errorf("create = %+v, want new associated filter rule from any source to the WAN address", create)
+	}
+
+	// Without prune nothing is deleted
+	if _, deletes := m.planPortForwards(state, []pfsense.NATPortForward{stale}, false); len(deletes) != 0 {
+		t.Errorf("planPortForwards() without prune deletes = %v, want none", deletes)
+	}
+}
@@ -0,0 +1,228 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+	"github.com/KristijanL/pfsense-container-controller/internal/labels"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+)
+
+// rulePositionTop places new filter rules above the other rules of their interface
+const rulePositionTop = "top"
+
+// desiredRule is a filter rule wanted on an endpoint
+type desiredRule struct {
+	rule  *pfsense.FirewallRule
+	adopt bool
+}
+
+// newRules converts the filter rules of a container to pfSense filter rules, named after the
+// container and the rule
+func (m *Manager) newRules(
+	containerInfo *container.Info,
+	firewallConfig *labels.FirewallConfig,
+	owner pfsense.Owner,
+) map[string]*pfsense.FirewallRule {
+	rules := make(map[string]*pfsense.FirewallRule, len(firewallConfig.Rules))
+	for _, rule := range m.parser.ConvertToFirewallRules(firewallConfig) {
+		name := containerInfo.Name + "-" + rule.Description
+		rule.SetOwner(name, owner)
+		rules[name] = &rule
+	}
+	return rules
+}
+
+// addDesiredRules adds the filter rules of a container to the desired state
+func (m *Manager) addDesiredRules(
+	state *desiredState,
+	containerInfo *container.Info,
+	firewallConfig *labels.FirewallConfig,
+	owner pfsense.Owner,
+) {
+	for name, rule := range m.newRules(containerInfo, firewallConfig, owner) {
+		state.rules[name] = &desiredRule{rule: rule, adopt: firewallConfig.Adopt}
+	}
+}
+
+// planRules computes the filter rule changes that converge the actual rules to the desired
+// ones: updates first, then deletes from the highest ID down, then creates. Since rules are
+// identified by their position, creates are placed against the rules left after the deletes.
+// Rules this controller does not own are left alone unless adopted. Without prune nothing is
+// deleted.
+func (m *Manager) planRules(state *desiredState, actual []pfsense.FirewallRule, prune bool) []Change {
+	actualByName := make(map[string]*pfsense.FirewallRule, len(actual))
+	for i := range actual {
+		if _, exists := actualByName[actual[i].Name()]; !exists {
+			actualByName[actual[i].Name()] = &actual[i]
+		}
+	}
+
+	var updates, deletes, creates []Change
+	for _, name := range pfsense.SortedKeys(state.rules) {
+		desired := state.rules[name]
+		existing := actualByName[name]
+
+		if existing == nil {
+			rule := *desired.rule
+			creates = append(creates, Change{Type: pfsense.ChangeCreate, Kind: KindRule, Name: name, Rule: &rule})
+			continue
+		}
+
+		if !pfsense.CanModify(&m.config.Global, existing.Owner(), desired.adopt) {
+			m.logger.Warnf("Not updating firewall rule %s, it is not owned by this controller", name)
+			continue
+		}
+
+		updated := *desired.rule
+		updated.ID = existing.ID
+		if ruleChanged(existing, &updated) {
+			updates = append(updates, Change{Type: pfsense.ChangeUpdate, Kind: KindRule, Name: name, Rule: &updated})
+		}
+	}
+
+	deleted := make(map[int]bool)
+	for i := range actual {
+		existing := &actual[i]
+		if !prune || !existing.Owner().IsOwnedBy(m.config.Global.InstanceID) {
+			continue
+		}
+		// Duplicates of a desired rule go as well
+		if state.rules[existing.Name()] == nil || actualByName[existing.Name()] != existing {
+			deletes = append(deletes, Change{Type: pfsense.ChangeDelete, Kind: KindRule, Name: existing.Name(), ID: existing.ID})
+			deleted[existing.ID] = true
+		}
+	}
+	pfsense.SortByIDDescending(deletes, changeID)
+
+	remaining := make([]pfsense.FirewallRule, 0, len(actual))
+	for i := range actual {
+		if !deleted[actual[i].ID] {
+			remaining = append(remaining, actual[i])
+		}
+	}
+	for i := range creates {
+		remaining = m.placeRule(remaining, creates[i].Rule)
+	}
+
+	return slices.Concat(updates, deletes, creates)
+}
+
+// placeRule sets the placement of a new rule according to the configured separator or
+// position, and returns the rules with the new rule inserted where it will end up. New rules
+// go below the separator rule of their interface, or otherwise above the other rules of their
+// interface or at the bottom, after the rules this controller already placed there.
+func (m *Manager) placeRule(rules []pfsense.FirewallRule, rule *pfsense.FirewallRule) []pfsense.FirewallRule {
+	iface := rule.Interface[0]
+	owned := func(index int) bool {
+		return slices.Contains(rules[index].Interface, iface) && rules[index].Owner().IsOwnedBy(m.config.Global.InstanceID)
+	}
+
+	index := -1
+	if separator := m.config.Global.FirewallRuleSeparator; separator != "" {
+		for i := range rules {
+			if rules[i].Name() == separator && slices.Contains(rules[i].Interface, iface) {
+				index = i + 1
+				break
+			}
+		}
+		if index < 0 {
+			m.logger.Warnf("Firewall rule separator %s not found on interface %s, placing rule %s by position",
+				separator, iface, rule.Name())
+		}
+	}
+
+	if index < 0 && m.config.Global.FirewallRulePosition == rulePositionTop {
+		for i := range rules {
+			if slices.Contains(rules[i].Interface, iface) {
+				index = i
+				break
+			}
+		}
+	}
+
+	if index < 0 {
+		return append(rules, *rule)
+	}
+
+	for index < len(rules) && owned(index) {
+		index++
+	}
+	if index == len(rules) {
+		return append(rules, *rule)
+	}
+
+	placement := index
+	rule.Placement = &placement
+	return slices.Insert(rules, index, *rule)
+}
+
+// syncRules computes the changes that create or update the filter rules of a container
+func (m *Manager) syncRules(
+	ctx context.Context,
+	client pfsense.API,
+	containerInfo *container.Info,
+	firewallConfig *labels.FirewallConfig,
+) ([]Change, error) {
+	if len(firewallConfig.Rules) == 0 {
+		return nil, nil
+	}
+
+	actual, err := client.GetFirewallRules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get firewall rules: %w", err)
+	}
+
+	state := &desiredState{rules: make(map[string]*desiredRule)}
+	m.addDesiredRules(state, containerInfo, firewallConfig, m.ownerFor(containerInfo, firewallConfig))
+
+	return m.planRules(state, actual, false), nil
+}
+
+// removeRules computes the deletion of the filter rules owned by a removed container
+func (m *Manager) removeRules(
+	ctx context.Context,
+	client pfsense.API,
+	containerInfo *container.Info,
+	firewallConfig *labels.FirewallConfig,
+) ([]Change, error) {
+	if len(firewallConfig.Rules) == 0 {
+		return nil, nil
+	}
+
+	actual, err := client.GetFirewallRules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get firewall rules: %w", err)
+	}
+
+	names := m.newRules(containerInfo, firewallConfig, pfsense.Owner{})
+	containerID := m.ownerFor(containerInfo, firewallConfig).ContainerID
+
+	var deletes []Change
+	for i := range actual {
+		existing := &actual[i]
+		owner := existing.Owner()
+		if names[existing.Name()] == nil || !owner.IsOwnedBy(m.config.Global.InstanceID) || owner.ContainerID != containerID {
+			continue
+		}
+		deletes = append(deletes, Change{Type: pfsense.ChangeDelete, Kind: KindRule, Name: existing.Name(), ID: existing.ID})
+	}
+	pfsense.SortByIDDescending(deletes, changeID)
+
+	return deletes, nil
+}
+
+// ruleChanged reports whether an existing filter rule differs from the desired one
+func ruleChanged(existing, desired *pfsense.FirewallRule) bool {
+	return existing.Type != desired.Type ||
+		!slices.Equal(existing.Interface, desired.Interface) ||
+		existing.IPProtocol != desired.IPProtocol ||
+		existing.ProtocolName() != desired.ProtocolName() ||
+		existing.Source != desired.Source ||
+		existing.SourcePort != desired.SourcePort ||
+		existing.Destination != desired.Destination ||
+		existing.DestinationPort != desired.DestinationPort ||
+		existing.Description != desired.Description
+}
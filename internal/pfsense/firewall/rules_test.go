@@ -0,0 +1,93 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package firewall
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+	"github.com/KristijanL/pfsense-container-controller/internal/labels"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+)
+
+func TestPlanRules(t *testing.T) {
+	m := newTestManager()
+
+	db := &container.Info{ID: "aaa", Name: "db"}
+	dbConfig := &labels.FirewallConfig{Rules: []labels.FirewallRule{
+		{Name: "admin", Action: "pass", Interface: "lan", Protocol: "tcp", Source: "admins",
+			Destination: "172.17.0.2", DestinationPort: "5432"},
+		{Name: "lan", Action: "pass", Interface: "lan", Protocol: "tcp", Source: "lan",
+			Destination: "172.17.0.2", DestinationPort: "5432"},
+		{Name: "ping", Action: "pass", Interface: "opt1", Protocol: "icmp", Source: "opt1", Destination: "172.17.0.2"},
+	}}
+
+	state := &desiredState{rules: make(map[string]*desiredRule)}
+	m.addDesiredRules(state, db, dbConfig, m.ownerFor(db, dbConfig))
+
+	owner := pfsense.Owner{InstanceID: "host-a", ContainerID: "aaa"}
+	rule := func(name, iface string, id int) pfsense.FirewallRule {
+		return pfsense.FirewallRule{Type: "pass", Interface: []string{iface}, Description: name, ID: id}
+	}
+
+	changed := *state.rules["db-lan"].rule
+	changed.Source = "any"
+	changed.ID = 1
+	stale := rule("", "lan", 2)
+	stale.SetOwner("db-old", owner)
+	actual := []pfsense.FirewallRule{
+		rule("Containers", "lan", 0),
+		changed,
+		stale,
+		rule("Default allow LAN", "lan", 3),
+		rule("Allow VPN", "opt1", 4),
+	}
+
+	m.config.Global.FirewallRuleSeparator = "Containers"
+	m.config.Global.FirewallRulePosition = rulePositionTop
+	changes := m.planRules(state, actual, true)
+
+	var got []string
+	for i := range changes {
+		got = append(got, changes[i].String())
+	}
+	want := []string{
+		"update firewall rule db-lan",
+		"delete firewall rule db-old",
+		"create firewall rule db-admin",
+		"create firewall rule db-ping",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("planRules() = %v, want %v", got, want)
+	}
+
+	if update := changes[0].Rule; update.ID != 1 || update.Source != "lan" {
+		t.Errorf("update = %+v, want rule 1 from lan", update)
+	}
+	if changes[1].ID != 2 {
+		t.Errorf("delete id = %d, want 2", changes[1].ID)
+	}
+	// After deleting rule 2 the new LAN rule goes below the separator and db-lan, and the
+	// OPT1 rule without a separator above the first OPT1 rule, which the LAN rule moved to 4
+	if placement := changes[2].Rule.Placement; placement == nil || *placement != 2 {
+		t.Errorf("db-admin placement = %v, want 2", placement)
+	}
+	if placement := changes[3].Rule.Placement; placement == nil || *placement != 4 {
+		t.Errorf("db-ping placement = %v, want 4", placement)
+	}
+
+	// At the bottom rules are appended without placement, and without prune nothing is deleted
+	m.config.Global.FirewallRuleSeparator = ""
+	m.config.Global.FirewallRulePosition = "bottom"
+	for _, c := range m.planRules(state, actual, false) {
+		if c.Type == pfsense.ChangeDelete {
+			t.Errorf("planRules() without prune = %s, want no deletes", &c)
+		}
+		if c.Type == pfsense.ChangeCreate && c.Rule.Placement != nil {
+			t.Errorf("%s placement = %d, want none", &c, *c.Rule.Placement)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package haproxy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/labels"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+)
+
+const (
+	// acmeKeyLength is the key length of ACME certificates created by the controller
+	acmeKeyLength = "2048"
+	// acmeRenewAfter is the number of days after which ACME certificates are renewed
+	acmeRenewAfter = 60
+)
+
+// desiredACMECertificate is an ACME certificate wanted on an endpoint, covering the hosts of
+// all containers using it
+type desiredACMECertificate struct {
+	certificate *pfsense.ACMECertificate
+	owner       pfsense.Owner
+	adopt       bool
+}
+
+// addDesiredACMECertificate adds the hosts of a route using an ACME certificate to the desired state
+func addDesiredACMECertificate(state *desiredState, frontend *labels.FrontendConfig, owner pfsense.Owner, adopt bool) {
+	name := frontend.TLSCertificate
+
+	desired, exists := state.acme[name]
+	if !exists {
+		desired = &desiredACMECertificate{
+			certificate: &pfsense.ACMECertificate{
+				Name:       name,
+				Status:     "active",
+				Account:    frontend.ACMEAccount,
+				KeyLength:  acmeKeyLength,
+				RenewAfter: acmeRenewAfter,
+			},
+			owner: owner,
+		}
+		state.acme[name] = desired
+	}
+
+	for _, host := range frontend.ACMEHosts {
+		if !desired.certificate.HasDomain(host) {
+			desired.certificate.Domains = append(desired.certificate.Domains, pfsense.ACMEDomain{
+				Status: "enable",
+				Name:   host,
+				Method: frontend.ACMEMethod,
+			})
+		}
+	}
+	slices.SortFunc(desired.certificate.Domains, func(a, b pfsense.ACMEDomain) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	desired.owner = desired.owner.Merge(owner)
+	desired.certificate.SetOwner(desired.owner)
+	desired.adopt = desired.adopt || adopt
+}
+
+// getACMECertificates fetches the certificates of the ACME package. Without ACME certificates
+// in the desired state they are only needed for cleaning up, and the package may not even be
+// installed, so failures are not fatal then.
+func (m *Manager) getACMECertificates(ctx context.Context, client pfsense.API, state *desiredState) ([]pfsense.ACMECertificate, error) {
+	certificates, err := client.GetACMECertificates(ctx)
+	if err == nil {
+		return certificates, nil
+	}
+
+	if len(state.acme) > 0 {
+		return nil, err
+	}
+
+	m.logger.Debugf("Not cleaning up ACME certificates: %v", err)
+	return nil, nil
+}
+
+// planACMECertificates computes ACME certificate creates, updates and deletes. New and changed
+// certificates are issued or renewed right away. Certificates this controller does not own are
+// only extended with missing domains. Without prune, owned certificates are only extended as
+// well and nothing is deleted.
+func (m *Manager) planACMECertificates(
+	state *desiredState,
+	actual []pfsense.ACMECertificate,
+	prune bool,
+) (writes, deletes []Change) {
+	actualByName := make(map[string]*pfsense.ACMECertificate, len(actual))
+	for i := range actual {
+		actualByName[actual[i].Name] = &actual[i]
+	}
+
+	for _, name := range pfsense.SortedKeys(state.acme) {
+		desired := state.acme[name]
+		existing := actualByName[name]
+
+		if existing == nil {
+			writes = append(writes,
+				Change{Type: pfsense.ChangeCreate, Kind: KindACMECertificate, Name: name, ACME: desired.certificate},
+				Change{Type: ChangeIssue, Kind: KindACMECertificate, Name: name},
+			)
+			continue
+		}
+
+		updated := *desired.certificate
+		updated.ID = existing.ID
+		if !prune || !pfsense.CanModify(&m.config.Global, existing.Owner(), desired.adopt) {
+			// Extend the certificate while keeping everything else as configured by hand
+			updated = *existing
+			updated.Domains = slices.Clone(existing.Domains)
+			for _, domain := range desired.certificate.Domains {
+				if !updated.HasDomain(domain.Name) {
+					updated.Domains = append(updated.Domains, domain)
+				}
+			}
+		}
+
+		if acmeCertificateChanged(existing, &updated) {
+			writes = append(writes,
+				Change{Type: pfsense.ChangeUpdate, Kind: KindACMECertificate, Name: name, ID: existing.ID, ACME: &updated},
+				Change{Type: ChangeRenew, Kind: KindACMECertificate, Name: name},
+			)
+		}
+	}
+
+	for i := len(actual) - 1; i >= 0 && prune; i-- {
+		existing := &actual[i]
+		if !existing.Owner().IsOwnedBy(m.config.Global.InstanceID) {
+			continue
+		}
+		if _, desired := state.acme[existing.Name]; !desired {
+			deletes = append(deletes, Change{Type: pfsense.ChangeDelete, Kind: KindACMECertificate, Name: existing.Name, ID: existing.ID})
+		}
+	}
+	pfsense.SortByIDDescending(deletes, changeID)
+
+	return writes, deletes
+}
+
+// syncACMECertificate creates or extends the ACME certificate of a route and starts issuing it
+func (m *Manager) syncACMECertificate(
+	ctx context.Context,
+	client pfsense.API,
+	containerConfig *labels.ContainerConfig,
+	route *labels.RouteConfig,
+	owner pfsense.Owner,
+) error {
+	actual, err := client.GetACMECertificates(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get ACME certificates: %w", err)
+	}
+
+	state := &desiredState{acme: make(map[string]*desiredACMECertificate)}
+	addDesiredACMECertificate(state, &route.FrontendConfig, owner, containerConfig.Adopt)
+
+	changes, _ := m.planACMECertificates(state, actual, false)
+	for i := range changes {
+		change := &changes[i]
+		m.logger.Infof("Updating ACME certificates: %s", change)
+		if err := pfsense.RetryOperation(ctx, &m.config.Global, m.logger, func() error {
+			return executeChange(ctx, client, change)
+		}); err != nil {
+			return fmt.Errorf("failed to %s: %w", change, err)
+		}
+	}
+
+	return nil
+}
+
+// acmeCertificateChanged reports whether an existing ACME certificate differs from the desired
+// one in a way that requires issuing it again
+func acmeCertificateChanged(existing, desired *pfsense.ACMECertificate) bool {
+	if existing.Account != desired.Account || len(existing.Domains) != len(desired.Domains) {
+		return true
+	}
+
+	for _, domain := range desired.Domains {
+		index := slices.IndexFunc(existing.Domains, func(d pfsense.ACMEDomain) bool { return d.Name == domain.Name })
+		if index < 0 || existing.Domains[index].Method != domain.Method {
+			return true
+		}
+	}
+
+	return false
+}
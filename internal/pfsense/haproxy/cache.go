@@ -0,0 +1,281 @@
+package haproxy
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+)
+
+// stateCache is a read-through cache of the HAProxy backends and frontends of an endpoint,
+// shared by syncs, health checks and metrics. Lists are kept for ttl and dropped by every
+// HAProxy write through the cache, so the controller always sees its own changes. Changes
+// made on pfSense directly show up once the lists expire. A ttl of zero disables caching.
+//
+// The cache also counts the HAProxy writes staged on pfSense since the last apply, so that
+// they can be applied together.
+type stateCache struct {
+	pfsense.API
+
+	backends  cachedList[pfsense.HAProxyBackend]
+	frontends cachedList[pfsense.HAProxyFrontend]
+	counts    applyStats
+	ttl       time.Duration
+	mu        sync.Mutex
+}
+
+// applyStats counts the HAProxy changes of an endpoint
+type applyStats struct {
+	// pending is the number of changes written since the last apply
+	pending int
+	// applies is the number of successful applies
+	applies int
+	// applied is the number of changes the successful applies carried
+	applied int
+}
+
+// cachedList is a cached list of objects indexed by name
+type cachedList[T any] struct {
+	fetched time.Time
+	byName  map[string]int
+	items   []T
+	valid   bool
+}
+
+// newStateCache returns a cache of the HAProxy state read through api
+func newStateCache(api pfsense.API, ttl time.Duration) *stateCache {
+	return &stateCache{API: api, ttl: ttl}
+}
+
+// get returns the cached list, fetching it first if it expired. The list is fetched while
+// holding the cache lock, so concurrent readers wait for a single fetch.
+func get[T any](
+	ctx context.Context,
+	c *stateCache,
+	list *cachedList[T],
+	fetch func(context.Context) ([]T, error),
+	name func(*T) string,
+) (*cachedList[T], error) {
+	if list.valid && time.Since(list.fetched) < c.ttl {
+		return list, nil
+	}
+
+	items, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	list.items = items
+	list.byName = make(map[string]int, len(items))
+	for i := range items {
+		// The first object of a name wins, as in a search of the list
+		if _, exists := list.byName[name(&items[i])]; !exists {
+			list.byName[name(&items[i])] = i
+		}
+	}
+	list.fetched = time.Now()
+	list.valid = true
+	return list, nil
+}
+
+func backendName(backend *pfsense.HAProxyBackend) string    { return backend.Name }
+func frontendName(frontend *pfsense.HAProxyFrontend) string { return frontend.Name }
+
+// GetHAProxyBackends returns a copy of the cached backends
+func (c *stateCache) GetHAProxyBackends(ctx context.Context) ([]pfsense.HAProxyBackend, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list, err := get(ctx, c, &c.backends, c.API.GetHAProxyBackends, backendName)
+	if err != nil {
+		return nil, err
+	}
+	backends := make([]pfsense.HAProxyBackend, len(list.items))
+	for i := range list.items {
+		backends[i] = copyBackend(&list.items[i])
+	}
+	return backends, nil
+}
+
+// FindBackendByName returns a copy of the cached backend of a name, or nil if there is none
+func (c *stateCache) FindBackendByName(ctx context.Context, name string) (*pfsense.HAProxyBackend, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list, err := get(ctx, c, &c.backends, c.API.GetHAProxyBackends, backendName)
+	if err != nil {
+		return nil, err
+	}
+	i, exists := list.byName[name]
+	if !exists {
+		return nil, nil
+	}
+	backend := copyBackend(&list.items[i])
+	return &backend, nil
+}
+
+// GetHAProxyFrontends returns a copy of the cached frontends
+func (c *stateCache) GetHAProxyFrontends(ctx context.Context) ([]pfsense.HAProxyFrontend, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list, err := get(ctx, c, &c.frontends, c.API.GetHAProxyFrontends, frontendName)
+	if err != nil {
+		return nil, err
+	}
+	frontends := make([]pfsense.HAProxyFrontend, len(list.items))
+	for i := range list.items {
+		frontends[i] = copyFrontend(&list.items[i])
+	}
+	return frontends, nil
+}
+
+// FindFrontendByName returns a copy of the cached frontend of a name, or nil if there is none
+func (c *stateCache) FindFrontendByName(ctx context.Context, name string) (*pfsense.HAProxyFrontend, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list, err := get(ctx, c, &c.frontends, c.API.GetHAProxyFrontends, frontendName)
+	if err != nil {
+		return nil, err
+	}
+	i, exists := list.byName[name]
+	if !exists {
+		return nil, nil
+	}
+	frontend := copyFrontend(&list.items[i])
+	return &frontend, nil
+}
+
+// wroteBackends drops the cached backends once a write is done, whether or not it succeeded,
+// since a failed request may still have changed them. Successful writes are staged until
+// the next apply.
+func (c *stateCache) wroteBackends(err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.backends.valid = false
+	if err == nil {
+		c.counts.pending++
+	}
+	return err
+}
+
+// wroteFrontends drops the cached frontends once a write is done
+func (c *stateCache) wroteFrontends(err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.frontends.valid = false
+	if err == nil {
+		c.counts.pending++
+	}
+	return err
+}
+
+func (c *stateCache) CreateHAProxyBackend(ctx context.Context, backend *pfsense.HAProxyBackend) error {
+	return c.wroteBackends(c.API.CreateHAProxyBackend(ctx, backend))
+}
+
+func (c *stateCache) UpdateHAProxyBackend(ctx context.Context, backend *pfsense.HAProxyBackend) error {
+	return c.wroteBackends(c.API.UpdateHAProxyBackend(ctx, backend))
+}
+
+func (c *stateCache) DeleteHAProxyBackend(ctx context.Context, backendID int) error {
+	return c.wroteBackends(c.API.DeleteHAProxyBackend(ctx, backendID))
+}
+
+func (c *stateCache) CreateHAProxyFrontend(ctx context.Context, frontend *pfsense.HAProxyFrontend) error {
+	return c.wroteFrontends(c.API.CreateHAProxyFrontend(ctx, frontend))
+}
+
+func (c *stateCache) DeleteHAProxyFrontend(ctx context.Context, frontendID int) error {
+	return c.wroteFrontends(c.API.DeleteHAProxyFrontend(ctx, frontendID))
+}
+
+func (c *stateCache) AddACLToFrontend(ctx context.Context, frontendID int, acl pfsense.HAProxyACL) error {
+	return c.wroteFrontends(c.API.AddACLToFrontend(ctx, frontendID, acl))
+}
+
+func (c *stateCache) UpdateFrontendACL(ctx context.Context, frontendID int, acl pfsense.HAProxyACL) error {
+	return c.wroteFrontends(c.API.UpdateFrontendACL(ctx, frontendID, acl))
+}
+
+func (c *stateCache) DeleteACLFromFrontend(ctx context.Context, frontendID, aclID int) error {
+	return c.wroteFrontends(c.API.DeleteACLFromFrontend(ctx, frontendID, aclID))
+}
+
+func (c *stateCache) AddActionToFrontend(ctx context.Context, frontendID int, action pfsense.HAProxyAction) error {
+	return c.wroteFrontends(c.API.AddActionToFrontend(ctx, frontendID, action))
+}
+
+func (c *stateCache) UpdateFrontendAction(ctx context.Context, frontendID int, action pfsense.HAProxyAction) error {
+	return c.wroteFrontends(c.API.UpdateFrontendAction(ctx, frontendID, action))
+}
+
+func (c *stateCache) DeleteActionFromFrontend(ctx context.Context, frontendID, actionID int) error {
+	return c.wroteFrontends(c.API.DeleteActionFromFrontend(ctx, frontendID, actionID))
+}
+
+func (c *stateCache) UpdateFrontendAddress(ctx context.Context, frontendID int, address pfsense.HAProxyFrontendAddress) error {
+	return c.wroteFrontends(c.API.UpdateFrontendAddress(ctx, frontendID, address))
+}
+
+func (c *stateCache) SetFrontendSSLOffloadCertificate(ctx context.Context, frontendID int, refID string) error {
+	return c.wroteFrontends(c.API.SetFrontendSSLOffloadCertificate(ctx, frontendID, refID))
+}
+
+func (c *stateCache) SetFrontendDescription(ctx context.Context, frontendID int, description string) error {
+	return c.wroteFrontends(c.API.SetFrontendDescription(ctx, frontendID, description))
+}
+
+func (c *stateCache) AddCertificateToFrontend(ctx context.Context, frontendID int, refID string) error {
+	return c.wroteFrontends(c.API.AddCertificateToFrontend(ctx, frontendID, refID))
+}
+
+func (c *stateCache) DeleteCertificateFromFrontend(ctx context.Context, frontendID, certificateID int) error {
+	return c.wroteFrontends(c.API.DeleteCertificateFromFrontend(ctx, frontendID, certificateID))
+}
+
+// ApplyHAProxyChanges applies the staged changes and counts them as applied
+func (c *stateCache) ApplyHAProxyChanges(ctx context.Context) error {
+	c.mu.Lock()
+	changes := c.counts.pending
+	c.mu.Unlock()
+
+	if err := c.API.ApplyHAProxyChanges(ctx); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Changes written while applying may not have made it into the applied configuration
+	c.counts.pending -= changes
+	c.counts.applies++
+	c.counts.applied += changes
+	return nil
+}
+
+// stats returns the counts of staged and applied changes
+func (c *stateCache) stats() applyStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts
+}
+
+// copyBackend returns a copy of a backend that shares no slices with it
+func copyBackend(backend *pfsense.HAProxyBackend) pfsense.HAProxyBackend {
+	copied := *backend
+	copied.Servers = slices.Clone(backend.Servers)
+	return copied
+}
+
+// copyFrontend returns a copy of a frontend that shares no slices with it
+func copyFrontend(frontend *pfsense.HAProxyFrontend) pfsense.HAProxyFrontend {
+	copied := *frontend
+	copied.Addresses = slices.Clone(frontend.Addresses)
+	copied.Certificates = slices.Clone(frontend.Certificates)
+	copied.HAACLs = slices.Clone(frontend.HAACLs)
+	copied.ActionItems = slices.Clone(frontend.ActionItems)
+	return copied
+}
@@ -0,0 +1,171 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package haproxy
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense/pfsensetest"
+)
+
+// gets counts the requests that read a path
+func gets(server *pfsensetest.Server, path string) int {
+	count := 0
+	for _, request := range server.Requests() {
+		if request.Method == http.MethodGet && request.Path == path {
+			count++
+		}
+	}
+	return count
+}
+
+func TestStateCache(t *testing.T) {
+	server := pfsensetest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	endpoint := server.Endpoint("test")
+	cache := newStateCache(pfsense.NewClient(&endpoint), time.Hour)
+
+	server.SetBackends([]pfsense.HAProxyBackend{
+		{Name: "web", Servers: []pfsense.HAProxyBackendServer{{Name: "web-1", Address: "172.17.0.2"}}},
+		{Name: "api"},
+	})
+
+	// Reads by name are served from a single fetch
+	for _, name := range []string{"web", "api", "missing", "web"} {
+		backend, err := cache.FindBackendByName(ctx, name)
+		if err != nil {
+			t.Fatalf("FindBackendByName(%s) error = %v", name, err)
+		}
+		if (backend != nil) != (name != "missing") || (backend != nil && backend.Name != name) {
+			t.Errorf("FindBackendByName(%s) = %+v", name, backend)
+		}
+	}
+	if _, err := cache.GetHAProxyBackends(ctx); err != nil {
+		t.Fatalf("GetHAProxyBackends() error = %v", err)
+	}
+	if got := gets(server, "/services/haproxy/backends"); got != 1 {
+		t.Errorf("backends were fetched %d times, want 1", got)
+	}
+
+	// Callers get copies they can change freely
+	backend, _ := cache.FindBackendByName(ctx, "web")
+	backend.Servers[0].Address = "10.0.0.1"
+	if backend, _ := cache.FindBackendByName(ctx, "web"); backend.Servers[0].Address != "172.17.0.2" {
+		t.Errorf("cached server address = %s after changing a copy, want 172.17.0.2", backend.Servers[0].Address)
+	}
+
+	// Writes drop the list they change, and only that list
+	if _, err := cache.GetHAProxyFrontends(ctx); err != nil {
+		t.Fatalf("GetHAProxyFrontends() error = %v", err)
+	}
+	if err := cache.CreateHAProxyBackend(ctx, &pfsense.HAProxyBackend{Name: "new"}); err != nil {
+		t.Fatalf("CreateHAProxyBackend() error = %v", err)
+	}
+	if backend, err := cache.FindBackendByName(ctx, "new"); err != nil || backend == nil {
+		t.Errorf("FindBackendByName(new) = %v, %v after creating it, want the new backend", backend, err)
+	}
+	if _, err := cache.GetHAProxyFrontends(ctx); err != nil {
+		t.Fatalf("GetHAProxyFrontends() error = %v", err)
+	}
+	if got := gets(server, "/services/haproxy/backends"); got != 2 {
+		t.Errorf("backends were fetched %d times, want a refetch after the write", got)
+	}
+	if got := gets(server, "/services/haproxy/frontends"); got != 1 {
+		t.Errorf("frontends were fetched %d times, want 1", got)
+	}
+
+	// A failed write drops the list too, and errors are not cached
+	server.AddFault(pfsensetest.Fault{Status: http.StatusServiceUnavailable, Times: 2})
+	if err := cache.DeleteHAProxyBackend(ctx, 0); err == nil {
+		t.Error("DeleteHAProxyBackend() succeeded against a failing pfSense")
+	}
+	if _, err := cache.GetHAProxyBackends(ctx); err == nil {
+		t.Error("GetHAProxyBackends() succeeded against a failing pfSense")
+	}
+	if backends, err := cache.GetHAProxyBackends(ctx); err != nil || len(backends) != 3 {
+		t.Errorf("GetHAProxyBackends() = %d backends, %v, want 3", len(backends), err)
+	}
+}
+
+func TestStateCache_Expiry(t *testing.T) {
+	server := pfsensetest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	endpoint := server.Endpoint("test")
+	cache := newStateCache(pfsense.NewClient(&endpoint), 20*time.Millisecond)
+
+	if frontend, err := cache.FindFrontendByName(ctx, "manual"); err != nil || frontend != nil {
+		t.Fatalf("FindFrontendByName(manual) = %v, %v, want none", frontend, err)
+	}
+
+	// Changes made on pfSense directly show up once the list expires
+	server.SetFrontends([]pfsense.HAProxyFrontend{{Name: "manual"}})
+	if frontend, _ := cache.FindFrontendByName(ctx, "manual"); frontend != nil {
+		t.Error("FindFrontendByName(manual) found the frontend before the list expired")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if frontend, _ := cache.FindFrontendByName(ctx, "manual"); frontend == nil {
+		t.Error("FindFrontendByName(manual) did not find the frontend after the list expired")
+	}
+}
+
+func TestManager_SyncContainerCached(t *testing.T) {
+	server, manager := newServerManager(t)
+	for _, client := range manager.clients {
+		client.ttl = time.Hour
+	}
+	ctx := context.Background()
+
+	web := webContainer("web", "172.17.0.2", "web.example.com")
+	api := webContainer("api", "172.17.0.3", "api.example.com")
+
+	if err := manager.Reconcile(ctx, []*container.Info{web, api}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	frontend := findFrontend(server.Frontends(), "shared")
+	if len(server.Backends()) != 2 || frontend == nil || len(routes(frontend)) != 2 {
+		t.Fatalf("got %d backends and frontend %+v, want both containers routed", len(server.Backends()), frontend)
+	}
+
+	// Health checks and statistics are served from the state a sync read
+	if err := manager.SyncContainer(ctx, web); err != nil {
+		t.Fatalf("SyncContainer(web) error = %v", err)
+	}
+	if _, err := manager.GetStats(ctx); err != nil {
+		t.Fatalf("GetStats() error = %v", err)
+	}
+	reads := gets(server, "/services/haproxy/backends") + gets(server, "/services/haproxy/frontends")
+	if err := manager.HealthCheck(ctx)["test"]; err != nil {
+		t.Fatalf("HealthCheck() error = %v", err)
+	}
+	stats, err := manager.GetStats(ctx)
+	if err != nil {
+		t.Fatalf("GetStats() error = %v", err)
+	}
+	if got := gets(server, "/services/haproxy/backends") + gets(server, "/services/haproxy/frontends"); got != reads {
+		t.Errorf("a health check and statistics made %d reads, want none", got-reads)
+	}
+	if count := stats["test"].(map[string]interface{})["backend_count"]; count != 2 {
+		t.Errorf("backend_count = %v, want 2", count)
+	}
+
+	// Removals still see the IDs shifted by earlier writes
+	for _, c := range []*container.Info{web, api} {
+		if err := manager.RemoveContainer(ctx, c); err != nil {
+			t.Fatalf("RemoveContainer(%s) error = %v", c.Name, err)
+		}
+	}
+	if backends := server.Backends(); len(backends) != 0 {
+		t.Errorf("got %d backends after removing every container, want 0", len(backends))
+	}
+}
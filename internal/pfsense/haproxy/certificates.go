@@ -0,0 +1,334 @@
+package haproxy
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/labels"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+)
+
+// certificatePlan collects the certificate manager changes of an endpoint and the refids of
+// the certificates frontends offer
+type certificatePlan struct {
+	// refIDs maps certificate references and fingerprints of imported certificates to refids.
+	// Certificates that are not found, or not imported yet, map to an empty refid.
+	refIDs map[string]string
+	// stale holds the refids of imported certificates no container uses anymore
+	stale map[string]bool
+	// inUse holds the refids of stale certificates frontends cannot stop offering
+	inUse   map[string]bool
+	imports []Change
+	unused  []pfsense.Certificate
+}
+
+// frontendRefIDs returns the refids of the certificates a desired frontend offers
+func (p *certificatePlan) frontendRefIDs(desired *desiredFrontend) []string {
+	var refIDs []string
+	for _, ref := range append(slices.Clone(desired.imports), desired.certificates...) {
+		if refID := p.refIDs[ref]; refID != "" && !slices.Contains(refIDs, refID) {
+			refIDs = append(refIDs, refID)
+		}
+	}
+	return refIDs
+}
+
+// deletes returns the deletions of stale imported certificates no frontend offers anymore,
+// from the highest ID down
+func (p *certificatePlan) deletes() []Change {
+	var deletes []Change
+	for _, cert := range p.unused {
+		if p.inUse[cert.RefID] {
+			continue
+		}
+		deletes = append(deletes, Change{Type: pfsense.ChangeDelete, Kind: KindImportedCertificate, Name: cert.Description, ID: cert.ID})
+	}
+	pfsense.SortByIDDescending(deletes, changeID)
+	return deletes
+}
+
+// certificateImports returns a plan with only the certificate imports of the given plan
+func certificateImports(plan *Plan) *Plan {
+	imports := &Plan{Endpoint: plan.Endpoint}
+	for _, change := range plan.Changes {
+		if change.Kind == KindImportedCertificate && change.Type == pfsense.ChangeCreate {
+			imports.Changes = append(imports.Changes, change)
+		}
+	}
+	return imports
+}
+
+// getCertificates fetches the certificates of the certificate manager. Without TLS frontends
+// they are only needed to clean up imported certificates, so failures are not fatal then.
+func (m *Manager) getCertificates(ctx context.Context, client pfsense.API, state *desiredState) ([]pfsense.Certificate, error) {
+	certificates, err := client.GetCertificates(ctx)
+	if err == nil {
+		return certificates, nil
+	}
+
+	for _, desired := range state.frontends {
+		if len(desired.certificates) > 0 || len(desired.imports) > 0 {
+			return nil, err
+		}
+	}
+
+	m.logger.Debugf("Not cleaning up imported certificates: %v", err)
+	return nil, nil
+}
+
+// planCertificates resolves the certificates wanted by the desired frontends to their refids,
+// plans the import of container certificates missing from the certificate manager, and finds
+// the imported certificates no container uses anymore
+func (m *Manager) planCertificates(state *desiredState, certificates []pfsense.Certificate) *certificatePlan {
+	plan := &certificatePlan{
+		refIDs: make(map[string]string),
+		stale:  make(map[string]bool),
+		inUse:  make(map[string]bool),
+	}
+
+	// Imported certificates are identified by their fingerprint, a changed file is imported anew
+	imported := make(map[string]string)
+	for i := range certificates {
+		owner := certificates[i].Owner()
+		if !owner.IsOwnedBy(m.config.Global.InstanceID) || owner.Fingerprint == "" {
+			continue
+		}
+		if _, desired := state.imports[owner.Fingerprint]; desired && imported[owner.Fingerprint] == "" {
+			imported[owner.Fingerprint] = certificates[i].RefID
+			continue
+		}
+		plan.stale[certificates[i].RefID] = true
+		plan.unused = append(plan.unused, certificates[i])
+	}
+
+	for _, fingerprint := range pfsense.SortedKeys(state.imports) {
+		plan.refIDs[fingerprint] = imported[fingerprint]
+		if imported[fingerprint] == "" {
+			bundle := state.imports[fingerprint]
+			plan.imports = append(plan.imports, Change{
+				Type:   pfsense.ChangeCreate,
+				Kind:   KindImportedCertificate,
+				Name:   bundle.Description(m.config.Global.InstanceID),
+				Bundle: bundle,
+			})
+		}
+	}
+
+	for _, name := range pfsense.SortedKeys(state.frontends) {
+		for _, ref := range state.frontends[name].certificates {
+			if _, done := plan.refIDs[ref]; done {
+				continue
+			}
+			cert := pfsense.MatchCertificate(certificates, ref)
+			if cert == nil && state.acme[ref] != nil {
+				m.logger.Infof("Waiting for ACME certificate %s of frontend %s to be issued", ref, name)
+				plan.refIDs[ref] = ""
+				continue
+			}
+			if cert == nil {
+				m.logger.Warnf("Certificate %s of frontend %s not found in the certificate manager", ref, name)
+				plan.refIDs[ref] = ""
+				continue
+			}
+			plan.refIDs[ref] = cert.RefID
+		}
+	}
+
+	return plan
+}
+
+// setFrontendCertificates makes a frontend about to be created offer the certificates with
+// the given refids, the way planFrontendTLS does for existing frontends: the first becomes
+// the default SSL offloading certificate, the others its additional certificates.
+func setFrontendCertificates(frontend *pfsense.HAProxyFrontend, refIDs []string) {
+	if len(refIDs) == 0 {
+		return
+	}
+
+	frontend.SSLOffloadCert = refIDs[0]
+	for _, refID := range refIDs[1:] {
+		frontend.Certificates = append(frontend.Certificates, pfsense.HAProxyFrontendCertificate{Certificate: refID})
+	}
+}
+
+// planFrontendTLS computes the changes that make an existing frontend offload SSL on all of its
+// listen addresses and offer the certificates with the given refids. The first certificate
+// becomes the default SSL offloading certificate when the frontend has none, the others are
+// added to its additional certificates, which HAProxy selects from through SNI.
+//
+// TLS settings are otherwise left alone, except for stale certificates imported by this
+// controller: they are removed from the additional certificates, and a stale default is
+// replaced by another certificate of the frontend. A stale default without replacement is
+// kept and recorded in inUse.
+func planFrontendTLS(existing *pfsense.HAProxyFrontend, refIDs []string, stale, inUse map[string]bool) []Change {
+	var changes []Change
+
+	if len(refIDs) > 0 {
+		for _, address := range existing.Addresses {
+			if address.SSL {
+				continue
+			}
+			address.SSL = true
+			changes = append(changes, Change{
+				Type:     pfsense.ChangeUpdate,
+				Kind:     KindAddress,
+				Name:     address.Address + ":" + address.Port,
+				Parent:   existing.Name,
+				ParentID: existing.ID,
+				ID:       address.ID,
+				Address:  &address,
+			})
+		}
+	}
+
+	// Additional certificates, minus stale ones
+	var additional []string
+	var deletes []Change
+	for _, cert := range existing.Certificates {
+		if stale[cert.Certificate] {
+			deletes = append(deletes, newCertificateChange(pfsense.ChangeDelete, existing, cert.Certificate, cert.ID))
+			continue
+		}
+		additional = append(additional, cert.Certificate)
+	}
+	pfsense.SortByIDDescending(deletes, changeID)
+
+	defaultCert := existing.SSLOffloadCert
+	if (defaultCert == "" && len(refIDs) > 0) || stale[defaultCert] {
+		replacement := ""
+		switch {
+		case len(refIDs) > 0:
+			replacement = refIDs[0]
+		case len(additional) > 0:
+			replacement = additional[0]
+		}
+
+		if replacement != "" {
+			changes = append(changes, newCertificateChange(pfsense.ChangeUpdate, existing, replacement, 0))
+			defaultCert = replacement
+		} else if defaultCert != "" {
+			inUse[defaultCert] = true
+		}
+	}
+
+	changes = append(changes, deletes...)
+	for _, refID := range refIDs {
+		if refID != defaultCert && !slices.Contains(additional, refID) {
+			changes = append(changes, newCertificateChange(pfsense.ChangeCreate, existing, refID, 0))
+			additional = append(additional, refID)
+		}
+	}
+
+	return changes
+}
+
+// newCertificateChange creates a change for a certificate offered by an existing frontend
+func newCertificateChange(changeType pfsense.ChangeType, frontend *pfsense.HAProxyFrontend, refID string, id int) Change {
+	return Change{
+		Type:     changeType,
+		Kind:     KindCertificate,
+		Name:     refID,
+		Parent:   frontend.Name,
+		ParentID: frontend.ID,
+		ID:       id,
+	}
+}
+
+// frontendCertificates returns the refid of the certificate a route's frontend offers, if any.
+// Certificates provided by the container are imported when the certificate manager does not
+// have them yet.
+func (m *Manager) frontendCertificates(ctx context.Context, client pfsense.API, route *labels.RouteConfig) ([]string, error) {
+	if !route.FrontendConfig.TLS {
+		return nil, nil
+	}
+
+	certificates, err := client.GetCertificates(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificates: %w", err)
+	}
+
+	if route.FrontendConfig.TLSCertFile == "" {
+		cert := pfsense.MatchCertificate(certificates, route.FrontendConfig.TLSCertificate)
+		if cert == nil && route.FrontendConfig.ACMEAccount != "" {
+			m.logger.Infof("Waiting for ACME certificate %s of frontend %s to be issued",
+				route.FrontendConfig.TLSCertificate, route.FrontendConfig.Name)
+			return nil, nil
+		}
+		if cert == nil {
+			m.logger.Warnf("Certificate %s of frontend %s not found in the certificate manager",
+				route.FrontendConfig.TLSCertificate, route.FrontendConfig.Name)
+			return nil, nil
+		}
+		return []string{cert.RefID}, nil
+	}
+
+	bundle, err := m.loadCertificateBundle(&route.FrontendConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range certificates {
+		owner := certificates[i].Owner()
+		if owner.IsOwnedBy(m.config.Global.InstanceID) && owner.Fingerprint == bundle.Fingerprint {
+			return []string{certificates[i].RefID}, nil
+		}
+	}
+
+	var refID string
+	if err := pfsense.RetryOperation(ctx, &m.config.Global, m.logger, func() error {
+		refID, err = client.ImportCertificate(ctx, bundle.Description(m.config.Global.InstanceID), bundle)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("failed to import certificate: %w", err)
+	}
+
+	return []string{refID}, nil
+}
+
+// loadCertificateBundle loads the certificate and key files of a frontend. Relative paths are
+// resolved in the secrets directory, where Docker mounts secrets.
+func (m *Manager) loadCertificateBundle(frontend *labels.FrontendConfig) (*pfsense.CertificateBundle, error) {
+	certFile, err := m.secretPath(frontend.TLSCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate %s: %w", frontend.TLSCertFile, err)
+	}
+	keyFile, err := m.secretPath(frontend.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key %s: %w", frontend.TLSKeyFile, err)
+	}
+
+	bundle, err := pfsense.LoadCertificateBundle(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate %s: %w", frontend.TLSCertFile, err)
+	}
+	return bundle, nil
+}
+
+// secretPath resolves a file path of a container label in the secrets directory. Paths that
+// lead out of it, through "..", as absolute paths or through symlinks, are rejected, so that
+// container labels cannot make the controller upload other files it can read to pfSense.
+func (m *Manager) secretPath(path string) (string, error) {
+	dir, err := filepath.Abs(m.config.Global.SecretsDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve secrets directory: %w", err)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve secrets directory: %w", err)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(realDir, resolved)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%s is outside the secrets directory %s", path, m.config.Global.SecretsDir)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,72 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package haproxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSecretPath(t *testing.T) {
+	root := t.TempDir()
+	secrets := filepath.Join(root, "secrets")
+	for _, dir := range []string{secrets, filepath.Join(secrets, "app")} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{filepath.Join(secrets, "app", "tls.crt"), filepath.Join(root, "host.key")} {
+		if err := os.WriteFile(file, []byte("secret"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(secrets, "app", "tls.crt"), filepath.Join(secrets, "current.crt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "host.key"), filepath.Join(secrets, "escape.key")); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manager{
+		config: &config.Config{Global: config.GlobalConfig{SecretsDir: secrets}},
+		logger: logrus.WithField("component", "test"),
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{name: "relative path", path: "app/tls.crt"},
+		{name: "absolute path in the secrets directory", path: filepath.Join(secrets, "app", "tls.crt")},
+		{name: "symlink within the secrets directory", path: "current.crt"},
+		{name: "parent directory", path: "../host.key", wantErr: true},
+		{name: "absolute path outside the secrets directory", path: filepath.Join(root, "host.key"), wantErr: true},
+		{name: "symlink out of the secrets directory", path: "escape.key", wantErr: true},
+		{name: "missing file", path: "missing.crt", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.secretPath(tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("secretPath(%q) = %q, want an error", tt.path, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("secretPath(%q) error = %v", tt.path, err)
+			}
+			if want := filepath.Join(secrets, "app", "tls.crt"); got != want {
+				t.Errorf("secretPath(%q) = %q, want %q", tt.path, got, want)
+			}
+		})
+	}
+}
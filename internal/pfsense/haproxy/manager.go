@@ -2,8 +2,12 @@
 package haproxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"time"
+	"slices"
+	"sort"
+	"strings"
 
 	"github.com/KristijanL/pfsense-container-controller/internal/config"
 	"github.com/KristijanL/pfsense-container-controller/internal/container"
@@ -12,9 +16,12 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// ErrNotOwned is returned when the controller refuses to modify a pfSense object it did not create
+var ErrNotOwned = errors.New("object is not owned by this controller, set the adopt label or adopt_unowned to take it over")
+
 // Manager manages HAProxy configurations for containers
 type Manager struct {
-	clients map[string]*pfsense.Client
+	clients map[string]*stateCache
 	parser  *labels.Parser
 	logger  *logrus.Entry
 	config  *config.Config
@@ -22,24 +29,31 @@ type Manager struct {
 
 // NewManager creates a new HAProxy manager
 func NewManager(cfg *config.Config) (*Manager, error) {
-	clients := make(map[string]*pfsense.Client)
+	clients := make(map[string]*stateCache)
 
-	// Create clients for all configured endpoints
+	// Create clients for all configured endpoints, reading the HAProxy state through a cache
+	// shared by syncs, health checks and metrics
 	for _, endpoint := range cfg.Endpoints {
-		client := pfsense.NewClient(&endpoint)
-		clients[endpoint.Name] = client
+		clients[endpoint.Name] = newStateCache(pfsense.NewClient(&endpoint), cfg.Global.StateCacheTTL.Duration)
 	}
 
 	return &Manager{
 		clients: clients,
-		parser:  labels.NewParser(cfg.Global.TraefikCompatMode),
-		logger:  logrus.WithField("component", "haproxy-manager"),
-		config:  cfg,
+		parser: labels.NewParserWithOptions(labels.Options{
+			TraefikCompatMode:  cfg.Global.TraefikCompatMode,
+			PreferredNetworks:  cfg.Global.PreferredNetworks,
+			AddressMode:        cfg.Global.AddressMode,
+			HostAddress:        cfg.Global.HostAddress,
+			AdvertiseAddresses: cfg.AdvertiseAddresses(),
+		}),
+		logger: logrus.WithField("component", "haproxy-manager"),
+		config: cfg,
 	}, nil
 }
 
-// SyncContainer synchronizes a container's configuration with pfSense HAProxy
-func (m *Manager) SyncContainer(containerInfo *container.Info) error {
+// SyncContainer synchronizes a container's configuration with pfSense HAProxy. The changes
+// are staged on pfSense until ApplyChanges is called.
+func (m *Manager) SyncContainer(ctx context.Context, containerInfo *container.Info) error {
 	// Parse container labels
 	containerConfig, err := m.parser.ParseContainer(containerInfo)
 	if err != nil {
@@ -53,31 +67,46 @@ func (m *Manager) SyncContainer(containerInfo *container.Info) error {
 		return fmt.Errorf("pfSense endpoint '%s' not found", containerConfig.EndpointName)
 	}
 
+	if m.config.Global.DryRun {
+		m.logger.Infof("Dry run: not syncing container %s to pfSense endpoint %s", containerInfo.Name, containerConfig.EndpointName)
+		return nil
+	}
+
 	m.logger.Infof("Syncing container %s to pfSense endpoint %s", containerInfo.Name, containerConfig.EndpointName)
 
-	// Sync backend first
-	if err := m.syncBackend(client, containerConfig); err != nil {
-		return fmt.Errorf("failed to sync backend: %w", err)
-	}
+	owner := m.ownerFor(containerInfo, containerConfig)
 
-	// Sync frontend
-	if err := m.syncFrontend(client, containerConfig); err != nil {
-		return fmt.Errorf("failed to sync frontend: %w", err)
-	}
+	for i := range containerConfig.Routes {
+		route := &containerConfig.Routes[i]
 
-	// Apply changes
-	if err := m.applyChangesWithRetry(client); err != nil {
-		return fmt.Errorf("failed to apply HAProxy changes: %w", err)
+		// Sync backend first
+		if err := m.syncBackend(ctx, client, containerConfig, route, owner); err != nil {
+			return fmt.Errorf("failed to sync backend: %w", err)
+		}
+
+		// Request the ACME certificate before the frontend offers it
+		if route.FrontendConfig.TLS && route.FrontendConfig.ACMEAccount != "" {
+			if err := m.syncACMECertificate(ctx, client, containerConfig, route, owner); err != nil {
+				return fmt.Errorf("failed to sync ACME certificate: %w", err)
+			}
+		}
+
+		// Sync frontend
+		if err := m.syncFrontend(ctx, client, route, owner); err != nil {
+			return fmt.Errorf("failed to sync frontend: %w", err)
+		}
 	}
 
 	m.logger.Infof("Successfully synced container %s", containerInfo.Name)
 	return nil
 }
 
-// RemoveContainer removes HAProxy configuration for a container
-func (m *Manager) RemoveContainer(containerInfo *container.Info) error {
-	// Parse container labels to determine which endpoint to use
-	containerConfig, err := m.parser.ParseContainer(containerInfo)
+// RemoveContainer removes HAProxy configuration for a container. The changes are staged on
+// pfSense until ApplyChanges is called.
+func (m *Manager) RemoveContainer(ctx context.Context, containerInfo *container.Info) error {
+	// Parse container labels to determine which endpoint to use. Removed containers
+	// usually no longer have an IP address, so the address is not required here.
+	containerConfig, err := m.parser.ParseContainerForRemoval(containerInfo)
 	if err != nil {
 		m.logger.Debugf("Container %s was not managed by controller", containerInfo.Name)
 		return nil
@@ -89,26 +118,258 @@ func (m *Manager) RemoveContainer(containerInfo *container.Info) error {
 		return fmt.Errorf("pfSense endpoint '%s' not found", containerConfig.EndpointName)
 	}
 
+	if m.config.Global.DryRun {
+		m.logger.Infof("Dry run: not removing HAProxy configuration for container %s", containerInfo.Name)
+		return nil
+	}
+
 	m.logger.Infof("Removing HAProxy configuration for container %s", containerInfo.Name)
 
-	// Note: For simplicity, we're not implementing removal in this version
-	// In production, you would want to:
-	// 1. Remove the backend
-	// 2. Remove associated ACLs and actions from frontends
-	// 3. Remove frontend if no more backends are using it
-	// 4. Apply changes
+	owner := m.ownerFor(containerInfo, containerConfig)
+	changed := false
+	for i := range containerConfig.Routes {
+		routeChanged, err := m.removeRoute(ctx, client, containerConfig, &containerConfig.Routes[i], owner)
+		if err != nil {
+			return err
+		}
+		changed = changed || routeChanged
+	}
+
+	if !changed {
+		m.logger.Debugf("No HAProxy configuration found for container %s", containerInfo.Name)
+		return nil
+	}
 
-	m.logger.Warnf("HAProxy configuration removal not implemented - manual cleanup required for container %s", containerInfo.Name)
+	m.logger.Infof("Successfully removed container %s", containerInfo.Name)
 	return nil
 }
 
+// removeRoute removes the backend server and, once the backend is empty, the frontend
+// routing of a route of a removed container
+func (m *Manager) removeRoute(
+	ctx context.Context,
+	client pfsense.API,
+	containerConfig *labels.ContainerConfig,
+	route *labels.RouteConfig,
+	owner pfsense.Owner,
+) (bool, error) {
+	// Remove the container's server from its backend first
+	backend, backendEmpty, changed, err := m.removeBackendServer(ctx, client, containerConfig, route, owner)
+	if err != nil {
+		return changed, fmt.Errorf("failed to remove backend server: %w", err)
+	}
+
+	// Routing is only removed once no servers are left to serve the backend. ACLs and
+	// actions carry no ownership marker of their own, frontends without a record of owned
+	// items own them through the backend they route to, so without the backend they are
+	// only removed when adopting.
+	if backendEmpty && backend == nil && !pfsense.CanModify(&m.config.Global, nil, containerConfig.Adopt) {
+		m.logger.Debugf("Backend %s not found, leaving frontend routing untouched", route.BackendConfig.Name)
+		backendEmpty = false
+	}
+
+	if backendEmpty {
+		frontendChanged, err := m.removeFrontendRouting(ctx, client, containerConfig, route)
+		if err != nil {
+			return changed, fmt.Errorf("failed to remove frontend routing: %w", err)
+		}
+		changed = changed || frontendChanged
+
+		// The backend can only be deleted after nothing references it anymore
+		if backend != nil {
+			m.logger.Infof("Deleting HAProxy backend: %s", backend.Name)
+			if err := pfsense.RetryOperation(ctx, &m.config.Global, m.logger, func() error {
+				return client.DeleteHAProxyBackend(ctx, backend.ID)
+			}); err != nil {
+				return changed, fmt.Errorf("failed to delete backend: %w", err)
+			}
+			changed = true
+		}
+	}
+
+	return changed, nil
+}
+
+// removeBackendServer removes the container's server from its backend. It reports the
+// backend (nil if it does not exist) and whether the backend has no servers left, in
+// which case the server is not deleted individually and the whole backend should go.
+func (m *Manager) removeBackendServer(
+	ctx context.Context,
+	client pfsense.API,
+	containerConfig *labels.ContainerConfig,
+	route *labels.RouteConfig,
+	owner pfsense.Owner,
+) (backend *pfsense.HAProxyBackend, empty, changed bool, err error) {
+	backend, err = client.FindBackendByName(ctx, route.BackendConfig.Name)
+	if err != nil {
+		return nil, false, false, fmt.Errorf("failed to check existing backend: %w", err)
+	}
+	if backend == nil {
+		return nil, true, false, nil
+	}
+
+	if !pfsense.CanModify(&m.config.Global, backend.Owner(), containerConfig.Adopt) {
+		return nil, false, false, fmt.Errorf("backend %s: %w", backend.Name, ErrNotOwned)
+	}
+
+	index := findServer(backend.Servers, route.BackendConfig.ServerName, owner.ContainerID)
+	remaining := len(backend.Servers)
+	if index >= 0 {
+		remaining--
+	}
+
+	if remaining == 0 {
+		return backend, true, false, nil
+	}
+
+	existingOwner := backend.Owner()
+	listed := existingOwner.IsOwnedBy(owner.InstanceID) && slices.Contains(strings.Split(existingOwner.ContainerID, ","), owner.ContainerID)
+	if index < 0 && !listed {
+		return backend, false, false, nil
+	}
+
+	// The server and the container's entry in the ownership marker are removed in one update,
+	// so that the backend never lists a container it no longer serves
+	updated := *backend
+	updated.Servers = make([]pfsense.HAProxyBackendServer, 0, remaining)
+	for i, s := range backend.Servers {
+		if i == index {
+			m.logger.Infof("Removing server %s from HAProxy backend %s", s.Name, backend.Name)
+			continue
+		}
+		s.ID = 0
+		updated.Servers = append(updated.Servers, s)
+	}
+	if listed {
+		updated.SetOwner(existingOwner.Remove(owner.ContainerID))
+	}
+
+	err = pfsense.RetryOperation(ctx, &m.config.Global, m.logger, func() error {
+		return client.UpdateHAProxyBackend(ctx, &updated)
+	})
+	if err != nil {
+		return backend, false, false, err
+	}
+
+	return backend, false, true, nil
+}
+
+// removeFrontendRouting removes the use_backend actions routing to the container's backend
+// and the ACLs only they use from its frontend, and deletes auto-created frontends that end
+// up without any rules
+func (m *Manager) removeFrontendRouting(
+	ctx context.Context,
+	client pfsense.API,
+	containerConfig *labels.ContainerConfig,
+	route *labels.RouteConfig,
+) (bool, error) {
+	frontendName := route.FrontendConfig.Name
+	backendName := route.BackendConfig.Name
+
+	frontend, err := client.FindFrontendByName(ctx, frontendName)
+	if err != nil {
+		return false, fmt.Errorf("failed to check existing frontend: %w", err)
+	}
+	if frontend == nil {
+		return false, nil
+	}
+
+	// Collect the owned actions routing to the backend, keeping track of ACLs still in use.
+	// Without a record of owned items, routing to the backend is owned through the backend.
+	ownedACLs, ownedActions, recorded := frontend.OwnedItems(m.config.Global.InstanceID)
+	var actionIDs []int
+	removedACLs := make(map[string]bool)
+	usedACLs := make(map[string]bool)
+	remainingActions := 0
+	for i := range frontend.ActionItems {
+		action := &frontend.ActionItems[i]
+		if action.Backend == backendName && (!recorded || ownedActions[action.ItemKey()]) {
+			actionIDs = append(actionIDs, action.ID)
+			delete(ownedActions, action.ItemKey())
+			for _, name := range action.ACLNames() {
+				removedACLs[name] = true
+			}
+			continue
+		}
+		remainingActions++
+		for _, name := range action.ACLNames() {
+			usedACLs[name] = true
+		}
+	}
+
+	var acls []pfsense.HAProxyACL
+	remainingACLs := 0
+	for _, acl := range frontend.HAACLs {
+		if removedACLs[acl.Name] && !usedACLs[acl.Name] && (!recorded || ownedACLs[acl.Name]) {
+			acls = append(acls, acl)
+			delete(ownedACLs, acl.Name)
+			continue
+		}
+		remainingACLs++
+	}
+
+	// pfSense identifies nested objects by their index, so delete from the end
+	// to keep the remaining IDs valid
+	sort.Sort(sort.Reverse(sort.IntSlice(actionIDs)))
+	sort.Slice(acls, func(i, j int) bool { return acls[i].ID > acls[j].ID })
+
+	for _, actionID := range actionIDs {
+		m.logger.Infof("Removing action for backend %s from frontend %s", backendName, frontendName)
+		if err := pfsense.RetryOperation(ctx, &m.config.Global, m.logger, func() error {
+			return client.DeleteActionFromFrontend(ctx, frontend.ID, actionID)
+		}); err != nil {
+			return false, fmt.Errorf("failed to delete action: %w", err)
+		}
+	}
+
+	for _, acl := range acls {
+		m.logger.Infof("Removing ACL %s from frontend %s", acl.Name, frontendName)
+		if err := pfsense.RetryOperation(ctx, &m.config.Global, m.logger, func() error {
+			return client.DeleteACLFromFrontend(ctx, frontend.ID, acl.ID)
+		}); err != nil {
+			return false, fmt.Errorf("failed to delete ACL: %w", err)
+		}
+	}
+
+	changed := len(actionIDs) > 0 || len(acls) > 0
+
+	// Only frontends created by the controller are removed, shared frontends are kept
+	if pfsense.CanModify(&m.config.Global, frontend.Owner(), containerConfig.Adopt) && remainingActions == 0 && remainingACLs == 0 {
+		m.logger.Infof("Deleting empty HAProxy frontend: %s", frontendName)
+		if err := pfsense.RetryOperation(ctx, &m.config.Global, m.logger, func() error {
+			return client.DeleteHAProxyFrontend(ctx, frontend.ID)
+		}); err != nil {
+			return false, fmt.Errorf("failed to delete frontend: %w", err)
+		}
+		return true, nil
+	}
+
+	if recorded && changed {
+		frontend.SetOwnedItems(m.config.Global.InstanceID, pfsense.SortedKeys(ownedACLs), pfsense.SortedKeys(ownedActions))
+		if err := pfsense.RetryOperation(ctx, &m.config.Global, m.logger, func() error {
+			return client.SetFrontendDescription(ctx, frontend.ID, frontend.Description)
+		}); err != nil {
+			return false, fmt.Errorf("failed to update owned items of frontend: %w", err)
+		}
+	}
+
+	return changed, nil
+}
+
 // syncBackend synchronizes the HAProxy backend configuration
-func (m *Manager) syncBackend(client *pfsense.Client, containerConfig *labels.ContainerConfig) error {
-	// Convert container config to HAProxy backend
-	desiredBackend := m.parser.ConvertToHAProxyBackend(containerConfig)
+func (m *Manager) syncBackend(
+	ctx context.Context,
+	client pfsense.API,
+	containerConfig *labels.ContainerConfig,
+	route *labels.RouteConfig,
+	owner pfsense.Owner,
+) error {
+	// Convert the route to HAProxy backend
+	desiredBackend := m.parser.ConvertToHAProxyBackend(route)
+	desiredBackend.SetOwner(owner)
 
 	// Check if backend already exists
-	existingBackend, err := client.FindBackendByName(desiredBackend.Name)
+	existingBackend, err := client.FindBackendByName(ctx, desiredBackend.Name)
 	if err != nil {
 		return fmt.Errorf("failed to check existing backend: %w", err)
 	}
@@ -116,29 +377,93 @@ func (m *Manager) syncBackend(client *pfsense.Client, containerConfig *labels.Co
 	if existingBackend == nil {
 		// Create new backend
 		m.logger.Infof("Creating new HAProxy backend: %s", desiredBackend.Name)
-		return m.retryOperation(func() error {
-			return client.CreateHAProxyBackend(desiredBackend)
+		return pfsense.RetryOperation(ctx, &m.config.Global, m.logger, func() error {
+			return client.CreateHAProxyBackend(ctx, desiredBackend)
 		})
 	}
 
+	// Never touch backends configured by hand unless explicitly adopted
+	if !pfsense.CanModify(&m.config.Global, existingBackend.Owner(), containerConfig.Adopt) {
+		return fmt.Errorf("backend %s: %w", desiredBackend.Name, ErrNotOwned)
+	}
+
+	// Keep the servers of other containers sharing the backend, replacing this container's server
+	existingOwner := existingBackend.Owner()
+	exclusive := existingOwner == nil || existingOwner.ContainerID == owner.ContainerID
+	desiredBackend.Servers = mergeServers(existingBackend.Servers, desiredBackend.Servers[0], owner.ContainerID, exclusive)
+	if existingOwner.IsOwnedBy(owner.InstanceID) {
+		desiredBackend.SetOwner(existingOwner.Merge(owner))
+	}
+
 	// Update existing backend
 	m.logger.Infof("Updating existing HAProxy backend: %s", desiredBackend.Name)
 	desiredBackend.ID = existingBackend.ID
-	return m.retryOperation(func() error {
-		return client.UpdateHAProxyBackend(desiredBackend)
+	return pfsense.RetryOperation(ctx, &m.config.Global, m.logger, func() error {
+		return client.UpdateHAProxyBackend(ctx, desiredBackend)
 	})
 }
 
+// mergeServers returns the servers of an existing backend with the server of a container
+// added. The container's previous server is replaced, other containers' servers are kept and
+// the server is named the way Reconcile names it. A server with the container's plain server
+// name is only taken as the container's own if its address matches or no other container
+// shares the backend, as it otherwise belongs to the container that claimed the name first.
+func mergeServers(
+	existing []pfsense.HAProxyBackendServer,
+	server pfsense.HAProxyBackendServer,
+	containerID string,
+	exclusive bool,
+) []pfsense.HAProxyBackendServer {
+	own := findServer(existing, server.Name, containerID)
+	if own >= 0 && existing[own].Name == server.Name && !exclusive &&
+		(existing[own].Address != server.Address || existing[own].Port != server.Port) {
+		own = -1
+	}
+
+	others := make([]pfsense.HAProxyBackendServer, 0, len(existing))
+	for i, s := range existing {
+		if i != own {
+			others = append(others, s)
+		}
+	}
+	server.Name = serverName(others, server.Name, containerID)
+
+	// The server keeps its position, so that Reconcile sees no change in the server order
+	servers := make([]pfsense.HAProxyBackendServer, 0, len(existing)+1)
+	for i, s := range existing {
+		if i == own {
+			s = server
+		}
+		s.ID = 0
+		servers = append(servers, s)
+	}
+	if own < 0 {
+		servers = append(servers, server)
+	}
+	return servers
+}
+
 // syncFrontend synchronizes the HAProxy frontend configuration
-func (m *Manager) syncFrontend(client *pfsense.Client, containerConfig *labels.ContainerConfig) error {
-	// Convert container config to HAProxy frontend
-	desiredFrontend, err := m.parser.ConvertToHAProxyFrontend(containerConfig)
+func (m *Manager) syncFrontend(
+	ctx context.Context,
+	client pfsense.API,
+	route *labels.RouteConfig,
+	owner pfsense.Owner,
+) error {
+	// Convert the route to HAProxy frontend
+	desiredFrontend, err := m.parser.ConvertToHAProxyFrontend(route)
 	if err != nil {
 		return fmt.Errorf("failed to convert to HAProxy frontend: %w", err)
 	}
+	desiredFrontend.SetOwner(owner)
+
+	refIDs, err := m.frontendCertificates(ctx, client, route)
+	if err != nil {
+		return err
+	}
 
 	// Check if frontend already exists
-	existingFrontend, err := client.FindFrontendByName(desiredFrontend.Name)
+	existingFrontend, err := client.FindFrontendByName(ctx, desiredFrontend.Name)
 	if err != nil {
 		return fmt.Errorf("failed to check existing frontend: %w", err)
 	}
@@ -146,82 +471,134 @@ func (m *Manager) syncFrontend(client *pfsense.Client, containerConfig *labels.C
 	if existingFrontend == nil {
 		// Create new frontend
 		m.logger.Infof("Creating new HAProxy frontend: %s", desiredFrontend.Name)
-		return m.retryOperation(func() error {
-			return client.CreateHAProxyFrontend(desiredFrontend)
+		if len(refIDs) > 0 {
+			m.logger.Warnf("Frontend %s is created without listen addresses, add one to enable TLS", desiredFrontend.Name)
+		}
+		setFrontendCertificates(desiredFrontend, refIDs)
+		recordOwnedItems(desiredFrontend, m.config.Global.InstanceID)
+		return pfsense.RetryOperation(ctx, &m.config.Global, m.logger, func() error {
+			return client.CreateHAProxyFrontend(ctx, desiredFrontend)
 		})
 	}
 
-	// Frontend exists, add ACL and action to it
-	m.logger.Infof("Frontend %s already exists, adding ACL and action", desiredFrontend.Name)
-
-	if len(desiredFrontend.HAACLs) == 0 || len(desiredFrontend.ActionItems) == 0 {
-		return fmt.Errorf("missing ACL or action in frontend configuration")
+	// Frontend exists, make sure it contains the container's ACLs and actions exactly once
+	routable, err := m.routableBackends(ctx, client, route.BackendConfig.Name)
+	if err != nil {
+		return err
 	}
 
-	acl := desiredFrontend.HAACLs[0]
-	action := desiredFrontend.ActionItems[0]
+	changes, record, _ := planFrontendItems(existingFrontend, desiredFrontend, m.config.Global.InstanceID, routable, false)
+	if len(refIDs) > 0 {
+		if len(existingFrontend.Addresses) == 0 {
+			m.logger.Warnf("Frontend %s has no listen addresses, add one to enable TLS", desiredFrontend.Name)
+		}
+		changes = append(changes, planFrontendTLS(existingFrontend, refIDs, nil, make(map[string]bool))...)
+	}
+	if record != nil {
+		changes = append(changes, *record)
+	}
+	if len(changes) == 0 {
+		m.logger.Debugf("Frontend %s already routes to backend %s", desiredFrontend.Name, route.BackendConfig.Name)
+		return nil
+	}
 
-	return m.retryOperation(func() error {
-		return client.UpdateFrontendWithACLAndAction(existingFrontend.ID, acl, action)
-	})
-}
+	for i := range changes {
+		change := &changes[i]
+		m.logger.Infof("Updating frontend %s: %s", desiredFrontend.Name, change)
+		if err := pfsense.RetryOperation(ctx, &m.config.Global, m.logger, func() error {
+			return executeChange(ctx, client, change)
+		}); err != nil {
+			return fmt.Errorf("failed to %s: %w", change, err)
+		}
+	}
 
-// applyChangesWithRetry applies HAProxy configuration changes with retry logic
-func (m *Manager) applyChangesWithRetry(client *pfsense.Client) error {
-	return m.retryOperation(func() error {
-		return client.ApplyHAProxyChanges()
-	})
+	return nil
 }
 
-// retryOperation retries an operation with exponential backoff
-func (m *Manager) retryOperation(operation func() error) error {
-	var lastErr error
+// routableBackends returns the backends whose frontend routing this controller owns:
+// the backends it owns on the endpoint and the backend of the container being synced
+func (m *Manager) routableBackends(ctx context.Context, client pfsense.API, backendName string) (map[string]bool, error) {
+	backends, err := client.GetHAProxyBackends(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backends: %w", err)
+	}
 
-	for attempt := 0; attempt < m.config.Global.RetryAttempts; attempt++ {
-		if attempt > 0 {
-			delay := time.Duration(attempt) * m.config.Global.RetryDelay.Duration
-			m.logger.Debugf("Retrying operation after %v (attempt %d/%d)", delay, attempt+1, m.config.Global.RetryAttempts)
-			time.Sleep(delay)
+	routable := map[string]bool{backendName: true}
+	for i := range backends {
+		if backends[i].Owner().IsOwnedBy(m.config.Global.InstanceID) {
+			routable[backends[i].Name] = true
 		}
+	}
 
-		if err := operation(); err != nil {
-			lastErr = err
-			m.logger.Warnf("Operation failed (attempt %d/%d): %v", attempt+1, m.config.Global.RetryAttempts, err)
-			continue
-		}
+	return routable, nil
+}
 
-		return nil
+// ownerFor returns the ownership marker for pfSense objects created for a container
+func (m *Manager) ownerFor(containerInfo *container.Info, containerConfig *labels.ContainerConfig) pfsense.Owner {
+	return pfsense.Owner{
+		InstanceID:  m.config.Global.InstanceID,
+		ContainerID: pfsense.ShortContainerID(containerInfo.ID),
+		LabelHash:   containerConfig.LabelHash,
 	}
+}
 
-	return fmt.Errorf("operation failed after %d attempts, last error: %w", m.config.Global.RetryAttempts, lastErr)
+// ApplyChanges applies the HAProxy changes staged on every endpoint, so that a batch of
+// container syncs and removals reloads HAProxy once per endpoint
+func (m *Manager) ApplyChanges(ctx context.Context) error {
+	var errs []error
+	for _, endpoint := range m.endpointNames() {
+		if err := m.applyChanges(ctx, endpoint); err != nil {
+			errs = append(errs, fmt.Errorf("endpoint %s: %w", endpoint, err))
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
-// getClient returns the pfSense client for the given endpoint name
-func (m *Manager) getClient(endpointName string) *pfsense.Client {
-	if client, exists := m.clients[endpointName]; exists {
-		return client
+// applyChanges applies the HAProxy changes staged on an endpoint, if there are any. Changes
+// that fail to apply stay staged for the next apply. Staged changes are only counted in
+// memory, and a previous run may have left changes staged without applying them, so the
+// first apply of an endpoint runs even without changes.
+func (m *Manager) applyChanges(ctx context.Context, endpoint string) error {
+	if m.config.Global.DryRun {
+		return nil
 	}
+	client := m.clients[endpoint]
 
-	// Try default endpoint if specified endpoint not found
-	if defaultEndpoint := m.config.GetDefaultEndpoint(); defaultEndpoint != nil {
-		if client, exists := m.clients[defaultEndpoint.Name]; exists {
-			m.logger.Warnf("Endpoint '%s' not found, using default endpoint '%s'", endpointName, defaultEndpoint.Name)
-			return client
-		}
+	stats := client.stats()
+	if stats.pending == 0 && stats.applies > 0 {
+		return nil
 	}
 
+	m.logger.Infof("Applying %d HAProxy changes on endpoint %s", stats.pending, endpoint)
+	err := pfsense.RetryOperation(ctx, &m.config.Global, m.logger, func() error {
+		return client.ApplyHAProxyChanges(ctx)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply HAProxy changes: %w", err)
+	}
 	return nil
 }
 
+// getClient returns the pfSense client for the given endpoint name
+func (m *Manager) getClient(endpointName string) pfsense.API {
+	return m.clients[pfsense.ResolveEndpoint(m.config, m.clients, endpointName, m.logger)]
+}
+
+// endpointNames returns the names of all configured endpoints in sorted order
+func (m *Manager) endpointNames() []string {
+	return pfsense.SortedKeys(m.clients)
+}
+
 // HealthCheck performs a health check on all configured pfSense endpoints
-func (m *Manager) HealthCheck() map[string]error {
+func (m *Manager) HealthCheck(ctx context.Context) map[string]error {
 	results := make(map[string]error)
 
 	for name, client := range m.clients {
 		m.logger.Debugf("Performing health check for endpoint: %s", name)
 
 		// Try to get HAProxy backends as a simple health check
-		_, err := client.GetHAProxyBackends()
+		_, err := client.GetHAProxyBackends(ctx)
 		results[name] = err
 
 		if err != nil {
@@ -235,14 +612,14 @@ func (m *Manager) HealthCheck() map[string]error {
 }
 
 // GetStats returns statistics about managed HAProxy configurations
-func (m *Manager) GetStats() (map[string]interface{}, error) {
+func (m *Manager) GetStats(ctx context.Context) (map[string]interface{}, error) {
 	stats := make(map[string]interface{})
 
 	for name, client := range m.clients {
 		endpointStats := make(map[string]interface{})
 
 		// Get backend count
-		backends, err := client.GetHAProxyBackends()
+		backends, err := client.GetHAProxyBackends(ctx)
 		if err != nil {
 			endpointStats["backend_count"] = -1
 			endpointStats["error"] = err.Error()
@@ -251,7 +628,7 @@ func (m *Manager) GetStats() (map[string]interface{}, error) {
 		}
 
 		// Get frontend count
-		frontends, err := client.GetHAProxyFrontends()
+		frontends, err := client.GetHAProxyFrontends(ctx)
 		if err != nil {
 			endpointStats["frontend_count"] = -1
 			if endpointStats["error"] == nil {
@@ -261,6 +638,12 @@ func (m *Manager) GetStats() (map[string]interface{}, error) {
 			endpointStats["frontend_count"] = len(frontends)
 		}
 
+		// Get the changes waiting to be applied and carried by earlier applies
+		counts := client.stats()
+		endpointStats["pending_changes"] = counts.pending
+		endpointStats["applies"] = counts.applies
+		endpointStats["applied_changes"] = counts.applied
+
 		stats[name] = endpointStats
 	}
 
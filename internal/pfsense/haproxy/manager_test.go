@@ -0,0 +1,499 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package haproxy
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/config"
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense/pfsensetest"
+)
+
+// newServerManager returns a manager of a single endpoint served by a fake pfSense
+func newServerManager(t *testing.T) (*pfsensetest.Server, *Manager) {
+	t.Helper()
+
+	server := pfsensetest.NewServer()
+	t.Cleanup(server.Close)
+
+	return server, newManager(t, server)
+}
+
+// newManager returns a manager of a single endpoint served by the given fake pfSense
+func newManager(t *testing.T, server *pfsensetest.Server) *Manager {
+	t.Helper()
+
+	cfg := &config.Config{
+		Endpoints: []config.EndpointConfig{server.Endpoint("test")},
+		Global: config.GlobalConfig{
+			InstanceID:    "test",
+			AddressMode:   "container",
+			RetryAttempts: 3,
+		},
+	}
+	cfg.Global.RetryDelay.Duration = time.Millisecond
+
+	manager, err := NewManager(cfg)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	return manager
+}
+
+// webContainer returns a running container routed to by host name on the shared frontend
+func webContainer(name, ip, host string) *container.Info {
+	return &container.Info{
+		ID:    name + "-id",
+		Name:  name,
+		State: "running",
+		Labels: map[string]string{
+			"pfsense-controller.enable":        "true",
+			"pfsense-controller.backend.port":  "8080",
+			"pfsense-controller.frontend.name": "shared",
+			"pfsense-controller.frontend.rule": "Host(`" + host + "`)",
+		},
+		Networks: map[string]container.NetworkInfo{
+			"default": {IPAddress: ip},
+		},
+	}
+}
+
+// routes returns the backends the actions of a frontend route to
+func routes(frontend *pfsense.HAProxyFrontend) []string {
+	var backends []string
+	for _, action := range frontend.ActionItems {
+		backends = append(backends, action.Backend)
+	}
+	return backends
+}
+
+func findFrontend(frontends []pfsense.HAProxyFrontend, name string) *pfsense.HAProxyFrontend {
+	for i := range frontends {
+		if frontends[i].Name == name {
+			return &frontends[i]
+		}
+	}
+	return nil
+}
+
+func TestManager_SyncAndRemoveContainer(t *testing.T) {
+	server, manager := newServerManager(t)
+	ctx := context.Background()
+
+	web := webContainer("web", "172.17.0.2", "web.example.com")
+	api := webContainer("api", "172.17.0.3", "api.example.com")
+
+	for _, c := range []*container.Info{web, api} {
+		if err := manager.SyncContainer(ctx, c); err != nil {
+			t.Fatalf("SyncContainer(%s) error = %v", c.Name, err)
+		}
+	}
+
+	if backends := server.Backends(); len(backends) != 2 {
+		t.Fatalf("got %d backends after syncing two containers, want 2", len(backends))
+	}
+	frontend := findFrontend(server.Frontends(), "shared")
+	if frontend == nil {
+		t.Fatal("frontend shared was not created")
+	}
+	if got := routes(frontend); len(got) != 2 {
+		t.Errorf("frontend routes to %v, want both backends", got)
+	}
+	if acls, actions, _ := frontend.OwnedItems("test"); len(acls) != 2 || len(actions) != 2 {
+		t.Errorf("frontend records %d ACLs and %d actions as owned, want 2 each", len(acls), len(actions))
+	}
+	if !server.Pending() || server.Applies() != 0 {
+		t.Errorf("Pending() = %v, Applies() = %d, want the syncs staged", server.Pending(), server.Applies())
+	}
+	if err := manager.ApplyChanges(ctx); err != nil {
+		t.Fatalf("ApplyChanges() error = %v", err)
+	}
+	if server.Pending() || server.Applies() != 1 {
+		t.Errorf("Pending() = %v, Applies() = %d, want both syncs applied at once", server.Pending(), server.Applies())
+	}
+
+	// Syncing again changes nothing
+	writes := len(server.Writes())
+	if err := manager.SyncContainer(ctx, web); err != nil {
+		t.Fatalf("SyncContainer(web) again error = %v", err)
+	}
+	if frontend := findFrontend(server.Frontends(), "shared"); len(frontend.ActionItems) != 2 || len(frontend.HAACLs) != 2 {
+		t.Errorf("frontend has %d actions and %d ACLs after a repeated sync, want 2 each",
+			len(frontend.ActionItems), len(frontend.HAACLs))
+	}
+
+	// Removing the first container shifts the IDs of the second one's objects
+	if err := manager.RemoveContainer(ctx, web); err != nil {
+		t.Fatalf("RemoveContainer(web) error = %v", err)
+	}
+	backends := server.Backends()
+	if len(backends) != 1 || backends[0].Servers[0].Address != "172.17.0.3" {
+		t.Errorf("backends after removing web = %+v, want only the api backend", backends)
+	}
+	frontend = findFrontend(server.Frontends(), "shared")
+	if got := routes(frontend); len(got) != 1 || got[0] != backends[0].Name {
+		t.Errorf("frontend routes to %v after removing web, want only %s", got, backends[0].Name)
+	}
+	if acls, actions, _ := frontend.OwnedItems("test"); len(acls) != 1 || len(actions) != 1 {
+		t.Errorf("frontend records %d ACLs and %d actions as owned after removing web, want 1 each", len(acls), len(actions))
+	}
+
+	if err := manager.RemoveContainer(ctx, api); err != nil {
+		t.Fatalf("RemoveContainer(api) error = %v", err)
+	}
+	if err := manager.ApplyChanges(ctx); err != nil {
+		t.Fatalf("ApplyChanges() error = %v", err)
+	}
+	if backends := server.Backends(); len(backends) != 0 {
+		t.Errorf("got %d backends after removing every container, want 0", len(backends))
+	}
+	if frontend := findFrontend(server.Frontends(), "shared"); frontend != nil && len(frontend.ActionItems) > 0 {
+		t.Errorf("frontend still routes to %v after removing every container", routes(frontend))
+	}
+	if server.Pending() {
+		t.Error("removals were not applied")
+	}
+	if len(server.Writes()) <= writes {
+		t.Error("removals made no writes")
+	}
+}
+
+func TestManager_Reconcile(t *testing.T) {
+	server, manager := newServerManager(t)
+	ctx := context.Background()
+
+	web := webContainer("web", "172.17.0.2", "web.example.com")
+	api := webContainer("api", "172.17.0.3", "api.example.com")
+
+	if err := manager.Reconcile(ctx, []*container.Info{web, api}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if backends := server.Backends(); len(backends) != 2 {
+		t.Fatalf("got %d backends, want 2", len(backends))
+	}
+	if server.Applies() != 1 {
+		t.Errorf("Applies() = %d, want a single apply per reconcile", server.Applies())
+	}
+
+	// Reconciling the same containers again is a no-op
+	writes := len(server.Writes())
+	if err := manager.Reconcile(ctx, []*container.Info{web, api}); err != nil {
+		t.Fatalf("Reconcile() again error = %v", err)
+	}
+	if got := server.Writes()[writes:]; len(got) != 0 {
+		t.Errorf("repeated Reconcile() wrote %+v, want nothing", got)
+	}
+	if server.Applies() != 1 {
+		t.Errorf("Applies() = %d after a no-op reconcile, want 1", server.Applies())
+	}
+
+	// Objects of containers that are gone are pruned, others are left alone
+	server.SetBackends(append(server.Backends(), pfsense.HAProxyBackend{Name: "manual"}))
+	if err := manager.Reconcile(ctx, []*container.Info{api}); err != nil {
+		t.Fatalf("Reconcile() without web error = %v", err)
+	}
+	var names []string
+	for _, backend := range server.Backends() {
+		names = append(names, backend.Name)
+	}
+	if len(names) != 2 || findFrontend(server.Frontends(), "shared") == nil {
+		t.Errorf("backends after pruning web = %v, want the api and manual backends", names)
+	}
+	if got := routes(findFrontend(server.Frontends(), "shared")); len(got) != 1 {
+		t.Errorf("frontend routes to %v after pruning web, want only the api backend", got)
+	}
+}
+
+func TestManager_ReconcileConflictingACL(t *testing.T) {
+	server, manager := newServerManager(t)
+	ctx := context.Background()
+
+	// Both routes name their ACL the same but match different hosts
+	web := webContainer("web", "172.17.0.2", "web.example.com")
+	api := webContainer("api", "172.17.0.3", "api.example.com")
+	for _, c := range []*container.Info{web, api} {
+		c.Labels["pfsense-controller.frontend.acl_name"] = "site"
+	}
+
+	if err := manager.Reconcile(ctx, []*container.Info{web, api}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	// Containers are taken in name order, so api keeps the ACL and web is skipped
+	var backends []string
+	for _, backend := range server.Backends() {
+		backends = append(backends, backend.Name)
+	}
+	if len(backends) != 1 || backends[0] != "api-backend" {
+		t.Errorf("backends = %v, want only the api backend", backends)
+	}
+	frontend := findFrontend(server.Frontends(), "shared")
+	if frontend == nil {
+		t.Fatal("frontend shared not created")
+	}
+	if len(frontend.HAACLs) != 1 || !strings.Contains(frontend.HAACLs[0].Value, "api.example.com") {
+		t.Errorf("frontend ACLs = %+v, want only the ACL of api", frontend.HAACLs)
+	}
+	if got := routes(frontend); len(got) != 1 || got[0] != "api-backend" {
+		t.Errorf("frontend routes to %v, want only the api backend", got)
+	}
+}
+
+func TestManager_SharedBackendServerNames(t *testing.T) {
+	server, manager := newServerManager(t)
+	ctx := context.Background()
+
+	// Replicas sharing a backend and server name
+	var replicas []*container.Info
+	for i, ip := range []string{"172.17.0.2", "172.17.0.3"} {
+		replica := webContainer(fmt.Sprintf("replica-%d", i+1), ip, "app.example.com")
+		replica.Labels["pfsense-controller.backend.name"] = "app"
+		replica.Labels["pfsense-controller.backend.server_name"] = "app"
+		replicas = append(replicas, replica)
+	}
+	serverNames := func() map[string]string {
+		names := make(map[string]string)
+		for _, backend := range server.Backends() {
+			for _, s := range backend.Servers {
+				names[s.Name] = s.Address
+			}
+		}
+		return names
+	}
+	want := map[string]string{"app": "172.17.0.2", "app-replica-2-id": "172.17.0.3"}
+
+	if err := manager.Reconcile(ctx, replicas); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if got := serverNames(); !maps.Equal(got, want) {
+		t.Fatalf("servers after Reconcile() = %v, want %v", got, want)
+	}
+
+	// Syncs name the servers the way reconciling does, so neither undoes the other
+	for _, replica := range []*container.Info{replicas[1], replicas[0]} {
+		if err := manager.SyncContainer(ctx, replica); err != nil {
+			t.Fatalf("SyncContainer(%s) error = %v", replica.Name, err)
+		}
+		if got := serverNames(); !maps.Equal(got, want) {
+			t.Errorf("servers after SyncContainer(%s) = %v, want %v", replica.Name, got, want)
+		}
+	}
+	writes := len(server.Writes())
+	if err := manager.Reconcile(ctx, replicas); err != nil {
+		t.Fatalf("Reconcile() again error = %v", err)
+	}
+	if got := server.Writes()[writes:]; len(got) != 0 {
+		t.Errorf("Reconcile() after the syncs wrote %+v, want nothing", got)
+	}
+
+	// Removing a replica removes its own server only
+	if err := manager.RemoveContainer(ctx, replicas[1]); err != nil {
+		t.Fatalf("RemoveContainer(%s) error = %v", replicas[1].Name, err)
+	}
+	if got := serverNames(); len(got) != 1 || got["app"] != "172.17.0.2" {
+		t.Errorf("servers after RemoveContainer(%s) = %v, want only app", replicas[1].Name, got)
+	}
+	if owner := server.Backends()[0].Owner(); owner.ContainerID != pfsense.ShortContainerID(replicas[0].ID) {
+		t.Errorf("backend owner after RemoveContainer(%s) = %+v, want only %s", replicas[1].Name, owner, replicas[0].Name)
+	}
+}
+
+func TestManager_SharedBackendSyncThenReconcile(t *testing.T) {
+	server, manager := newServerManager(t)
+	ctx := context.Background()
+
+	var replicas []*container.Info
+	for i, ip := range []string{"172.17.0.2", "172.17.0.3"} {
+		replica := webContainer(fmt.Sprintf("replica-%d", i+1), ip, "app.example.com")
+		replica.Labels["pfsense-controller.backend.name"] = "app"
+		replicas = append(replicas, replica)
+	}
+
+	// Containers are synced in event order, which differs from the order reconciling uses
+	for _, replica := range []*container.Info{replicas[1], replicas[0]} {
+		if err := manager.SyncContainer(ctx, replica); err != nil {
+			t.Fatalf("SyncContainer(%s) error = %v", replica.Name, err)
+		}
+	}
+
+	writes := len(server.Writes())
+	if err := manager.Reconcile(ctx, replicas); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if got := server.Writes()[writes:]; len(got) != 0 {
+		t.Errorf("Reconcile() after the syncs wrote %+v, want nothing", got)
+	}
+}
+
+func TestManager_ApplyChanges(t *testing.T) {
+	server, manager := newServerManager(t)
+	ctx := context.Background()
+
+	containers := []*container.Info{
+		webContainer("web", "172.17.0.2", "web.example.com"),
+		webContainer("api", "172.17.0.3", "api.example.com"),
+		webContainer("docs", "172.17.0.4", "docs.example.com"),
+	}
+	for _, c := range containers {
+		if err := manager.SyncContainer(ctx, c); err != nil {
+			t.Fatalf("SyncContainer(%s) error = %v", c.Name, err)
+		}
+	}
+	writes := len(server.Writes())
+
+	// Nothing is applied until asked, and then once for all containers
+	if server.Applies() != 0 {
+		t.Errorf("Applies() = %d before ApplyChanges(), want 0", server.Applies())
+	}
+	for range 2 {
+		if err := manager.ApplyChanges(ctx); err != nil {
+			t.Fatalf("ApplyChanges() error = %v", err)
+		}
+	}
+	if server.Applies() != 1 {
+		t.Errorf("Applies() = %d, want a single apply of the staged changes", server.Applies())
+	}
+
+	stats, err := manager.GetStats(ctx)
+	if err != nil {
+		t.Fatalf("GetStats() error = %v", err)
+	}
+	endpointStats := stats["test"].(map[string]interface{})
+	if endpointStats["applies"] != 1 || endpointStats["applied_changes"] != writes || endpointStats["pending_changes"] != 0 {
+		t.Errorf("GetStats() = %v, want 1 apply carrying %d changes and none pending", endpointStats, writes)
+	}
+
+	// A full sync applies changes staged by earlier events even when it has none of its own
+	if err := manager.RemoveContainer(ctx, containers[2]); err != nil {
+		t.Fatalf("RemoveContainer(docs) error = %v", err)
+	}
+	if err := manager.Reconcile(ctx, containers[:2]); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if server.Pending() || server.Applies() != 2 {
+		t.Errorf("Pending() = %v, Applies() = %d after Reconcile(), want the removal applied", server.Pending(), server.Applies())
+	}
+}
+
+func TestManager_FirstApplyAfterRestart(t *testing.T) {
+	server, manager := newServerManager(t)
+	ctx := context.Background()
+
+	web := webContainer("web", "172.17.0.2", "web.example.com")
+	if err := manager.SyncContainer(ctx, web); err != nil {
+		t.Fatalf("SyncContainer(web) error = %v", err)
+	}
+
+	// A restarted controller does not know about the changes staged before the restart, so
+	// its first sync applies even though it finds nothing to change
+	restarted := newManager(t, server)
+	for range 2 {
+		if err := restarted.Reconcile(ctx, []*container.Info{web}); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+	}
+	if server.Pending() || server.Applies() != 1 {
+		t.Errorf("Pending() = %v, Applies() = %d after restart, want a single apply", server.Pending(), server.Applies())
+	}
+}
+
+func TestManager_SyncContainerRetries(t *testing.T) {
+	server, manager := newServerManager(t)
+	ctx := context.Background()
+	web := webContainer("web", "172.17.0.2", "web.example.com")
+
+	// A transient failure is retried
+	server.AddFault(pfsensetest.Fault{
+		Method: http.MethodPost,
+		Path:   "/services/haproxy/backend",
+		Status: http.StatusServiceUnavailable,
+		Times:  1,
+	})
+	if err := manager.SyncContainer(ctx, web); err != nil {
+		t.Fatalf("SyncContainer() with a transient failure error = %v", err)
+	}
+	if err := manager.ApplyChanges(ctx); err != nil {
+		t.Fatalf("ApplyChanges() error = %v", err)
+	}
+	if len(server.Backends()) != 1 || server.Applies() != 1 {
+		t.Errorf("got %d backends and %d applies, want the sync to go through", len(server.Backends()), server.Applies())
+	}
+
+	// A lasting failure to apply leaves the changes staged for the next apply
+	server.AddFault(pfsensetest.Fault{
+		Method: http.MethodPost,
+		Path:   "/services/haproxy/apply",
+		Status: http.StatusInternalServerError,
+	})
+	api := webContainer("api", "172.17.0.3", "api.example.com")
+	if err := manager.SyncContainer(ctx, api); err != nil {
+		t.Fatalf("SyncContainer(api) error = %v", err)
+	}
+	if err := manager.ApplyChanges(ctx); err == nil {
+		t.Error("ApplyChanges() succeeded although applying failed")
+	}
+	if !server.Pending() {
+		t.Error("Pending() = false, want the changes left unapplied")
+	}
+
+	server.ClearFaults()
+	if err := manager.ApplyChanges(ctx); err != nil {
+		t.Fatalf("ApplyChanges() after the failure cleared error = %v", err)
+	}
+	if server.Pending() || server.Applies() != 2 {
+		t.Errorf("Pending() = %v, Applies() = %d, want the staged changes applied", server.Pending(), server.Applies())
+	}
+}
+
+func TestManager_SyncContainerCanceled(t *testing.T) {
+	server, manager := newServerManager(t)
+	manager.config.Global.RetryDelay.Duration = time.Hour
+
+	server.AddFault(pfsensetest.Fault{Status: http.StatusServiceUnavailable})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := manager.SyncContainer(ctx, webContainer("web", "172.17.0.2", "web.example.com")); err == nil {
+		t.Error("SyncContainer() succeeded against a failing pfSense")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("SyncContainer() took %v, want the retry delay cut short by the deadline", elapsed)
+	}
+}
+
+func TestManager_SyncContainerClientError(t *testing.T) {
+	server, manager := newServerManager(t)
+
+	server.AddFault(pfsensetest.Fault{
+		Method: http.MethodPost,
+		Path:   "/services/haproxy/backend",
+		Status: http.StatusUnauthorized,
+	})
+
+	err := manager.SyncContainer(context.Background(), webContainer("web", "172.17.0.2", "web.example.com"))
+	if !pfsense.IsAuth(err) {
+		t.Fatalf("SyncContainer() error = %v, want an authentication error", err)
+	}
+
+	creates := 0
+	for _, request := range server.Requests() {
+		if request.Method == http.MethodPost && request.Path == "/services/haproxy/backend" {
+			creates++
+		}
+	}
+	if creates != 1 {
+		t.Errorf("backend creation was attempted %d times, want client errors not retried", creates)
+	}
+}
@@ -0,0 +1,999 @@
+package haproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/container"
+	"github.com/KristijanL/pfsense-container-controller/internal/labels"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+)
+
+// Besides creating, updating and deleting objects, HAProxy changes order ACME certificates
+const (
+	// ChangeIssue starts issuing a new ACME certificate
+	ChangeIssue pfsense.ChangeType = "issue"
+	// ChangeRenew starts renewing an ACME certificate
+	ChangeRenew pfsense.ChangeType = "renew"
+)
+
+// ObjectKind describes the kind of HAProxy object a change applies to
+type ObjectKind string
+
+const (
+	// KindBackend is a HAProxy backend
+	KindBackend ObjectKind = "backend"
+	// KindFrontend is a HAProxy frontend
+	KindFrontend ObjectKind = "frontend"
+	// KindACL is an ACL of a HAProxy frontend
+	KindACL ObjectKind = "acl"
+	// KindAction is an action of a HAProxy frontend
+	KindAction ObjectKind = "action"
+	// KindAddress is a listen address of a HAProxy frontend
+	KindAddress ObjectKind = "address"
+	// KindCertificate is a certificate offered by a HAProxy frontend. Creating one adds it to
+	// the additional certificates, updating one makes it the default SSL offloading certificate.
+	KindCertificate ObjectKind = "certificate"
+	// KindImportedCertificate is a certificate imported into the pfSense certificate manager
+	KindImportedCertificate ObjectKind = "imported certificate"
+	// KindACMECertificate is a certificate of the pfSense ACME package
+	KindACMECertificate ObjectKind = "ACME certificate"
+)
+
+// Change is a single create, update or delete of a HAProxy object
+type Change struct {
+	Backend  *pfsense.HAProxyBackend         `json:"backend,omitempty"`
+	Frontend *pfsense.HAProxyFrontend        `json:"frontend,omitempty"`
+	ACL      *pfsense.HAProxyACL             `json:"acl,omitempty"`
+	Action   *pfsense.HAProxyAction          `json:"action,omitempty"`
+	Address  *pfsense.HAProxyFrontendAddress `json:"address,omitempty"`
+	ACME     *pfsense.ACMECertificate        `json:"acme_certificate,omitempty"`
+	Bundle   *pfsense.CertificateBundle      `json:"-"`
+	Type     pfsense.ChangeType              `json:"type"`
+	Kind     ObjectKind                      `json:"kind"`
+	Name     string                          `json:"name"`
+	Parent   string                          `json:"parent,omitempty"`
+	ID       int                             `json:"-"`
+	ParentID int                             `json:"-"`
+}
+
+// String names the object by its kind and name, and the frontend it belongs to if any
+func (c *Change) String() string {
+	if c.Parent != "" {
+		return fmt.Sprintf("%s %s %s on frontend %s", c.Type, c.Kind, c.Name, c.Parent)
+	}
+	return fmt.Sprintf("%s %s %s", c.Type, c.Kind, c.Name)
+}
+
+// changeID returns the pfSense ID of the object a change applies to
+func changeID(c *Change) int {
+	return c.ID
+}
+
+// Plan holds the HAProxy changes of an endpoint
+type Plan = pfsense.Plan[Change]
+
+// desiredBackend is a backend wanted on an endpoint, aggregated from all containers sharing it
+type desiredBackend struct {
+	backend *pfsense.HAProxyBackend
+	owner   pfsense.Owner
+	adopt   bool
+}
+
+// desiredFrontend is a frontend wanted on an endpoint, merged from all containers routing through it
+type desiredFrontend struct {
+	frontend *pfsense.HAProxyFrontend
+	// certificates are the refids or descriptions of the certificates the frontend offers
+	certificates []string
+	// imports are the fingerprints of the imported certificates the frontend offers
+	imports []string
+	adopt   bool
+}
+
+// desiredState is the HAProxy configuration wanted on a single endpoint
+type desiredState struct {
+	backends  map[string]*desiredBackend
+	frontends map[string]*desiredFrontend
+	// imports are the certificates to import into the certificate manager, by fingerprint
+	imports map[string]*pfsense.CertificateBundle
+	acme    map[string]*desiredACMECertificate
+}
+
+// Reconcile converges the HAProxy configuration of every endpoint to the desired state
+// derived from the given containers. The actual state is fetched once per endpoint,
+// objects owned by this controller that are no longer needed are deleted, and changes
+// are applied once per endpoint, together with any changes staged since the last apply.
+func (m *Manager) Reconcile(ctx context.Context, containers []*container.Info) error {
+	states := m.buildDesiredStates(containers)
+
+	var errs []error
+	for _, endpoint := range m.endpointNames() {
+		if err := m.reconcileEndpoint(ctx, endpoint, states[endpoint]); err != nil {
+			errs = append(errs, fmt.Errorf("endpoint %s: %w", endpoint, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Plan computes the changes Reconcile would make on every endpoint without executing them.
+// Only the actual state is read from pfSense, nothing is written or applied.
+func (m *Manager) Plan(ctx context.Context, containers []*container.Info) ([]*Plan, error) {
+	states := m.buildDesiredStates(containers)
+
+	var plans []*Plan
+	var errs []error
+	for _, endpoint := range m.endpointNames() {
+		plan, err := m.planEndpoint(ctx, endpoint, m.clients[endpoint], states[endpoint])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("endpoint %s: failed to plan changes: %w", endpoint, err))
+			continue
+		}
+		plans = append(plans, plan)
+	}
+
+	return plans, errors.Join(errs...)
+}
+
+// reconcileEndpoint plans and executes the changes for a single endpoint
+func (m *Manager) reconcileEndpoint(ctx context.Context, endpoint string, state *desiredState) error {
+	client := m.clients[endpoint]
+
+	plan, err := m.planEndpoint(ctx, endpoint, client, state)
+	if err != nil {
+		return fmt.Errorf("failed to plan changes: %w", err)
+	}
+
+	if len(plan.Changes) == 0 {
+		m.logger.Debugf("HAProxy configuration of endpoint %s is up to date", endpoint)
+		return m.applyChanges(ctx, endpoint)
+	}
+
+	if m.config.Global.DryRun {
+		m.logger.Infof("Dry run: endpoint %s needs %d changes", endpoint, len(plan.Changes))
+		for i := range plan.Changes {
+			m.logger.Infof("Dry run: endpoint %s: would %s", endpoint, &plan.Changes[i])
+		}
+		return nil
+	}
+
+	m.logger.Infof("Reconciling endpoint %s with %d changes", endpoint, len(plan.Changes))
+
+	// pfSense assigns the refid of imported certificates, so the frontends offering them are
+	// planned again once they are imported
+	if imports := certificateImports(plan); len(imports.Changes) > 0 {
+		if err := m.executePlan(ctx, client, imports); err != nil {
+			return err
+		}
+		if plan, err = m.planEndpoint(ctx, endpoint, client, state); err != nil {
+			return fmt.Errorf("failed to plan changes: %w", err)
+		}
+	}
+
+	if err := m.executePlan(ctx, client, plan); err != nil {
+		return err
+	}
+
+	return m.applyChanges(ctx, endpoint)
+}
+
+// buildDesiredStates builds the desired HAProxy state of every endpoint from all running containers
+func (m *Manager) buildDesiredStates(containers []*container.Info) map[string]*desiredState {
+	states := make(map[string]*desiredState)
+	for _, endpoint := range m.endpointNames() {
+		states[endpoint] = &desiredState{
+			backends:  make(map[string]*desiredBackend),
+			frontends: make(map[string]*desiredFrontend),
+			imports:   make(map[string]*pfsense.CertificateBundle),
+			acme:      make(map[string]*desiredACMECertificate),
+		}
+	}
+
+	// Process containers in a stable order so conflicts resolve the same way every cycle
+	sorted := make([]*container.Info, len(containers))
+	copy(sorted, containers)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	for _, containerInfo := range sorted {
+		if containerInfo.State != "running" {
+			continue
+		}
+
+		containerConfig, err := m.parser.ParseContainer(containerInfo)
+		if err != nil {
+			m.logger.Debugf("Container %s not eligible for HAProxy sync: %v", containerInfo.Name, err)
+			continue
+		}
+
+		endpoint := pfsense.ResolveEndpoint(m.config, m.clients, containerConfig.EndpointName, m.logger)
+		if endpoint == "" {
+			m.logger.Errorf("pfSense endpoint '%s' not found for container %s", containerConfig.EndpointName, containerInfo.Name)
+			continue
+		}
+
+		if err := m.addDesiredContainer(states[endpoint], containerInfo, containerConfig); err != nil {
+			m.logger.Errorf("Skipping container %s: %v", containerInfo.Name, err)
+		}
+	}
+
+	return states
+}
+
+// addDesiredContainer adds the backends and frontend routing of all routes of a container
+// to the desired state
+func (m *Manager) addDesiredContainer(
+	state *desiredState,
+	containerInfo *container.Info,
+	containerConfig *labels.ContainerConfig,
+) error {
+	owner := m.ownerFor(containerInfo, containerConfig)
+
+	// Routers of the same container may route to the same service, which is one server
+	seen := make(map[string]bool)
+	for i := range containerConfig.Routes {
+		if err := m.addDesiredRoute(state, containerConfig, &containerConfig.Routes[i], owner, seen); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addDesiredRoute adds the backend and frontend routing of a route to the desired state
+func (m *Manager) addDesiredRoute(
+	state *desiredState,
+	containerConfig *labels.ContainerConfig,
+	route *labels.RouteConfig,
+	owner pfsense.Owner,
+	seen map[string]bool,
+) error {
+	var bundle *pfsense.CertificateBundle
+	if route.FrontendConfig.TLS && route.FrontendConfig.TLSCertFile != "" {
+		var err error
+		if bundle, err = m.loadCertificateBundle(&route.FrontendConfig); err != nil {
+			return err
+		}
+	}
+
+	backend := m.parser.ConvertToHAProxyBackend(route)
+
+	frontend, err := m.parser.ConvertToHAProxyFrontend(route)
+	if err != nil {
+		return fmt.Errorf("failed to convert to HAProxy frontend: %w", err)
+	}
+
+	// An ACL name can only match one thing, the actions of a route whose ACL is taken by a
+	// different expression would route the other route's traffic
+	if conflict := conflictingACL(state.frontends[frontend.Name], frontend.HAACLs); conflict != nil {
+		m.logger.Warnf("ACL %s of the route to backend %s conflicts with another route on frontend %s, skipping",
+			conflict.Name, backend.Name, frontend.Name)
+		return nil
+	}
+
+	existing, exists := state.backends[backend.Name]
+	switch {
+	case seen[backend.Name]:
+		// Already added by another router of this container
+	case exists:
+		// Containers sharing a backend name become servers of the same backend
+		addDesiredServer(existing, backend.Servers[0], owner)
+		existing.adopt = existing.adopt || containerConfig.Adopt
+	default:
+		backend.SetOwner(owner)
+		state.backends[backend.Name] = &desiredBackend{backend: backend, owner: owner, adopt: containerConfig.Adopt}
+	}
+	seen[backend.Name] = true
+
+	desired, exists := state.frontends[frontend.Name]
+	if !exists {
+		desired = &desiredFrontend{
+			frontend: &pfsense.HAProxyFrontend{Name: frontend.Name},
+		}
+		desired.frontend.SetOwner(owner)
+		state.frontends[frontend.Name] = desired
+	}
+	desired.adopt = desired.adopt || containerConfig.Adopt
+	switch {
+	case bundle != nil:
+		state.imports[bundle.Fingerprint] = bundle
+		if !slices.Contains(desired.imports, bundle.Fingerprint) {
+			desired.imports = append(desired.imports, bundle.Fingerprint)
+		}
+	case route.FrontendConfig.TLS:
+		if route.FrontendConfig.ACMEAccount != "" {
+			addDesiredACMECertificate(state, &route.FrontendConfig, owner, containerConfig.Adopt)
+		}
+		if !slices.Contains(desired.certificates, route.FrontendConfig.TLSCertificate) {
+			desired.certificates = append(desired.certificates, route.FrontendConfig.TLSCertificate)
+		}
+	}
+
+	for _, acl := range frontend.HAACLs {
+		if findACL(desired.frontend.HAACLs, acl.Name) == nil {
+			desired.frontend.HAACLs = append(desired.frontend.HAACLs, acl)
+		}
+	}
+	for _, action := range frontend.ActionItems {
+		if !containsAction(desired.frontend.ActionItems, action) {
+			desired.frontend.ActionItems = append(desired.frontend.ActionItems, action)
+		}
+	}
+
+	return nil
+}
+
+// conflictingACL returns the first of the ACLs whose name the desired frontend already uses
+// for a different expression or value, or nil if there is none
+func conflictingACL(desired *desiredFrontend, acls []pfsense.HAProxyACL) *pfsense.HAProxyACL {
+	if desired == nil {
+		return nil
+	}
+	for i := range acls {
+		if other := findACL(desired.frontend.HAACLs, acls[i].Name); other != nil && !aclEqual(other, &acls[i]) {
+			return &acls[i]
+		}
+	}
+	return nil
+}
+
+// addDesiredServer adds the server of another container to a desired backend. The backend
+// settings of the first container are kept.
+func addDesiredServer(desired *desiredBackend, server pfsense.HAProxyBackendServer, owner pfsense.Owner) {
+	server.Name = serverName(desired.backend.Servers, server.Name, owner.ContainerID)
+	desired.backend.Servers = append(desired.backend.Servers, server)
+	desired.owner = desired.owner.Merge(owner)
+	desired.backend.SetOwner(desired.owner)
+}
+
+// serverName returns the name of a container's server in a backend already holding the
+// servers of other containers. Server names must be unique within a backend, so a name
+// taken by another container is suffixed with the container ID.
+func serverName(others []pfsense.HAProxyBackendServer, name, containerID string) string {
+	for _, server := range others {
+		if server.Name == name {
+			return name + "-" + containerID
+		}
+	}
+	return name
+}
+
+// findServer returns the index of a container's server in a backend, or -1 if there is
+// none. A server suffixed with the container ID is preferred over one with the plain name.
+func findServer(servers []pfsense.HAProxyBackendServer, name, containerID string) int {
+	plain := -1
+	for i := range servers {
+		switch servers[i].Name {
+		case name + "-" + containerID:
+			return i
+		case name:
+			plain = i
+		}
+	}
+	return plain
+}
+
+// planEndpoint fetches the actual state of an endpoint and computes the changes needed
+// to reach the desired state. Changes are ordered so that object IDs stay valid while
+// executing them: pfSense identifies objects by their index, so deletions run last and
+// from the highest ID down, and backends outlive the frontend routing that uses them.
+func (m *Manager) planEndpoint(ctx context.Context, endpoint string, client pfsense.API, state *desiredState) (*Plan, error) {
+	backends, err := client.GetHAProxyBackends(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backends: %w", err)
+	}
+
+	frontends, err := client.GetHAProxyFrontends(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get frontends: %w", err)
+	}
+
+	certificates, err := m.getCertificates(ctx, client, state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificates: %w", err)
+	}
+
+	acmeCertificates, err := m.getACMECertificates(ctx, client, state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ACME certificates: %w", err)
+	}
+
+	tls := m.planCertificates(state, certificates)
+	acmeWrites, acmeDeletes := m.planACMECertificates(state, acmeCertificates, true)
+	backendWrites, backendDeletes, routable := m.planBackends(state, backends)
+	frontendCreates, frontendItems, frontendDeletes := m.planFrontends(state, frontends, routable, tls)
+
+	plan := &Plan{Endpoint: endpoint}
+	plan.Changes = append(plan.Changes, tls.imports...)
+	plan.Changes = append(plan.Changes, acmeWrites...)
+	plan.Changes = append(plan.Changes, backendWrites...)
+	plan.Changes = append(plan.Changes, frontendCreates...)
+	plan.Changes = append(plan.Changes, frontendItems...)
+	plan.Changes = append(plan.Changes, frontendDeletes...)
+	plan.Changes = append(plan.Changes, backendDeletes...)
+	plan.Changes = append(plan.Changes, tls.deletes()...)
+	plan.Changes = append(plan.Changes, acmeDeletes...)
+
+	return plan, nil
+}
+
+// planBackends computes backend creates, updates and deletes. It also returns the set of
+// backends whose frontend routing is owned by this controller: desired backends it may
+// write to, and existing backends it owns.
+func (m *Manager) planBackends(
+	state *desiredState,
+	actual []pfsense.HAProxyBackend,
+) (writes, deletes []Change, routable map[string]bool) {
+	routable = make(map[string]bool)
+
+	actualByName := make(map[string]*pfsense.HAProxyBackend, len(actual))
+	for i := range actual {
+		actualByName[actual[i].Name] = &actual[i]
+	}
+
+	for _, name := range pfsense.SortedKeys(state.backends) {
+		desired := state.backends[name]
+		existing := actualByName[name]
+
+		if existing == nil {
+			writes = append(writes, Change{Type: pfsense.ChangeCreate, Kind: KindBackend, Name: name, Backend: desired.backend})
+			routable[name] = true
+			continue
+		}
+
+		if !pfsense.CanModify(&m.config.Global, existing.Owner(), desired.adopt) {
+			m.logger.Warnf("Not syncing backend %s: %v", name, ErrNotOwned)
+			continue
+		}
+		routable[name] = true
+
+		if backendChanged(existing, desired.backend) {
+			updated := *desired.backend
+			updated.ID = existing.ID
+			writes = append(writes, Change{Type: pfsense.ChangeUpdate, Kind: KindBackend, Name: name, ID: existing.ID, Backend: &updated})
+		}
+	}
+
+	for i := len(actual) - 1; i >= 0; i-- {
+		existing := &actual[i]
+		if !existing.Owner().IsOwnedBy(m.config.Global.InstanceID) {
+			continue
+		}
+		routable[existing.Name] = true
+
+		if _, desired := state.backends[existing.Name]; !desired {
+			deletes = append(deletes, Change{Type: pfsense.ChangeDelete, Kind: KindBackend, Name: existing.Name, ID: existing.ID})
+		}
+	}
+	pfsense.SortByIDDescending(deletes, changeID)
+
+	return writes, deletes, routable
+}
+
+// planFrontends computes frontend creates and deletes, and the ACL, action and TLS changes of
+// existing frontends. Only routing to backends in the routable set is touched.
+func (m *Manager) planFrontends(
+	state *desiredState,
+	actual []pfsense.HAProxyFrontend,
+	routable map[string]bool,
+	tls *certificatePlan,
+) (creates, items, deletes []Change) {
+	actualByName := make(map[string]*pfsense.HAProxyFrontend, len(actual))
+	names := pfsense.SortedKeys(state.frontends)
+	for i := range actual {
+		actualByName[actual[i].Name] = &actual[i]
+		if _, desired := state.frontends[actual[i].Name]; !desired {
+			names = append(names, actual[i].Name)
+		}
+	}
+
+	for _, name := range names {
+		// Frontends no container asks for are only deleted when this controller owns them
+		desired := &pfsense.HAProxyFrontend{Name: name}
+		adopt := false
+		var refIDs []string
+		if d, exists := state.frontends[name]; exists {
+			desired = routableFrontend(d.frontend, routable)
+			adopt = d.adopt || m.config.Global.AdoptUnowned
+			refIDs = tls.frontendRefIDs(d)
+		}
+
+		existing := actualByName[name]
+		if existing == nil {
+			if len(desired.ActionItems) > 0 {
+				if len(refIDs) > 0 {
+					m.logger.Warnf("Frontend %s is created without listen addresses, add one to enable TLS", name)
+				}
+				setFrontendCertificates(desired, refIDs)
+				recordOwnedItems(desired, m.config.Global.InstanceID)
+				creates = append(creates, Change{Type: pfsense.ChangeCreate, Kind: KindFrontend, Name: name, Frontend: desired})
+			}
+			continue
+		}
+
+		changes, record, remaining := planFrontendItems(existing, desired, m.config.Global.InstanceID, routable, true)
+		items = append(items, changes...)
+
+		owned := existing.Owner().IsOwnedBy(m.config.Global.InstanceID) || adopt
+		if owned && remaining == 0 {
+			deletes = append(deletes, Change{Type: pfsense.ChangeDelete, Kind: KindFrontend, Name: name, ID: existing.ID})
+			continue
+		}
+		if record != nil {
+			items = append(items, *record)
+		}
+
+		if len(refIDs) > 0 && len(existing.Addresses) == 0 {
+			m.logger.Warnf("Frontend %s has no listen addresses, add one to enable TLS", name)
+		}
+		items = append(items, planFrontendTLS(existing, refIDs, tls.stale, tls.inUse)...)
+	}
+	pfsense.SortByIDDescending(deletes, changeID)
+
+	return creates, items, deletes
+}
+
+// frontendItemPlan collects the ACL and action changes of a single existing frontend.
+// ACLs and actions are owned through the record kept in the frontend description. On
+// frontends without a record, routing to backends in the routable set belongs to this
+// controller. Everything else is left alone.
+type frontendItemPlan struct {
+	existing      *pfsense.HAProxyFrontend
+	desired       *pfsense.HAProxyFrontend
+	routable      map[string]bool
+	recorded      bool
+	ownedActions  map[string]bool
+	ownedACLs     map[string]bool
+	foreignACLs   map[string]bool
+	keptActions   []string
+	keptACLs      []string
+	patches       []Change
+	actionDeletes []Change
+	aclDeletes    []Change
+	aclCreates    []Change
+	actionCreates []Change
+	remaining     int
+	prune         bool
+}
+
+// planFrontendItems computes the ACL and action changes that make an existing frontend contain
+// each desired ACL and action exactly once, and returns the number of ACLs and actions left
+// once they are executed. Identical entries are left alone, owned entries whose expression or
+// backend changed are patched, and duplicates are deleted. With prune set, owned entries that
+// are not desired are deleted as well. The returned record change, if any, updates the record
+// of owned entries once the other changes are executed.
+func planFrontendItems(
+	existing, desired *pfsense.HAProxyFrontend,
+	instanceID string,
+	routable map[string]bool,
+	prune bool,
+) (changes []Change, record *Change, remaining int) {
+	ownedACLs, ownedActions, recorded := existing.OwnedItems(instanceID)
+	p := &frontendItemPlan{
+		existing:     existing,
+		desired:      desired,
+		routable:     routable,
+		recorded:     recorded,
+		ownedActions: ownedActions,
+		ownedACLs:    ownedACLs,
+		foreignACLs:  make(map[string]bool),
+		prune:        prune,
+	}
+
+	p.planActions()
+	p.planACLs()
+
+	// pfSense identifies ACLs and actions by their index within the frontend, so patches run
+	// before any deletion and deletions run from the highest ID down
+	pfsense.SortByIDDescending(p.actionDeletes, changeID)
+	pfsense.SortByIDDescending(p.aclDeletes, changeID)
+
+	changes = append(changes, p.patches...)
+	changes = append(changes, p.actionDeletes...)
+	changes = append(changes, p.aclDeletes...)
+	changes = append(changes, p.aclCreates...)
+	changes = append(changes, p.actionCreates...)
+
+	return changes, p.recordChange(instanceID, ownedACLs, ownedActions), p.remaining
+}
+
+// owns reports whether the action belongs to this controller
+func (p *frontendItemPlan) owns(action *pfsense.HAProxyAction) bool {
+	if p.recorded {
+		return p.ownedActions[action.ItemKey()]
+	}
+	return p.routable[action.Backend]
+}
+
+// recordChange returns the change that records the ACLs and actions this controller owns
+// once the plan is executed, or nil if the record is unchanged
+func (p *frontendItemPlan) recordChange(instanceID string, ownedACLs, ownedActions map[string]bool) *Change {
+	if !p.recorded && len(p.keptACLs) == 0 && len(p.keptActions) == 0 {
+		return nil
+	}
+	if p.recorded && sameItems(ownedACLs, p.keptACLs) && sameItems(ownedActions, p.keptActions) {
+		return nil
+	}
+
+	frontend := *p.existing
+	frontend.SetOwnedItems(instanceID, p.keptACLs, p.keptActions)
+	return &Change{Type: pfsense.ChangeUpdate, Kind: KindFrontend, Name: frontend.Name, ID: frontend.ID, Frontend: &frontend}
+}
+
+// sameItems reports whether the set contains exactly the given items
+func sameItems(set map[string]bool, items []string) bool {
+	kept := make(map[string]bool, len(items))
+	for _, item := range items {
+		kept[item] = true
+	}
+	return maps.Equal(set, kept)
+}
+
+// recordOwnedItems records every ACL and action of a frontend about to be created as owned
+// by the controller instance
+func recordOwnedItems(frontend *pfsense.HAProxyFrontend, instanceID string) {
+	acls := make([]string, 0, len(frontend.HAACLs))
+	for _, acl := range frontend.HAACLs {
+		acls = append(acls, acl.Name)
+	}
+	actions := make([]string, 0, len(frontend.ActionItems))
+	for i := range frontend.ActionItems {
+		actions = append(actions, frontend.ActionItems[i].ItemKey())
+	}
+	frontend.SetOwnedItems(instanceID, acls, actions)
+}
+
+// planActions plans the action changes of the frontend
+func (p *frontendItemPlan) planActions() {
+	actions := p.existing.ActionItems
+	matched := make([]bool, len(actions))
+	present := make(map[string]bool)
+
+	// Keep one exact copy of every desired action. A desired action that is not recorded as
+	// owned, such as one created before its record was written, is claimed.
+	owned := make([]bool, len(actions))
+	for i := range actions {
+		action := &actions[i]
+		key := actionKey(*action)
+		wanted := containsAction(p.desired.ActionItems, *action) && !present[key]
+		owned[i] = p.owns(action) || wanted
+		if !owned[i] {
+			for _, name := range action.ACLNames() {
+				p.foreignACLs[name] = true
+			}
+			matched[i] = true
+			p.remaining++
+			continue
+		}
+		for _, name := range action.ACLNames() {
+			p.ownedACLs[name] = true
+		}
+
+		if wanted {
+			present[key] = true
+			matched[i] = true
+			p.keptActions = append(p.keptActions, action.ItemKey())
+			p.remaining++
+		}
+	}
+
+	// Patch owned actions whose ACL is still wanted with another backend, delete duplicates
+	for i, action := range actions {
+		if matched[i] {
+			continue
+		}
+
+		target := unmatchedAction(p.desired.ActionItems, action.ACL, present)
+		switch {
+		case target != nil && !present[actionKey(action)]:
+			patched := *target
+			patched.ID = action.ID
+			p.patches = append(p.patches, newActionChange(pfsense.ChangeUpdate, p.existing, patched))
+			present[actionKey(patched)] = true
+			p.keptActions = append(p.keptActions, patched.ItemKey())
+			p.remaining++
+		case present[actionKey(action)] || p.prune:
+			p.actionDeletes = append(p.actionDeletes, newActionChange(pfsense.ChangeDelete, p.existing, action))
+		default:
+			p.keptActions = append(p.keptActions, action.ItemKey())
+			p.remaining++
+		}
+	}
+
+	for _, action := range p.desired.ActionItems {
+		if !present[actionKey(action)] {
+			p.actionCreates = append(p.actionCreates, newActionChange(pfsense.ChangeCreate, p.existing, action))
+			p.keptActions = append(p.keptActions, action.ItemKey())
+			p.remaining++
+		}
+	}
+}
+
+// planACLs plans the ACL changes of the frontend. It must run after planActions, which
+// determines the ACLs used by owned and foreign routing.
+func (p *frontendItemPlan) planACLs() {
+	acls := p.existing.HAACLs
+	matched := make([]bool, len(acls))
+	seen := make(map[string]bool)
+
+	// Keep one exact copy of every desired ACL, and every ACL this controller does not own
+	for i := range acls {
+		acl := &acls[i]
+		want := findACL(p.desired.HAACLs, acl.Name)
+
+		if p.foreignACLs[acl.Name] || (want == nil && !p.ownedACLs[acl.Name]) {
+			seen[acl.Name] = seen[acl.Name] || want != nil
+			matched[i] = true
+			if p.ownedACLs[acl.Name] {
+				p.keptACLs = append(p.keptACLs, acl.Name)
+			}
+			p.remaining++
+			continue
+		}
+		if want != nil && !seen[acl.Name] && aclEqual(acl, want) {
+			seen[acl.Name] = true
+			matched[i] = true
+			p.keptACLs = append(p.keptACLs, acl.Name)
+			p.remaining++
+		}
+	}
+
+	// Patch owned ACLs whose expression changed, delete duplicates
+	for i, acl := range acls {
+		if matched[i] {
+			continue
+		}
+
+		want := findACL(p.desired.HAACLs, acl.Name)
+		switch {
+		case want != nil && !seen[acl.Name]:
+			patched := *want
+			patched.ID = acl.ID
+			p.patches = append(p.patches, newACLChange(pfsense.ChangeUpdate, p.existing, patched))
+			seen[acl.Name] = true
+			p.keptACLs = append(p.keptACLs, acl.Name)
+			p.remaining++
+		case want != nil || p.prune:
+			p.aclDeletes = append(p.aclDeletes, newACLChange(pfsense.ChangeDelete, p.existing, acl))
+		default:
+			p.keptACLs = append(p.keptACLs, acl.Name)
+			p.remaining++
+		}
+	}
+
+	for _, acl := range p.desired.HAACLs {
+		if !seen[acl.Name] {
+			p.aclCreates = append(p.aclCreates, newACLChange(pfsense.ChangeCreate, p.existing, acl))
+			p.keptACLs = append(p.keptACLs, acl.Name)
+			p.remaining++
+		}
+	}
+}
+
+// unmatchedAction returns the desired action using the given ACL that is not present yet
+func unmatchedAction(actions []pfsense.HAProxyAction, aclName string, present map[string]bool) *pfsense.HAProxyAction {
+	for i := range actions {
+		if actions[i].ACL == aclName && !present[actionKey(actions[i])] {
+			return &actions[i]
+		}
+	}
+	return nil
+}
+
+// executePlan executes the changes of a plan in order, stopping at the first failure
+func (m *Manager) executePlan(ctx context.Context, client pfsense.API, plan *Plan) error {
+	return plan.Execute(ctx, &m.config.Global, m.logger, func(change *Change) error {
+		return executeChange(ctx, client, change)
+	})
+}
+
+// executeChange calls the client operation matching the kind and type of a HAProxy or
+// certificate change
+func executeChange(ctx context.Context, client pfsense.API, change *Change) error {
+	switch change.Kind {
+	case KindBackend:
+		switch change.Type {
+		case pfsense.ChangeCreate:
+			return client.CreateHAProxyBackend(ctx, change.Backend)
+		case pfsense.ChangeUpdate:
+			return client.UpdateHAProxyBackend(ctx, change.Backend)
+		case pfsense.ChangeDelete:
+			return client.DeleteHAProxyBackend(ctx, change.ID)
+		}
+
+	case KindFrontend:
+		switch change.Type {
+		case pfsense.ChangeCreate:
+			return client.CreateHAProxyFrontend(ctx, change.Frontend)
+		case pfsense.ChangeUpdate:
+			return client.SetFrontendDescription(ctx, change.ID, change.Frontend.Description)
+		case pfsense.ChangeDelete:
+			return client.DeleteHAProxyFrontend(ctx, change.ID)
+		}
+
+	case KindACL:
+		switch change.Type {
+		case pfsense.ChangeCreate:
+			return client.AddACLToFrontend(ctx, change.ParentID, *change.ACL)
+		case pfsense.ChangeUpdate:
+			return client.UpdateFrontendACL(ctx, change.ParentID, *change.ACL)
+		case pfsense.ChangeDelete:
+			return client.DeleteACLFromFrontend(ctx, change.ParentID, change.ID)
+		}
+
+	case KindAction:
+		switch change.Type {
+		case pfsense.ChangeCreate:
+			return client.AddActionToFrontend(ctx, change.ParentID, *change.Action)
+		case pfsense.ChangeUpdate:
+			return client.UpdateFrontendAction(ctx, change.ParentID, *change.Action)
+		case pfsense.ChangeDelete:
+			return client.DeleteActionFromFrontend(ctx, change.ParentID, change.ID)
+		}
+
+	case KindAddress:
+		if change.Type == pfsense.ChangeUpdate {
+			return client.UpdateFrontendAddress(ctx, change.ParentID, *change.Address)
+		}
+
+	case KindCertificate:
+		switch change.Type {
+		case pfsense.ChangeCreate:
+			return client.AddCertificateToFrontend(ctx, change.ParentID, change.Name)
+		case pfsense.ChangeUpdate:
+			return client.SetFrontendSSLOffloadCertificate(ctx, change.ParentID, change.Name)
+		case pfsense.ChangeDelete:
+			return client.DeleteCertificateFromFrontend(ctx, change.ParentID, change.ID)
+		}
+
+	case KindACMECertificate:
+		switch change.Type {
+		case pfsense.ChangeCreate:
+			return client.CreateACMECertificate(ctx, change.ACME)
+		case pfsense.ChangeUpdate:
+			return client.UpdateACMECertificate(ctx, change.ACME)
+		case pfsense.ChangeDelete:
+			return client.DeleteACMECertificate(ctx, change.ID)
+		case ChangeIssue:
+			return client.IssueACMECertificate(ctx, change.Name)
+		case ChangeRenew:
+			return client.RenewACMECertificate(ctx, change.Name)
+		}
+
+	case KindImportedCertificate:
+		switch change.Type {
+		case pfsense.ChangeCreate:
+			_, err := client.ImportCertificate(ctx, change.Name, change.Bundle)
+			return err
+		case pfsense.ChangeDelete:
+			return client.DeleteCertificate(ctx, change.ID)
+		}
+	}
+
+	return fmt.Errorf("unsupported change: %s", change)
+}
+
+// routableFrontend returns a copy of the frontend limited to actions routing to backends in
+// the routable set, and the ACLs those actions use
+func routableFrontend(frontend *pfsense.HAProxyFrontend, routable map[string]bool) *pfsense.HAProxyFrontend {
+	filtered := &pfsense.HAProxyFrontend{
+		Name:        frontend.Name,
+		Description: frontend.Description,
+	}
+
+	usedACLs := make(map[string]bool)
+	for _, action := range frontend.ActionItems {
+		if routable[action.Backend] {
+			filtered.ActionItems = append(filtered.ActionItems, action)
+			for _, name := range action.ACLNames() {
+				usedACLs[name] = true
+			}
+		}
+	}
+	for _, acl := range frontend.HAACLs {
+		if usedACLs[acl.Name] {
+			filtered.HAACLs = append(filtered.HAACLs, acl)
+		}
+	}
+
+	return filtered
+}
+
+// newACLChange creates a change for an ACL of an existing frontend
+func newACLChange(changeType pfsense.ChangeType, frontend *pfsense.HAProxyFrontend, acl pfsense.HAProxyACL) Change {
+	return Change{
+		Type:     changeType,
+		Kind:     KindACL,
+		Name:     acl.Name,
+		Parent:   frontend.Name,
+		ParentID: frontend.ID,
+		ID:       acl.ID,
+		ACL:      &acl,
+	}
+}
+
+// newActionChange creates a change for an action of an existing frontend
+func newActionChange(changeType pfsense.ChangeType, frontend *pfsense.HAProxyFrontend, action pfsense.HAProxyAction) Change {
+	return Change{
+		Type:     changeType,
+		Kind:     KindAction,
+		Name:     fmt.Sprintf("%s %s if %s", action.Action, action.Backend, action.ACL),
+		Parent:   frontend.Name,
+		ParentID: frontend.ID,
+		ID:       action.ID,
+		Action:   &action,
+	}
+}
+
+// backendChanged reports whether an existing backend differs from the desired one. Servers
+// are compared by name, as syncs add the servers of shared backends in event order.
+func backendChanged(existing, desired *pfsense.HAProxyBackend) bool {
+	if !checkTypeEqual(existing.CheckType, desired.CheckType) ||
+		existing.MonitorURI != desired.MonitorURI ||
+		existing.MonitorHTTPVersion != desired.MonitorHTTPVersion ||
+		existing.AdvancedBackend != desired.AdvancedBackend {
+		return true
+	}
+
+	if len(existing.Servers) != len(desired.Servers) {
+		return true
+	}
+	for i := range desired.Servers {
+		index := slices.IndexFunc(existing.Servers, func(s pfsense.HAProxyBackendServer) bool {
+			return s.Name == desired.Servers[i].Name
+		})
+		if index < 0 ||
+			existing.Servers[index].Address != desired.Servers[i].Address ||
+			existing.Servers[index].Port != desired.Servers[i].Port {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkTypeEqual compares health check types, treating an empty check type as "none"
+func checkTypeEqual(a, b string) bool {
+	if a == "" {
+		a = labels.NoneValue
+	}
+	if b == "" {
+		b = labels.NoneValue
+	}
+	return strings.EqualFold(a, b)
+}
+
+// aclEqual reports whether two ACLs match the same traffic
+func aclEqual(a, b *pfsense.HAProxyACL) bool {
+	return a.Expression == b.Expression && a.Value == b.Value
+}
+
+// findACL returns the ACL with the given name, or nil if there is none
+func findACL(acls []pfsense.HAProxyACL, name string) *pfsense.HAProxyACL {
+	for i := range acls {
+		if acls[i].Name == name {
+			return &acls[i]
+		}
+	}
+	return nil
+}
+
+// containsAction reports whether the actions contain an action with the same ACL and backend
+func containsAction(actions []pfsense.HAProxyAction, action pfsense.HAProxyAction) bool {
+	key := actionKey(action)
+	for _, existing := range actions {
+		if actionKey(existing) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// actionKey identifies an action by what it does, ignoring its ID
+func actionKey(action pfsense.HAProxyAction) string {
+	return action.Action + "|" + action.ACL + "|" + action.Backend
+}
@@ -0,0 +1,422 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package haproxy
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/config"
+	"github.com/KristijanL/pfsense-container-controller/internal/labels"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+	"github.com/sirupsen/logrus"
+)
+
+func TestPlanFrontendItems(t *testing.T) {
+	desired := &pfsense.HAProxyFrontend{
+		Name: "shared",
+		HAACLs: []pfsense.HAProxyACL{
+			{Name: "web-acl", Expression: "host_matches", Value: "web.example.com"},
+		},
+		ActionItems: []pfsense.HAProxyAction{
+			{Action: "use_backend", ACL: "web-acl", Backend: "web-backend"},
+		},
+	}
+	routable := map[string]bool{"web-backend": true, "old-backend": true}
+	adminAction := pfsense.HAProxyAction{Action: "use_backend", ACL: "admin-acl", Backend: "admin-backend", ID: 0}
+	recorded := func(frontend *pfsense.HAProxyFrontend, acls []string, actions ...pfsense.HAProxyAction) *pfsense.HAProxyFrontend {
+		keys := make([]string, 0, len(actions))
+		for i := range actions {
+			keys = append(keys, actions[i].ItemKey())
+		}
+		frontend.SetOwnedItems("test", acls, keys)
+		return frontend
+	}
+
+	tests := []struct {
+		existing      *pfsense.HAProxyFrontend
+		want          map[pfsense.ChangeType]int
+		name          string
+		wantACLs      []string
+		prune         bool
+		wantRemaining int
+	}{
+		{
+			name: "identical entries are left alone",
+			existing: &pfsense.HAProxyFrontend{
+				HAACLs: []pfsense.HAProxyACL{
+					{Name: "admin-acl", Expression: "host_matches", Value: "admin.example.com", ID: 0},
+					{Name: "web-acl", Expression: "host_matches", Value: "web.example.com", ID: 1},
+				},
+				ActionItems: []pfsense.HAProxyAction{
+					{Action: "use_backend", ACL: "admin-acl", Backend: "admin-backend", ID: 0},
+					{Action: "use_backend", ACL: "web-acl", Backend: "web-backend", ID: 1},
+				},
+			},
+			want:          map[pfsense.ChangeType]int{},
+			wantACLs:      []string{"web-acl"},
+			wantRemaining: 4,
+		},
+		{
+			name:          "missing entries are added",
+			existing:      &pfsense.HAProxyFrontend{},
+			want:          map[pfsense.ChangeType]int{pfsense.ChangeCreate: 2},
+			wantACLs:      []string{"web-acl"},
+			wantRemaining: 2,
+		},
+		{
+			name: "duplicates are deleted",
+			existing: &pfsense.HAProxyFrontend{
+				HAACLs: []pfsense.HAProxyACL{
+					{Name: "web-acl", Expression: "host_matches", Value: "web.example.com", ID: 0},
+					{Name: "web-acl", Expression: "host_matches", Value: "web.example.com", ID: 1},
+					{Name: "web-acl", Expression: "host_matches", Value: "web.example.com", ID: 2},
+				},
+				ActionItems: []pfsense.HAProxyAction{
+					{Action: "use_backend", ACL: "web-acl", Backend: "web-backend", ID: 0},
+					{Action: "use_backend", ACL: "web-acl", Backend: "web-backend", ID: 1},
+				},
+			},
+			want:          map[pfsense.ChangeType]int{pfsense.ChangeDelete: 3},
+			wantACLs:      []string{"web-acl"},
+			wantRemaining: 2,
+		},
+		{
+			name: "changed expression and backend are patched",
+			existing: &pfsense.HAProxyFrontend{
+				HAACLs: []pfsense.HAProxyACL{
+					{Name: "web-acl", Expression: "host_matches", Value: "old.example.com", ID: 0},
+				},
+				ActionItems: []pfsense.HAProxyAction{
+					{Action: "use_backend", ACL: "web-acl", Backend: "old-backend", ID: 0},
+				},
+			},
+			want:          map[pfsense.ChangeType]int{pfsense.ChangeUpdate: 2},
+			wantACLs:      []string{"web-acl"},
+			wantRemaining: 2,
+		},
+		{
+			name: "owned entries that are not desired are kept without prune",
+			existing: &pfsense.HAProxyFrontend{
+				HAACLs: []pfsense.HAProxyACL{
+					{Name: "old-acl", Expression: "host_matches", Value: "old.example.com", ID: 0},
+				},
+				ActionItems: []pfsense.HAProxyAction{
+					{Action: "use_backend", ACL: "old-acl", Backend: "old-backend", ID: 0},
+				},
+			},
+			want:          map[pfsense.ChangeType]int{pfsense.ChangeCreate: 2},
+			wantACLs:      []string{"old-acl", "web-acl"},
+			wantRemaining: 4,
+		},
+		{
+			name: "owned entries that are not desired are deleted with prune",
+			existing: &pfsense.HAProxyFrontend{
+				HAACLs: []pfsense.HAProxyACL{
+					{Name: "old-acl", Expression: "host_matches", Value: "old.example.com", ID: 0},
+				},
+				ActionItems: []pfsense.HAProxyAction{
+					{Action: "use_backend", ACL: "old-acl", Backend: "old-backend", ID: 0},
+				},
+			},
+			prune:         true,
+			want:          map[pfsense.ChangeType]int{pfsense.ChangeCreate: 2, pfsense.ChangeDelete: 2},
+			wantACLs:      []string{"web-acl"},
+			wantRemaining: 2,
+		},
+		{
+			name: "recorded entries are owned whatever backend they route to",
+			existing: recorded(&pfsense.HAProxyFrontend{
+				HAACLs: []pfsense.HAProxyACL{
+					{Name: "admin-acl", Expression: "host_matches", Value: "admin.example.com", ID: 0},
+				},
+				ActionItems: []pfsense.HAProxyAction{adminAction},
+			}, []string{"admin-acl"}, adminAction),
+			prune:         true,
+			want:          map[pfsense.ChangeType]int{pfsense.ChangeCreate: 2, pfsense.ChangeDelete: 2},
+			wantACLs:      []string{"web-acl"},
+			wantRemaining: 2,
+		},
+		{
+			name: "unrecorded entries are left alone on frontends with a record",
+			existing: recorded(&pfsense.HAProxyFrontend{
+				HAACLs: []pfsense.HAProxyACL{
+					{Name: "old-acl", Expression: "host_matches", Value: "old.example.com", ID: 0},
+				},
+				ActionItems: []pfsense.HAProxyAction{
+					{Action: "use_backend", ACL: "old-acl", Backend: "old-backend", ID: 0},
+				},
+			}, nil),
+			prune:         true,
+			want:          map[pfsense.ChangeType]int{pfsense.ChangeCreate: 2},
+			wantACLs:      []string{"web-acl"},
+			wantRemaining: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, record, remaining := planFrontendItems(tt.existing, desired, "test", routable, tt.prune)
+
+			got := make(map[pfsense.ChangeType]int)
+			for _, change := range changes {
+				got[change.Type]++
+			}
+			for _, changeType := range []pfsense.ChangeType{pfsense.ChangeCreate, pfsense.ChangeUpdate, pfsense.ChangeDelete} {
+				if got[changeType] != tt.want[changeType] {
+					t.Errorf("planFrontendItems() %s changes = %d, want %d (%v)", changeType, got[changeType], tt.want[changeType], changes)
+				}
+			}
+			if remaining != tt.wantRemaining {
+				t.Errorf("planFrontendItems() remaining = %d, want %d", remaining, tt.wantRemaining)
+			}
+
+			owned := tt.existing
+			if record != nil {
+				owned = record.Frontend
+			}
+			acls, _, _ := owned.OwnedItems("test")
+			if got := pfsense.SortedKeys(acls); !slices.Equal(got, tt.wantACLs) {
+				t.Errorf("planFrontendItems() recorded ACLs = %v, want %v", got, tt.wantACLs)
+			}
+
+			// Deletions must run from the highest ID down to keep the remaining IDs valid
+			lastID := map[ObjectKind]int{KindACL: 1 << 30, KindAction: 1 << 30}
+			for _, change := range changes {
+				if change.Type != pfsense.ChangeDelete {
+					continue
+				}
+				if change.ID > lastID[change.Kind] {
+					t.Errorf("planFrontendItems() deletes %s ID %d after ID %d", change.Kind, change.ID, lastID[change.Kind])
+				}
+				lastID[change.Kind] = change.ID
+			}
+		})
+	}
+}
+
+func TestPlanFrontendTLS(t *testing.T) {
+	stale := map[string]bool{"old": true}
+
+	tests := []struct {
+		existing  *pfsense.HAProxyFrontend
+		name      string
+		refIDs    []string
+		want      []string
+		wantInUse bool
+	}{
+		{
+			name: "configured frontend is left alone",
+			existing: &pfsense.HAProxyFrontend{
+				SSLOffloadCert: "default",
+				Addresses:      []pfsense.HAProxyFrontendAddress{{Address: "wan_ipv4", Port: "443", SSL: true}},
+				Certificates:   []pfsense.HAProxyFrontendCertificate{{Certificate: "app"}},
+			},
+			refIDs: []string{"default", "app"},
+		},
+		{
+			name: "SSL offloading is enabled on every address",
+			existing: &pfsense.HAProxyFrontend{
+				SSLOffloadCert: "app",
+				Addresses: []pfsense.HAProxyFrontendAddress{
+					{Address: "wan_ipv4", Port: "443", ID: 0},
+					{Address: "wan_ipv6", Port: "443", SSL: true, ID: 1},
+					{Address: "lan_ipv4", Port: "443", ID: 2},
+				},
+			},
+			refIDs: []string{"app"},
+			want:   []string{"update address wan_ipv4:443", "update address lan_ipv4:443"},
+		},
+		{
+			name:     "first certificate becomes the default",
+			existing: &pfsense.HAProxyFrontend{},
+			refIDs:   []string{"app", "api"},
+			want:     []string{"update certificate app", "create certificate api"},
+		},
+		{
+			name:     "certificates are added next to the default",
+			existing: &pfsense.HAProxyFrontend{SSLOffloadCert: "default"},
+			refIDs:   []string{"app"},
+			want:     []string{"create certificate app"},
+		},
+		{
+			name: "stale certificates are replaced",
+			existing: &pfsense.HAProxyFrontend{
+				SSLOffloadCert: "old",
+				Addresses:      []pfsense.HAProxyFrontendAddress{{Address: "wan_ipv4", Port: "443", SSL: true}},
+				Certificates: []pfsense.HAProxyFrontendCertificate{
+					{Certificate: "old", ID: 0},
+					{Certificate: "manual", ID: 1},
+					{Certificate: "old", ID: 2},
+				},
+			},
+			refIDs: []string{"new"},
+			want: []string{
+				"update certificate new",
+				"delete certificate old",
+				"delete certificate old",
+			},
+		},
+		{
+			name: "stale default falls back to another certificate",
+			existing: &pfsense.HAProxyFrontend{
+				SSLOffloadCert: "old",
+				Certificates:   []pfsense.HAProxyFrontendCertificate{{Certificate: "manual"}},
+			},
+			want: []string{"update certificate manual"},
+		},
+		{
+			name:      "stale default without replacement is kept",
+			existing:  &pfsense.HAProxyFrontend{SSLOffloadCert: "old"},
+			wantInUse: true,
+		},
+		{
+			name:     "frontends without TLS are left alone",
+			existing: &pfsense.HAProxyFrontend{Certificates: []pfsense.HAProxyFrontendCertificate{{Certificate: "manual"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inUse := make(map[string]bool)
+			changes := planFrontendTLS(tt.existing, tt.refIDs, stale, inUse)
+
+			got := make([]string, 0, len(changes))
+			for _, change := range changes {
+				got = append(got, fmt.Sprintf("%s %s %s", change.Type, change.Kind, change.Name))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("planFrontendTLS() = %v, want %v", got, tt.want)
+			}
+			if inUse["old"] != tt.wantInUse {
+				t.Errorf("planFrontendTLS() in use = %v, want %v", inUse["old"], tt.wantInUse)
+			}
+		})
+	}
+}
+
+func TestSetFrontendCertificates(t *testing.T) {
+	frontend := &pfsense.HAProxyFrontend{Name: "https"}
+	setFrontendCertificates(frontend, []string{"app", "api", "docs"})
+
+	var additional []string
+	for _, cert := range frontend.Certificates {
+		additional = append(additional, cert.Certificate)
+	}
+	if frontend.SSLOffloadCert != "app" || !slices.Equal(additional, []string{"api", "docs"}) {
+		t.Errorf("setFrontendCertificates() = default %q, additional %v, want default app and additional api, docs",
+			frontend.SSLOffloadCert, additional)
+	}
+
+	// Once created, the frontend offers everything planFrontendTLS asks for
+	if changes := planFrontendTLS(frontend, []string{"app", "api", "docs"}, nil, make(map[string]bool)); len(changes) != 0 {
+		t.Errorf("planFrontendTLS() after setFrontendCertificates() = %v, want no changes", changes)
+	}
+}
+
+func TestPlanCertificates(t *testing.T) {
+	m := &Manager{
+		config: &config.Config{Global: config.GlobalConfig{InstanceID: "host-a"}},
+		logger: logrus.WithField("component", "test"),
+	}
+
+	current := &pfsense.CertificateBundle{Name: "app", Fingerprint: "aaa"}
+	rotated := &pfsense.CertificateBundle{Name: "api", Fingerprint: "bbb"}
+	state := &desiredState{
+		frontends: map[string]*desiredFrontend{
+			"https": {imports: []string{"aaa", "bbb"}, certificates: []string{"wildcard", "missing"}},
+		},
+		imports: map[string]*pfsense.CertificateBundle{"aaa": current, "bbb": rotated},
+	}
+	certificates := []pfsense.Certificate{
+		{RefID: "r0", Description: "wildcard", ID: 0},
+		{RefID: "r1", Description: current.Description("host-a"), ID: 1},
+		{RefID: "r2", Description: "api pfsense-controller:instance=host-a;fingerprint=ccc", ID: 2},
+		{RefID: "r3", Description: "api pfsense-controller:instance=host-b;fingerprint=ddd", ID: 3},
+		{RefID: "r4", Description: "app pfsense-controller:instance=host-a;fingerprint=eee", ID: 4},
+	}
+
+	plan := m.planCertificates(state, certificates)
+
+	if got := plan.frontendRefIDs(state.frontends["https"]); !slices.Equal(got, []string{"r1", "r0"}) {
+		t.Errorf("frontendRefIDs() = %v, want [r1 r0]", got)
+	}
+	if len(plan.imports) != 1 || plan.imports[0].Bundle != rotated {
+		t.Errorf("planCertificates() imports = %v, want import of the rotated certificate", plan.imports)
+	}
+
+	// Stale certificates are deleted from the highest ID down, unless a frontend still needs them
+	plan.inUse["r2"] = true
+	deletes := plan.deletes()
+	if len(deletes) != 1 || deletes[0].ID != 4 {
+		t.Errorf("deletes() = %v, want deletion of certificate 4", deletes)
+	}
+}
+
+func TestPlanACMECertificates(t *testing.T) {
+	m := &Manager{
+		config: &config.Config{Global: config.GlobalConfig{InstanceID: "host-a"}},
+		logger: logrus.WithField("component", "test"),
+	}
+	owner := pfsense.Owner{InstanceID: "host-a", ContainerID: "abc"}
+	foreign := pfsense.Owner{InstanceID: "host-b"}
+
+	state := &desiredState{acme: make(map[string]*desiredACMECertificate)}
+	for _, name := range []string{"web", "shared", "manual"} {
+		addDesiredACMECertificate(state, &labels.FrontendConfig{
+			TLSCertificate: name,
+			ACMEAccount:    "letsencrypt",
+			ACMEMethod:     "standalone",
+			ACMEHosts:      []string{name + ".example.com"},
+		}, owner, false)
+	}
+
+	domain := func(name string) pfsense.ACMEDomain {
+		return pfsense.ACMEDomain{Status: "enable", Name: name, Method: "standalone"}
+	}
+	actual := []pfsense.ACMECertificate{
+		{Name: "shared", Account: "letsencrypt", Domains: []pfsense.ACMEDomain{domain("old.example.com")}, ID: 0},
+		{Name: "manual", Account: "letsencrypt", Domains: []pfsense.ACMEDomain{domain("other.example.com")}, ID: 1},
+		{Name: "gone", Account: "letsencrypt", ID: 2},
+		{Name: "foreign", Account: "letsencrypt", ID: 3},
+	}
+	actual[0].SetOwner(owner)
+	actual[2].SetOwner(owner)
+	actual[3].SetOwner(foreign)
+
+	writes, deletes := m.planACMECertificates(state, actual, true)
+
+	got := make([]string, 0, len(writes)+len(deletes))
+	for _, change := range append(writes, deletes...) {
+		got = append(got, fmt.Sprintf("%s %s", change.Type, change.Name))
+		if change.Type == pfsense.ChangeUpdate {
+			got[len(got)-1] += fmt.Sprintf(" %v", change.ACME.DomainNames())
+		}
+	}
+	want := []string{
+		"update manual [other.example.com manual.example.com]",
+		"renew manual",
+		"update shared [shared.example.com]",
+		"renew shared",
+		"create web",
+		"issue web",
+		"delete gone",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("planACMECertificates() = %v, want %v", got, want)
+	}
+
+	// Without prune owned certificates are only extended
+	writes, deletes = m.planACMECertificates(state, actual[:1], false)
+	if len(deletes) != 0 {
+		t.Errorf("planACMECertificates() without prune deletes %v", deletes)
+	}
+	for _, change := range writes {
+		if change.Type == pfsense.ChangeUpdate && !slices.Equal(change.ACME.DomainNames(), []string{"old.example.com", "shared.example.com"}) {
+			t.Errorf("planACMECertificates() without prune domains = %v", change.ACME.DomainNames())
+		}
+	}
+}
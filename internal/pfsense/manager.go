@@ -0,0 +1,40 @@
+package pfsense
+
+import (
+	"maps"
+	"slices"
+	"sort"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// ResolveEndpoint returns the name of the configured endpoint to use for the given endpoint
+// name, falling back to the default endpoint, or an empty string if there is none
+func ResolveEndpoint[T any](cfg *config.Config, clients map[string]T, endpointName string, logger *logrus.Entry) string {
+	if _, exists := clients[endpointName]; exists {
+		return endpointName
+	}
+
+	// Try default endpoint if specified endpoint not found
+	if defaultEndpoint := cfg.GetDefaultEndpoint(); defaultEndpoint != nil {
+		if _, exists := clients[defaultEndpoint.Name]; exists {
+			logger.Warnf("Endpoint '%s' not found, using default endpoint '%s'", endpointName, defaultEndpoint.Name)
+			return defaultEndpoint.Name
+		}
+	}
+
+	return ""
+}
+
+// SortByIDDescending sorts changes from the highest object ID down. pfSense identifies objects
+// by their index, so deleting in this order leaves the IDs of the objects still to be deleted
+// valid.
+func SortByIDDescending[T any](changes []T, id func(*T) int) {
+	sort.SliceStable(changes, func(i, j int) bool { return id(&changes[i]) > id(&changes[j]) })
+}
+
+// SortedKeys returns the keys of a map in sorted order
+func SortedKeys[T any](m map[string]T) []string {
+	return slices.Sorted(maps.Keys(m))
+}
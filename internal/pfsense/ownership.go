@@ -0,0 +1,302 @@
+package pfsense
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/config"
+)
+
+// ownerMarkerPrefix identifies the ownership marker in description and comment fields
+const ownerMarkerPrefix = "pfsense-controller:"
+
+// itemsMarkerPrefix identifies the record of the frontend ACLs and actions a controller
+// instance owns, kept in the frontend description
+const itemsMarkerPrefix = "pfsense-controller-items:"
+
+// Owner identifies the controller instance and container that own a pfSense object
+type Owner struct {
+	InstanceID  string
+	ContainerID string
+	LabelHash   string
+	// Fingerprint is the fingerprint of a certificate imported by the controller
+	Fingerprint string
+}
+
+// String returns the ownership marker written into pfSense objects
+func (o Owner) String() string {
+	marker := fmt.Sprintf("%sinstance=%s;container=%s;labels=%s", ownerMarkerPrefix, o.InstanceID, o.ContainerID, o.LabelHash)
+	if o.Fingerprint != "" {
+		marker += ";fingerprint=" + o.Fingerprint
+	}
+	return marker
+}
+
+// ParseOwner extracts an ownership marker from a description or comment text.
+// It returns nil if the text does not contain a marker.
+func ParseOwner(text string) *Owner {
+	index := strings.Index(text, ownerMarkerPrefix)
+	if index < 0 {
+		return nil
+	}
+
+	marker := text[index+len(ownerMarkerPrefix):]
+	if end := strings.IndexAny(marker, " \n"); end >= 0 {
+		marker = marker[:end]
+	}
+
+	owner := &Owner{}
+	for _, field := range strings.Split(marker, ";") {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "instance":
+			owner.InstanceID = value
+		case "container":
+			owner.ContainerID = value
+		case "labels":
+			owner.LabelHash = value
+		case "fingerprint":
+			owner.Fingerprint = value
+		}
+	}
+
+	if owner.InstanceID == "" {
+		return nil
+	}
+
+	return owner
+}
+
+// Merge returns an owner that also lists the containers of the other owner. Objects shared
+// by several containers, such as a backend serving multiple replicas, list all of them.
+// Containers are listed by ID, together with their label hashes, so that merging the same
+// containers in any order gives the same marker.
+func (o Owner) Merge(other Owner) Owner {
+	hashes := make(map[string]string)
+	var containers []string
+	for _, owner := range []Owner{o, other} {
+		ownerHashes := splitList(owner.LabelHash)
+		for i, containerID := range splitList(owner.ContainerID) {
+			if containsString(containers, containerID) {
+				continue
+			}
+			containers = append(containers, containerID)
+			if i < len(ownerHashes) {
+				hashes[containerID] = ownerHashes[i]
+			}
+		}
+	}
+	slices.Sort(containers)
+
+	var labelHashes []string
+	for _, containerID := range containers {
+		if hash, found := hashes[containerID]; found {
+			labelHashes = append(labelHashes, hash)
+		}
+	}
+
+	fingerprint := o.Fingerprint
+	if fingerprint == "" {
+		fingerprint = other.Fingerprint
+	}
+
+	return Owner{
+		InstanceID:  o.InstanceID,
+		ContainerID: strings.Join(containers, ","),
+		LabelHash:   strings.Join(labelHashes, ","),
+		Fingerprint: fingerprint,
+	}
+}
+
+// Remove returns an owner that no longer lists the container. An owner without containers
+// left means nothing uses the object anymore.
+func (o Owner) Remove(containerID string) Owner {
+	containers := splitList(o.ContainerID)
+	hashes := splitList(o.LabelHash)
+
+	var keptContainers, keptHashes []string
+	for i, id := range containers {
+		if id == containerID {
+			continue
+		}
+		keptContainers = append(keptContainers, id)
+		if i < len(hashes) {
+			keptHashes = append(keptHashes, hashes[i])
+		}
+	}
+
+	return Owner{
+		InstanceID:  o.InstanceID,
+		ContainerID: strings.Join(keptContainers, ","),
+		LabelHash:   strings.Join(keptHashes, ","),
+		Fingerprint: o.Fingerprint,
+	}
+}
+
+// splitList splits a comma separated marker value
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// containsString reports whether the slice contains the value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Owner returns the ownership marker stored in the backend's advanced configuration
+func (b *HAProxyBackend) Owner() *Owner {
+	advanced, err := base64.StdEncoding.DecodeString(b.AdvancedBackend)
+	if err != nil {
+		return nil
+	}
+	return ParseOwner(string(advanced))
+}
+
+// SetOwner stores an ownership marker as a comment in the backend's advanced configuration,
+// replacing any previous marker
+func (b *HAProxyBackend) SetOwner(owner Owner) {
+	advanced, err := base64.StdEncoding.DecodeString(b.AdvancedBackend)
+	if err != nil {
+		advanced = nil
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(advanced), "\n") {
+		if line == "" || strings.Contains(line, ownerMarkerPrefix) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "# "+owner.String())
+
+	b.AdvancedBackend = base64.StdEncoding.EncodeToString([]byte(strings.Join(lines, "\n")))
+}
+
+// Owner returns the ownership marker stored in the frontend's description
+func (f *HAProxyFrontend) Owner() *Owner {
+	return ParseOwner(f.Description)
+}
+
+// SetOwner stores an ownership marker in the frontend's description, replacing any previous
+// marker. The rest of the description, such as text added by hand and the records of the ACLs
+// and actions controller instances own, is kept.
+func (f *HAProxyFrontend) SetOwner(owner Owner) {
+	var text, records []string
+	for _, field := range strings.Fields(f.Description) {
+		switch {
+		case strings.HasPrefix(field, itemsMarkerPrefix):
+			records = append(records, field)
+		case !strings.HasPrefix(field, ownerMarkerPrefix):
+			text = append(text, field)
+		}
+	}
+	text = append(text, owner.String())
+	f.Description = strings.Join(append(text, records...), " ")
+}
+
+// OwnedItems returns the ACLs, by name, and the actions, by ItemKey, of the frontend that the
+// given controller instance recorded as its own. ACLs and actions have no description of
+// their own, so their ownership is recorded in the description of their frontend. As ACLs
+// are recorded by name only, an owned ACL stays owned when its expression or value is edited
+// by hand. It reports whether the instance keeps a record on the frontend at all, which
+// frontends configured before records were kept do not.
+func (f *HAProxyFrontend) OwnedItems(instanceID string) (acls, actions map[string]bool, recorded bool) {
+	acls = make(map[string]bool)
+	actions = make(map[string]bool)
+	for _, field := range strings.Fields(f.Description) {
+		marker, found := strings.CutPrefix(field, itemsMarkerPrefix)
+		if !found {
+			continue
+		}
+
+		instance := ""
+		var aclNames, actionKeys []string
+		for _, part := range strings.Split(marker, ";") {
+			key, value, _ := strings.Cut(part, "=")
+			switch key {
+			case "instance":
+				instance = value
+			case "acls":
+				aclNames = splitList(value)
+			case "actions":
+				actionKeys = splitList(value)
+			}
+		}
+		if instance != instanceID {
+			continue
+		}
+
+		recorded = true
+		for _, name := range aclNames {
+			acls[name] = true
+		}
+		for _, key := range actionKeys {
+			actions[key] = true
+		}
+	}
+	return acls, actions, recorded
+}
+
+// SetOwnedItems records the ACLs, by name, and the actions, by ItemKey, that the given
+// controller instance owns in the frontend's description, replacing its previous record.
+// The rest of the description is kept.
+func (f *HAProxyFrontend) SetOwnedItems(instanceID string, acls, actions []string) {
+	var fields []string
+	if f.Description != "" {
+		for _, field := range strings.Split(f.Description, " ") {
+			if !strings.HasPrefix(field, itemsMarkerPrefix+"instance="+instanceID+";") {
+				fields = append(fields, field)
+			}
+		}
+	}
+
+	acls = slices.Compact(slices.Sorted(slices.Values(acls)))
+	actions = slices.Compact(slices.Sorted(slices.Values(actions)))
+	fields = append(fields, fmt.Sprintf("%sinstance=%s;acls=%s;actions=%s",
+		itemsMarkerPrefix, instanceID, strings.Join(acls, ","), strings.Join(actions, ",")))
+	f.Description = strings.Join(fields, " ")
+}
+
+// ItemKey identifies an action in the record of owned frontend items. It is derived from
+// what the action does, as action IDs change when other actions are deleted.
+func (a *HAProxyAction) ItemKey() string {
+	sum := sha256.Sum256([]byte(a.Action + "|" + a.ACL + "|" + a.Backend))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// IsOwnedBy reports whether the owner belongs to the given controller instance
+func (o *Owner) IsOwnedBy(instanceID string) bool {
+	return o != nil && o.InstanceID == instanceID
+}
+
+// CanModify reports whether the controller may modify or delete an object with the given owner.
+// Objects owned by this controller instance are always modifiable, other objects only when adopted.
+func CanModify(global *config.GlobalConfig, owner *Owner, adopt bool) bool {
+	if owner.IsOwnedBy(global.InstanceID) {
+		return true
+	}
+	return adopt || global.AdoptUnowned
+}
+
+// ShortContainerID returns the short form of a container ID used in ownership markers
+func ShortContainerID(containerID string) string {
+	if len(containerID) > 12 {
+		return containerID[:12]
+	}
+	return containerID
+}
@@ -0,0 +1,195 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package pfsense
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/config"
+)
+
+func TestHAProxyBackend_SetOwner(t *testing.T) {
+	passThru := "http-request set-header Host 172.17.0.2"
+	backend := &HAProxyBackend{
+		Name:            "web-backend",
+		AdvancedBackend: base64.StdEncoding.EncodeToString([]byte(passThru)),
+	}
+
+	if backend.Owner() != nil {
+		t.Fatalf("Owner() = %v, want nil for backend without marker", backend.Owner())
+	}
+
+	backend.SetOwner(Owner{InstanceID: "host-a", ContainerID: "abc", LabelHash: "111"})
+	backend.SetOwner(Owner{InstanceID: "host-a", ContainerID: "def", LabelHash: "222"})
+
+	owner := backend.Owner()
+	if !owner.IsOwnedBy("host-a") {
+		t.Fatalf("Owner() = %v, want owned by host-a", owner)
+	}
+	if owner.ContainerID != "def" || owner.LabelHash != "222" {
+		t.Errorf("Owner() = %+v, want latest marker", owner)
+	}
+
+	advanced, err := base64.StdEncoding.DecodeString(backend.AdvancedBackend)
+	if err != nil {
+		t.Fatalf("failed to decode advanced backend: %v", err)
+	}
+	if !strings.HasPrefix(string(advanced), passThru+"\n") {
+		t.Errorf("advanced backend = %q, want pass-through preserved", advanced)
+	}
+	if strings.Count(string(advanced), ownerMarkerPrefix) != 1 {
+		t.Errorf("advanced backend = %q, want exactly one marker", advanced)
+	}
+}
+
+func TestParseOwner(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		instance string
+		wantNil  bool
+	}{
+		{
+			name:    "no marker",
+			text:    "Managed by hand",
+			wantNil: true,
+		},
+		{
+			name:     "marker in description",
+			text:     "Web frontend pfsense-controller:instance=host-a;container=abc;labels=111",
+			instance: "host-a",
+		},
+		{
+			name:    "marker without instance",
+			text:    "pfsense-controller:container=abc",
+			wantNil: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner := ParseOwner(tt.text)
+			if tt.wantNil {
+				if owner != nil {
+					t.Errorf("ParseOwner() = %+v, want nil", owner)
+				}
+				return
+			}
+			if !owner.IsOwnedBy(tt.instance) {
+				t.Errorf("ParseOwner() = %+v, want instance %s", owner, tt.instance)
+			}
+		})
+	}
+}
+
+func TestOwner_Remove(t *testing.T) {
+	owner := Owner{InstanceID: "host-a", ContainerID: "abc,def", LabelHash: "111,222"}
+
+	remaining := owner.Remove("abc")
+	if remaining.ContainerID != "def" || remaining.LabelHash != "222" || remaining.InstanceID != "host-a" {
+		t.Errorf("Remove(abc) = %+v, want container def with labels 222", remaining)
+	}
+
+	if remaining = remaining.Remove("def"); remaining.ContainerID != "" || remaining.LabelHash != "" {
+		t.Errorf("Remove(def) = %+v, want no containers left", remaining)
+	}
+
+	if remaining = owner.Remove("xyz"); remaining.ContainerID != owner.ContainerID {
+		t.Errorf("Remove(xyz) = %+v, want owner unchanged", remaining)
+	}
+}
+
+func TestOwner_MergeKeepsFingerprint(t *testing.T) {
+	owner := Owner{InstanceID: "host-a", ContainerID: "abc", LabelHash: "111", Fingerprint: "f00"}
+
+	merged := owner.Merge(Owner{InstanceID: "host-a", ContainerID: "def", LabelHash: "222"})
+	if merged.ContainerID != "abc,def" || merged.Fingerprint != "f00" {
+		t.Errorf("Merge() = %+v, want both containers and fingerprint f00", merged)
+	}
+
+	parsed := ParseOwner(merged.String())
+	if parsed == nil || *parsed != merged {
+		t.Errorf("ParseOwner(%q) = %+v, want %+v", merged.String(), parsed, merged)
+	}
+}
+
+func TestOwner_MergeSortsContainers(t *testing.T) {
+	web := Owner{InstanceID: "host-a", ContainerID: "def", LabelHash: "222"}
+	api := Owner{InstanceID: "host-a", ContainerID: "abc", LabelHash: "111"}
+
+	// Containers merged in any order give the same marker, with hashes kept with their IDs
+	for _, merged := range []Owner{web.Merge(api), api.Merge(web)} {
+		if merged.ContainerID != "abc,def" || merged.LabelHash != "111,222" {
+			t.Errorf("Merge() = %+v, want containers abc,def with hashes 111,222", merged)
+		}
+	}
+}
+
+func TestHAProxyFrontend_OwnedItems(t *testing.T) {
+	frontend := &HAProxyFrontend{Description: "Shared frontend"}
+	action := HAProxyAction{Action: "use_backend", ACL: "web-acl", Backend: "web-backend"}
+
+	if _, _, recorded := frontend.OwnedItems("host-a"); recorded {
+		t.Fatal("OwnedItems() recorded = true, want false for frontend without record")
+	}
+
+	frontend.SetOwnedItems("host-a", []string{"web-acl"}, []string{action.ItemKey()})
+	frontend.SetOwnedItems("host-b", []string{"api-acl"}, nil)
+	frontend.SetOwner(Owner{InstanceID: "host-a", ContainerID: "abc"})
+	frontend.SetOwnedItems("host-a", []string{"web-acl", "admin-acl"}, []string{action.ItemKey()})
+
+	acls, actions, recorded := frontend.OwnedItems("host-a")
+	if !recorded || len(acls) != 2 || !acls["web-acl"] || !acls["admin-acl"] || !actions[action.ItemKey()] {
+		t.Errorf("OwnedItems(host-a) = %v, %v, %v, want latest record", acls, actions, recorded)
+	}
+	if acls, _, _ := frontend.OwnedItems("host-b"); len(acls) != 1 || !acls["api-acl"] {
+		t.Errorf("OwnedItems(host-b) ACLs = %v, want api-acl", acls)
+	}
+	if !frontend.Owner().IsOwnedBy("host-a") {
+		t.Errorf("Owner() = %+v, want owned by host-a", frontend.Owner())
+	}
+	if strings.Count(frontend.Description, itemsMarkerPrefix) != 2 {
+		t.Errorf("description = %q, want one record per instance", frontend.Description)
+	}
+
+	// Text added by hand is kept when the marker is replaced
+	frontend.SetOwner(Owner{InstanceID: "host-a", ContainerID: "def"})
+	if !strings.HasPrefix(frontend.Description, "Shared frontend ") || strings.Count(frontend.Description, ownerMarkerPrefix) != 1 {
+		t.Errorf("description = %q, want the text kept and a single ownership marker", frontend.Description)
+	}
+	if owner := frontend.Owner(); owner == nil || owner.ContainerID != "def" {
+		t.Errorf("Owner() = %+v, want container def", owner)
+	}
+}
+
+func TestCanModify(t *testing.T) {
+	owned := &Owner{InstanceID: "host-a"}
+	foreign := &Owner{InstanceID: "host-b"}
+
+	tests := []struct {
+		name         string
+		owner        *Owner
+		adopt        bool
+		adoptUnowned bool
+		want         bool
+	}{
+		{name: "owned", owner: owned, want: true},
+		{name: "foreign", owner: foreign},
+		{name: "unowned", owner: nil},
+		{name: "adopted by label", owner: foreign, adopt: true, want: true},
+		{name: "adopted by configuration", owner: nil, adoptUnowned: true, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			global := &config.GlobalConfig{InstanceID: "host-a", AdoptUnowned: tt.adoptUnowned}
+			if got := CanModify(global, tt.owner, tt.adopt); got != tt.want {
+				t.Errorf("CanModify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package pfsensetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+)
+
+// DHCPStaticMappings returns a copy of the DHCP static mappings of all interfaces
+func (s *Server) DHCPStaticMappings() []pfsense.DHCPStaticMapping {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return clone(s.mappings)
+}
+
+// SetDHCPStaticMappings replaces the DHCP static mappings, as if they had been configured
+func (s *Server) SetDHCPStaticMappings(mappings []pfsense.DHCPStaticMapping) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mappings = clone(mappings)
+	s.renumberMappings()
+}
+
+// SetInterfaces replaces the network interfaces
+func (s *Server) SetInterfaces(interfaces []pfsense.Interface) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interfaces = clone(interfaces)
+}
+
+// dhcpRoutes returns the handlers of the interface and /services/dhcp_server endpoints by
+// path and method
+func (s *Server) dhcpRoutes() map[string]map[string]handler {
+	return map[string]map[string]handler{
+		"/interfaces": {
+			http.MethodGet: func(*request) (any, *apiError) { return s.interfaces, nil },
+		},
+		"/services/dhcp_server/static_mappings": {
+			http.MethodGet: func(*request) (any, *apiError) { return s.mappings, nil },
+		},
+		"/services/dhcp_server/static_mapping": {
+			http.MethodPost:   s.createMapping,
+			http.MethodPatch:  s.updateMapping,
+			http.MethodDelete: s.deleteMapping,
+		},
+		"/services/dhcp_server/apply": {
+			http.MethodPost: func(*request) (any, *apiError) { return map[string]bool{"applied": true}, nil },
+		},
+	}
+}
+
+func (s *Server) createMapping(req *request) (any, *apiError) {
+	var mapping pfsense.DHCPStaticMapping
+	if err := req.decode(&mapping); err != nil {
+		return nil, err
+	}
+	if mapping.ParentID == "" {
+		return nil, badRequest("FIELD_IS_REQUIRED", "Field `parent_id` is required")
+	}
+	s.mappings = append(s.mappings, mapping)
+	s.renumberMappings()
+	return &s.mappings[len(s.mappings)-1], nil
+}
+
+func (s *Server) updateMapping(req *request) (any, *apiError) {
+	i, err := s.findMapping(req)
+	if err != nil {
+		return nil, err
+	}
+	mapping := clone(s.mappings[i])
+	if err := req.patch(&mapping); err != nil {
+		return nil, err
+	}
+	s.mappings[i] = mapping
+	return &s.mappings[i], nil
+}
+
+func (s *Server) deleteMapping(req *request) (any, *apiError) {
+	i, err := s.findMapping(req)
+	if err != nil {
+		return nil, err
+	}
+	mapping := s.mappings[i]
+	s.mappings = slices.Delete(s.mappings, i, i+1)
+	s.renumberMappings()
+	return &mapping, nil
+}
+
+// findMapping returns the index of the static mapping selected by the parent_id and id of a
+// request
+func (s *Server) findMapping(req *request) (int, *apiError) {
+	parentID := req.query.Get("parent_id")
+	if raw, exists := req.fields["parent_id"]; exists {
+		if err := json.Unmarshal(raw, &parentID); err != nil {
+			return 0, badRequest("FIELD_INVALID_TYPE", "Field `parent_id` must be a string")
+		}
+	}
+	id, err := req.intField("id")
+	if err != nil {
+		return 0, err
+	}
+	for i := range s.mappings {
+		if s.mappings[i].ParentID == parentID && s.mappings[i].ID == id {
+			return i, nil
+		}
+	}
+	return 0, notFound(fmt.Sprintf("DHCP static mapping with ID %d does not exist on interface %s", id, parentID))
+}
+
+// renumberMappings sets the ID of every static mapping to its position on its interface
+func (s *Server) renumberMappings() {
+	next := make(map[string]int)
+	for i := range s.mappings {
+		s.mappings[i].ID = next[s.mappings[i].ParentID]
+		next[s.mappings[i].ParentID]++
+	}
+}
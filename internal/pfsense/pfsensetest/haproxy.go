@@ -0,0 +1,393 @@
+package pfsensetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+)
+
+// Backends returns a copy of the HAProxy backends
+func (s *Server) Backends() []pfsense.HAProxyBackend {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return clone(s.backends)
+}
+
+// SetBackends replaces the HAProxy backends, as if they had been configured and applied
+func (s *Server) SetBackends(backends []pfsense.HAProxyBackend) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backends = clone(backends)
+	s.renumber()
+}
+
+// Frontends returns a copy of the HAProxy frontends
+func (s *Server) Frontends() []pfsense.HAProxyFrontend {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return clone(s.frontends)
+}
+
+// SetFrontends replaces the HAProxy frontends, as if they had been configured and applied
+func (s *Server) SetFrontends(frontends []pfsense.HAProxyFrontend) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frontends = clone(frontends)
+	s.renumber()
+}
+
+// haproxyRoutes returns the handlers of the /services/haproxy endpoints by path and method
+func (s *Server) haproxyRoutes() map[string]map[string]handler {
+	return map[string]map[string]handler{
+		"/services/haproxy/backends": {
+			http.MethodGet: func(*request) (any, *apiError) { return s.backends, nil },
+		},
+		"/services/haproxy/backend": {
+			http.MethodPost:   s.write(s.createBackend),
+			http.MethodPatch:  s.write(s.updateBackend),
+			http.MethodDelete: s.write(s.deleteBackend),
+		},
+		"/services/haproxy/frontends": {
+			http.MethodGet: func(*request) (any, *apiError) { return s.frontends, nil },
+		},
+		"/services/haproxy/frontend": {
+			http.MethodPost:   s.write(s.createFrontend),
+			http.MethodPatch:  s.write(s.updateFrontend),
+			http.MethodDelete: s.write(s.deleteFrontend),
+		},
+		"/services/haproxy/frontend/acl": children(s, s.frontendACLs, nil),
+		"/services/haproxy/frontend/action": children(s, s.frontendActions, func(action *pfsense.HAProxyAction) *apiError {
+			return s.validateAction(action)
+		}),
+		"/services/haproxy/frontend/certificate": children(s, s.frontendCertificates, nil),
+		"/services/haproxy/frontend/address":     children(s, s.frontendAddresses, nil),
+		"/services/haproxy/apply": {
+			http.MethodGet:  func(*request) (any, *apiError) { return map[string]bool{"applied": !s.pending}, nil },
+			http.MethodPost: s.apply,
+		},
+	}
+}
+
+// write wraps a handler changing the configuration, leaving the change pending until applied
+func (s *Server) write(serve handler) handler {
+	return func(req *request) (any, *apiError) {
+		data, err := serve(req)
+		if err == nil {
+			s.pending = true
+			s.renumber()
+		}
+		return data, err
+	}
+}
+
+// apply applies the pending HAProxy changes
+func (s *Server) apply(*request) (any, *apiError) {
+	s.pending = false
+	s.applies++
+	return map[string]bool{"applied": true}, nil
+}
+
+func (s *Server) createBackend(req *request) (any, *apiError) {
+	var backend pfsense.HAProxyBackend
+	if err := req.decode(&backend); err != nil {
+		return nil, err
+	}
+	if err := s.validateBackend(&backend, -1); err != nil {
+		return nil, err
+	}
+	s.backends = append(s.backends, backend)
+	return &s.backends[len(s.backends)-1], nil
+}
+
+func (s *Server) updateBackend(req *request) (any, *apiError) {
+	id, err := req.intField("id")
+	if err != nil {
+		return nil, err
+	}
+	if id < 0 || id >= len(s.backends) {
+		return nil, notFound(fmt.Sprintf("HAProxy backend with ID %d does not exist", id))
+	}
+
+	backend := clone(s.backends[id])
+	if err := req.patch(&backend); err != nil {
+		return nil, err
+	}
+	if err := s.validateBackend(&backend, id); err != nil {
+		return nil, err
+	}
+	s.backends[id] = backend
+	return &s.backends[id], nil
+}
+
+func (s *Server) deleteBackend(req *request) (any, *apiError) {
+	id, err := req.intField("id")
+	if err != nil {
+		return nil, err
+	}
+	if id < 0 || id >= len(s.backends) {
+		return nil, notFound(fmt.Sprintf("HAProxy backend with ID %d does not exist", id))
+	}
+
+	// Like pfSense, refuse to delete a backend frontends still route to
+	backend := s.backends[id]
+	for _, frontend := range s.frontends {
+		for _, action := range frontend.ActionItems {
+			if action.Backend == backend.Name {
+				return nil, &apiError{
+					status:     http.StatusConflict,
+					responseID: "HAPROXY_BACKEND_IN_USE",
+					message:    fmt.Sprintf("HAProxy backend %s is in use by frontend %s", backend.Name, frontend.Name),
+				}
+			}
+		}
+	}
+
+	s.backends = slices.Delete(s.backends, id, id+1)
+	return &backend, nil
+}
+
+// validateBackend checks a backend about to be stored at index id, -1 for a new backend
+func (s *Server) validateBackend(backend *pfsense.HAProxyBackend, id int) *apiError {
+	if backend.Name == "" {
+		return badRequest("FIELD_IS_REQUIRED", "Field `name` is required")
+	}
+	for i := range s.backends {
+		if i != id && s.backends[i].Name == backend.Name {
+			return badRequest("FIELD_MUST_BE_UNIQUE", fmt.Sprintf("Field `name` must be unique, %s is in use", backend.Name))
+		}
+	}
+	return nil
+}
+
+func (s *Server) createFrontend(req *request) (any, *apiError) {
+	var frontend pfsense.HAProxyFrontend
+	if err := req.decode(&frontend); err != nil {
+		return nil, err
+	}
+	if err := s.validateFrontend(&frontend, -1); err != nil {
+		return nil, err
+	}
+	s.frontends = append(s.frontends, frontend)
+	return &s.frontends[len(s.frontends)-1], nil
+}
+
+func (s *Server) updateFrontend(req *request) (any, *apiError) {
+	id, err := req.intField("id")
+	if err != nil {
+		return nil, err
+	}
+	if id < 0 || id >= len(s.frontends) {
+		return nil, notFound(fmt.Sprintf("HAProxy frontend with ID %d does not exist", id))
+	}
+
+	frontend := clone(s.frontends[id])
+	if err := req.patch(&frontend); err != nil {
+		return nil, err
+	}
+	if err := s.validateFrontend(&frontend, id); err != nil {
+		return nil, err
+	}
+	s.frontends[id] = frontend
+	return &s.frontends[id], nil
+}
+
+func (s *Server) deleteFrontend(req *request) (any, *apiError) {
+	id, err := req.intField("id")
+	if err != nil {
+		return nil, err
+	}
+	if id < 0 || id >= len(s.frontends) {
+		return nil, notFound(fmt.Sprintf("HAProxy frontend with ID %d does not exist", id))
+	}
+
+	frontend := s.frontends[id]
+	s.frontends = slices.Delete(s.frontends, id, id+1)
+	return &frontend, nil
+}
+
+// validateFrontend checks a frontend about to be stored at index id, -1 for a new frontend
+func (s *Server) validateFrontend(frontend *pfsense.HAProxyFrontend, id int) *apiError {
+	if frontend.Name == "" {
+		return badRequest("FIELD_IS_REQUIRED", "Field `name` is required")
+	}
+	for i := range s.frontends {
+		if i != id && s.frontends[i].Name == frontend.Name {
+			return badRequest("FIELD_MUST_BE_UNIQUE", fmt.Sprintf("Field `name` must be unique, %s is in use", frontend.Name))
+		}
+	}
+	for i := range frontend.ActionItems {
+		if err := s.validateAction(&frontend.ActionItems[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateAction checks that the backend an action routes to exists
+func (s *Server) validateAction(action *pfsense.HAProxyAction) *apiError {
+	if action.Backend == "" {
+		return nil
+	}
+	for i := range s.backends {
+		if s.backends[i].Name == action.Backend {
+			return nil
+		}
+	}
+	return badRequest("FIELD_INVALID_CHOICE", fmt.Sprintf("Field `backend` must be an existing backend, %s does not exist", action.Backend))
+}
+
+func (s *Server) frontend(parentID int) (*pfsense.HAProxyFrontend, *apiError) {
+	if parentID < 0 || parentID >= len(s.frontends) {
+		return nil, notFound(fmt.Sprintf("HAProxy frontend with ID %d does not exist", parentID))
+	}
+	return &s.frontends[parentID], nil
+}
+
+func (s *Server) frontendACLs(parentID int) (*[]pfsense.HAProxyACL, *apiError) {
+	frontend, err := s.frontend(parentID)
+	if err != nil {
+		return nil, err
+	}
+	return &frontend.HAACLs, nil
+}
+
+func (s *Server) frontendActions(parentID int) (*[]pfsense.HAProxyAction, *apiError) {
+	frontend, err := s.frontend(parentID)
+	if err != nil {
+		return nil, err
+	}
+	return &frontend.ActionItems, nil
+}
+
+func (s *Server) frontendCertificates(parentID int) (*[]pfsense.HAProxyFrontendCertificate, *apiError) {
+	frontend, err := s.frontend(parentID)
+	if err != nil {
+		return nil, err
+	}
+	return &frontend.Certificates, nil
+}
+
+func (s *Server) frontendAddresses(parentID int) (*[]pfsense.HAProxyFrontendAddress, *apiError) {
+	frontend, err := s.frontend(parentID)
+	if err != nil {
+		return nil, err
+	}
+	return &frontend.Addresses, nil
+}
+
+// children returns the handlers of objects nested in another object, such as the servers of
+// a backend. The parent is selected by parent_id and the object by its position in the parent.
+func children[T any](
+	s *Server,
+	list func(parentID int) (*[]T, *apiError),
+	validate func(*T) *apiError,
+) map[string]handler {
+	// find returns the list of the parent of a request and the requested position in it
+	find := func(req *request, withID bool) (*[]T, int, *apiError) {
+		parentID, err := req.intField("parent_id")
+		if err != nil {
+			return nil, 0, err
+		}
+		items, err := list(parentID)
+		if err != nil || !withID {
+			return items, 0, err
+		}
+		id, err := req.intField("id")
+		if err != nil {
+			return nil, 0, err
+		}
+		if id < 0 || id >= len(*items) {
+			return nil, 0, notFound(fmt.Sprintf("Object with ID %d does not exist in parent %d", id, parentID))
+		}
+		return items, id, nil
+	}
+
+	return map[string]handler{
+		http.MethodPost: s.write(func(req *request) (any, *apiError) {
+			items, _, err := find(req, false)
+			if err != nil {
+				return nil, err
+			}
+			var item T
+			if err := req.decode(&item); err != nil {
+				return nil, err
+			}
+			if validate != nil {
+				if err := validate(&item); err != nil {
+					return nil, err
+				}
+			}
+			*items = append(*items, item)
+			return &(*items)[len(*items)-1], nil
+		}),
+		http.MethodPatch: s.write(func(req *request) (any, *apiError) {
+			items, id, err := find(req, true)
+			if err != nil {
+				return nil, err
+			}
+			item := clone((*items)[id])
+			if err := req.patch(&item); err != nil {
+				return nil, err
+			}
+			if validate != nil {
+				if err := validate(&item); err != nil {
+					return nil, err
+				}
+			}
+			(*items)[id] = item
+			return &(*items)[id], nil
+		}),
+		http.MethodDelete: s.write(func(req *request) (any, *apiError) {
+			items, id, err := find(req, true)
+			if err != nil {
+				return nil, err
+			}
+			item := (*items)[id]
+			*items = slices.Delete(*items, id, id+1)
+			return &item, nil
+		}),
+	}
+}
+
+// renumber sets the ID of every object to its position, as pfSense identifies them
+func (s *Server) renumber() {
+	for i := range s.backends {
+		backend := &s.backends[i]
+		backend.ID = i
+		for j := range backend.Servers {
+			backend.Servers[j].ID = j
+		}
+	}
+	for i := range s.frontends {
+		frontend := &s.frontends[i]
+		frontend.ID = i
+		for j := range frontend.Addresses {
+			frontend.Addresses[j].ID = j
+		}
+		for j := range frontend.Certificates {
+			frontend.Certificates[j].ID = j
+		}
+		for j := range frontend.HAACLs {
+			frontend.HAACLs[j].ID = j
+		}
+		for j := range frontend.ActionItems {
+			frontend.ActionItems[j].ID = j
+		}
+	}
+}
+
+// clone returns a deep copy of a value
+func clone[T any](value T) T {
+	var copied T
+	data, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	if err := json.Unmarshal(data, &copied); err != nil {
+		panic(err)
+	}
+	return copied
+}
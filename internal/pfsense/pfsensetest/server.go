@@ -0,0 +1,372 @@
+// Package pfsensetest provides an in-process fake of the pfSense REST API for tests. It keeps
+// the HAProxy configuration and the DHCP static mappings in memory and identifies objects by
+// their position, the way pfSense does, so deleting an object shifts the IDs of the objects
+// after it.
+package pfsensetest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/config"
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+)
+
+const (
+	// APIKey is the API key the server accepts
+	APIKey = "pfsensetest-api-key"
+
+	// apiPrefix is the path the REST API is served under
+	apiPrefix = "/api/v2"
+)
+
+// Fault makes the server fail or delay the requests it matches
+type Fault struct {
+	// Method and Path select the requests, such as POST and /services/haproxy/apply. Empty
+	// values match every method or path.
+	Method string
+	Path   string
+	// Status is the HTTP status to fail with, 0 serves the request normally after the latency
+	Status int
+	// Latency delays the response, or until the request is canceled
+	Latency time.Duration
+	// Times is the number of requests the fault affects, 0 for all of them
+	Times int
+}
+
+// matches reports whether the fault applies to a request
+func (f *Fault) matches(method, path string) bool {
+	return (f.Method == "" || strings.EqualFold(f.Method, method)) && (f.Path == "" || f.Path == path)
+}
+
+// Request is a request the server received
+type Request struct {
+	Method string
+	// Path is the API path without the /api/v2 prefix and query, such as /services/haproxy/backend
+	Path   string
+	Status int
+}
+
+// Server is a fake pfSense REST API served over HTTP
+type Server struct {
+	*httptest.Server
+
+	routes     map[string]map[string]handler
+	backends   []pfsense.HAProxyBackend
+	frontends  []pfsense.HAProxyFrontend
+	mappings   []pfsense.DHCPStaticMapping
+	interfaces []pfsense.Interface
+	faults     []*Fault
+	requests   []Request
+	applies    int
+	pending    bool
+	mu         sync.Mutex
+}
+
+// NewServer starts a server without any configuration. Close it when done.
+func NewServer() *Server {
+	s := &Server{}
+	s.routes = s.haproxyRoutes()
+	maps.Copy(s.routes, s.dhcpRoutes())
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Endpoint returns the configuration of an endpoint using the server
+func (s *Server) Endpoint(name string) config.EndpointConfig {
+	return config.EndpointConfig{
+		Name:   name,
+		URL:    s.URL + apiPrefix,
+		APIKey: APIKey,
+	}
+}
+
+// AddFault makes the server fail or delay the requests matching the fault
+func (s *Server) AddFault(fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &fault)
+}
+
+// ClearFaults removes all faults
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// Requests returns the requests received so far, in order
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Writes returns the successful requests that changed the configuration, excluding applies
+func (s *Server) Writes() []Request {
+	var writes []Request
+	for _, request := range s.Requests() {
+		if request.Method != http.MethodGet && request.Status < 400 && !strings.HasSuffix(request.Path, "/apply") {
+			writes = append(writes, request)
+		}
+	}
+	return writes
+}
+
+// Applies returns the number of times the HAProxy changes were applied
+func (s *Server) Applies() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.applies
+}
+
+// Pending reports whether there are HAProxy changes that were not applied
+func (s *Server) Pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending
+}
+
+// handler serves a request to an API path, returning the response data or an error
+type handler func(req *request) (any, *apiError)
+
+// request is a decoded API request
+type request struct {
+	fields map[string]json.RawMessage
+	query  url.Values
+	body   []byte
+}
+
+// intField returns an integer field of the request body, or otherwise of the query
+func (r *request) intField(name string) (int, *apiError) {
+	if raw, exists := r.fields[name]; exists {
+		var value int
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return 0, badRequest("FIELD_INVALID_TYPE", "Field `"+name+"` must be an integer")
+		}
+		return value, nil
+	}
+	if value := r.query.Get(name); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, badRequest("FIELD_INVALID_TYPE", "Field `"+name+"` must be an integer")
+		}
+		return parsed, nil
+	}
+	return 0, badRequest("FIELD_IS_REQUIRED", "Field `"+name+"` is required")
+}
+
+// decode decodes the request body into an object, ignoring its ID fields
+func (r *request) decode(object any) *apiError {
+	if err := json.Unmarshal(r.body, object); err != nil {
+		return badRequest("REQUEST_BODY_INVALID", "Request body is not a valid object: "+err.Error())
+	}
+	return nil
+}
+
+// patch updates an object with the fields of the request body, leaving the other fields as
+// they are
+func (r *request) patch(object any) *apiError {
+	current, err := json.Marshal(object)
+	if err != nil {
+		return serverError(err)
+	}
+	merged := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(current, &merged); err != nil {
+		return serverError(err)
+	}
+	for name, value := range r.fields {
+		if name != "id" && name != "parent_id" {
+			merged[name] = value
+		}
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return serverError(err)
+	}
+	if err := json.Unmarshal(data, object); err != nil {
+		return badRequest("REQUEST_BODY_INVALID", "Request body is not a valid object: "+err.Error())
+	}
+	return nil
+}
+
+// apiError is an error response of the API
+type apiError struct {
+	responseID string
+	message    string
+	status     int
+}
+
+func badRequest(responseID, message string) *apiError {
+	return &apiError{status: http.StatusBadRequest, responseID: responseID, message: message}
+}
+
+func notFound(message string) *apiError {
+	return &apiError{status: http.StatusNotFound, responseID: "MODEL_OBJECT_NOT_FOUND", message: message}
+}
+
+func serverError(err error) *apiError {
+	return &apiError{status: http.StatusInternalServerError, responseID: "SERVER_ERROR", message: err.Error()}
+}
+
+// response is the envelope of every API response
+type response struct {
+	Data       json.RawMessage `json:"data"`
+	Status     string          `json:"status"`
+	ResponseID string          `json:"response_id"`
+	Message    string          `json:"message"`
+	Code       int             `json:"code"`
+}
+
+// serveHTTP authenticates a request, applies the faults matching it and routes it
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, apiPrefix)
+
+	var status int
+	defer func() {
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{Method: r.Method, Path: path, Status: status})
+		s.mu.Unlock()
+	}()
+
+	// Reading the body first lets the server notice requests canceled while delayed
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		status = writeResponse(w, nil, serverError(err))
+		return
+	}
+
+	if fault := s.takeFault(r.Method, path); fault != nil {
+		if !sleep(r.Context(), fault.Latency) {
+			status = http.StatusServiceUnavailable
+			return
+		}
+		if fault.Status != 0 {
+			status = writeResponse(w, nil, &apiError{
+				status:     fault.Status,
+				responseID: "PFSENSETEST_FAULT",
+				message:    "Injected fault",
+			})
+			return
+		}
+	}
+
+	if r.Header.Get("X-API-Key") != APIKey {
+		status = writeResponse(w, nil, &apiError{
+			status:     http.StatusUnauthorized,
+			responseID: "AUTH_AUTHENTICATION_FAILED",
+			message:    "Authentication failed",
+		})
+		return
+	}
+
+	methods, exists := s.routes[path]
+	if !exists || !strings.HasPrefix(r.URL.Path, apiPrefix) {
+		status = writeResponse(w, nil, &apiError{
+			status:     http.StatusNotFound,
+			responseID: "ENDPOINT_NOT_FOUND",
+			message:    "Endpoint " + r.URL.Path + " does not exist",
+		})
+		return
+	}
+	serve, exists := methods[r.Method]
+	if !exists {
+		status = writeResponse(w, nil, &apiError{
+			status:     http.StatusMethodNotAllowed,
+			responseID: "ENDPOINT_METHOD_NOT_ALLOWED",
+			message:    "Method " + r.Method + " is not allowed for " + path,
+		})
+		return
+	}
+
+	req := &request{query: r.URL.Query()}
+	if len(body) > 0 {
+		req.body = body
+		if err := json.Unmarshal(body, &req.fields); err != nil {
+			status = writeResponse(w, nil, badRequest("REQUEST_BODY_INVALID", "Request body is not a JSON object"))
+			return
+		}
+	}
+
+	// Encode while locked, the data may point into the configuration
+	s.mu.Lock()
+	data, apiErr := serve(req)
+	encoded, err := json.Marshal(data)
+	s.mu.Unlock()
+	if err != nil {
+		apiErr = serverError(err)
+	}
+
+	status = writeResponse(w, encoded, apiErr)
+}
+
+// takeFault returns the first fault matching a request, counting the request against it
+func (s *Server) takeFault(method, path string) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, fault := range s.faults {
+		if !fault.matches(method, path) {
+			continue
+		}
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return fault
+	}
+	return nil
+}
+
+// sleep waits for a duration, returning false if ctx is canceled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// writeResponse writes the response envelope and returns its HTTP status. Responses without
+// data carry an empty list, as pfSense's do.
+func writeResponse(w http.ResponseWriter, data json.RawMessage, apiErr *apiError) int {
+	resp := response{
+		Code:       http.StatusOK,
+		Status:     "ok",
+		ResponseID: "SUCCESS",
+		Data:       data,
+	}
+	if apiErr != nil {
+		resp = response{
+			Code:       apiErr.status,
+			Status:     strings.ToLower(http.StatusText(apiErr.status)),
+			ResponseID: apiErr.responseID,
+			Message:    apiErr.message,
+		}
+	}
+	if len(resp.Data) == 0 || string(resp.Data) == "null" {
+		resp.Data = json.RawMessage("[]")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.Code)
+	_ = json.NewEncoder(w).Encode(resp)
+	return resp.Code
+}
@@ -0,0 +1,149 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package pfsensetest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/pfsense"
+)
+
+func newClient(t *testing.T) (*Server, *pfsense.Client) {
+	t.Helper()
+	server := NewServer()
+	t.Cleanup(server.Close)
+	endpoint := server.Endpoint("test")
+	return server, pfsense.NewClient(&endpoint)
+}
+
+func TestServer_IDsFollowPositions(t *testing.T) {
+	server, client := newClient(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"a", "b", "c"} {
+		if err := client.CreateHAProxyBackend(ctx, &pfsense.HAProxyBackend{Name: name}); err != nil {
+			t.Fatalf("CreateHAProxyBackend(%s) error = %v", name, err)
+		}
+	}
+	if err := client.DeleteHAProxyBackend(ctx, 0); err != nil {
+		t.Fatalf("DeleteHAProxyBackend() error = %v", err)
+	}
+
+	backend, err := client.FindBackendByName(ctx, "c")
+	if err != nil || backend == nil {
+		t.Fatalf("FindBackendByName() = %v, %v", backend, err)
+	}
+	if backend.ID != 1 {
+		t.Errorf("backend c ID = %d after deleting the first backend, want 1", backend.ID)
+	}
+
+	if err := client.DeleteHAProxyBackend(ctx, 2); err == nil {
+		t.Error("DeleteHAProxyBackend() of a shifted out ID succeeded, want not found")
+	}
+	if err := client.CreateHAProxyBackend(ctx, &pfsense.HAProxyBackend{Name: "b"}); err == nil {
+		t.Error("CreateHAProxyBackend() with a duplicate name succeeded")
+	}
+
+	if !server.Pending() {
+		t.Error("Pending() = false after writes")
+	}
+	if err := client.ApplyHAProxyChanges(ctx); err != nil {
+		t.Fatalf("ApplyHAProxyChanges() error = %v", err)
+	}
+	if server.Pending() || server.Applies() != 1 {
+		t.Errorf("Pending() = %v, Applies() = %d after applying, want false, 1", server.Pending(), server.Applies())
+	}
+}
+
+func TestServer_NestedObjects(t *testing.T) {
+	server, client := newClient(t)
+	ctx := context.Background()
+
+	server.SetBackends([]pfsense.HAProxyBackend{{Name: "web"}, {Name: "api"}})
+	server.SetFrontends([]pfsense.HAProxyFrontend{{Name: "shared"}})
+
+	for _, acl := range []string{"web-acl", "api-acl"} {
+		if err := client.AddACLToFrontend(ctx, 0, pfsense.HAProxyACL{Name: acl, Expression: "host_matches"}); err != nil {
+			t.Fatalf("AddACLToFrontend(%s) error = %v", acl, err)
+		}
+	}
+	if err := client.AddActionToFrontend(ctx, 0, pfsense.HAProxyAction{Action: "use_backend", ACL: "api-acl", Backend: "api"}); err != nil {
+		t.Fatalf("AddActionToFrontend() error = %v", err)
+	}
+	if err := client.AddActionToFrontend(ctx, 0, pfsense.HAProxyAction{Action: "use_backend", Backend: "missing"}); err == nil {
+		t.Error("AddActionToFrontend() to a missing backend succeeded")
+	}
+	if err := client.AddACLToFrontend(ctx, 1, pfsense.HAProxyACL{Name: "orphan"}); err == nil {
+		t.Error("AddACLToFrontend() to a missing frontend succeeded")
+	}
+
+	if err := client.DeleteACLFromFrontend(ctx, 0, 0); err != nil {
+		t.Fatalf("DeleteACLFromFrontend() error = %v", err)
+	}
+	if err := client.UpdateFrontendACL(ctx, 0, pfsense.HAProxyACL{ID: 0, Name: "api-acl", Expression: "host_starts_with"}); err != nil {
+		t.Fatalf("UpdateFrontendACL() error = %v", err)
+	}
+
+	frontend := server.Frontends()[0]
+	if len(frontend.HAACLs) != 1 || frontend.HAACLs[0].Expression != "host_starts_with" || frontend.HAACLs[0].ID != 0 {
+		t.Errorf("ACLs = %+v, want the updated api-acl at ID 0", frontend.HAACLs)
+	}
+
+	// Backends frontends route to cannot be deleted
+	if err := client.DeleteHAProxyBackend(ctx, 1); err == nil {
+		t.Error("DeleteHAProxyBackend() of a backend in use succeeded")
+	}
+	if err := client.DeleteActionFromFrontend(ctx, 0, 0); err != nil {
+		t.Fatalf("DeleteActionFromFrontend() error = %v", err)
+	}
+	if err := client.DeleteHAProxyBackend(ctx, 1); err != nil {
+		t.Errorf("DeleteHAProxyBackend() after removing its action error = %v", err)
+	}
+}
+
+func TestServer_Faults(t *testing.T) {
+	server, client := newClient(t)
+	ctx := context.Background()
+
+	server.AddFault(Fault{Method: http.MethodPost, Path: "/services/haproxy/apply", Status: http.StatusServiceUnavailable, Times: 2})
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		if err := client.ApplyHAProxyChanges(ctx); err == nil {
+			t.Fatalf("ApplyHAProxyChanges() attempt %d succeeded, want the injected fault", attempt)
+		}
+	}
+	if err := client.ApplyHAProxyChanges(ctx); err != nil {
+		t.Fatalf("ApplyHAProxyChanges() after the fault ran out error = %v", err)
+	}
+	if server.Applies() != 1 {
+		t.Errorf("Applies() = %d, want 1", server.Applies())
+	}
+
+	server.AddFault(Fault{Latency: time.Second})
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.GetHAProxyBackends(ctx); err == nil {
+		t.Error("GetHAProxyBackends() succeeded despite the deadline")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("GetHAProxyBackends() took %v, want it aborted at the deadline", elapsed)
+	}
+	server.ClearFaults()
+
+	endpoint := server.Endpoint("test")
+	endpoint.APIKey = "wrong"
+	if _, err := pfsense.NewClient(&endpoint).GetHAProxyBackends(context.Background()); err == nil {
+		t.Error("GetHAProxyBackends() with a wrong API key succeeded")
+	}
+
+	requests := server.Requests()
+	if last := requests[len(requests)-1]; last.Status != http.StatusUnauthorized {
+		t.Errorf("last request status = %d, want %d", last.Status, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,72 @@
+package pfsense
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/KristijanL/pfsense-container-controller/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// maxRetryDelay caps the delay between retries
+const maxRetryDelay = time.Minute
+
+// Retry runs an operation up to attempts times while it fails with a retryable error,
+// doubling the delay between attempts and adding jitter so that several controllers do not
+// retry in lockstep. Errors that are not retryable are returned right away, and retrying stops
+// once ctx is canceled.
+func Retry(ctx context.Context, attempts int, delay time.Duration, logger *logrus.Entry, operation func() error) error {
+	attempts = max(attempts, 1)
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			wait := backoff(delay, attempt)
+			logger.Debugf("Retrying operation after %v (attempt %d/%d)", wait, attempt+1, attempts)
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%w, last error: %w", ctx.Err(), lastErr)
+			case <-time.After(wait):
+			}
+		}
+
+		err := operation()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w, last error: %w", ctx.Err(), err)
+		}
+		if !IsRetryable(err) {
+			return err
+		}
+
+		lastErr = err
+		logger.Warnf("Operation failed (attempt %d/%d): %v", attempt+1, attempts, err)
+	}
+
+	return fmt.Errorf("operation failed after %d attempts, last error: %w", attempts, lastErr)
+}
+
+// RetryOperation runs Retry with the retry attempts and delay of the global configuration
+func RetryOperation(ctx context.Context, global *config.GlobalConfig, logger *logrus.Entry, operation func() error) error {
+	return Retry(ctx, global.RetryAttempts, global.RetryDelay.Duration, logger, operation)
+}
+
+// backoff returns the delay before a retry: the base delay doubled for every earlier retry,
+// capped at maxRetryDelay, of which the second half is random
+func backoff(delay time.Duration, attempt int) time.Duration {
+	if delay <= 0 {
+		return 0
+	}
+	wait := delay
+	for i := 1; i < attempt && wait < maxRetryDelay; i++ {
+		wait *= 2
+	}
+	wait = min(wait, maxRetryDelay)
+
+	half := wait / 2
+	return half + time.Duration(rand.Int64N(int64(wait-half)+1)) // #nosec G404 - Jitter does not need a secure random source
+}